@@ -0,0 +1,90 @@
+package logthing
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// byTimestamp sorts messages by their precomputed unix nano timestamps
+type byTimestamp struct {
+	keys     []int64
+	messages []*logMsg
+}
+
+func (b byTimestamp) Len() int           { return len(b.keys) }
+func (b byTimestamp) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byTimestamp) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.messages[i], b.messages[j] = b.messages[j], b.messages[i]
+}
+
+// orderByTimestamp returns true if the messages are ordered by their timestamps. Unordered messages are sorted (stable)
+// if sortUnordered is true, which is done once per batch for all writers.
+func orderByTimestamp(logMessages []*logMsg, sortUnordered bool) (ordered bool) {
+	keys := make([]int64, len(logMessages))
+	ordered = true
+	for i, msg := range logMessages {
+		keys[i] = time.Time(msg.timestamp).UnixNano()
+		ordered = ordered && (i == 0 || keys[i-1] <= keys[i])
+	}
+	if ordered || !sortUnordered {
+		return ordered
+	}
+	sort.Stable(byTimestamp{keys: keys, messages: logMessages})
+	return true
+}
+
+// writersOrderInsensitive returns true if the order of messages doesn't matter for any writer (see logwriter.OrderInsensitive)
+func (ld *logDispatcher) writersOrderInsensitive() bool {
+	for _, lw := range ld.logWriters {
+		if lw == nil {
+			continue
+		}
+		if oi, ok := lw.(logwriter.OrderInsensitive); !ok || !oi.OrderInsensitive() {
+			return false
+		}
+	}
+	return true
+}
+
+// writeBatch writes the messages with WriteBatchCheckpointed (and persists the checkpoint) if the writer implements
+// logwriter.CheckpointedWriter, with WriteBatch if it implements logwriter.BatchWriter and otherwise with
+// WriteLogMessages. Batches that exceed the writer's max batch bytes (see logwriter.Capabilities) are split into chunks.
+func writeBatch(lw logwriter.LogWriter, batch logwriter.Batch, checkpoints *checkpointStore) error {
+	for _, chunk := range chunkBatch(batch, logwriter.CapabilitiesOf(lw).MaxBatchBytes) {
+		var err error
+		if cw, ok := lw.(logwriter.CheckpointedWriter); ok {
+			var checkpoint logwriter.Checkpoint
+			if checkpoint, err = cw.WriteBatchCheckpointed(chunk); err == nil {
+				err = checkpoints.store(lw, checkpoint)
+			}
+		} else if bw, ok := lw.(logwriter.BatchWriter); ok {
+			err = bw.WriteBatch(chunk)
+		} else {
+			err = lw.WriteLogMessages(chunk.LogMessages, chunk.Timestamps)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkBatch splits the batch into chunks whose messages don't exceed maxBytes (0: unlimited). A single message that
+// exceeds maxBytes is returned in its own chunk.
+func chunkBatch(batch logwriter.Batch, maxBytes int) (chunks []logwriter.Batch) {
+	if maxBytes <= 0 {
+		return []logwriter.Batch{batch}
+	}
+	start, size := 0, 0
+	for i, logMessage := range batch.LogMessages {
+		if i > start && size+len(logMessage)+1 > maxBytes {
+			chunks = append(chunks, logwriter.Batch{LogMessages: batch.LogMessages[start:i], Timestamps: batch.Timestamps[start:i], Ordered: batch.Ordered})
+			start, size = i, 0
+		}
+		size += len(logMessage) + 1
+	}
+	return append(chunks, logwriter.Batch{LogMessages: batch.LogMessages[start:], Timestamps: batch.Timestamps[start:], Ordered: batch.Ordered})
+}
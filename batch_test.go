@@ -0,0 +1,50 @@
+package logthing
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestOrderByTimestamp(t *testing.T) {
+	now := time.Now()
+	newMsg := func(offset time.Duration) *logMsg {
+		return NewLogMsg("test").SetTimestamp(now.Add(offset)).msgData()
+	}
+	messages := []*logMsg{newMsg(0), newMsg(time.Second)}
+	if !orderByTimestamp(messages, false) {
+		t.Errorf("expected ordered messages")
+	}
+	messages = []*logMsg{newMsg(2 * time.Second), newMsg(0), newMsg(time.Second)}
+	if orderByTimestamp(messages, false) || messages[0].Timestamp() != now.Add(2*time.Second) {
+		t.Errorf("expected unordered messages to be kept")
+	}
+	if !orderByTimestamp(messages, true) {
+		t.Errorf("expected sorted messages")
+	}
+	for i, msg := range messages {
+		if !msg.Timestamp().Equal(now.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("unexpected order: %v", msg.Timestamp())
+		}
+	}
+}
+
+func TestChunkBatch(t *testing.T) {
+	batch := logwriter.Batch{
+		LogMessages: []json.RawMessage{json.RawMessage(`"aaaa"`), json.RawMessage(`"bbbb"`), json.RawMessage(`"cccccccccccc"`), json.RawMessage(`"d"`)},
+		Timestamps:  make([]time.Time, 4),
+		Ordered:     true,
+	}
+	chunks := chunkBatch(batch, 14)
+	if len(chunks) != 3 || len(chunks[0].LogMessages) != 2 || len(chunks[1].LogMessages) != 1 || len(chunks[2].Timestamps) != 1 || !chunks[2].Ordered {
+		t.Errorf("unexpected chunks: %+v", chunks)
+	}
+	if len(chunkBatch(batch, 0)) != 1 {
+		t.Errorf("expected single chunk without limit")
+	}
+	if caps := logwriter.CapabilitiesOf(logwriter.NewAzureMonitorWriter()); caps.MaxBatchBytes <= 0 || caps.Format != logwriter.FormatJSONArray {
+		t.Errorf("unexpected Azure Monitor capabilities: %+v", caps)
+	}
+}
@@ -0,0 +1,135 @@
+package logthing
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// MsgTypeBudgetExceeded is the message type of the alert message that is logged when a writer exceeds its daily ingestion budget
+const MsgTypeBudgetExceeded = "logthing_budget_exceeded"
+
+// BudgetPolicy defines how writing is degraded when a writer exceeded its daily ingestion budget (see WithDailyIngestionBudget).
+// Messages with severity <= SeverityError are always written.
+type BudgetPolicy struct {
+	DropSeverity Severity              // messages with severity >= DropSeverity are dropped (e.g. SeverityInfo drops Info and Trace messages, the zero value all non-errors), SeverityNotApplied drops none
+	SampleRate   float64               // fraction (0 < SampleRate < 1) of the remaining messages that are written, other values keep all
+	Exempt       []logwriter.LogWriter // writers without budget (e.g. a cheap archive), which still receive all messages ("dead-letter")
+}
+
+// budgetOptions configures the daily ingestion budget
+type budgetOptions struct {
+	dailyBytes int64
+	policy     BudgetPolicy
+}
+
+// WithDailyIngestionBudget sets the number of bytes that each writer may write per day (UTC). Once the budget is
+// exceeded, an alert message of type "logthing_budget_exceeded" is logged and writing is degraded according to the policy
+// until the end of the day.
+//
+//	logthing.WithDailyIngestionBudget(5<<30, logthing.BudgetPolicy{DropSeverity: logthing.SeverityInfo, Exempt: []logwriter.LogWriter{archive}})
+func WithDailyIngestionBudget(bytes int64, policy BudgetPolicy) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		policy.Exempt = append([]logwriter.LogWriter{}, policy.Exempt...)
+		opt.budget = budgetOptions{
+			dailyBytes: bytes,
+			policy:     policy,
+		}
+	}
+}
+
+// ingestionBudget tracks the bytes written by a writer on a day. Writes of writer pools (see WithWriterConcurrency) are
+// added by the pool's workers, therefore the budget is guarded by a mutex.
+type ingestionBudget struct {
+	mutex sync.Mutex
+	day   string
+	bytes int64
+}
+
+// writerBudget returns the ingestion budget of the writer or nil if the writer has no budget
+func (ld *logDispatcher) writerBudget(index int, lw logwriter.LogWriter, options dispatcherOptions) *ingestionBudget {
+	if options.budget.dailyBytes <= 0 {
+		return nil
+	}
+	for _, exempt := range options.budget.policy.Exempt {
+		if exempt == lw {
+			return nil
+		}
+	}
+	if ld.budgets == nil {
+		ld.budgets = map[int]*ingestionBudget{}
+	}
+	budget, ok := ld.budgets[index]
+	if !ok {
+		budget = &ingestionBudget{}
+		ld.budgets[index] = budget
+	}
+	budget.mutex.Lock()
+	if day := time.Now().UTC().Format("2006-01-02"); budget.day != day {
+		budget.day = day
+		budget.bytes = 0
+	}
+	budget.mutex.Unlock()
+	return budget
+}
+
+// exceeded returns true if the budget is exceeded
+func (b *ingestionBudget) exceeded(options budgetOptions) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.bytes >= options.dailyBytes
+}
+
+// degrade returns the messages that shall be written according to the policy if the budget is exceeded. Sampled messages
+// are stamped with their sample rate (see PropertySampleRate).
+func (b *ingestionBudget) degrade(logMessages []json.RawMessage, timestamps []time.Time, severities []Severity, options budgetOptions, marshal marshalFunc) ([]json.RawMessage, []time.Time) {
+	if !b.exceeded(options) {
+		return logMessages, timestamps
+	}
+	policy := options.policy
+	var keptMessages []json.RawMessage
+	var keptTimestamps []time.Time
+	for i, severity := range severities {
+		logMessage := logMessages[i]
+		if severity > SeverityError {
+			if policy.DropSeverity != SeverityNotApplied && severity >= policy.DropSeverity {
+				continue
+			}
+			if policy.SampleRate > 0 && policy.SampleRate < 1 {
+				if rand.Float64() >= policy.SampleRate {
+					continue
+				}
+				logMessage = stampSampleRate(logMessage, policy.SampleRate, marshal)
+			}
+		}
+		keptMessages = append(keptMessages, logMessage)
+		keptTimestamps = append(keptTimestamps, timestamps[i])
+	}
+	return keptMessages, keptTimestamps
+}
+
+// add adds the size of the written messages and returns true if the budget has been exceeded by them
+func (b *ingestionBudget) add(logMessages []json.RawMessage, options budgetOptions) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	wasExceeded := b.bytes >= options.dailyBytes
+	for _, logMessage := range logMessages {
+		b.bytes += int64(len(logMessage)) + 1
+	}
+	return !wasExceeded && b.bytes >= options.dailyBytes
+}
+
+// budgetExceededMsg returns the alert message for a writer that exceeded its budget
+func budgetExceededMsg(writer string, budget *ingestionBudget, options budgetOptions) LogMsg {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	return NewLogMsg(MsgTypeBudgetExceeded, WithWhitelistFlag()).
+		SetProperty("writer", writer).
+		SetProperty("day", budget.day).
+		SetProperty("budget_bytes", options.dailyBytes).
+		SetProperty("written_bytes", budget.bytes).
+		Alertf("writer %v exceeded its daily ingestion budget of %v bytes", writer, options.dailyBytes)
+}
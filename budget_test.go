@@ -0,0 +1,32 @@
+package logthing
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestIngestionBudget(t *testing.T) {
+	ld := &logDispatcher{}
+	options := dispatcherOptions{}
+	WithDailyIngestionBudget(10, BudgetPolicy{DropSeverity: SeverityInfo})(&options)
+	budget := ld.writerBudget(0, nil, options)
+	messages := []json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`{"b":2}`), json.RawMessage(`{"c":3}`)}
+	timestamps := []time.Time{time.Now(), time.Now(), time.Now()}
+	severities := []Severity{SeverityError, SeverityNotice, SeverityInfo}
+	if kept, _ := budget.degrade(messages, timestamps, severities, options.budget, options.marshal); len(kept) != 3 {
+		t.Errorf("expected all messages to be kept within budget, got %v", len(kept))
+	}
+	if !budget.add(messages[:2], options.budget) {
+		t.Errorf("expected budget to be exceeded")
+	}
+	if budget.add(messages[:1], options.budget) {
+		t.Errorf("expected exceeded budget to be reported only once")
+	}
+	if kept, _ := budget.degrade(messages, timestamps, severities, options.budget, options.marshal); len(kept) != 2 {
+		t.Errorf("expected info message to be dropped, got %v messages", len(kept))
+	}
+	if ld.writerBudget(0, nil, dispatcherOptions{}) != nil {
+		t.Errorf("expected no budget without option")
+	}
+}
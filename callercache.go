@@ -0,0 +1,93 @@
+package logthing
+
+import (
+	"container/list"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// callerCacheSize is the max number of program counters whose caller info is cached
+const callerCacheSize = 4096
+
+// caller is the resolved caller info of a program counter
+type caller struct {
+	pc       uintptr
+	file     string
+	line     int
+	function string
+	location string // "file:line function"
+}
+
+// callerLRU caches the resolved caller info per program counter, since resolving frames is expensive compared to
+// runtime.Callers
+type callerLRU struct {
+	mutex   sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	callers map[uintptr]*list.Element
+}
+
+var callerCache = newCallerLRU(callerCacheSize)
+
+func newCallerLRU(size int) *callerLRU {
+	return &callerLRU{
+		size:    size,
+		order:   list.New(),
+		callers: map[uintptr]*list.Element{},
+	}
+}
+
+// get returns the caller info of the program counter and resolves it if it isn't cached yet
+func (c *callerLRU) get(pc uintptr) *caller {
+	c.mutex.Lock()
+	if element, ok := c.callers[pc]; ok {
+		c.order.MoveToFront(element)
+		c.mutex.Unlock()
+		return element.Value.(*caller)
+	}
+	c.mutex.Unlock()
+
+	resolved := resolveCaller(pc)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.callers[pc]; ok {
+		return element.Value.(*caller)
+	}
+	c.callers[pc] = c.order.PushFront(resolved)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.callers, oldest.Value.(*caller).pc)
+	}
+	return resolved
+}
+
+// resolveCaller resolves file name, line and short function name of the program counter
+func resolveCaller(pc uintptr) *caller {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if frame.File == "" {
+		return &caller{pc: pc, file: "???", location: "???:0"}
+	}
+	resolved := &caller{
+		pc:       pc,
+		file:     filepath.Base(frame.File),
+		line:     frame.Line,
+		function: shortFuncName(frame.Function),
+	}
+	resolved.location = resolved.file + ":" + strconv.Itoa(resolved.line)
+	if resolved.function != "" {
+		resolved.location += " " + resolved.function
+	}
+	return resolved
+}
+
+// callerAt returns the cached caller info of the given call depth (like runtime.Caller)
+func callerAt(calldepth int) *caller {
+	var pcs [1]uintptr
+	if runtime.Callers(calldepth+2, pcs[:]) == 0 {
+		return &caller{file: "???", location: "???:0"}
+	}
+	return callerCache.get(pcs[0])
+}
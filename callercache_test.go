@@ -0,0 +1,28 @@
+package logthing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallerLRU(t *testing.T) {
+	callerCache = newCallerLRU(1)
+	defer func() { callerCache = newCallerLRU(callerCacheSize) }()
+	first := callerAt(0)
+	if first.file != "callercache_test.go" || !strings.HasPrefix(first.location, "callercache_test.go:") {
+		t.Errorf("unexpected caller %+v", first)
+	}
+	second := callerAt(0)
+	if callerCache.order.Len() != 1 || callerCache.callers[second.pc] == nil || callerCache.callers[first.pc] != nil {
+		t.Errorf("expected only the most recent caller to be cached")
+	}
+	if callerAt(0) == callerAt(0) {
+		t.Errorf("expected different callers for different call sites")
+	}
+}
+
+func BenchmarkCallerInfo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		callerInfo(0)
+	}
+}
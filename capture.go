@@ -0,0 +1,155 @@
+package logthing
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	captureMaxBatchLines = 100
+	captureFlushDelay    = 500 * time.Millisecond
+)
+
+// ErrOutputAlreadySet is returned by CapturePipe when stdout or stderr of the command has already been set
+var ErrOutputAlreadySet = errors.New("stdout or stderr already set")
+
+// CaptureOption configures CapturePipe
+type CaptureOption func(*lineCapture)
+
+// WithCaptureInference sets rules that infer the severity, type and timestamp of the captured lines (see
+// InferenceRules).
+// Consecutive lines with the same severity and type are batched, continuation lines like stack trace frames keep the
+// severity and type of the previous line. Lines that don't match any rule are logged with the severity and type given
+// to CapturePipe.
+func WithCaptureInference(rules InferenceRules) CaptureOption {
+	return func(lc *lineCapture) {
+		lc.rules = &rules
+	}
+}
+
+// lineCapture is an io.Writer that splits the written data into lines and logs them batched as messages
+type lineCapture struct {
+	mutex    sync.Mutex
+	cmd      *exec.Cmd
+	msgType  string
+	severity Severity
+	stream   string
+	partial  []byte
+	lines    []string
+	timer    *time.Timer
+	rules    *InferenceRules
+	// severity and type of the batched lines (see WithCaptureInference)
+	batchSeverity Severity
+	batchType     string
+}
+
+func (lc *lineCapture) Write(p []byte) (int, error) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.partial = append(lc.partial, p...)
+	for {
+		i := bytes.IndexByte(lc.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lc.addLocked(strings.TrimSuffix(string(lc.partial[:i]), "\r"))
+		lc.partial = lc.partial[i+1:]
+	}
+	if (len(lc.lines) > 0 || len(lc.partial) > 0) && lc.timer == nil {
+		lc.timer = time.AfterFunc(captureFlushDelay, lc.flush)
+	}
+	return len(p), nil
+}
+
+func (lc *lineCapture) flush() {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if lc.timer != nil {
+		lc.timer.Stop()
+		lc.timer = nil
+	}
+	if len(lc.partial) > 0 {
+		lc.addLocked(string(lc.partial))
+		lc.partial = nil
+	}
+	lc.flushLocked()
+}
+
+// addLocked appends the line to the batch. With inference rules, the batch is flushed before if the line's severity or
+// type differ.
+func (lc *lineCapture) addLocked(line string) {
+	if lc.rules != nil {
+		severity, msgType := lc.batchSeverity, lc.batchType
+		if len(lc.lines) == 0 || !isContinuation(line) {
+			severity, msgType = lc.rules.Infer([]string{line}, lc.severity, lc.msgType)
+		}
+		if len(lc.lines) > 0 && (severity != lc.batchSeverity || msgType != lc.batchType) {
+			lc.flushLocked()
+		}
+		lc.batchSeverity, lc.batchType = severity, msgType
+	}
+	lc.lines = append(lc.lines, line)
+	if len(lc.lines) >= captureMaxBatchLines {
+		lc.flushLocked()
+	}
+}
+
+// flushLocked logs the buffered lines as single message
+func (lc *lineCapture) flushLocked() {
+	if len(lc.lines) == 0 {
+		return
+	}
+	msgType, severity := lc.msgType, lc.severity
+	if lc.rules != nil {
+		msgType, severity = lc.batchType, lc.batchSeverity
+	}
+	msg := NewLogMsg(msgType).
+		SetProperty("command", filepath.Base(lc.cmd.Path)).
+		SetProperty("stream", lc.stream)
+	if lc.cmd.Process != nil {
+		msg.SetProperty("pid", lc.cmd.Process.Pid)
+	}
+	msg.SetSeverity(severity)
+	if lc.rules != nil {
+		if timestamp, ok := lc.rules.InferTimestamp(lc.lines); ok {
+			msg.SetTimestamp(timestamp)
+		}
+	}
+	msg.msgData().output = lc.lines
+	msg.msgData().resolved = true // captured lines are plain text without placeholders
+	lc.lines = nil
+	Log(msg)
+}
+
+// CapturePipe streams the lines of the child process's stdout and stderr into structured messages of given type and
+// severity (with "command", "stream" and "pid" properties). Lines are batched into messages of up to 100 lines, that are
+// logged at the latest 500ms after the first line. The severity and type of plain-text lines can be inferred with
+// WithCaptureInference. Must be called before the command is started. The returned flush function logs the remaining
+// lines (including a last line without newline) and should be called after cmd.Wait returned, so that the final output
+// isn't lost when the dispatcher is closed before the 500ms passed:
+//
+//	flush, err := logthing.CapturePipe(cmd, "tool", logthing.SeverityInfo)
+//	...
+//	err = cmd.Run()
+//	flush()
+func CapturePipe(cmd *exec.Cmd, msgType string, severity Severity, opts ...CaptureOption) (flush func(), err error) {
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, ErrOutputAlreadySet
+	}
+	stdout := &lineCapture{cmd: cmd, msgType: msgType, severity: severity, stream: "stdout"}
+	stderr := &lineCapture{cmd: cmd, msgType: msgType, severity: severity, stream: "stderr"}
+	for _, opt := range opts {
+		opt(stdout)
+		opt(stderr)
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return func() {
+		stdout.flush()
+		stderr.flush()
+	}, nil
+}
@@ -0,0 +1,43 @@
+package logthing
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestCapturePipeFlush(t *testing.T) {
+	var err error
+	ld, err = newLogDispatcher(nil, WithDispatchInterval(time.Hour), WithRecentMessages(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	cmd := exec.Command("sh", "-c", `printf 'first\nlast without newline'; printf 'failure\n' >&2`)
+	flush, err := CapturePipe(cmd, "tool", SeverityInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	messages := RecentMessages(MessageFilter{})
+	if len(messages) != 2 {
+		t.Fatalf("expected the output of stdout and stderr to be logged without delay, got %v", messages)
+	}
+	output := map[string][]string{}
+	for _, msg := range messages {
+		output[msg.Property("stream").(string)] = msg.Output()
+	}
+	if stdout := output["stdout"]; len(stdout) != 2 || stdout[0] != "first" || stdout[1] != "last without newline" {
+		t.Errorf("expected all stdout lines, got %q", stdout)
+	}
+	if stderr := output["stderr"]; len(stderr) != 1 || stderr[0] != "failure" {
+		t.Errorf("expected stderr line, got %q", stderr)
+	}
+	if _, err := CapturePipe(cmd, "tool", SeverityInfo); !errors.Is(err, ErrOutputAlreadySet) {
+		t.Errorf("expected ErrOutputAlreadySet, got %v", err)
+	}
+}
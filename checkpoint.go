@@ -0,0 +1,101 @@
+package logthing
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// WithCheckpointFile sets the file in which the checkpoints of writers that implement logwriter.CheckpointedWriter are
+// persisted after every written batch. When the dispatcher is initialized, the writers are resumed with their last
+// persisted checkpoint (writers are identified by their name, so that checkpointed writers must have unique names).
+func WithCheckpointFile(path string) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.checkpointFile = path
+	}
+}
+
+// checkpointStore persists the checkpoints of the writers by writer name
+type checkpointStore struct {
+	mutex       sync.Mutex
+	path        string
+	checkpoints map[string]logwriter.Checkpoint
+}
+
+// loadCheckpoints reads the persisted checkpoints. A missing file is an empty store.
+func loadCheckpoints(path string) (*checkpointStore, error) {
+	store := &checkpointStore{path: path, checkpoints: map[string]logwriter.Checkpoint{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return store, err
+	}
+	return store, json.Unmarshal(data, &store.checkpoints)
+}
+
+// resume resumes the writer with its persisted checkpoint if it is a logwriter.CheckpointedWriter
+func (s *checkpointStore) resume(lw logwriter.LogWriter) error {
+	cw, ok := lw.(logwriter.CheckpointedWriter)
+	if s == nil || !ok {
+		return nil
+	}
+	s.mutex.Lock()
+	checkpoint, ok := s.checkpoints[writerName(lw)]
+	s.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	return cw.Resume(checkpoint)
+}
+
+// store durably persists the writer's checkpoint (the file is synced and atomically replaced). Checkpoints must be
+// stored in order, so that checkpointed writers are never written concurrently (see WithWriterConcurrency).
+func (s *checkpointStore) store(lw logwriter.LogWriter, checkpoint logwriter.Checkpoint) error {
+	if s == nil || checkpoint == "" {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.checkpoints[writerName(lw)] = checkpoint
+	data, err := json.Marshal(s.checkpoints)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+// syncDir syncs the directory, so that a rename within it is durable. Directories that can't be opened (e.g. on
+// Windows) are skipped.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
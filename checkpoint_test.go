@@ -0,0 +1,59 @@
+package logthing
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// checkpointWriter returns the sequence number of the written batches as checkpoint
+type checkpointWriter struct {
+	soakWriter
+	seq     int
+	resumed logwriter.Checkpoint
+}
+
+func (w *checkpointWriter) WriteBatchCheckpointed(batch logwriter.Batch) (logwriter.Checkpoint, error) {
+	w.seq++
+	return logwriter.Checkpoint(strconv.Itoa(w.seq)), w.WriteLogMessages(batch.LogMessages, batch.Timestamps)
+}
+
+func (w *checkpointWriter) Resume(checkpoint logwriter.Checkpoint) error {
+	w.resumed = checkpoint
+	w.seq, _ = strconv.Atoi(string(checkpoint))
+	return nil
+}
+
+func TestCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	writer := &checkpointWriter{}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithMaxBatchSize(1), WithCheckpointFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Info("first"))
+	ld.log(1, NewLogMsg("test").Info("second"))
+	ld.close()
+	if writer.written != 2 || writer.resumed != "" {
+		t.Fatalf("expected 2 written messages without resume, got %v (%q)", writer.written, writer.resumed)
+	}
+	// crash and restart
+	writer = &checkpointWriter{}
+	ld, err = newLogDispatcher([]logwriter.LogWriter{writer}, WithCheckpointFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.close()
+	if writer.resumed != "2" {
+		t.Errorf("expected writer to be resumed from checkpoint 2, got %q", writer.resumed)
+	}
+	// checkpoints must be stored in order, so that checkpointed writers aren't written concurrently
+	options := dispatcherOptions{}
+	WithWriterConcurrency(writer, 4)(&options)
+	if pool := ld.writerPool(writer, options); pool != nil {
+		t.Error("expected no writer pool for checkpointed writer")
+	}
+}
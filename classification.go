@@ -0,0 +1,211 @@
+package logthing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// Classification is the data classification of a property (see SetClassifiedProperty)
+type Classification int
+
+const (
+	// Unclassified properties are written by all writers as they are
+	Unclassified Classification = iota
+	// PII marks personally identifiable information, e.g. user names, email or ip addresses
+	PII
+	// Confidential marks confidential business data
+	Confidential
+)
+
+// ClassificationAction determines how a writer writes classified properties (see WithClassificationPolicy)
+type ClassificationAction int
+
+const (
+	// ClassificationKeep writes classified properties verbatim (default)
+	ClassificationKeep ClassificationAction = iota
+	// ClassificationHash replaces classified property values by their hex encoded SHA-256 hash (HMAC-SHA256 with
+	// WithClassificationHashKey), so that they can still be correlated
+	ClassificationHash
+	// ClassificationDrop removes classified properties
+	ClassificationDrop
+)
+
+// classificationPolicy is the action of a writer for a classification
+type classificationPolicy struct {
+	writer         logwriter.LogWriter
+	classification Classification
+	action         ClassificationAction
+}
+
+// classificationOptions configures how writers write classified properties
+type classificationOptions struct {
+	policies []classificationPolicy
+	hashKey  []byte
+}
+
+// WithClassificationPolicy sets how the given writer writes properties of the given classification (see
+// SetClassifiedProperty), e.g. hash PII for Log Analytics, keep it verbatim for an EU-region cluster and drop it for
+// the archive:
+//
+//	logthing.WithClassificationPolicy(azureMonitor, logthing.PII, logthing.ClassificationHash),
+//	logthing.WithClassificationPolicy(archive, logthing.PII, logthing.ClassificationDrop),
+//
+// Writers without policy for a classification keep the properties verbatim.
+func WithClassificationPolicy(lw logwriter.LogWriter, classification Classification, action ClassificationAction) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		policies := make([]classificationPolicy, 0, len(opt.classification.policies)+1)
+		for _, policy := range opt.classification.policies {
+			if policy.writer != lw || policy.classification != classification {
+				policies = append(policies, policy)
+			}
+		}
+		opt.classification.policies = append(policies, classificationPolicy{writer: lw, classification: classification, action: action})
+	}
+}
+
+// WithClassificationHashKey sets the key with which classified properties are hashed (HMAC-SHA256) for writers with
+// ClassificationHash policy, so that hashed values can't be reversed by hashing known values
+func WithClassificationHashKey(key []byte) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.classification.hashKey = append([]byte{}, key...)
+	}
+}
+
+// SetClassifiedProperty like SetProperty but tags the property with given data classification, so that writers can
+// hash, keep or drop it (see WithClassificationPolicy)
+func (lm *logMsg) SetClassifiedProperty(key string, value interface{}, classification Classification) LogMsg {
+	if lm == nil {
+		return lm.Self()
+	}
+	if classification == Unclassified {
+		delete(lm.classifications, key)
+	} else {
+		if lm.classifications == nil {
+			lm.classifications = map[string]Classification{}
+		}
+		lm.classifications[key] = classification
+	}
+	return lm.SetProperty(key, value)
+}
+
+// classifiedMsg is a message with classified properties or drifted property kinds, whose properties are kept to
+// marshal it again for writers with classification or schema drift policies
+type classifiedMsg struct {
+	properties      map[string]interface{}
+	classifications map[string]Classification
+	drifts          []schemaDrift
+}
+
+// actions returns the actions of the writer by classification or nil if the writer keeps all properties
+func (c classificationOptions) actions(lw logwriter.LogWriter) (actions map[Classification]ClassificationAction) {
+	for _, policy := range c.policies {
+		if policy.writer == lw && policy.action != ClassificationKeep {
+			if actions == nil {
+				actions = map[Classification]ClassificationAction{}
+			}
+			actions[policy.classification] = policy.action
+		}
+	}
+	return actions
+}
+
+// apply returns the messages with the classified properties hashed or dropped according to the writer's policies.
+// classified contains the classified messages with the same index as the raw messages (nil for other messages).
+// Messages that can't be marshalled after applying the policies are dropped instead of being written with the
+// classified properties unchanged. In this case the timestamps and severities are returned without the dropped messages
+// and the (first) marshal error is returned.
+func (c classificationOptions) apply(lw logwriter.LogWriter, rawLogMessages []json.RawMessage, timestamps []time.Time, severities []Severity, classified []*classifiedMsg, marshal marshalFunc) ([]json.RawMessage, []time.Time, []Severity, error) {
+	actions := c.actions(lw)
+	if actions == nil {
+		return rawLogMessages, timestamps, severities, nil
+	}
+	var mac hash.Hash
+	if len(c.hashKey) > 0 {
+		mac = hmac.New(sha256.New, c.hashKey)
+	} else {
+		mac = sha256.New()
+	}
+	var applied []json.RawMessage
+	var failed []bool
+	var marshalErr error
+	for i, msg := range classified {
+		if msg == nil {
+			continue
+		}
+		properties := make(map[string]interface{}, len(msg.properties))
+		for key, value := range msg.properties {
+			properties[key] = value
+		}
+		changed := false
+		for key, classification := range msg.classifications {
+			value, ok := properties[key]
+			if !ok {
+				continue
+			}
+			switch actions[classification] {
+			case ClassificationHash:
+				properties[key] = hashValue(mac, value)
+				changed = true
+			case ClassificationDrop:
+				delete(properties, key)
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		rawLogMessage, err := marshal(properties)
+		if err != nil {
+			if failed == nil {
+				failed = make([]bool, len(rawLogMessages))
+				marshalErr = err
+			}
+			failed[i] = true
+			continue
+		}
+		if applied == nil {
+			applied = append([]json.RawMessage{}, rawLogMessages...)
+		}
+		applied[i] = rawLogMessage
+	}
+	if applied == nil {
+		applied = rawLogMessages
+	}
+	if failed == nil {
+		return applied, timestamps, severities, nil
+	}
+	var kept []json.RawMessage
+	var keptTimestamps []time.Time
+	var keptSeverities []Severity
+	for i := range applied {
+		if !failed[i] {
+			kept = append(kept, applied[i])
+			keptTimestamps = append(keptTimestamps, timestamps[i])
+			keptSeverities = append(keptSeverities, severities[i])
+		}
+	}
+	return kept, keptTimestamps, keptSeverities, fmt.Errorf("applying classification policies failed, %v messages dropped: %w", len(applied)-len(kept), marshalErr)
+}
+
+// hashValue returns the hex encoded hash of the value. Strings are hashed as they are, other values JSON marshalled.
+func hashValue(mac hash.Hash, value interface{}) string {
+	if sp, ok := value.(sProp); ok {
+		value = sp.value
+	}
+	var data []byte
+	if s, ok := value.(string); ok {
+		data = []byte(s)
+	} else {
+		data, _ = json.Marshal(value)
+	}
+	mac.Reset()
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,56 @@
+package logthing
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestClassificationPolicy(t *testing.T) {
+	var hashing, dropping, keeping logwriter.LogWriter = new(struct{ logwriter.LogWriter }), new(struct{ logwriter.LogWriter }), new(struct{ logwriter.LogWriter })
+	options := dispatcherOptions{}
+	WithClassificationPolicy(hashing, PII, ClassificationHash)(&options)
+	WithClassificationPolicy(dropping, PII, ClassificationDrop)(&options)
+
+	msg := NewLogMsg("login").SetClassifiedProperty("email", "jane@example.com", PII).SetProperty("status", 200).msgData()
+	raw, _ := json.Marshal(msg.Properties())
+	classified := []*classifiedMsg{{properties: msg.Properties(), classifications: msg.classifications}}
+	timestamps, severities := []time.Time{time.Now()}, []Severity{SeverityInfo}
+
+	hashed, _, _, _ := options.classification.apply(hashing, []json.RawMessage{raw}, timestamps, severities, classified, options.marshal)
+	if strings.Contains(string(hashed[0]), "jane") || !strings.Contains(string(hashed[0]), `"status":200`) {
+		t.Errorf("expected hashed email: %s", hashed[0])
+	}
+	dropped, _, _, _ := options.classification.apply(dropping, []json.RawMessage{raw}, timestamps, severities, classified, options.marshal)
+	if string(dropped[0]) != `{"status":200}` {
+		t.Errorf("expected dropped email: %s", dropped[0])
+	}
+	kept, _, _, _ := options.classification.apply(keeping, []json.RawMessage{raw}, timestamps, severities, classified, options.marshal)
+	if string(kept[0]) != string(raw) {
+		t.Errorf("expected kept email: %s", kept[0])
+	}
+}
+
+func TestClassificationPolicyMarshalError(t *testing.T) {
+	var hashing logwriter.LogWriter = new(struct{ logwriter.LogWriter })
+	options := dispatcherOptions{}
+	WithClassificationPolicy(hashing, PII, ClassificationHash)(&options)
+	msg := NewLogMsg("login").SetClassifiedProperty("email", "jane@example.com", PII).msgData()
+	raws := []json.RawMessage{json.RawMessage(`{"email":"jane@example.com"}`), json.RawMessage(`{"status":200}`)}
+	classified := []*classifiedMsg{{properties: msg.Properties(), classifications: msg.classifications}, nil}
+	timestamps, severities := []time.Time{time.Now(), time.Now()}, []Severity{SeverityInfo, SeverityError}
+	marshalErr := errors.New("marshal failed")
+	marshal := func(properties map[string]interface{}) (json.RawMessage, error) { return nil, marshalErr }
+
+	applied, appliedTimestamps, appliedSeverities, err := options.classification.apply(hashing, raws, timestamps, severities, classified, marshal)
+	if !errors.Is(err, marshalErr) {
+		t.Errorf("expected marshal error, got %v", err)
+	}
+	if len(applied) != 1 || string(applied[0]) != `{"status":200}` || len(appliedTimestamps) != 1 || appliedSeverities[0] != SeverityError {
+		t.Errorf("expected unhashed message to be dropped, got %s", applied)
+	}
+}
@@ -0,0 +1,85 @@
+package logthing
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// PropertyLabels contains the labels that have been attached to the message by the classifier (see WithClassifier)
+const PropertyLabels = "labels"
+
+// ErrSampledOut is returned when the message has been dropped by a label sample rate (see WithLabelSampleRate)
+var ErrSampledOut error = errors.New("LogMessage sampled out")
+
+// WithClassifier sets function that computes labels like "known_noise" or "security_relevant" for every message with
+// user-supplied heuristics or models before it is dispatched. The labels are attached as "labels" property and can be
+// referenced by sampling rules (see WithLabelSampleRate) and message filters (see MessageFilter).
+func WithClassifier(classifier func(LogMsg) (labels []string)) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.classifier = classifier
+	}
+}
+
+// WithLabelSampleRate sets the fraction (0 <= rate < 1) of messages with the given label (see WithClassifier) that are
+// written, e.g. to keep only 1% of "known_noise" messages. Written messages are marked with their sample rate (see
+// MarkSampled). Messages with severity <= SeverityError are always written.
+func WithLabelSampleRate(label string, rate float64) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		labelSampleRates := map[string]float64{}
+		for l, r := range opt.labelSampleRates {
+			labelSampleRates[l] = r
+		}
+		labelSampleRates[label] = rate
+		opt.labelSampleRates = labelSampleRates
+	}
+}
+
+// Labels returns the labels of the message (see WithClassifier)
+func Labels(msg LogMsg) []string {
+	labels, _ := msg.Property(PropertyLabels).([]string)
+	return labels
+}
+
+// HasLabel returns true if the message has the given label (see WithClassifier)
+func HasLabel(msg LogMsg, label string) bool {
+	for _, l := range Labels(msg) {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// classify attaches the labels computed by the classifier to the message
+func classify(msg *logMsg, classifier func(LogMsg) []string) {
+	labels := classifier(msg.Self())
+	if len(labels) == 0 {
+		return
+	}
+	merged := append([]string{}, Labels(msg)...)
+	for _, label := range labels {
+		if !HasLabel(msg, label) {
+			merged = append(merged, label)
+		}
+	}
+	msg.SetProperty(PropertyLabels, merged)
+}
+
+// sampleLabels returns false if the message shall be dropped according to the label sample rates. Kept messages are
+// marked with their sample rate.
+func sampleLabels(msg *logMsg, labelSampleRates map[string]float64) bool {
+	if msg.severity <= SeverityError {
+		return true
+	}
+	for _, label := range Labels(msg) {
+		rate, ok := labelSampleRates[label]
+		if !ok || rate >= 1 {
+			continue
+		}
+		if rate <= 0 || rand.Float64() >= rate {
+			return false
+		}
+		MarkSampled(msg, rate)
+	}
+	return true
+}
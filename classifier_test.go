@@ -0,0 +1,34 @@
+package logthing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifier(t *testing.T) {
+	ld, _ := newLogDispatcher(nil, WithDispatchInterval(time.Hour),
+		WithClassifier(func(msg LogMsg) []string {
+			if strings.HasPrefix(msg.Type(), "health") {
+				return []string{"known_noise"}
+			}
+			return nil
+		}),
+		WithLabelSampleRate("known_noise", 0))
+	defer ld.close()
+	options := ld.currentOptions()
+	if _, err := ld.admit(1, NewLogMsg("health_check").Info("ok"), options); err != ErrSampledOut {
+		t.Errorf("expected noise to be sampled out, got %v", err)
+	}
+	msg, err := ld.admit(1, NewLogMsg("health_check").Error("failed"), options)
+	if err != nil || !HasLabel(msg, "known_noise") {
+		t.Errorf("expected labeled error to be kept, got %v", err)
+	}
+	if !newMessageMatcher(MessageFilter{Labels: []string{"known_noise"}}).matches(msg) {
+		t.Errorf("expected filter to match label")
+	}
+	msg, err = ld.admit(1, NewLogMsg("payment").Info("paid"), options)
+	if err != nil || len(Labels(msg)) != 0 {
+		t.Errorf("expected unlabeled message, got %v: %v", Labels(msg), err)
+	}
+}
@@ -0,0 +1,216 @@
+package logthing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// PropertyCloudProvider contains the cloud provider (azure, aws or gcp) (see WithCloudMetadataEnrichment)
+	PropertyCloudProvider = "cloud.provider"
+	// PropertyCloudRegion contains the region of the instance (see WithCloudMetadataEnrichment)
+	PropertyCloudRegion = "cloud.region"
+	// PropertyCloudZone contains the availability zone of the instance (see WithCloudMetadataEnrichment)
+	PropertyCloudZone = "cloud.zone"
+	// PropertyCloudInstance contains the VM / instance name (see WithCloudMetadataEnrichment)
+	PropertyCloudInstance = "cloud.instance"
+	// PropertyCloudScaleSet contains the Azure VM scale set name (see WithCloudMetadataEnrichment)
+	PropertyCloudScaleSet = "cloud.vmss"
+	// PropertyK8sNode contains the Kubernetes node name (see WithCloudMetadataEnrichment)
+	PropertyK8sNode = "k8s.node"
+	// PropertyK8sNamespace contains the Kubernetes namespace (see WithCloudMetadataEnrichment)
+	PropertyK8sNamespace = "k8s.namespace"
+	// PropertyK8sPod contains the Kubernetes pod name (see WithCloudMetadataEnrichment)
+	PropertyK8sPod = "k8s.pod"
+)
+
+// metadata endpoints (variables to be replaced in tests)
+var (
+	azureIMDSEndpoint      = "http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01"
+	awsTokenEndpoint       = "http://169.254.169.254/latest/api/token"
+	awsIdentityEndpoint    = "http://169.254.169.254/latest/dynamic/instance-identity/document"
+	gcpMetadataEndpoint    = "http://metadata.google.internal/computeMetadata/v1/instance/"
+	k8sNamespaceFile       = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	cloudMetadataTimeout   = 2 * time.Second
+	cloudMetadataProviders = []func(ctx context.Context, client *http.Client) map[string]interface{}{
+		azureMetadata,
+		awsMetadata,
+		gcpMetadata,
+	}
+)
+
+// WithCloudMetadataEnrichment enables that every message is stamped with the region, zone, VM / instance and VM scale
+// set name of the cloud instance (Azure IMDS, AWS or GCP metadata endpoints) as well as the Kubernetes node, namespace
+// and pod (downward API environment variables NODE_NAME, POD_NAMESPACE and POD_NAME). The metadata is queried once in
+// the background when the dispatcher is initialized, so that messages that are logged before might not be stamped.
+// Static properties (see WithSetStaticProperties) take precedence.
+func WithCloudMetadataEnrichment() func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.cloudMetadata = true
+	}
+}
+
+// loadCloudMetadata queries the cloud metadata and stores it to be stamped on every message
+func (ld *logDispatcher) loadCloudMetadata() {
+	defer ld.background.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-ld.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	properties := cloudMetadata(ctx)
+	if len(properties) > 0 {
+		ld.cloudMetadata.Store(properties)
+	}
+}
+
+// cloudMetadata returns the metadata of the first cloud provider that responds and the Kubernetes metadata
+func cloudMetadata(ctx context.Context) map[string]interface{} {
+	properties := k8sMetadata()
+	client := &http.Client{Timeout: cloudMetadataTimeout, Transport: &http.Transport{Proxy: nil}}
+	for _, provider := range cloudMetadataProviders {
+		if metadata := provider(ctx, client); metadata != nil {
+			for k, v := range metadata {
+				properties[k] = v
+			}
+			break
+		}
+	}
+	return properties
+}
+
+// k8sMetadata returns the Kubernetes metadata from the downward API environment variables
+func k8sMetadata() map[string]interface{} {
+	properties := map[string]interface{}{}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return properties
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		properties[PropertyK8sNode] = node
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		if data, err := os.ReadFile(k8sNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace != "" {
+		properties[PropertyK8sNamespace] = namespace
+	}
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	if pod != "" {
+		properties[PropertyK8sPod] = pod
+	}
+	return properties
+}
+
+// metadataRequest requests the metadata endpoint and returns the response body or nil on failure
+func metadataRequest(ctx context.Context, client *http.Client, method string, url string, header map[string]string) []byte {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// azureMetadata returns the metadata of the Azure Instance Metadata Service
+func azureMetadata(ctx context.Context, client *http.Client) map[string]interface{} {
+	body := metadataRequest(ctx, client, http.MethodGet, azureIMDSEndpoint, map[string]string{"Metadata": "true"})
+	var compute struct {
+		Location       string `json:"location"`
+		Zone           string `json:"zone"`
+		Name           string `json:"name"`
+		VMScaleSetName string `json:"vmScaleSetName"`
+	}
+	if body == nil || json.Unmarshal(body, &compute) != nil {
+		return nil
+	}
+	return nonEmptyProperties(map[string]interface{}{
+		PropertyCloudProvider: "azure",
+		PropertyCloudRegion:   compute.Location,
+		PropertyCloudZone:     compute.Zone,
+		PropertyCloudInstance: compute.Name,
+		PropertyCloudScaleSet: compute.VMScaleSetName,
+	})
+}
+
+// awsMetadata returns the metadata of the AWS instance identity document (IMDSv2)
+func awsMetadata(ctx context.Context, client *http.Client) map[string]interface{} {
+	token := metadataRequest(ctx, client, http.MethodPut, awsTokenEndpoint, map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if token == nil {
+		return nil
+	}
+	body := metadataRequest(ctx, client, http.MethodGet, awsIdentityEndpoint, map[string]string{"X-aws-ec2-metadata-token": string(token)})
+	var identity struct {
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceID       string `json:"instanceId"`
+	}
+	if body == nil || json.Unmarshal(body, &identity) != nil {
+		return nil
+	}
+	return nonEmptyProperties(map[string]interface{}{
+		PropertyCloudProvider: "aws",
+		PropertyCloudRegion:   identity.Region,
+		PropertyCloudZone:     identity.AvailabilityZone,
+		PropertyCloudInstance: identity.InstanceID,
+	})
+}
+
+// gcpMetadata returns the metadata of the GCP metadata server
+func gcpMetadata(ctx context.Context, client *http.Client) map[string]interface{} {
+	header := map[string]string{"Metadata-Flavor": "Google"}
+	zone := metadataRequest(ctx, client, http.MethodGet, gcpMetadataEndpoint+"zone", header)
+	if zone == nil {
+		return nil
+	}
+	// zone is returned as projects/<project number>/zones/<zone>
+	zoneName := string(zone[strings.LastIndex(string(zone), "/")+1:])
+	region := zoneName
+	if i := strings.LastIndex(zoneName, "-"); i > 0 {
+		region = zoneName[:i]
+	}
+	return nonEmptyProperties(map[string]interface{}{
+		PropertyCloudProvider: "gcp",
+		PropertyCloudRegion:   region,
+		PropertyCloudZone:     zoneName,
+		PropertyCloudInstance: string(metadataRequest(ctx, client, http.MethodGet, gcpMetadataEndpoint+"name", header)),
+	})
+}
+
+// nonEmptyProperties removes properties with empty string values
+func nonEmptyProperties(properties map[string]interface{}) map[string]interface{} {
+	for k, v := range properties {
+		if v == "" {
+			delete(properties, k)
+		}
+	}
+	return properties
+}
@@ -0,0 +1,42 @@
+package logthing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCloudMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"location":"westeurope","zone":"1","name":"vm_3","vmScaleSetName":"workers"}`))
+	}))
+	defer server.Close()
+	defer func(endpoint string) { azureIMDSEndpoint = endpoint }(azureIMDSEndpoint)
+	azureIMDSEndpoint = server.URL
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("NODE_NAME", "node-1")
+	t.Setenv("POD_NAMESPACE", "payments")
+	t.Setenv("POD_NAME", "api-7d9f")
+
+	properties := cloudMetadata(context.Background())
+	expected := map[string]interface{}{
+		PropertyCloudProvider: "azure",
+		PropertyCloudRegion:   "westeurope",
+		PropertyCloudZone:     "1",
+		PropertyCloudInstance: "vm_3",
+		PropertyCloudScaleSet: "workers",
+		PropertyK8sNode:       "node-1",
+		PropertyK8sNamespace:  "payments",
+		PropertyK8sPod:        "api-7d9f",
+	}
+	for k, v := range expected {
+		if properties[k] != v {
+			t.Errorf("expected %v to be %v, got %v", k, v, properties[k])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mfmayer/logthing"
+)
+
+// patternFlags collects the regular expressions given with -start
+type patternFlags []*regexp.Regexp
+
+func (p *patternFlags) String() string {
+	patterns := make([]string, len(*p))
+	for i, pattern := range *p {
+		patterns[i] = pattern.String()
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (p *patternFlags) Set(value string) error {
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, pattern)
+	return nil
+}
+
+// severityRuleFlags collects the rules given with -severity
+type severityRuleFlags []logthing.SeverityRule
+
+func (r *severityRuleFlags) String() string {
+	rules := make([]string, len(*r))
+	for i, rule := range *r {
+		rules[i] = fmt.Sprintf("%v=%v", rule.Pattern, rule.Severity)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (r *severityRuleFlags) Set(value string) error {
+	rule, err := logthing.ParseSeverityRule(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+// timestampRuleFlags collects the rules given with -timestamp
+type timestampRuleFlags []logthing.TimestampRule
+
+func (r *timestampRuleFlags) String() string {
+	rules := make([]string, len(*r))
+	for i, rule := range *r {
+		rules[i] = fmt.Sprintf("%v=%v", rule.Pattern, rule.Layout)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (r *timestampRuleFlags) Set(value string) error {
+	rule, err := logthing.ParseTimestampRule(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+// runCollect runs the collect command
+func runCollect(args []string) {
+	var startPatterns, typePatterns patternFlags
+	var severityRules severityRuleFlags
+	var timestampRules timestampRuleFlags
+	flags := flag.NewFlagSet("collect", flag.ExitOnError)
+	configFile := flags.String("config", "", "config file that declares the writers (see logthing.DispatcherFromConfig)")
+	msgType := flags.String("type", "stdin", "type of the logged messages")
+	idle := flags.Duration("idle", 0, "duration after which a pending message is logged if no further line is read (default: 1s)")
+	flags.Var(&startPatterns, "start", "regular expression of lines that start a new message (can be repeated)")
+	flags.Var(&severityRules, "severity", "severity rule as <regexp>=<severity> that takes precedence over the default keywords (can be repeated)")
+	flags.Var(&timestampRules, "timestamp", "timestamp rule as <regexp>=<layout> that takes precedence over the default formats (can be repeated)")
+	flags.Var(&typePatterns, "type-pattern", "regular expression whose first submatch is used as message type (can be repeated)")
+	flags.Parse(args)
+	if *configFile == "" {
+		fmt.Fprintln(os.Stderr, "-config must be given")
+		os.Exit(2)
+	}
+	if err := logthing.DispatcherFromConfig(*configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		var initErrors logthing.WriterInitErrors
+		if !errors.As(err, &initErrors) {
+			os.Exit(2)
+		}
+	}
+	rules := logthing.InferenceRules{
+		Severity:  append(severityRules, logthing.DefaultSeverityRules...),
+		Timestamp: append(timestampRules, logthing.DefaultTimestampRules...),
+	}
+	for _, pattern := range typePatterns {
+		rules.Type = append(rules.Type, logthing.TypeRule{Pattern: pattern})
+	}
+	opts := []logthing.CollectOption{logthing.WithCollectStartPatterns(startPatterns...), logthing.WithCollectInference(rules)}
+	if *idle > 0 {
+		opts = append(opts, logthing.WithCollectIdleFlush(*idle))
+	}
+	err := logthing.Collect(os.Stdin, *msgType, opts...)
+	logthing.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,130 @@
+// Command logthing provides helpers for logs written with logthing.
+//
+// Usage:
+//
+//	logthing verify -key <keyID>=<base64 public key> [-key ...] [file ...]
+//
+// verify verifies the signatures of messages (one JSON message per line, e.g. exported from the log store) that have
+// been signed with logthing.WithMessageSigning. Messages are read from the given files or stdin. Invalid messages are
+// reported with their line number and the command exits with status 1 if any message is invalid.
+//
+//	logthing collect -config <file> [-type <msgType>] [-start <regexp> ...] [-severity <regexp>=<severity> ...] [-type-pattern <regexp> ...] [-timestamp <regexp>=<layout> ...] [-idle <duration>]
+//
+// collect logs the lines piped to stdin (e.g. by other runtimes) with the dispatcher declared in the config file (see
+// logthing.DispatcherFromConfig) until stdin is closed. Multi-line records like Java, Python and Go stack traces are
+// folded into single messages. With -start, lines matching any of the regular expressions start a new message and all
+// other lines are folded into the previous one. The severity of the messages is inferred from keywords like "ERROR",
+// "WARN" or "panic:" and additional -severity rules. The first submatch of a -type-pattern is used as message type.
+// Timestamps at the beginning of the lines (RFC 3339, "2006-01-02 15:04:05", "2006/01/02 15:04:05", syslog and
+// additional -timestamp rules) are used as event time of the messages.
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mfmayer/logthing"
+)
+
+// keyFlags collects the public keys given with -key
+type keyFlags map[string]ed25519.PublicKey
+
+func (k keyFlags) String() string {
+	ids := make([]string, 0, len(k))
+	for id := range k {
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (k keyFlags) Set(value string) error {
+	id, encoded, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("key must be given as <keyID>=<base64 public key>")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid Ed25519 public key of %q", id)
+	}
+	k[id] = ed25519.PublicKey(key)
+	return nil
+}
+
+const usage = `usage:
+  logthing verify -key <keyID>=<base64 public key> [-key ...] [file ...]
+  logthing collect -config <file> [-type <msgType>] [-start <regexp> ...] [-severity <regexp>=<severity> ...] [-type-pattern <regexp> ...] [-timestamp <regexp>=<layout> ...] [-idle <duration>]`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "verify":
+		runVerify(os.Args[2:])
+	case "collect":
+		runCollect(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// runVerify runs the verify command
+func runVerify(args []string) {
+	keys := keyFlags{}
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Var(keys, "key", "public key as <keyID>=<base64 public key> (can be repeated)")
+	flags.Parse(args)
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one -key must be given")
+		os.Exit(2)
+	}
+	invalid := 0
+	if flags.NArg() == 0 {
+		invalid = verify(os.Stdin, "stdin", keys)
+	}
+	for _, name := range flags.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		invalid += verify(file, name, keys)
+		file.Close()
+	}
+	if invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+// verify verifies all messages of the reader and returns the number of invalid messages
+func verify(r io.Reader, name string, keys map[string]ed25519.PublicKey) (invalid int) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line, valid := 0, 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		if err := logthing.VerifySignature(scanner.Bytes(), keys); err != nil {
+			fmt.Printf("%v:%v: %v\n", name, line, err)
+			invalid++
+			continue
+		}
+		valid++
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", name, err)
+		invalid++
+	}
+	fmt.Printf("%v: %v valid, %v invalid\n", name, valid, invalid)
+	return invalid
+}
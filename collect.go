@@ -0,0 +1,136 @@
+package logthing
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxCollectLineLength is the max length of collected lines, longer lines are cut
+const maxCollectLineLength = 1024 * 1024
+
+// CollectOption configures Collect
+type CollectOption func(*collectOptions)
+
+type collectOptions struct {
+	startPatterns []*regexp.Regexp
+	idleFlush     time.Duration
+	rules         InferenceRules
+}
+
+// WithCollectInference sets the rules that infer the severity and type of the collected messages (default:
+// DefaultInferenceRules). Messages that don't match any rule are logged with SeverityInfo and the type given to Collect.
+func WithCollectInference(rules InferenceRules) CollectOption {
+	return func(opt *collectOptions) {
+		opt.rules = rules
+	}
+}
+
+// WithCollectStartPatterns sets the patterns of lines that start a new record (see NewLineGrouper), e.g. for logs
+// whose lines start with a timestamp. By default Java, Python and Go stack traces are grouped.
+func WithCollectStartPatterns(patterns ...*regexp.Regexp) CollectOption {
+	return func(opt *collectOptions) {
+		opt.startPatterns = append(opt.startPatterns, patterns...)
+	}
+}
+
+// WithCollectIdleFlush sets the duration after which the pending record is logged if no further line is read
+// (default: 1s)
+func WithCollectIdleFlush(d time.Duration) CollectOption {
+	return func(opt *collectOptions) {
+		opt.idleFlush = d
+	}
+}
+
+// Collect reads the lines of r (e.g. stdin piped from another process) until it's closed and logs them as messages of
+// given type. Multi-line records like stack traces are folded into a single message (see LineGrouper). The severity of
+// the messages is inferred from keywords like "ERROR" or "WARN" and timestamps at the beginning of the lines are used
+// as event time (see WithCollectInference). Lines longer than 1 MiB are cut and the output of the messages is
+// limited by the output caps (see LOGTHING_MAX_OUTPUT_LINES and LOGTHING_MAX_OUTPUT_BYTES).
+func Collect(r io.Reader, msgType string, opts ...CollectOption) error {
+	options := collectOptions{idleFlush: time.Second, rules: DefaultInferenceRules()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	grouper := NewLineGrouper(options.startPatterns...)
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxCollectLineLength)
+		scanner.Split(scanCutLines(maxCollectLineLength))
+		for scanner.Scan() {
+			lines <- strings.TrimSuffix(scanner.Text(), "\r")
+		}
+		scanErr <- scanner.Err()
+		close(lines)
+	}()
+	idle := time.NewTimer(options.idleFlush)
+	defer idle.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				logRecord(msgType, options.rules, grouper.Flush())
+				return <-scanErr
+			}
+			logRecord(msgType, options.rules, grouper.Add(line))
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(options.idleFlush)
+		case <-idle.C:
+			logRecord(msgType, options.rules, grouper.Flush())
+		}
+	}
+}
+
+// logRecord logs the lines of the record as single message
+func logRecord(msgType string, rules InferenceRules, record []string) {
+	if len(record) == 0 {
+		return
+	}
+	severity, msgType := rules.Infer(record, SeverityInfo, msgType)
+	msg := NewLogMsg(msgType)
+	msg.SetSeverity(severity)
+	if timestamp, ok := rules.InferTimestamp(record); ok {
+		msg.SetTimestamp(timestamp)
+	}
+	for _, line := range record {
+		msg.msgData().addOutputLine(line)
+	}
+	msg.msgData().resolved = true // collected lines are plain text without placeholders
+	Log(msg)
+}
+
+// scanCutLines returns a split function like bufio.ScanLines that cuts lines longer than maxLength instead of failing
+// with bufio.ErrTooLong. The remainder of a cut line is skipped.
+func scanCutLines(maxLength int) bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if skipping {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+		advance, token, err = bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= maxLength {
+			n := maxLength - len("…")
+			for n > 0 && !utf8.RuneStart(data[n]) {
+				n--
+			}
+			skipping = true
+			return maxLength, append(data[:n:n], "…"...), nil
+		}
+		return advance, token, err
+	}
+}
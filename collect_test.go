@@ -0,0 +1,57 @@
+package logthing
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestCollect(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_MAX_OUTPUT_LINES", "3")
+	ReloadConfig()
+	writer := &recordingWriter{}
+	var err error
+	ld, err = newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"ERROR request failed",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.Main.run(Main.java:5)",
+		"\tat com.example.Main.main(Main.java:3)",
+		"\tat java.base/java.lang.Thread.run(Thread.java:829)",
+		strings.Repeat("x", 2*maxCollectLineLength),
+		"WARN after the long line",
+	}, "\n")
+	if err := Collect(strings.NewReader(input), "collected"); err != nil {
+		t.Fatalf("expected long line to be cut, got %v", err)
+	}
+	Close()
+	var outputs [][]string
+	for _, logMessage := range writer.logMessages {
+		var record struct {
+			Output []string `json:"output"`
+		}
+		if err := json.Unmarshal(logMessage, &record); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, record.Output)
+	}
+	if len(outputs) != 4 {
+		t.Fatalf("expected 4 collected messages, got %v", len(outputs))
+	}
+	if len(outputs[1]) != 3 || outputs[1][2] != "… 2 lines truncated" {
+		t.Errorf("expected stack trace within the output caps, got %q", outputs[1])
+	}
+	if len(outputs[2]) != 1 || len(outputs[2][0]) != maxCollectLineLength || !strings.HasSuffix(outputs[2][0], "…") {
+		t.Errorf("expected cut long line")
+	}
+	if len(outputs[3]) != 1 || outputs[3][0] != "WARN after the long line" {
+		t.Errorf("expected line after the long line, got %q", outputs[3])
+	}
+}
@@ -0,0 +1,105 @@
+package logthing
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+const (
+	// PropertyLogEntryID contains the id of the log entry (see WithSetLogEntryID and WithIDGenerator)
+	PropertyLogEntryID = "logEntryID"
+	// PropertyDetailProperties lists the properties that have been moved into the companion message (see WithCompanionRecords)
+	PropertyDetailProperties = "detailProperties"
+	// companionTypeSuffix is appended to the message type of companion messages
+	companionTypeSuffix = "_detail"
+)
+
+// companionOptions configures splitting of large properties into companion messages
+type companionOptions struct {
+	maxPropertySize int
+	archiveWriters  []logwriter.LogWriter
+}
+
+// WithCompanionRecords enables that properties whose JSON size exceeds maxPropertySize bytes (e.g. full request bodies)
+// are moved into a companion message of type "<type>_detail", which is linked to the primary message by its logEntryID.
+// Companion messages are only written to the given archive writers (which must be registered with the dispatcher as
+// well), so that the primary records stay small for e.g. Log Analytics.
+func WithCompanionRecords(maxPropertySize int, archiveWriters ...logwriter.LogWriter) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.companion = companionOptions{
+			maxPropertySize: maxPropertySize,
+			archiveWriters:  append([]logwriter.LogWriter{}, archiveWriters...),
+		}
+	}
+}
+
+// isArchiveWriter returns true if companion messages shall be written to the writer
+func (c companionOptions) isArchiveWriter(lw logwriter.LogWriter) bool {
+	for _, archiveWriter := range c.archiveWriters {
+		if archiveWriter == lw {
+			return true
+		}
+	}
+	return false
+}
+
+// isReservedProperty returns true for properties that are never moved into companion messages
+func isReservedProperty(key string) bool {
+	switch key {
+	case PropertyTimestamp, PropertyType, PropertySeverity, PropertyTrackingID, PropertyOutput, PropertyWhitelist, PropertyLogEntryID:
+		return true
+	}
+	return false
+}
+
+// splitCompanion moves the properties that exceed the max property size into a companion message. If the message has no
+// logEntryID yet, one is set to link both messages. Returns nil if no property exceeds the max size.
+func (ld *logDispatcher) splitCompanion(msg *logMsg, options dispatcherOptions) *logMsg {
+	if options.companion.maxPropertySize <= 0 {
+		return nil
+	}
+	var large []string
+	for key, value := range msg.Properties() {
+		if isReservedProperty(key) {
+			continue
+		}
+		if raw, err := json.Marshal(value); err == nil && len(raw) > options.companion.maxPropertySize {
+			large = append(large, key)
+		}
+	}
+	if len(large) == 0 {
+		return nil
+	}
+	sort.Strings(large)
+	if msg.Property(PropertyLogEntryID) == nil {
+		if options.idGenerator != nil {
+			msg.SetProperty(PropertyLogEntryID, options.idGenerator())
+		} else {
+			msg.SetProperty(PropertyLogEntryID, atomic.AddUint64(&ld.logEntryIDCounter, 1))
+		}
+	}
+	companion := NewLogMsg(msg.logMessageType + companionTypeSuffix).msgData()
+	companion.companion = true
+	companion.timestamp = msg.timestamp
+	companion.severity = msg.severity
+	companion.SetProperty(PropertyTimestamp, msg.timestamp)
+	companion.SetProperty(PropertyType, companion.logMessageType)
+	companion.SetProperty(PropertySeverity, msg.severity)
+	companion.SetProperty(PropertyLogEntryID, msg.Property(PropertyLogEntryID))
+	if msg.trackingID != "" {
+		companion.SetProperty(PropertyTrackingID, msg.trackingID)
+	}
+	properties := msg.Properties()
+	for _, key := range large {
+		companion.setProperty(key, properties[key])
+		delete(properties, key)
+		if classification, ok := msg.classifications[key]; ok {
+			companion.SetClassifiedProperty(key, companion.Property(key), classification)
+		}
+	}
+	msg.SetProperty(PropertyDetailProperties, large)
+	return companion
+}
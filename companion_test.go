@@ -0,0 +1,29 @@
+package logthing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitCompanion(t *testing.T) {
+	ld := &logDispatcher{}
+	options := dispatcherOptions{companion: companionOptions{maxPropertySize: 16}}
+	msg := NewLogMsg("request").SetTrackingID("t").SetProperty("status", 200).SetProperty("body", strings.Repeat("x", 32)).msgData()
+	ld.prepare(msg)
+	companion := ld.splitCompanion(msg, options)
+	if companion == nil {
+		t.Fatal("expected companion message")
+	}
+	if companion.Type() != "request_detail" || !companion.companion {
+		t.Errorf("unexpected companion type: %v", companion.Type())
+	}
+	if msg.Property("body") != nil || companion.Property("body") == nil || msg.Property("status") != 200 {
+		t.Errorf("expected body to be moved into companion: %v %v", msg.Properties(), companion.Properties())
+	}
+	if msg.Property(PropertyLogEntryID) == nil || msg.Property(PropertyLogEntryID) != companion.Property(PropertyLogEntryID) {
+		t.Errorf("expected messages to be linked by logEntryID")
+	}
+	if ld.splitCompanion(NewLogMsg("small").SetProperty("status", 200).msgData(), options) != nil {
+		t.Errorf("expected no companion for small message")
+	}
+}
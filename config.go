@@ -1,37 +1,145 @@
 package logthing
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/mfmayer/logthing/logwriter"
 )
 
+// environmentVariables lists all environment variables that are used by the logthing package
+var environmentVariables = []string{
+	"LOGTHING_LOG_NAME",
+	"LOGTHING_LOG_MAX_SEVERITY",
+	"LOGTHING_PRINT_MAX_SEVERITY",
+	"LOGTHING_WHITELIST_LOG_TYPES",
+	"LOGTHING_WHITELIST_LOG_TYPES_REGEX",
+	"LOGTHING_WHITELIST_PROPERTIES",
+	"LOGTHING_DENY_LOG_TYPES",
+	"LOGTHING_DENY_PROPERTIES",
+	"LOGTHING_PRINT_PROPERTIES",
+	"LOGTHING_MAX_OUTPUT_LINES",
+	"LOGTHING_MAX_OUTPUT_BYTES",
+	"LOGTHING_PRE_INIT_BUFFER",
+	"LOGTHING_PRINT_FOLD_LINES",
+	"LOGTHING_PRINT_EXPAND_SEVERITY",
+	"LOGTHING_PRINT_TIMEZONE",
+	"LOGTHING_PRINT_TIME_LAYOUT",
+	"LOGTHING_PRINT_STREAMS",
+	"LOGTHING_ROUTES",
+	"LOGTHING_CALLER_PROPERTIES",
+	"LOGTHING_OUTPUT_CALLER",
+	"LOGTHING_CALLER_DISABLED_TYPES",
+	"LOGTHING_PREFIX",
+	"LOGTHING_PROFILE",
+	"LOGTHING_AZURE_SUBSCRIPTION_ID",
+	"LOGTHING_AZURE_RESOURCE_GROUP",
+	"LOGTHING_AZURE_WORKSPACE_NAME",
+}
+
+var (
+	// ErrUnknownVariable is reported for LOGTHING_* environment variables that aren't used by logthing (e.g. typos)
+	ErrUnknownVariable = errors.New("unknown environment variable")
+	// ErrInvalidValue is reported for environment variables with values that can't be parsed
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrOutOfRange is reported for environment variables with values that are out of the allowed range
+	ErrOutOfRange = errors.New("value out of range")
+)
+
+// ConfigError describes an issue of the configuration found by ValidateConfig()
+type ConfigError struct {
+	Variable string // name of the environment variable
+	Value    string // value of the environment variable
+	Warning  bool   // true if the issue doesn't prevent logthing from working (e.g. unknown variables)
+	Err      error  // ErrUnknownVariable, ErrInvalidValue or ErrOutOfRange
+	Hint     string // optional hint how to fix the issue
+}
+
+func (e ConfigError) Error() string {
+	msg := fmt.Sprintf("%v=%q: %v", e.Variable, e.Value, e.Err)
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+func (e ConfigError) Unwrap() error {
+	return e.Err
+}
+
 type configStruct struct {
 	logName               string
 	logMaxSeverity        Severity
 	whitelistLogTypes     map[string]struct{}
+	whitelistTypePatterns []string
+	whitelistTypesRegex   *regexp.Regexp
+	denyLogTypes          typeMatcher
+	denyProperties        map[string]struct{}
 	whitelistProperties   map[string]struct{}
 	printMaxSeverity      Severity
 	printOutputProperties map[string]struct{}
+	maxOutputLines        int
+	maxOutputBytes        int
+	printFoldLines        int
+	printExpandSeverity   Severity
+	callerProperties      bool
+	outputCaller          bool
+	callerDisabledTypes   typeMatcher
+	printStreams          map[Severity]io.Writer
 }
 
-var config configStruct = configStruct{
-	logName:               os.Getenv("LOGTHING_LOG_NAME"),
-	logMaxSeverity:        SeverityTrace,
-	whitelistLogTypes:     map[string]struct{}{},
-	whitelistProperties:   map[string]struct{}{},
-	printMaxSeverity:      SeverityError,
-	printOutputProperties: map[string]struct{}{},
+// configValue holds the current *configStruct. ReloadConfig replaces it atomically, so that messages can be logged
+// concurrently; a function that reads several fields should load it once (see currentConfig).
+var configValue atomic.Value
+
+// logNameOverride holds the log name (string) of the config file that overrides LOGTHING_LOG_NAME, also when the
+// configuration is reloaded (see DispatcherFromConfig)
+var logNameOverride atomic.Value
+
+// currentConfig returns the current configuration, which must not be modified
+func currentConfig() *configStruct {
+	return configValue.Load().(*configStruct)
+}
+
+// defaultConfig returns the configuration without environment variables
+func defaultConfig() configStruct {
+	return configStruct{
+		logName:               logwriter.Getenv("LOGTHING_LOG_NAME"),
+		logMaxSeverity:        SeverityTrace,
+		whitelistLogTypes:     map[string]struct{}{},
+		whitelistProperties:   map[string]struct{}{},
+		printMaxSeverity:      SeverityError,
+		printOutputProperties: map[string]struct{}{},
+		printExpandSeverity:   SeverityError,
+		outputCaller:          true,
+	}
 }
 
 func (c configStruct) meetsPrintMaxSeverity(severity Severity) bool {
-	return severity <= config.printMaxSeverity && config.printMaxSeverity != SeverityNotApplied
+	return severity <= c.printMaxSeverity && c.printMaxSeverity != SeverityNotApplied
 }
 
 func (c configStruct) meetsLogMaxSeverity(severity Severity) bool {
-	return severity <= config.logMaxSeverity && config.logMaxSeverity != SeverityNotApplied
+	return severity <= c.logMaxSeverity && c.logMaxSeverity != SeverityNotApplied
+}
+
+// meetsOutputCaps returns true if a message with given number of output lines and output bytes doesn't exceed the
+// configured output caps
+func (c configStruct) meetsOutputCaps(lines int, bytes int) bool {
+	if c.maxOutputLines > 0 && lines >= c.maxOutputLines {
+		return false
+	}
+	return c.maxOutputBytes <= 0 || bytes <= c.maxOutputBytes
 }
 
 func (c configStruct) isWhitelistedProperty(key string) bool {
@@ -48,59 +156,319 @@ func (c configStruct) isWhitelistedProperty(key string) bool {
 }
 
 func (c configStruct) isWhitelisted(logType string) bool {
-	whitelisted := false
-	if len(logType) > 0 {
-		_, whitelisted = c.whitelistLogTypes[logType]
+	if len(logType) == 0 {
+		return false
 	}
-	return whitelisted
+	if _, whitelisted := c.whitelistLogTypes[logType]; whitelisted {
+		return true
+	}
+	if matchesAnyPattern(c.whitelistTypePatterns, logType) {
+		return true
+	}
+	return c.whitelistTypesRegex != nil && c.whitelistTypesRegex.MatchString(logType)
+}
+
+func (c configStruct) isDenied(logType string) bool {
+	return c.denyLogTypes.matches(logType)
+}
+
+func (c configStruct) isDeniedProperty(key string) bool {
+	if key == PropertyOutput || key == PropertyTimestamp || key == PropertyType || key == PropertySeverity || key == PropertyWhitelist {
+		return false
+	}
+	_, denied := c.denyProperties[key]
+	return denied
+}
+
+// typeMatcher matches log types against exact types and glob patterns
+type typeMatcher struct {
+	types    map[string]struct{}
+	patterns []string
+}
+
+func newTypeMatcher(types []string) (m typeMatcher) {
+	m.types = map[string]struct{}{}
+	for logType := range stringSetFromSlice(types) {
+		if isPattern(logType) {
+			m.patterns = append(m.patterns, logType)
+		} else {
+			m.types[logType] = struct{}{}
+		}
+	}
+	return
+}
+
+func (m typeMatcher) matches(logType string) bool {
+	if len(logType) == 0 {
+		return false
+	}
+	if _, ok := m.types[logType]; ok {
+		return true
+	}
+	return matchesAnyPattern(m.patterns, logType)
+}
+
+// list returns all types and patterns of the matcher
+func (m typeMatcher) list() []string {
+	list := append([]string{}, m.patterns...)
+	for logType := range m.types {
+		list = append(list, logType)
+	}
+	return list
+}
+
+// matchesAnyPattern returns true if s matches any of the given glob patterns
+func matchesAnyPattern(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isPattern returns true if the given string contains glob pattern characters
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
 }
 
 func initConfig() {
 	godotenv.Load()
+	config := defaultConfig()
 
+	if logName, _ := logNameOverride.Load().(string); logName != "" {
+		config.logName = logName
+	}
 	if config.logName == "" {
 		config.logName = "default"
 	}
-	if logMaxSeverity, err := strconv.Atoi(os.Getenv("LOGTHING_LOG_MAX_SEVERITY")); err == nil {
-		config.logMaxSeverity = Severity(logMaxSeverity)
+	if logMaxSeverity, err := ParseSeverity(logwriter.Getenv("LOGTHING_LOG_MAX_SEVERITY")); err == nil {
+		config.logMaxSeverity = logMaxSeverity
 	}
-	if printMaxSeverity, err := strconv.Atoi(os.Getenv("LOGTHING_PRINT_MAX_SEVERITY")); err == nil {
-		config.printMaxSeverity = Severity(printMaxSeverity)
+	if printMaxSeverity, err := ParseSeverity(logwriter.Getenv("LOGTHING_PRINT_MAX_SEVERITY")); err == nil {
+		config.printMaxSeverity = printMaxSeverity
 	}
-	config.whitelistProperties = stringSetFromSlice(strings.Split(strings.TrimSpace(os.Getenv("LOGTHING_WHITELIST_PROPERTIES")), ","))
-	config.whitelistLogTypes = stringSetFromSlice(strings.Split(strings.TrimSpace(os.Getenv("LOGTHING_WHITELIST_LOG_TYPES")), ","))
-	config.printOutputProperties = stringSetFromSlice(strings.Split(strings.TrimSpace(os.Getenv("LOGTHING_PRINT_PROPERTIES")), ","))
+	config.whitelistProperties = stringSetFromSlice(strings.Split(strings.TrimSpace(logwriter.Getenv("LOGTHING_WHITELIST_PROPERTIES")), ","))
+	whitelistLogTypes := newTypeMatcher(strings.Split(strings.TrimSpace(logwriter.Getenv("LOGTHING_WHITELIST_LOG_TYPES")), ","))
+	config.whitelistLogTypes = whitelistLogTypes.types
+	config.whitelistTypePatterns = whitelistLogTypes.patterns
+	config.whitelistTypesRegex = nil
+	if expr := strings.TrimSpace(logwriter.Getenv("LOGTHING_WHITELIST_LOG_TYPES_REGEX")); expr != "" {
+		config.whitelistTypesRegex, _ = regexp.Compile(expr)
+	}
+	config.denyLogTypes = newTypeMatcher(strings.Split(strings.TrimSpace(logwriter.Getenv("LOGTHING_DENY_LOG_TYPES")), ","))
+	config.denyProperties = stringSetFromSlice(strings.Split(strings.TrimSpace(logwriter.Getenv("LOGTHING_DENY_PROPERTIES")), ","))
+	config.printOutputProperties = stringSetFromSlice(strings.Split(strings.TrimSpace(logwriter.Getenv("LOGTHING_PRINT_PROPERTIES")), ","))
+	config.maxOutputLines, _ = strconv.Atoi(strings.TrimSpace(logwriter.Getenv("LOGTHING_MAX_OUTPUT_LINES")))
+	config.maxOutputBytes, _ = strconv.Atoi(strings.TrimSpace(logwriter.Getenv("LOGTHING_MAX_OUTPUT_BYTES")))
+	config.printFoldLines, _ = strconv.Atoi(strings.TrimSpace(logwriter.Getenv("LOGTHING_PRINT_FOLD_LINES")))
+	if printExpandSeverity, err := ParseSeverity(logwriter.Getenv("LOGTHING_PRINT_EXPAND_SEVERITY")); err == nil {
+		config.printExpandSeverity = printExpandSeverity
+	}
+	config.callerProperties, _ = strconv.ParseBool(logwriter.Getenv("LOGTHING_CALLER_PROPERTIES"))
+	if outputCaller, err := strconv.ParseBool(logwriter.Getenv("LOGTHING_OUTPUT_CALLER")); err == nil {
+		config.outputCaller = outputCaller
+	}
+	config.callerDisabledTypes = newTypeMatcher(strings.Split(strings.TrimSpace(logwriter.Getenv("LOGTHING_CALLER_DISABLED_TYPES")), ","))
+	config.printStreams, _ = parsePrintStreams(logwriter.Getenv("LOGTHING_PRINT_STREAMS"))
+	configValue.Store(&config)
+}
+
+// lazyConfig reloads the configuration when the first dispatcher is initialized
+var lazyConfig sync.Once
+
+// loadConfigOnce reloads the configuration if it hasn't been reloaded yet, so that environment variables that have been
+// set by the application after the package has been initialized (e.g. by loading a .env file in main) take effect
+func loadConfigOnce() {
+	lazyConfig.Do(ReloadConfig)
+}
+
+// ReloadConfig re-reads the configuration from the environment variables (and the .env file), e.g. after the application
+// has set them. It's called automatically when the first dispatcher is initialized (see InitDispatcher). The new
+// configuration replaces the current one atomically, so that ReloadConfig may be called while messages are logged.
+func ReloadConfig() {
+	initConfig()
+	initPreInitBuffer()
+	initConsoleTime()
+	configureLoggers()
 }
 
 // ConfigLogName returns configured log name (LOGTHING_LOG_NAME)
 func ConfigLogName() string {
-	return config.logName
+	return currentConfig().logName
 }
 
 // ConfigLogMaxSeverity returns configured max severity for which log messages will be written (LOGTHING_LOG_MAX_SEVERITY)
 func ConfigLogMaxSeverity() Severity {
-	return config.logMaxSeverity
+	return currentConfig().logMaxSeverity
 }
 
 // ConfigPrintMaxSeverity returns configure max severity for which log messages will be printed to stdout/stderr (LOGTHING_PRINT_MAX_SEVERITY)
 func ConfigPrintMaxSeverity() Severity {
-	return config.printMaxSeverity
+	return currentConfig().printMaxSeverity
 }
 
-// ConfigWhiteListLogTypes returns list of whitelisted log types (LOGTHING_WHITELIST_LOG_TYPES)
+// ConfigWhiteListLogTypes returns list of whitelisted log types and log type patterns (LOGTHING_WHITELIST_LOG_TYPES)
 func ConfigWhiteListLogTypes() []string {
+	config := currentConfig()
 	types := []string{}
 	for k := range config.whitelistLogTypes {
 		types = append(types, k)
 	}
+	types = append(types, config.whitelistTypePatterns...)
 	return types
 }
 
 // ConfigPrintOutputProperties returns list of properties that are added to stdout/stderr output of log messages (LOGTHING_PRINT_PROPERTIES)
 func ConfigPrintOutputProperties() []string {
+	config := currentConfig()
 	types := []string{}
 	for k := range config.printOutputProperties {
 		types = append(types, k)
 	}
 	return types
 }
+
+// editDistance returns the levenshtein distance of both strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = cur[j-1] + 1
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// validateSeverity validates severity environment variable
+func validateSeverity(name string) *ConfigError {
+	value, ok := logwriter.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	if _, err := ParseSeverity(value); err != nil {
+		if _, numErr := strconv.Atoi(strings.TrimSpace(value)); numErr == nil {
+			return &ConfigError{Variable: name, Value: value, Err: ErrOutOfRange, Hint: "expected number between 0 and 8"}
+		}
+		return &ConfigError{Variable: name, Value: value, Err: ErrInvalidValue, Hint: "expected number between 0 and 8 or severity name"}
+	}
+	return nil
+}
+
+// validateNonNegativeInt validates integer environment variable
+func validateNonNegativeInt(name string) *ConfigError {
+	value, ok := logwriter.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return &ConfigError{Variable: name, Value: value, Err: ErrInvalidValue, Hint: "expected number"}
+	}
+	if n < 0 {
+		return &ConfigError{Variable: name, Value: value, Err: ErrOutOfRange, Hint: "expected number >= 0"}
+	}
+	return nil
+}
+
+// validateBool validates boolean environment variable
+func validateBool(name string) *ConfigError {
+	value, ok := logwriter.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	if _, err := strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+		return &ConfigError{Variable: name, Value: value, Err: ErrInvalidValue, Hint: "expected true or false"}
+	}
+	return nil
+}
+
+// ValidateConfig validates the environment configuration and returns all found issues: Errors for malformed or out of range
+// values (which are silently ignored otherwise) and warnings for unknown LOGTHING_* variables (e.g. typos).
+// See also WithStrictConfig to fail hard on invalid configuration.
+func ValidateConfig() (issues []ConfigError) {
+	for _, name := range []string{"LOGTHING_LOG_MAX_SEVERITY", "LOGTHING_PRINT_MAX_SEVERITY", "LOGTHING_PRINT_EXPAND_SEVERITY"} {
+		if issue := validateSeverity(name); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+	for _, name := range []string{"LOGTHING_MAX_OUTPUT_LINES", "LOGTHING_MAX_OUTPUT_BYTES", "LOGTHING_PRINT_FOLD_LINES", "LOGTHING_PRE_INIT_BUFFER"} {
+		if issue := validateNonNegativeInt(name); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+	for _, name := range []string{"LOGTHING_CALLER_PROPERTIES", "LOGTHING_OUTPUT_CALLER"} {
+		if issue := validateBool(name); issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+	for _, name := range []string{"LOGTHING_WHITELIST_LOG_TYPES", "LOGTHING_DENY_LOG_TYPES"} {
+		for _, pattern := range strings.Split(logwriter.Getenv(name), ",") {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				issues = append(issues, ConfigError{Variable: name, Value: pattern, Err: ErrInvalidValue, Hint: err.Error()})
+			}
+		}
+	}
+	if streams := logwriter.Getenv("LOGTHING_PRINT_STREAMS"); streams != "" {
+		if _, err := parsePrintStreams(streams); err != nil {
+			issues = append(issues, ConfigError{Variable: "LOGTHING_PRINT_STREAMS", Value: streams, Err: ErrInvalidValue, Hint: err.Error()})
+		}
+	}
+	if routes := logwriter.Getenv("LOGTHING_ROUTES"); routes != "" {
+		if _, err := ParseRoutingRules(routes); err != nil {
+			issues = append(issues, ConfigError{Variable: "LOGTHING_ROUTES", Value: routes, Err: ErrInvalidValue, Hint: err.Error()})
+		}
+	}
+	if tz := strings.TrimSpace(logwriter.Getenv("LOGTHING_PRINT_TIMEZONE")); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			issues = append(issues, ConfigError{Variable: "LOGTHING_PRINT_TIMEZONE", Value: tz, Err: ErrInvalidValue, Hint: err.Error()})
+		}
+	}
+	if expr := strings.TrimSpace(logwriter.Getenv("LOGTHING_WHITELIST_LOG_TYPES_REGEX")); expr != "" {
+		if _, err := regexp.Compile(expr); err != nil {
+			issues = append(issues, ConfigError{Variable: "LOGTHING_WHITELIST_LOG_TYPES_REGEX", Value: expr, Err: ErrInvalidValue, Hint: err.Error()})
+		}
+	}
+	known := append(append([]string{}, environmentVariables...), logwriter.EnvironmentVariables()...)
+	knownSet := stringSetFromSlice(known)
+	env := logwriter.CurrentEnv()
+	for _, variable := range os.Environ() {
+		kv := strings.SplitN(variable, "=", 2)
+		name, ok := env.Canonical(kv[0])
+		if !ok {
+			continue
+		}
+		if _, ok := knownSet[name]; ok {
+			continue
+		}
+		issue := ConfigError{Variable: kv[0], Warning: true, Err: ErrUnknownVariable}
+		if len(kv) > 1 {
+			issue.Value = kv[1]
+		}
+		bestDistance := 4
+		for _, knownName := range known {
+			if d := editDistance(name, knownName); d < bestDistance {
+				bestDistance = d
+				issue.Hint = "did you mean " + env.Prefix + knownName + "?"
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package logthing_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mfmayer/logthing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	t.Setenv("LOGTHING_LOG_MAX_SEVERITY", "9")
+	t.Setenv("LOGTHING_PRINT_MAX_SEVERITY", "warning!")
+	t.Setenv("LOGTHING_WHITLIST_LOG_TYPES", "foo")
+
+	issues := map[string]logthing.ConfigError{}
+	for _, issue := range logthing.ValidateConfig() {
+		issues[issue.Variable] = issue
+	}
+	if !errors.Is(issues["LOGTHING_LOG_MAX_SEVERITY"], logthing.ErrOutOfRange) {
+		t.Errorf("expected out of range error, got: %v", issues["LOGTHING_LOG_MAX_SEVERITY"])
+	}
+	if !errors.Is(issues["LOGTHING_PRINT_MAX_SEVERITY"], logthing.ErrInvalidValue) {
+		t.Errorf("expected invalid value error, got: %v", issues["LOGTHING_PRINT_MAX_SEVERITY"])
+	}
+	unknown := issues["LOGTHING_WHITLIST_LOG_TYPES"]
+	if !errors.Is(unknown, logthing.ErrUnknownVariable) || !unknown.Warning || unknown.Hint != "did you mean LOGTHING_WHITELIST_LOG_TYPES?" {
+		t.Errorf("expected unknown variable warning, got: %v", unknown)
+	}
+}
+
+func TestParseSeverity(t *testing.T) {
+	for s, expected := range map[string]logthing.Severity{
+		"3":       logthing.SeverityError,
+		"Warning": logthing.SeverityWarning,
+		"INFO":    logthing.SeverityInfo,
+		"crit":    logthing.SeverityCritical,
+	} {
+		if severity, err := logthing.ParseSeverity(s); err != nil || severity != expected {
+			t.Errorf("ParseSeverity(%q) = %v, %v", s, severity, err)
+		}
+	}
+	if _, err := logthing.ParseSeverity("9"); err == nil {
+		t.Errorf("expected error for out of range severity")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Cleanup(logthing.ReloadConfig)
+	t.Setenv("LOGTHING_LOG_NAME", "reloaded")
+	t.Setenv("LOGTHING_PRINT_MAX_SEVERITY", "trace")
+	logthing.ReloadConfig()
+	if logthing.ConfigLogName() != "reloaded" || logthing.ConfigPrintMaxSeverity() != logthing.SeverityTrace {
+		t.Errorf("expected reloaded configuration, got %v and %v", logthing.ConfigLogName(), logthing.ConfigPrintMaxSeverity())
+	}
+}
+
+func TestReloadConfigWhileLogging(t *testing.T) {
+	t.Cleanup(logthing.ReloadConfig)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			logthing.NewLogMsg("reload").Info("logged while reloading").Log()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		logthing.ReloadConfig()
+	}
+	<-done
+}
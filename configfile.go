@@ -0,0 +1,201 @@
+package logthing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// configDuration is a time.Duration that is unmarshalled from a duration string like "5s"
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(duration)
+	return nil
+}
+
+// writerConfig declares a writer of the config file
+type writerConfig struct {
+	Type    string            `json:"type"`
+	Config  map[string]string `json:"config"`  // config of the writer (for built-in writers their environment variables)
+	Writers []writerConfig    `json:"writers"` // wrapped writers of "failover" and "replicating" writers
+}
+
+// dispatcherConfig is the declarative configuration of the dispatcher (see DispatcherFromConfig)
+type dispatcherConfig struct {
+	LogName                 string                    `json:"logName"`
+	DispatchInterval        configDuration            `json:"dispatchInterval"`
+	TypeDispatchIntervals   map[string]configDuration `json:"typeDispatchIntervals"`
+	QueueSize               int                       `json:"queueSize"`
+	MaxBatchSize            int                       `json:"maxBatchSize"`
+	MaxMessageAge           configDuration            `json:"maxMessageAge"`
+	ImmediateFlushSeverity  string                    `json:"immediateFlushSeverity"`
+	ConsoleFallbackSeverity string                    `json:"consoleFallbackSeverity"`
+	HeartbeatInterval       configDuration            `json:"heartbeatInterval"`
+	RuntimeMetricsInterval  configDuration            `json:"runtimeMetricsInterval"`
+	SetLogEntryID           bool                      `json:"setLogEntryID"`
+	StrictConfig            bool                      `json:"strictConfig"`
+	StaticProperties        map[string]interface{}    `json:"staticProperties"`
+	Routes                  string                    `json:"routes"`
+	Writers                 []writerConfig            `json:"writers"`
+}
+
+// newWriterFromConfig creates the declared writer with the factory that is registered in the logwriter package (see logwriter.Register)
+func newWriterFromConfig(wc writerConfig) (logwriter.LogWriter, error) {
+	writerType := strings.ToLower(wc.Type)
+	switch writerType {
+	case "failover", "replicating":
+		var writers []logwriter.LogWriter
+		for _, nested := range wc.Writers {
+			lw, err := newWriterFromConfig(nested)
+			if err != nil {
+				return nil, err
+			}
+			writers = append(writers, lw)
+		}
+		if writerType == "failover" {
+			if len(writers) == 0 {
+				return nil, fmt.Errorf("failover writer needs at least one writer")
+			}
+			return logwriter.NewFailoverWriter(writers[0], writers[1:]...), nil
+		}
+		if len(writers) != 2 {
+			return nil, fmt.Errorf("replicating writer needs exactly two writers (primary and replica)")
+		}
+		return logwriter.NewReplicatingWriter(writers[0], writers[1]), nil
+	}
+	return logwriter.New(writerType, wc.Config)
+}
+
+// options returns the declared dispatcher options
+func (dc dispatcherConfig) options() (opts []func(*dispatcherOptions), err error) {
+	if dc.DispatchInterval > 0 {
+		opts = append(opts, WithDispatchInterval(time.Duration(dc.DispatchInterval)))
+	}
+	for msgType, interval := range dc.TypeDispatchIntervals {
+		opts = append(opts, WithTypeDispatchInterval(msgType, time.Duration(interval)))
+	}
+	if dc.QueueSize > 0 {
+		opts = append(opts, WithQueueSize(dc.QueueSize))
+	}
+	if dc.MaxBatchSize > 0 {
+		opts = append(opts, WithMaxBatchSize(dc.MaxBatchSize))
+	}
+	if dc.MaxMessageAge > 0 {
+		opts = append(opts, WithMaxMessageAge(time.Duration(dc.MaxMessageAge)))
+	}
+	if dc.ImmediateFlushSeverity != "" {
+		severity, err := ParseSeverity(dc.ImmediateFlushSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("immediateFlushSeverity: %w", err)
+		}
+		opts = append(opts, WithImmediateFlushSeverity(severity))
+	}
+	if dc.ConsoleFallbackSeverity != "" {
+		severity, err := ParseSeverity(dc.ConsoleFallbackSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("consoleFallbackSeverity: %w", err)
+		}
+		opts = append(opts, WithConsoleFallback(severity))
+	}
+	if dc.HeartbeatInterval > 0 {
+		opts = append(opts, WithHeartbeat(time.Duration(dc.HeartbeatInterval)))
+	}
+	if dc.RuntimeMetricsInterval > 0 {
+		opts = append(opts, WithRuntimeMetrics(time.Duration(dc.RuntimeMetricsInterval)))
+	}
+	if dc.SetLogEntryID {
+		opts = append(opts, WithSetLogEntryID())
+	}
+	if dc.StrictConfig {
+		opts = append(opts, WithStrictConfig())
+	}
+	if len(dc.StaticProperties) > 0 {
+		opts = append(opts, WithSetStaticProperties(dc.StaticProperties))
+	}
+	if dc.Routes != "" {
+		rules, err := ParseRoutingRules(dc.Routes)
+		if err != nil {
+			return nil, fmt.Errorf("routes: %w", err)
+		}
+		opts = append(opts, WithRoutingRules(rules))
+	}
+	return opts, nil
+}
+
+// loadDispatcherConfig reads the config file and creates the declared writers and options
+func loadDispatcherConfig(path string) (dc dispatcherConfig, writers []logwriter.LogWriter, opts []func(*dispatcherOptions), err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return dc, nil, nil, fmt.Errorf("YAML config files aren't supported, use JSON instead")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return dc, nil, nil, err
+	}
+	if err = json.Unmarshal(data, &dc); err != nil {
+		return dc, nil, nil, fmt.Errorf("invalid config file %v: %w", path, err)
+	}
+	if opts, err = dc.options(); err != nil {
+		return dc, nil, nil, fmt.Errorf("invalid config file %v: %w", path, err)
+	}
+	for _, wc := range dc.Writers {
+		lw, err := newWriterFromConfig(wc)
+		if err != nil {
+			return dc, nil, nil, fmt.Errorf("invalid config file %v: %w", path, err)
+		}
+		writers = append(writers, lw)
+	}
+	return dc, writers, opts, nil
+}
+
+// DispatcherFromConfig initializes the default dispatcher with the writers and options that are declared in the given
+// JSON config file, so that the log topology can be changed without code changes. YAML files aren't supported, since
+// logthing doesn't depend on a YAML parser; files with ".yaml" or ".yml" extension are rejected. The declared
+// "logName" overrides LOGTHING_LOG_NAME, also when the configuration is reloaded (see ReloadConfig). Writers are configured with their
+// environment variables (with or without "LOGTHING_" prefix) in their "config" object, which take precedence over the
+// environment. Additional options can be given and are applied after the declared options. Example:
+//
+//	{
+//	  "logName": "MyService",
+//	  "dispatchInterval": "5s",
+//	  "immediateFlushSeverity": "critical",
+//	  "writers": [
+//	    {"type": "azuremonitor", "config": {"AZURE_WORKSPACE_ID": "...", "AZURE_WORKSPACE_KEY": "..."}},
+//	    {"type": "failover", "writers": [
+//	      {"type": "elasticsearch", "config": {"ELASTICSEARCH_URL": "https://es:9200"}},
+//	      {"type": "gelf", "config": {"GELF_ADDRESS": "graylog:12201"}}
+//	    ]}
+//	  ]
+//	}
+//
+// Writer types are the names of the writers registered in the logwriter package (see logwriter.Register), e.g. azuremonitor,
+// dataexplorer, elasticsearch, opensearch, fluentforward, gelf, plugin, pulsar and servicebus, as well as failover and replicating
+// (with nested "writers").
+func DispatcherFromConfig(path string, opts ...func(*dispatcherOptions)) error {
+	dc, writers, declaredOpts, err := loadDispatcherConfig(path)
+	if err != nil {
+		return err
+	}
+	logNameOverride.Store(dc.LogName)
+	loadConfigOnce()
+	if dc.LogName != "" {
+		config := *currentConfig()
+		config.logName = dc.LogName
+		configValue.Store(&config)
+	}
+	return InitDispatcher(writers, append(declaredOpts, opts...)...)
+}
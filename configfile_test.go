@@ -0,0 +1,58 @@
+package logthing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestLoadDispatcherConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logthing.json")
+	os.WriteFile(path, []byte(`{
+		"logName": "MyService",
+		"dispatchInterval": "2s",
+		"immediateFlushSeverity": "critical",
+		"writers": [
+			{"type": "elasticsearch", "config": {"ELASTICSEARCH_URL": "http://localhost:9200"}},
+			{"type": "failover", "writers": [{"type": "gelf"}, {"type": "gelf"}]}
+		]
+	}`), 0644)
+	dc, writers, opts, err := loadDispatcherConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.LogName != "MyService" || len(writers) != 2 || len(opts) != 2 {
+		t.Fatalf("unexpected config: %+v, %v writers, %v options", dc, len(writers), len(opts))
+	}
+	// the writer's config is used instead of the environment variables
+	if err := writers[0].Init(logwriter.Config{LogName: dc.LogName}); err != nil {
+		t.Errorf("writer not configured from config file: %v", err)
+	}
+
+	os.WriteFile(path, []byte(`{"writers": [{"type": "unknown"}]}`), 0644)
+	if _, _, _, err := loadDispatcherConfig(path); err == nil {
+		t.Errorf("expected error for unknown writer type")
+	}
+}
+
+func TestDispatcherFromConfigLogName(t *testing.T) {
+	t.Cleanup(func() {
+		Close()
+		logNameOverride.Store("")
+		ReloadConfig()
+	})
+	path := filepath.Join(t.TempDir(), "logthing.json")
+	os.WriteFile(path, []byte(`{"logName": "MyService"}`), 0644)
+	if err := DispatcherFromConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	ReloadConfig()
+	if logName := ConfigLogName(); logName != "MyService" {
+		t.Errorf("expected log name of the config file after reload, got %v", logName)
+	}
+	if err := DispatcherFromConfig(filepath.Join(t.TempDir(), "logthing.yaml")); err == nil {
+		t.Errorf("expected error for YAML config file")
+	}
+}
@@ -0,0 +1,181 @@
+package logthing
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// defaultConsoleTimeLayout is the timestamp layout of the console loggers' standard flags (see log.LstdFlags)
+const defaultConsoleTimeLayout = "2006/01/02 15:04:05"
+
+// consoleTime contains the time zone and layout of console timestamps (see SetConsoleTime)
+var consoleTime = struct {
+	sync.RWMutex
+	location *time.Location
+	layout   string
+}{}
+
+// SetConsoleTime sets the time zone and layout (see time.Layout) of the timestamps of printed messages, e.g.
+// SetConsoleTime(time.Local, "15:04:05.000") for local times without date. It doesn't affect the timestamps of written
+// messages, which are always UTC. A nil location defaults to time.Local and an empty layout to "2006/01/02 15:04:05"
+// (both nil and empty restore the standard log flags).
+// Both can also be set with LOGTHING_PRINT_TIMEZONE (e.g. "UTC", "Local" or "Europe/Berlin") and
+// LOGTHING_PRINT_TIME_LAYOUT. By default the standard log flags (local time with date) are used.
+func SetConsoleTime(location *time.Location, layout string) {
+	consoleTime.Lock()
+	consoleTime.location = location
+	consoleTime.layout = layout
+	if location != nil && layout == "" {
+		consoleTime.layout = defaultConsoleTimeLayout
+	}
+	if layout != "" && location == nil {
+		consoleTime.location = time.Local
+	}
+	consoleTime.Unlock()
+	configureLoggers()
+}
+
+// initConsoleTime sets the console time zone and layout from LOGTHING_PRINT_TIMEZONE and LOGTHING_PRINT_TIME_LAYOUT
+func initConsoleTime() {
+	var location *time.Location
+	if tz := strings.TrimSpace(logwriter.Getenv("LOGTHING_PRINT_TIMEZONE")); tz != "" {
+		location, _ = time.LoadLocation(tz)
+	}
+	layout := logwriter.Getenv("LOGTHING_PRINT_TIME_LAYOUT")
+	if location == nil && layout == "" {
+		return
+	}
+	consoleTime.Lock()
+	consoleTime.location = location
+	consoleTime.layout = layout
+	if location == nil {
+		consoleTime.location = time.Local
+	}
+	if layout == "" {
+		consoleTime.layout = defaultConsoleTimeLayout
+	}
+	consoleTime.Unlock()
+}
+
+// severityOutputs contains the outputs set with SetSeverityOutput
+var severityOutputs = struct {
+	sync.RWMutex
+	outputs [SeverityNotApplied]io.Writer
+}{}
+
+// SetSeverityOutput sets the output of printed messages with the given severity, e.g. os.Stdout for all severities on
+// platforms that treat stderr specially (AWS Lambda, some PaaS), or a custom io.Writer. A nil output restores the
+// default: LOGTHING_PRINT_STREAMS or stderr for severities <= SeverityError and stdout for the others.
+func SetSeverityOutput(severity Severity, output io.Writer) {
+	if severity < SeverityEmergency || severity >= SeverityNotApplied {
+		return
+	}
+	severityOutputs.Lock()
+	severityOutputs.outputs[severity] = output
+	severityOutputs.Unlock()
+	configureLoggers()
+}
+
+// severityOutput returns the output set with SetSeverityOutput or nil
+func severityOutput(severity Severity) io.Writer {
+	severityOutputs.RLock()
+	defer severityOutputs.RUnlock()
+	return severityOutputs.outputs[severity]
+}
+
+// parsePrintStreams parses comma separated severity:stream pairs (e.g. "error:stdout,warning:stderr" or "*:stdout"),
+// where stream is stdout or stderr and severity "*" applies to all severities
+func parsePrintStreams(s string) (map[Severity]io.Writer, error) {
+	streams := map[Severity]io.Writer{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid severity:stream pair %q", pair)
+		}
+		var output io.Writer
+		switch strings.ToLower(strings.TrimSpace(kv[1])) {
+		case "stdout":
+			output = os.Stdout
+		case "stderr":
+			output = os.Stderr
+		default:
+			return nil, fmt.Errorf("invalid stream %q (stdout or stderr)", kv[1])
+		}
+		if strings.TrimSpace(kv[0]) == "*" {
+			for severity := SeverityEmergency; severity < SeverityNotApplied; severity++ {
+				streams[severity] = output
+			}
+			continue
+		}
+		severity, err := ParseSeverity(kv[0])
+		if err != nil || severity == SeverityNotApplied {
+			return nil, fmt.Errorf("invalid severity %q", kv[0])
+		}
+		streams[severity] = output
+	}
+	return streams, nil
+}
+
+// configureLoggers sets the outputs and flags of the console loggers
+func configureLoggers() {
+	isSystemD := (os.Getenv("INVOCATION_ID") != "")
+	consoleTime.RLock()
+	location, layout := consoleTime.location, consoleTime.layout
+	consoleTime.RUnlock()
+	for severityLevel := Severity(0); severityLevel < SeverityNotApplied; severityLevel++ {
+		lg := *loggers[severityLevel]
+		output := loggerOutput(severityLevel)
+		flag := log.LstdFlags
+		switch {
+		case isSystemD:
+			flag = 0
+		case layout != "":
+			flag = 0
+			if output != io.Discard {
+				output = &timestampWriter{output: output, prefixLen: len(lg.Prefix()), location: location, layout: layout}
+			}
+		}
+		lg.SetFlags(flag)
+		lg.SetOutput(output)
+	}
+}
+
+// timestampWriter inserts the timestamp in the configured time zone and layout after the logger's prefix
+type timestampWriter struct {
+	output    io.Writer
+	prefixLen int
+	location  *time.Location
+	layout    string
+}
+
+// Write writes the line with inserted timestamp
+func (tw *timestampWriter) Write(p []byte) (int, error) {
+	if len(p) < tw.prefixLen {
+		return tw.output.Write(p)
+	}
+	buf := logwriter.GetBuffer()
+	defer logwriter.PutBuffer(buf)
+	buf.Write(p[:tw.prefixLen])
+	buf.WriteString(time.Now().In(tw.location).Format(tw.layout))
+	buf.WriteByte(' ')
+	buf.Write(p[tw.prefixLen:])
+	if _, err := tw.output.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// width returns the width of the inserted timestamp
+func (tw *timestampWriter) width() int {
+	return len(time.Now().In(tw.location).Format(tw.layout)) + 1
+}
@@ -0,0 +1,46 @@
+package logthing
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsoleTime(t *testing.T) {
+	defer SetConsoleTime(nil, "")
+	SetConsoleTime(time.UTC, "")
+	tw, ok := Error.Writer().(*timestampWriter)
+	if !ok || Error.Flags() != 0 || tw.location != time.UTC || tw.layout != defaultConsoleTimeLayout {
+		t.Fatalf("expected timestamp writer with UTC and default layout, got %#v", Error.Writer())
+	}
+	buf := &bytes.Buffer{}
+	tw = &timestampWriter{output: buf, prefixLen: len(Error.Prefix()), location: time.UTC, layout: "15:04Z07:00"}
+	Error.SetOutput(tw)
+	Error.Print("hello")
+	if !strings.HasPrefix(buf.String(), Error.Prefix()) || !strings.HasSuffix(buf.String(), "Z hello\n") {
+		t.Errorf("unexpected console line: %q", buf.String())
+	}
+	SetConsoleTime(nil, "")
+	if _, ok := Error.Writer().(*timestampWriter); ok {
+		t.Errorf("expected no timestamp writer after reset")
+	}
+}
+
+func TestSeverityOutput(t *testing.T) {
+	streams, err := parsePrintStreams("*:stdout, error:stderr")
+	if err != nil || streams[SeverityAlert] != os.Stdout || streams[SeverityError] != os.Stderr {
+		t.Errorf("unexpected streams %v: %v", streams, err)
+	}
+	if _, err := parsePrintStreams("error:stdlog"); err == nil {
+		t.Error("expected invalid stream error")
+	}
+	buf := &bytes.Buffer{}
+	SetSeverityOutput(SeverityError, buf)
+	defer SetSeverityOutput(SeverityError, nil)
+	Error.Print("custom")
+	if !strings.HasSuffix(buf.String(), "custom\n") {
+		t.Errorf("expected error output in custom writer, got %q", buf.String())
+	}
+}
@@ -0,0 +1,133 @@
+package logthing
+
+import (
+	"sync"
+	"time"
+)
+
+// PropertyMessageCount contains the number of messages that have been merged into a wide message (see Correlator)
+const PropertyMessageCount = "messageCount"
+
+// correlation buffers the messages of a single tracking ID
+type correlation struct {
+	messages []LogMsg // copies of the buffered messages
+	timer    *time.Timer
+}
+
+// Correlator buffers all messages that share a tracking ID and merges them into one wide message (all properties and the
+// concatenated output) when the transaction is finished or timed out, for writers that prefer one row per request.
+type Correlator struct {
+	mutex          sync.Mutex
+	msgType        string
+	timeout        time.Duration
+	emitIndividual bool
+	correlations   map[string]*correlation
+}
+
+// NewCorrelator returns new Correlator that logs merged messages with given message type. If a transaction isn't finished
+// within the timeout, it's merged and logged anyway. If emitIndividual is true, the individual messages are logged as well.
+func NewCorrelator(msgType string, timeout time.Duration, emitIndividual bool) *Correlator {
+	return &Correlator{
+		msgType:        msgType,
+		timeout:        timeout,
+		emitIndividual: emitIndividual,
+		correlations:   map[string]*correlation{},
+	}
+}
+
+// Log buffers the message to be merged with the other messages of its tracking ID. Messages without tracking ID are logged directly.
+func (c *Correlator) Log(msg LogMsg) error {
+	if msg == nil || msg.IsNil() {
+		return nil
+	}
+	trackingID := msg.TrackingID()
+	if trackingID == "" {
+		return LogMsgWithCalldepth(2, msg)
+	}
+	if msg.Timestamp().IsZero() {
+		msg.SetTimestamp(time.Now())
+	}
+	c.mutex.Lock()
+	corr, ok := c.correlations[trackingID]
+	if !ok {
+		corr = &correlation{}
+		if c.timeout > 0 {
+			corr.timer = time.AfterFunc(c.timeout, func() { c.Finish(trackingID) })
+		}
+		c.correlations[trackingID] = corr
+	}
+	corr.messages = append(corr.messages, copyMsg(msg))
+	c.mutex.Unlock()
+	if c.emitIndividual {
+		return LogMsgWithCalldepth(2, msg)
+	}
+	return nil
+}
+
+// Finish merges all buffered messages of the tracking ID into one wide message and logs it
+func (c *Correlator) Finish(trackingID string) error {
+	c.mutex.Lock()
+	corr, ok := c.correlations[trackingID]
+	delete(c.correlations, trackingID)
+	c.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+	if corr.timer != nil {
+		corr.timer.Stop()
+	}
+	return LogMsgWithCalldepth(2, mergeMessages(c.msgType, corr.messages))
+}
+
+// Close finishes all pending transactions
+func (c *Correlator) Close() {
+	c.mutex.Lock()
+	trackingIDs := make([]string, 0, len(c.correlations))
+	for trackingID := range c.correlations {
+		trackingIDs = append(trackingIDs, trackingID)
+	}
+	c.mutex.Unlock()
+	for _, trackingID := range trackingIDs {
+		c.Finish(trackingID)
+	}
+}
+
+// copyMsg returns a copy of the message with copied properties map, duration histograms (see RecordDuration) and
+// output, so that the copy isn't affected when the original message is logged
+func copyMsg(msg LogMsg) LogMsg {
+	data := *msg.msgData()
+	data.properties = nil
+	data.fields = nil
+	for k, v := range msg.Properties() {
+		if h, ok := v.(*durationHistogram); ok {
+			v = h.clone()
+		}
+		data.SetProperty(k, v)
+	}
+	data.output = append([]string{}, data.output...)
+	data.self = &data
+	return &data
+}
+
+// mergeMessages merges the messages into one wide message with all properties, the concatenated output, the highest severity
+// and the earliest timestamp. Properties of later messages overwrite the ones of earlier messages.
+func mergeMessages(msgType string, messages []LogMsg) LogMsg {
+	merged := NewLogMsg(msgType)
+	for i, msg := range messages {
+		if i == 0 || msg.Timestamp().Before(merged.Timestamp()) {
+			merged.SetTimestamp(msg.Timestamp())
+		}
+		merged.SetTrackingID(msg.TrackingID())
+		merged.SetSeverity(msg.Severity())
+		for k, v := range msg.Properties() {
+			merged.SetProperty(k, v)
+		}
+		data := msg.msgData()
+		if data.whitelisted {
+			WithWhitelistFlag()(merged)
+		}
+		merged.msgData().output = append(merged.msgData().output, data.output...)
+	}
+	merged.SetProperty(PropertyMessageCount, len(messages))
+	return merged
+}
@@ -0,0 +1,24 @@
+package logthing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeMessages(t *testing.T) {
+	first := NewLogMsg("a").SetTrackingID("t").SetTimestamp(time.Unix(10, 0)).SetProperty("foo", 1).Info("first")
+	second := NewLogMsg("b").SetTrackingID("t").SetTimestamp(time.Unix(5, 0)).SetProperty("bar", 2).Error("second")
+	merged := mergeMessages("request", []LogMsg{copyMsg(first), copyMsg(second)})
+	if merged.Type() != "request" || merged.TrackingID() != "t" {
+		t.Errorf("unexpected type or tracking id: %v %v", merged.Type(), merged.TrackingID())
+	}
+	if merged.Severity() != SeverityError || !merged.Timestamp().Equal(time.Unix(5, 0)) {
+		t.Errorf("unexpected severity or timestamp: %v %v", merged.Severity(), merged.Timestamp())
+	}
+	if merged.Property("foo") != 1 || merged.Property("bar") != 2 || merged.Property(PropertyMessageCount) != 2 {
+		t.Errorf("unexpected properties: %v", merged.Properties())
+	}
+	if len(merged.Output()) != len(first.Output())+len(second.Output()) {
+		t.Errorf("unexpected output: %v", merged.Output())
+	}
+}
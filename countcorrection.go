@@ -0,0 +1,114 @@
+package logthing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// PropertySampleRate contains the fraction (0 < sample_rate <= 1) with which the message has been sampled, i.e. the
+	// message represents 1/sample_rate messages (see MarkSampled)
+	PropertySampleRate = "sample_rate"
+	// PropertyDedupCount contains the number of identical messages the message represents (see MarkDeduplicated)
+	PropertyDedupCount = "dedup_count"
+	// PropertyWindow contains the window in which messages have been deduplicated (see MarkDeduplicated)
+	PropertyWindow = "window"
+)
+
+// MarkSampled marks the message as sampled with given rate (0 < rate <= 1), so that dashboards can reconstruct the true
+// count (see CountCorrectionQuery). If the message has been sampled before, the rates are multiplied.
+func MarkSampled(msg LogMsg, rate float64) LogMsg {
+	if rate <= 0 || rate > 1 {
+		return msg
+	}
+	if previous, ok := PropertyAs[float64](msg, PropertySampleRate); ok && previous > 0 {
+		rate *= previous
+	}
+	return msg.SetProperty(PropertySampleRate, rate)
+}
+
+// MarkDeduplicated marks the message as representative of count identical messages within the window, so that
+// dashboards can reconstruct the true count (see CountCorrectionQuery)
+func MarkDeduplicated(msg LogMsg, count int, window time.Duration) LogMsg {
+	return msg.SetProperty(PropertyDedupCount, count).SetProperty(PropertyWindow, window.String())
+}
+
+// stampSampleRate sets the sample rate of the marshalled message (multiplied with the message's previous sample rate)
+func stampSampleRate(rawLogMessage json.RawMessage, rate float64, marshal marshalFunc) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(rawLogMessage))
+	decoder.UseNumber()
+	var properties map[string]interface{}
+	if err := decoder.Decode(&properties); err != nil {
+		return rawLogMessage
+	}
+	if previous, ok := properties[PropertySampleRate].(json.Number); ok {
+		if previousRate, err := previous.Float64(); err == nil && previousRate > 0 {
+			rate *= previousRate
+		}
+	}
+	properties[PropertySampleRate] = rate
+	stamped, err := marshal(properties)
+	if err != nil {
+		return rawLogMessage
+	}
+	return stamped
+}
+
+// QueryDialect is the query language of the log store (see CountCorrectionQuery)
+type QueryDialect int
+
+const (
+	// QueryLogAnalytics generates KQL for Log Analytics custom logs (table "<logName>_CL" with typed column suffixes)
+	QueryLogAnalytics QueryDialect = iota
+	// QueryDataExplorer generates KQL for Azure Data Explorer tables
+	QueryDataExplorer
+	// QueryElasticsearch generates an Elasticsearch search request body with a "true_count" aggregation
+	QueryElasticsearch
+)
+
+// CountCorrectionQuery returns a query that counts the messages of given type in bins of the given interval, weighted
+// with dedup_count / sample_rate, so that dashboards show the true counts despite client-side sampling and deduplication:
+//
+//	MyService_CL
+//	| where type_s == "request"
+//	| extend weight = todouble(coalesce(dedup_count_d, 1.0)) / todouble(coalesce(sample_rate_d, 1.0))
+//	| summarize true_count = sum(weight) by bin(TimeGenerated, 5m)
+func CountCorrectionQuery(dialect QueryDialect, logName string, msgType string, interval time.Duration) string {
+	quoted, _ := json.Marshal(msgType)
+	switch dialect {
+	case QueryElasticsearch:
+		return fmt.Sprintf(`{"size":0,"query":{"match":{%q:%s}},"aggs":{"bins":{"date_histogram":{"field":%q,"fixed_interval":"%vs"},`+
+			`"aggs":{"true_count":{"sum":{"script":{"source":"(doc.containsKey('%v') && !doc['%v'].empty ? doc['%v'].value : 1.0) / (doc.containsKey('%v') && !doc['%v'].empty ? doc['%v'].value : 1.0)"}}}}}}}`,
+			PropertyType, quoted, PropertyTimestamp, int64(interval.Seconds()),
+			PropertyDedupCount, PropertyDedupCount, PropertyDedupCount, PropertySampleRate, PropertySampleRate, PropertySampleRate)
+	case QueryDataExplorer:
+		return strings.Join([]string{
+			logName,
+			fmt.Sprintf("| where %v == %s", PropertyType, quoted),
+			fmt.Sprintf("| extend weight = todouble(coalesce(%v, 1.0)) / todouble(coalesce(%v, 1.0))", PropertyDedupCount, PropertySampleRate),
+			fmt.Sprintf("| summarize true_count = sum(weight) by bin(%v, %v)", PropertyTimestamp, kqlTimespan(interval)),
+		}, "\n")
+	}
+	return strings.Join([]string{
+		logName + "_CL",
+		fmt.Sprintf("| where %v_s == %s", PropertyType, quoted),
+		fmt.Sprintf("| extend weight = todouble(coalesce(%v_d, 1.0)) / todouble(coalesce(%v_d, 1.0))", PropertyDedupCount, PropertySampleRate),
+		fmt.Sprintf("| summarize true_count = sum(weight) by bin(TimeGenerated, %v)", kqlTimespan(interval)),
+	}, "\n")
+}
+
+// kqlTimespan returns the duration as KQL timespan literal
+func kqlTimespan(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%vh", int64(d/time.Hour))
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%vm", int64(d/time.Minute))
+	case d >= time.Second && d%time.Second == 0:
+		return fmt.Sprintf("%vs", int64(d/time.Second))
+	}
+	return fmt.Sprintf("%vms", d.Milliseconds())
+}
@@ -0,0 +1,39 @@
+package logthing
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStampSampleRate(t *testing.T) {
+	raw := json.RawMessage(`{"id":12345678901234567890,"sample_rate":0.5}`)
+	stamped := stampSampleRate(raw, 0.1, dispatcherOptions{}.marshal)
+	var properties map[string]interface{}
+	if err := json.Unmarshal(stamped, &properties); err != nil {
+		t.Fatal(err)
+	}
+	if rate := properties[PropertySampleRate].(float64); rate < 0.0499 || rate > 0.0501 {
+		t.Errorf("expected multiplied sample rate, got %v", rate)
+	}
+	if !strings.Contains(string(stamped), `"id":12345678901234567890`) {
+		t.Errorf("expected numbers to be kept: %s", stamped)
+	}
+	if msg := MarkSampled(MarkSampled(NewLogMsg("request"), 0.5), 0.5); msg.Property(PropertySampleRate) != 0.25 {
+		t.Errorf("expected sample rate 0.25, got %v", msg.Property(PropertySampleRate))
+	}
+}
+
+func TestCountCorrectionQuery(t *testing.T) {
+	query := CountCorrectionQuery(QueryLogAnalytics, "MyService", "request", 5*time.Minute)
+	for _, expected := range []string{"MyService_CL", `type_s == "request"`, "sample_rate_d", "bin(TimeGenerated, 5m)"} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("expected %q in query:\n%v", expected, query)
+		}
+	}
+	var esQuery map[string]interface{}
+	if err := json.Unmarshal([]byte(CountCorrectionQuery(QueryElasticsearch, "myservice", "request", time.Hour)), &esQuery); err != nil {
+		t.Errorf("expected valid elasticsearch query: %v", err)
+	}
+}
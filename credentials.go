@@ -0,0 +1,50 @@
+package logthing
+
+import (
+	"context"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// writerTokens are the registered tokens of a writer
+type writerTokens struct {
+	writer string
+	tokens []*logwriter.RefreshingToken
+}
+
+// writerCredentials returns the registered tokens of all writers that implement logwriter.CredentialRefresher
+func writerCredentials(logWriters []logwriter.LogWriter) (credentials []writerTokens) {
+	for _, lw := range logWriters {
+		if refresher, ok := lw.(logwriter.CredentialRefresher); ok {
+			if tokens := refresher.Credentials(); len(tokens) > 0 {
+				credentials = append(credentials, writerTokens{writer: writerName(lw), tokens: tokens})
+			}
+		}
+	}
+	return
+}
+
+// refreshCredentials refreshes the tokens that are about to expire every interval, so that writers don't have to wait
+// for a token when writing a batch. Failed refreshes are reported as DispatchError with PhaseCredentials.
+func (ld *logDispatcher) refreshCredentials(interval time.Duration, credentials []writerTokens) {
+	defer ld.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, wt := range credentials {
+				for _, token := range wt.tokens {
+					ctx, cancel := context.WithTimeout(context.Background(), interval)
+					if err := token.Refresh(ctx); err != nil {
+						ld.reportError(DispatchError{Phase: PhaseCredentials, Writer: wt.writer, Retryable: true, Err: err})
+					}
+					cancel()
+				}
+			}
+		case <-ld.stop:
+			return
+		}
+	}
+}
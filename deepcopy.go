@@ -0,0 +1,80 @@
+package logthing
+
+import "reflect"
+
+// WithDeepCopy makes SetProperty of the message behave like SetPropertyCopy, so that all maps and slices are
+// snapshotted when they are set
+func WithDeepCopy() Option {
+	return func(lm LogMsg) {
+		if msg := lm.msgData(); msg != nil {
+			msg.deepCopy = true
+		}
+	}
+}
+
+// SetPropertyCopy like SetProperty but snapshots the value at set time by deep-copying maps, slices and arrays
+// (recursively, also within interface values), so that mutating them afterwards doesn't affect the logged message.
+// Pointers and struct fields aren't copied.
+func (lm *logMsg) SetPropertyCopy(key string, value interface{}) LogMsg {
+	if lm == nil {
+		return lm.Self()
+	}
+	if sp, ok := value.(sProp); ok {
+		return lm.setProperty(key, sProp{value: deepCopy(sp.value)})
+	}
+	return lm.setProperty(key, deepCopy(value))
+}
+
+// deepCopy returns a deep copy of maps, slices and arrays. Other values are returned as they are.
+func deepCopy(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch v := value.(type) { // fast path for scalar values
+	case string, bool, int, int64, uint64, float64:
+		return v
+	}
+	copied := deepCopyValue(reflect.ValueOf(value))
+	if !copied.IsValid() {
+		return value
+	}
+	return copied.Interface()
+}
+
+func deepCopyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), deepCopyValue(iter.Value()))
+		}
+		return copied
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+		return copied
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(deepCopyValue(v.Elem()))
+		return copied
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package logthing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DispatchPhase declares the phase of the dispatch pipeline in which an error occurred
+type DispatchPhase string
+
+const (
+	// PhaseInit when a writer failed to init
+	PhaseInit DispatchPhase = "init"
+	// PhaseQueue when a message couldn't be queued (e.g. queue is full)
+	PhaseQueue DispatchPhase = "queue"
+	// PhaseMarshal when a message couldn't be marshalled
+	PhaseMarshal DispatchPhase = "marshal"
+	// PhaseSchema when a writer failed to handle a schema change
+	PhaseSchema DispatchPhase = "schema"
+	// PhaseWrite when a writer failed to write a batch
+	PhaseWrite DispatchPhase = "write"
+	// PhaseCredentials when a writer's credentials couldn't be refreshed
+	PhaseCredentials DispatchPhase = "credentials"
+)
+
+// DispatchError carries structured information about an error in the dispatch pipeline. See Errors()
+type DispatchError struct {
+	Phase     DispatchPhase // phase in which the error occurred
+	Writer    string        // name of the affected writer (empty if not writer related)
+	BatchID   uint64        // id of the affected batch (0 if not batch related)
+	Retryable bool          // whether the operation might succeed when tried again
+	Err       error         // the original error
+}
+
+func (e DispatchError) Error() string {
+	if e.Writer != "" {
+		return fmt.Sprintf("%v failed (writer: %v, batch: %v): %v", e.Phase, e.Writer, e.BatchID, e.Err)
+	}
+	return fmt.Sprintf("%v failed (batch: %v): %v", e.Phase, e.BatchID, e.Err)
+}
+
+func (e DispatchError) Unwrap() error {
+	return e.Err
+}
+
+// WriterInitError is the error of a writer that failed to init
+type WriterInitError struct {
+	Name string // name of the writer
+	Err  error  // the original error
+}
+
+func (e WriterInitError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Name, e.Err)
+}
+
+func (e WriterInitError) Unwrap() error {
+	return e.Err
+}
+
+// WriterInitErrors is returned by InitDispatcher when writers failed to init. The dispatcher is started with the
+// remaining writers anyway, so callers can decide whether to proceed (e.g. console-only) or to abort the startup:
+//
+//	var initErrors logthing.WriterInitErrors
+//	if errors.As(err, &initErrors) && len(initErrors) == len(writers) {
+//		// no writer is available
+//	}
+type WriterInitErrors []WriterInitError
+
+func (e WriterInitErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, initError := range e {
+		messages[i] = initError.Error()
+	}
+	return "init of writers failed: [" + strings.Join(messages, "; ") + "]"
+}
+
+// Unwrap returns the errors of the writers
+func (e WriterInitErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, initError := range e {
+		errs[i] = initError
+	}
+	return errs
+}
+
+// Is returns true if the error of any writer matches the target (see errors.Is)
+func (e WriterInitErrors) Is(target error) bool {
+	for _, initError := range e {
+		if errors.Is(initError, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the writers that matches the target (see errors.As)
+func (e WriterInitErrors) As(target interface{}) bool {
+	for _, initError := range e {
+		if errors.As(initError, target) {
+			return true
+		}
+	}
+	return false
+}
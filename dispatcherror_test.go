@@ -0,0 +1,43 @@
+package logthing
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// failingWriter fails every write
+type failingWriter struct {
+	soakWriter
+	err error
+}
+
+func (w *failingWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	return w.err
+}
+
+func TestDispatchErrors(t *testing.T) {
+	writeErr := errors.New("backend unavailable")
+	writer := &failingWriter{err: writeErr}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Info("message"))
+	ld.close()
+	var dispatchErrors []DispatchError
+	for dispatchErr := range ld.errorCh { // terminates as the channel is closed by close
+		dispatchErrors = append(dispatchErrors, dispatchErr)
+	}
+	if len(dispatchErrors) != 1 {
+		t.Fatalf("expected one dispatch error, got %v", dispatchErrors)
+	}
+	dispatchErr := dispatchErrors[0]
+	if dispatchErr.Phase != PhaseWrite || dispatchErr.Writer != writerName(writer) || dispatchErr.BatchID == 0 ||
+		!dispatchErr.Retryable || !errors.Is(dispatchErr, writeErr) {
+		t.Errorf("unexpected dispatch error: %#v", dispatchErr)
+	}
+}
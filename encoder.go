@@ -0,0 +1,37 @@
+package logthing
+
+import "encoding/json"
+
+// Encoder marshals the message properties to JSON. Encoders must marshal maps with sorted keys (like encoding/json),
+// so that signatures can be verified (see WithMessageSigning).
+type Encoder interface {
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// JSONEncoder marshals with encoding/json
+type JSONEncoder struct{}
+
+// Marshal marshals v with encoding/json
+func (JSONEncoder) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// defaultEncoder is used when no encoder is set with WithEncoder. It's replaced by the encoders that are enabled with
+// the "gojson" or "sonic" build tags.
+var defaultEncoder Encoder = JSONEncoder{}
+
+// marshalFunc marshals the message properties
+type marshalFunc func(properties map[string]interface{}) (json.RawMessage, error)
+
+// marshal marshals (and signs) the message properties with the dispatcher's encoder and signer
+func (opt dispatcherOptions) marshal(properties map[string]interface{}) (json.RawMessage, error) {
+	return marshalMessage(properties, opt.encoder, opt.signer)
+}
+
+// WithEncoder sets the encoder that marshals messages (default: encoding/json, or go-json / sonic when built with the
+// "gojson" or "sonic" build tag), e.g. for high-performance encoders of wide messages
+func WithEncoder(encoder Encoder) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.encoder = encoder
+	}
+}
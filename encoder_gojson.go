@@ -0,0 +1,17 @@
+//go:build gojson
+
+package logthing
+
+import gojson "github.com/goccy/go-json"
+
+// GoJSONEncoder marshals with github.com/goccy/go-json (only available with the "gojson" build tag)
+type GoJSONEncoder struct{}
+
+// Marshal marshals v with go-json
+func (GoJSONEncoder) Marshal(v interface{}) ([]byte, error) {
+	return gojson.Marshal(v)
+}
+
+func init() {
+	defaultEncoder = GoJSONEncoder{}
+}
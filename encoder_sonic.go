@@ -0,0 +1,18 @@
+//go:build sonic && !gojson
+
+package logthing
+
+import "github.com/bytedance/sonic"
+
+// SonicEncoder marshals with github.com/bytedance/sonic (only available with the "sonic" build tag). The std config is
+// used, which sorts map keys and escapes HTML like encoding/json.
+type SonicEncoder struct{}
+
+// Marshal marshals v with sonic
+func (SonicEncoder) Marshal(v interface{}) ([]byte, error) {
+	return sonic.ConfigStd.Marshal(v)
+}
+
+func init() {
+	defaultEncoder = SonicEncoder{}
+}
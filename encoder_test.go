@@ -0,0 +1,37 @@
+package logthing
+
+import (
+	"fmt"
+	"testing"
+)
+
+// wideProperties returns properties of a wide message
+func wideProperties() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < 100; i++ {
+		properties[fmt.Sprintf("string%v", i)] = fmt.Sprintf("value %v", i)
+		properties[fmt.Sprintf("number%v", i)] = i
+	}
+	properties[PropertyOutput] = []string{"[main.go:10]: request handled"}
+	return properties
+}
+
+func TestWithEncoder(t *testing.T) {
+	options := dispatcherOptions{}
+	WithEncoder(JSONEncoder{})(&options)
+	raw, err := options.marshal(map[string]interface{}{"b": 2, "a": "<1>"})
+	if err != nil || string(raw) != `{"a":"\u003c1\u003e","b":2}` {
+		t.Errorf("unexpected encoding %s (%v)", raw, err)
+	}
+}
+
+// BenchmarkEncoder benchmarks the default encoder (run with -tags gojson or -tags sonic to compare)
+func BenchmarkEncoder(b *testing.B) {
+	properties := wideProperties()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := marshalMessage(properties, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package logthing
+
+import "sort"
+
+// Enricher adds properties to messages before they are marshalled and written (see WithEnricher)
+type Enricher interface {
+	Enrich(msg LogMsg)
+}
+
+// EnricherFunc is a function that implements Enricher
+type EnricherFunc func(msg LogMsg)
+
+// Enrich calls f(msg)
+func (f EnricherFunc) Enrich(msg LogMsg) {
+	f(msg)
+}
+
+// orderedEnricher is an enricher with its position in the chain
+type orderedEnricher struct {
+	order    int
+	enricher Enricher
+}
+
+// WithEnricher adds the enricher to the chain of enrichers that is invoked for every message that is written (also
+// for imported and ingested messages, see Import and ServeIngest), after static and cloud metadata properties have been
+// set. Enrichers are invoked in ascending order and enrichers with the same order in the order they have been added,
+// so that an enricher sees the properties that have been set by the enrichers before.
+func WithEnricher(order int, enricher Enricher) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		enrichers := append([]orderedEnricher{}, opt.enrichers...)
+		enrichers = append(enrichers, orderedEnricher{order: order, enricher: enricher})
+		sort.SliceStable(enrichers, func(i, j int) bool {
+			return enrichers[i].order < enrichers[j].order
+		})
+		opt.enrichers = enrichers
+	}
+}
+
+// enrich invokes the chain of enrichers
+func enrich(msg *logMsg, enrichers []orderedEnricher) {
+	for _, e := range enrichers {
+		e.enricher.Enrich(msg.Self())
+	}
+}
@@ -0,0 +1,54 @@
+package logthing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestEnricher(t *testing.T) {
+	db, err := LoadGeoIPCSV(strings.NewReader(`range_start,range_end,country,asn,as_org
+1.0.0.0,1.0.0.255,US,13335,CLOUDFLARENET
+# comment
+2001:db8::,2001:db8::ffff,DE,3320,DTAG
+10.0.0.0,10.255.255.255,None,0,Not routed
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chain []string
+	writer := &soakWriter{}
+	ld, err = newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour),
+		WithEnricher(10, EnricherFunc(func(msg LogMsg) {
+			chain = append(chain, "late")
+			if msg.Property(PropertyGeoCountry) == "US" {
+				msg.SetProperty("region", "NA")
+			}
+		})),
+		WithEnricher(0, &GeoIPEnricher{Database: db}),
+		WithEnricher(10, EnricherFunc(func(msg LogMsg) { chain = append(chain, "later") })),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	msg := NewLogMsg("request").SetProperty(PropertyClientIP, "1.0.0.1:443")
+	ld.prepare(msg.msgData())
+	ld.complete(msg.msgData(), ld.currentOptions())
+	if msg.Property(PropertyGeoCountry) != "US" || msg.Property(PropertyGeoASN) != 13335 ||
+		msg.Property(PropertyGeoASOrg) != "CLOUDFLARENET" || msg.Property("region") != "NA" {
+		t.Errorf("unexpected enriched properties: %v", msg.Properties())
+	}
+	if len(chain) != 2 || chain[0] != "late" || chain[1] != "later" {
+		t.Errorf("unexpected enricher order: %v", chain)
+	}
+	for ip, country := range map[string]interface{}{"2001:db8::1": "DE", "10.1.2.3": nil, "8.8.8.8": nil} {
+		msg := NewLogMsg("request").SetProperty(PropertyClientIP, ip)
+		ld.complete(msg.msgData(), ld.currentOptions())
+		if msg.Property(PropertyGeoCountry) != country {
+			t.Errorf("expected country %v for %v, got %v", country, ip, msg.Property(PropertyGeoCountry))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package logthing
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// MsgTypeFatal is the message type of messages logged with Fatalf
+const MsgTypeFatal = "fatal"
+
+// fatalExit contains the exit code and flush timeout of Fatal (see SetFatalExit)
+var fatalExit = struct {
+	sync.Mutex
+	code         int
+	flushTimeout time.Duration
+}{
+	code:         1,
+	flushTimeout: 10 * time.Second,
+}
+
+// osExit exits the program (variable to be replaced in tests)
+var osExit = os.Exit
+
+// SetFatalExit sets the exit code (default: 1) and the max time to flush the queued messages (default: 10s) of Fatal
+// and Fatalf
+func SetFatalExit(code int, flushTimeout time.Duration) {
+	fatalExit.Lock()
+	defer fatalExit.Unlock()
+	fatalExit.code = code
+	fatalExit.flushTimeout = flushTimeout
+}
+
+// Fatal sets the message's severity to SeverityEmergency, logs it, closes the default dispatcher to synchronously
+// flush all queued messages (see SetFatalExit for the timeout) and exits the program with the configured exit code.
+// In contrast to log.Fatal the final message isn't lost, because it goes through the pipeline.
+func Fatal(msg LogMsg) {
+	fatal(3, msg)
+}
+
+// Fatalf logs a message of type "fatal" with the formatted output like Fatal and exits the program
+func Fatalf(format string, v ...interface{}) {
+	msg := NewLogMsg(MsgTypeFatal)
+	msg.msgData().appendOutput(2, SeverityEmergency, formatOutput(format, v...))
+	fatal(3, msg)
+}
+
+// fatal logs the message, flushes the dispatcher and exits
+func fatal(calldepth int, msg LogMsg) {
+	fatalExit.Lock()
+	code, flushTimeout := fatalExit.code, fatalExit.flushTimeout
+	fatalExit.Unlock()
+	if msg != nil && !msg.IsNil() {
+		msg.SetSeverity(SeverityEmergency)
+		if ld == nil {
+			printLogMsg(calldepth, msg.msgData())
+		} else {
+			LogMsgWithCalldepth(calldepth, msg)
+		}
+	}
+	closed := make(chan struct{})
+	go func() {
+		Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(flushTimeout):
+		Emergency.Printf("Flushing the dispatcher timed out after %v", flushTimeout)
+	}
+	osExit(code)
+}
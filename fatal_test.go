@@ -0,0 +1,29 @@
+package logthing
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestFatal(t *testing.T) {
+	exitCode := -1
+	osExit = func(code int) { exitCode = code }
+	defer func() {
+		osExit = os.Exit
+		SetFatalExit(1, 10*time.Second)
+	}()
+	writer := &soakWriter{}
+	var err error
+	if ld, err = newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	SetFatalExit(3, time.Second)
+	Fatalf("giving up: %v", "disk full")
+	if exitCode != 3 || ld != nil || atomic.LoadUint64(&writer.written) != 1 {
+		t.Errorf("expected exit code 3 after flushing the fatal message, got %v (%v written)", exitCode, writer.written)
+	}
+}
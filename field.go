@@ -0,0 +1,119 @@
+package logthing
+
+import (
+	"math"
+	"time"
+)
+
+// fieldType defines how the value of a Field is stored
+type fieldType uint8
+
+const (
+	fieldAny fieldType = iota
+	fieldString
+	fieldInt
+	fieldFloat
+	fieldBool
+	fieldDuration
+	fieldTime
+)
+
+// Field is a typed property value that can be passed to the KV output methods (e.g. InfoKV) without boxing it into an
+// interface. Fields are only converted to properties when the message's properties are accessed (e.g. when it is logged).
+// The constructors are prefixed with "F" (FString, FInt, ...) to keep the package namespace free of generic names.
+//
+//	msg.InfoKV("request served", logthing.FString("path", path), logthing.FInt("status", status))
+type Field struct {
+	Key   string
+	typ   fieldType
+	num   int64
+	str   string
+	iface interface{}
+}
+
+// FString returns a Field with string value
+func FString(key string, value string) Field {
+	return Field{Key: key, typ: fieldString, str: value}
+}
+
+// FInt returns a Field with int value
+func FInt(key string, value int) Field {
+	return Field{Key: key, typ: fieldInt, num: int64(value)}
+}
+
+// FInt64 returns a Field with int64 value
+func FInt64(key string, value int64) Field {
+	return Field{Key: key, typ: fieldInt, num: value}
+}
+
+// FFloat64 returns a Field with float64 value
+func FFloat64(key string, value float64) Field {
+	return Field{Key: key, typ: fieldFloat, num: int64(math.Float64bits(value))}
+}
+
+// FBool returns a Field with bool value
+func FBool(key string, value bool) Field {
+	field := Field{Key: key, typ: fieldBool}
+	if value {
+		field.num = 1
+	}
+	return field
+}
+
+// FDuration returns a Field with duration value (marshalled in nanoseconds like SetProperty)
+func FDuration(key string, value time.Duration) Field {
+	return Field{Key: key, typ: fieldDuration, num: int64(value)}
+}
+
+// FTime returns a Field with time value (converted to UTC)
+func FTime(key string, value time.Time) Field {
+	return Field{Key: key, typ: fieldTime, num: value.UnixNano()}
+}
+
+// FErr returns a Field with key "error" and the error's message as value
+func FErr(err error) Field {
+	if err == nil {
+		return Field{Key: "error", typ: fieldAny}
+	}
+	return Field{Key: "error", typ: fieldAny, iface: err}
+}
+
+// FAny returns a Field with any value like SetProperty
+func FAny(key string, value interface{}) Field {
+	return Field{Key: key, typ: fieldAny, iface: value}
+}
+
+// Value returns the field's value
+func (f Field) Value() interface{} {
+	switch f.typ {
+	case fieldString:
+		return f.str
+	case fieldInt:
+		return f.num
+	case fieldFloat:
+		return math.Float64frombits(uint64(f.num))
+	case fieldBool:
+		return f.num == 1
+	case fieldDuration:
+		return time.Duration(f.num)
+	case fieldTime:
+		return UTCTime(time.Unix(0, f.num))
+	}
+	if err, ok := f.iface.(error); ok {
+		return err.Error()
+	}
+	return f.iface
+}
+
+// applyFields sets the pending fields as properties
+func (lm *logMsg) applyFields(properties map[string]interface{}) {
+	fields := lm.fields
+	lm.fields = nil
+	for _, field := range fields {
+		value := field.Value()
+		if lm.deepCopy && field.typ == fieldAny {
+			value = deepCopy(value)
+		}
+		properties[field.Key] = value
+	}
+}
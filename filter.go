@@ -0,0 +1,71 @@
+package logthing
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MessageFilter filters messages by type, severity, tracking ID, timestamp and labels (see Subscribe and
+// RecentMessages). Empty fields match all messages.
+type MessageFilter struct {
+	Types       []string  // log message types (glob patterns like "http_*" are supported)
+	MaxSeverity Severity  // messages with severity > MaxSeverity don't match (0: all severities)
+	TrackingID  string    // tracking ID of the messages
+	Since       time.Time // messages with earlier timestamps don't match (see RecentMessages)
+	Labels      []string  // labels that the messages must have (see WithClassifier)
+}
+
+// messageMatcher is the prepared MessageFilter
+type messageMatcher struct {
+	filter MessageFilter
+	types  typeMatcher
+}
+
+func newMessageMatcher(filter MessageFilter) messageMatcher {
+	return messageMatcher{filter: filter, types: newTypeMatcher(filter.Types)}
+}
+
+// matches returns true if the message matches the filter
+func (m messageMatcher) matches(msg *logMsg) bool {
+	if len(m.filter.Types) > 0 && !m.types.matches(msg.logMessageType) {
+		return false
+	}
+	if m.filter.MaxSeverity > 0 && msg.severity > m.filter.MaxSeverity {
+		return false
+	}
+	for _, label := range m.filter.Labels {
+		if !HasLabel(msg, label) {
+			return false
+		}
+	}
+	if !m.filter.Since.IsZero() && time.Time(msg.timestamp).Before(m.filter.Since) {
+		return false
+	}
+	return m.filter.TrackingID == "" || m.filter.TrackingID == msg.trackingID
+}
+
+// messageFilterFromQuery returns the filter of the query parameters "type" (comma separated), "severity", "trackingID",
+// "since" (duration, e.g. "2m") and "label" (comma separated)
+func messageFilterFromQuery(query url.Values) (filter MessageFilter, err error) {
+	if types := query.Get("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if severity := query.Get("severity"); severity != "" {
+		if filter.MaxSeverity, err = ParseSeverity(severity); err != nil {
+			return
+		}
+	}
+	filter.TrackingID = query.Get("trackingID")
+	if labels := query.Get("label"); labels != "" {
+		filter.Labels = strings.Split(labels, ",")
+	}
+	if since := query.Get("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = time.Now().Add(-d)
+	}
+	return
+}
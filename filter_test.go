@@ -0,0 +1,88 @@
+package logthing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWhitelistLogTypes(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_LOG_MAX_SEVERITY", "error")
+	t.Setenv("LOGTHING_WHITELIST_LOG_TYPES", "audit,payment_*")
+	t.Setenv("LOGTHING_WHITELIST_LOG_TYPES_REGEX", "^billing\\.(invoice|refund)$")
+	ReloadConfig()
+	ld, err := newLogDispatcher(nil, WithDispatchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	for logType, whitelisted := range map[string]bool{
+		"audit":            true,
+		"payment_captured": true,
+		"billing.invoice":  true,
+		"billing.refund":   true,
+		"auditing":         false,
+		"payments":         false,
+		"billing.invoices": false,
+		"request":          false,
+	} {
+		err := ld.log(1, NewLogMsg(logType).Info("message"))
+		if whitelisted && err != nil {
+			t.Errorf("expected log type %q to be whitelisted, got %v", logType, err)
+		} else if !whitelisted && !errors.Is(err, ErrSeverityAboveMax) {
+			t.Errorf("expected log type %q to be filtered, got %v", logType, err)
+		}
+	}
+}
+
+func TestValidateWhitelistPatterns(t *testing.T) {
+	t.Setenv("LOGTHING_WHITELIST_LOG_TYPES", "payment_[")
+	t.Setenv("LOGTHING_WHITELIST_LOG_TYPES_REGEX", "billing.(")
+	issues := map[string]ConfigError{}
+	for _, issue := range ValidateConfig() {
+		issues[issue.Variable] = issue
+	}
+	for _, variable := range []string{"LOGTHING_WHITELIST_LOG_TYPES", "LOGTHING_WHITELIST_LOG_TYPES_REGEX"} {
+		if !errors.Is(issues[variable], ErrInvalidValue) {
+			t.Errorf("expected invalid value error for %v, got %v", variable, issues[variable])
+		}
+	}
+}
+
+func TestDenyLists(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_DENY_LOG_TYPES", "noise,thirdparty_*")
+	t.Setenv("LOGTHING_DENY_PROPERTIES", "password")
+	t.Setenv("LOGTHING_WHITELIST_PROPERTIES", "user,password")
+	ReloadConfig()
+	ld, err := newLogDispatcher(nil, WithDispatchInterval(time.Hour), DenyTypes("health"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for logType, denied := range map[string]bool{
+		"noise":           true,
+		"thirdparty_http": true,
+		"health":          true,
+		"request":         false,
+	} {
+		// denied types are dropped even if the message is whitelisted
+		err := ld.log(1, NewLogMsg(logType, WithWhitelistFlag()).Emergency("message"))
+		if denied != errors.Is(err, ErrDenied) {
+			t.Errorf("expected log type %q to be denied: %v, got %v", logType, denied, err)
+		}
+	}
+	whitelisted := NewLogMsg("login", WithWhitelistFlag()).SetProperty("user", "alice").SetProperty("password", "secret").Info("login")
+	filtered := NewLogMsg("login").SetProperty("user", "bob").SetProperty("password", "secret").Info("login")
+	for _, msg := range []LogMsg{whitelisted, filtered} {
+		if err := ld.log(1, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ld.close()
+	for _, msg := range []LogMsg{whitelisted, filtered} {
+		if msg.Property("password") != nil || msg.Property("user") == nil {
+			t.Errorf("expected only the denied property to be removed, got %v", msg.Properties())
+		}
+	}
+}
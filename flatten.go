@@ -0,0 +1,97 @@
+package logthing
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// WithFlattenedProperties enables that nested property objects are flattened into keys joined by separator (e.g. "."
+// or "_") for writers that store properties in columns (e.g. Azure Monitor and Azure Data Explorer, see
+// logwriter.Capabilities), while they stay nested for document stores (e.g. Elasticsearch). The properties schema
+// contains the flattened properties.
+func WithFlattenedProperties(separator string) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.flattenSeparator = separator
+	}
+}
+
+// FlattenProperties returns the properties with nested objects flattened into keys joined by separator, e.g.
+// {"http": {"status": 200}} becomes {"http.status": 200} with separator ".". Other values (e.g. arrays) and the
+// dynamic "schemaOverflow" property (see SchemaDriftOverflow) are kept.
+func FlattenProperties(properties map[string]interface{}, separator string) map[string]interface{} {
+	flattened := make(map[string]interface{}, len(properties))
+	flattenInto(flattened, "", properties, separator)
+	return flattened
+}
+
+func flattenInto(flattened map[string]interface{}, prefix string, properties map[string]interface{}, separator string) {
+	for key, value := range properties {
+		if prefix != "" {
+			key = prefix + separator + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 && key != PropertySchemaOverflow {
+			flattenInto(flattened, key, nested, separator)
+			continue
+		}
+		flattened[key] = value
+	}
+}
+
+// ExpandProperties is the inverse of FlattenProperties: keys that contain the separator are expanded into nested
+// objects, e.g. {"http.status": 200} becomes {"http": {"status": 200}} with separator ".". Keys that conflict with
+// existing non-object values are kept flat.
+func ExpandProperties(properties map[string]interface{}, separator string) map[string]interface{} {
+	expanded := make(map[string]interface{}, len(properties))
+	var dotted []string
+	for key, value := range properties {
+		if strings.Contains(key, separator) {
+			dotted = append(dotted, key)
+			continue
+		}
+		expanded[key] = value
+	}
+	for _, key := range dotted {
+		parts := strings.Split(key, separator)
+		target := expanded
+		for _, part := range parts[:len(parts)-1] {
+			nested, ok := target[part].(map[string]interface{})
+			if !ok {
+				if _, exists := target[part]; exists {
+					target = nil
+					break
+				}
+				nested = map[string]interface{}{}
+				target[part] = nested
+			}
+			target = nested
+		}
+		if target == nil {
+			expanded[key] = properties[key]
+			continue
+		}
+		target[parts[len(parts)-1]] = properties[key]
+	}
+	return expanded
+}
+
+// flattenMessages returns the marshalled messages with flattened properties
+func flattenMessages(rawLogMessages []json.RawMessage, separator string, marshal marshalFunc) []json.RawMessage {
+	flattened := make([]json.RawMessage, len(rawLogMessages))
+	for i, rawLogMessage := range rawLogMessages {
+		flattened[i] = rawLogMessage
+		if !bytes.Contains(rawLogMessage, []byte(":{")) {
+			continue // no nested objects
+		}
+		var properties map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(rawLogMessage))
+		decoder.UseNumber()
+		if err := decoder.Decode(&properties); err != nil {
+			continue
+		}
+		if rawFlattened, err := marshal(FlattenProperties(properties, separator)); err == nil {
+			flattened[i] = rawFlattened
+		}
+	}
+	return flattened
+}
@@ -0,0 +1,54 @@
+package logthing
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFlattenProperties(t *testing.T) {
+	nested := map[string]interface{}{
+		"message": "hello",
+		"http": map[string]interface{}{
+			"status": 200,
+			"request": map[string]interface{}{
+				"method": "GET",
+			},
+		},
+		"tags": []string{"a", "b"},
+	}
+	flattened := FlattenProperties(nested, ".")
+	expected := map[string]interface{}{
+		"message":             "hello",
+		"http.status":         200,
+		"http.request.method": "GET",
+		"tags":                []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(flattened, expected) {
+		t.Fatalf("unexpected flattened properties: %v", flattened)
+	}
+	if expanded := ExpandProperties(flattened, "."); !reflect.DeepEqual(expanded, nested) {
+		t.Errorf("unexpected expanded properties: %v", expanded)
+	}
+	// conflicting keys stay flat
+	conflicting := ExpandProperties(map[string]interface{}{"http": "x", "http.status": 200}, ".")
+	if conflicting["http"] != "x" || conflicting["http.status"] != 200 {
+		t.Errorf("unexpected expanded conflicting properties: %v", conflicting)
+	}
+}
+
+func TestFlattenMessages(t *testing.T) {
+	raw := []json.RawMessage{
+		json.RawMessage(`{"a":1}`),
+		json.RawMessage(`{"a":{"b":12345678901234567890,"c":"x"}}`),
+	}
+	flattened := flattenMessages(raw, "_", func(properties map[string]interface{}) (json.RawMessage, error) {
+		return json.Marshal(properties)
+	})
+	if string(flattened[0]) != `{"a":1}` {
+		t.Errorf("unexpected message: %s", flattened[0])
+	}
+	if string(flattened[1]) != `{"a_b":12345678901234567890,"a_c":"x"}` {
+		t.Errorf("unexpected flattened message: %s", flattened[1])
+	}
+}
@@ -0,0 +1,92 @@
+package logthing
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// PropertyFlowID contains the ID of the business flow (e.g. a checkout session) the message belongs to (see SetFlow)
+	PropertyFlowID = "flow.id"
+	// PropertyFlowStep contains the name of the flow step (see SetFlow)
+	PropertyFlowStep = "flow.step"
+	// PropertyFlowSeq contains the auto-incremented number of the flow step within the process, starting with 1 (see SetFlow)
+	PropertyFlowSeq = "flow.seq"
+)
+
+// flowCountersSize is the max number of flows whose step counters are kept
+const flowCountersSize = 10000
+
+// flowCounter is the step counter of a flow
+type flowCounter struct {
+	flowID string
+	seq    uint64
+}
+
+// flowCounterLRU keeps the step counters of the most recently used flows, so that flows that are never ended (see
+// EndFlow) don't grow the counters without bounds
+type flowCounterLRU struct {
+	mutex    sync.Mutex
+	size     int
+	order    *list.List // most recently used first
+	counters map[string]*list.Element
+}
+
+var flowCounters = newFlowCounterLRU(flowCountersSize)
+
+func newFlowCounterLRU(size int) *flowCounterLRU {
+	return &flowCounterLRU{
+		size:     size,
+		order:    list.New(),
+		counters: map[string]*list.Element{},
+	}
+}
+
+// next increments and returns the step counter of the flow
+func (c *flowCounterLRU) next(flowID string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.counters[flowID]; ok {
+		c.order.MoveToFront(element)
+		counter := element.Value.(*flowCounter)
+		counter.seq++
+		return counter.seq
+	}
+	c.counters[flowID] = c.order.PushFront(&flowCounter{flowID: flowID, seq: 1})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.counters, oldest.Value.(*flowCounter).flowID)
+	}
+	return 1
+}
+
+// remove releases the step counter of the flow
+func (c *flowCounterLRU) remove(flowID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.counters[flowID]; ok {
+		c.order.Remove(element)
+		delete(c.counters, flowID)
+	}
+}
+
+// SetFlow sets the flow ID and step name and the next number of the flow's auto-incrementing step counter, so that
+// the steps of multi-step business flows can be ordered and visualized from the logs. The counters are kept per
+// process until the flow is ended (see EndFlow). At most the counters of the 10000 most recently used flows are kept,
+// steps of an evicted flow start again with 1.
+func (lm *logMsg) SetFlow(flowID string, step string) LogMsg {
+	if lm == nil {
+		return lm.Self()
+	}
+	seq := flowCounters.next(flowID)
+	lm.SetProperty(PropertyFlowID, flowID)
+	lm.SetProperty(PropertyFlowStep, step)
+	return lm.SetProperty(PropertyFlowSeq, seq)
+}
+
+// EndFlow releases the step counter of the flow (see SetFlow). Steps of the flow that are set afterwards start again
+// with 1.
+func EndFlow(flowID string) {
+	flowCounters.remove(flowID)
+}
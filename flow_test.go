@@ -0,0 +1,40 @@
+package logthing
+
+import "testing"
+
+func TestSetFlow(t *testing.T) {
+	defer EndFlow("checkout-1")
+	for i, step := range []string{"cart", "payment", "confirmation"} {
+		msg := NewLogMsg("checkout").SetFlow("checkout-1", step)
+		if seq := msg.Property(PropertyFlowSeq); seq != uint64(i+1) {
+			t.Errorf("expected step %v of %v to have seq %v, got %v", step, msg.Property(PropertyFlowID), i+1, seq)
+		}
+	}
+	if seq := NewLogMsg("checkout").SetFlow("checkout-2", "cart").Property(PropertyFlowSeq); seq != uint64(1) {
+		t.Errorf("expected other flow to start with seq 1, got %v", seq)
+	}
+	EndFlow("checkout-2")
+	EndFlow("checkout-1")
+	if seq := NewLogMsg("checkout").SetFlow("checkout-1", "cart").Property(PropertyFlowSeq); seq != uint64(1) {
+		t.Errorf("expected ended flow to start again with seq 1, got %v", seq)
+	}
+}
+
+func TestFlowCounterEviction(t *testing.T) {
+	counters := newFlowCounterLRU(2)
+	counters.next("a")
+	counters.next("b")
+	if seq := counters.next("a"); seq != 2 {
+		t.Errorf("expected seq 2, got %v", seq)
+	}
+	counters.next("c")
+	if len(counters.counters) != 2 || counters.order.Len() != 2 {
+		t.Errorf("expected 2 counters, got %v", len(counters.counters))
+	}
+	if seq := counters.next("a"); seq != 3 {
+		t.Errorf("expected recently used flow to be kept, got seq %v", seq)
+	}
+	if seq := counters.next("b"); seq != 1 {
+		t.Errorf("expected least recently used flow to be evicted, got seq %v", seq)
+	}
+}
@@ -0,0 +1,150 @@
+package logthing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PropertyClientIP contains the IP address of the client that is looked up by the GeoIPEnricher
+	PropertyClientIP = "client_ip"
+	// PropertyGeoCountry contains the ISO country code of the client IP address (see GeoIPEnricher)
+	PropertyGeoCountry = "geo.country"
+	// PropertyGeoASN contains the autonomous system number of the client IP address (see GeoIPEnricher)
+	PropertyGeoASN = "geo.asn"
+	// PropertyGeoASOrg contains the organization of the autonomous system (see GeoIPEnricher)
+	PropertyGeoASOrg = "geo.as_org"
+)
+
+// GeoIPRecord is the location and autonomous system of an IP address
+type GeoIPRecord struct {
+	Country string // ISO country code
+	ASN     int    // autonomous system number
+	ASOrg   string // organization of the autonomous system
+}
+
+// GeoIPDatabase looks up IP addresses, e.g. with a MaxMind or IP2Location database
+type GeoIPDatabase interface {
+	Lookup(ip net.IP) (GeoIPRecord, bool)
+}
+
+// GeoIPEnricher is an Enricher (see WithEnricher) that sets the country and autonomous system of messages with a
+// client IP address:
+//
+//	logthing.WithEnricher(0, &logthing.GeoIPEnricher{Database: db})
+type GeoIPEnricher struct {
+	Database GeoIPDatabase
+	Property string // property with the client IP address (default: "client_ip"), may contain a port
+}
+
+// Enrich sets the properties "geo.country", "geo.asn" and "geo.as_org" of messages whose client IP address is found
+func (e *GeoIPEnricher) Enrich(msg LogMsg) {
+	property := e.Property
+	if property == "" {
+		property = PropertyClientIP
+	}
+	address, ok := msg.Property(property).(string)
+	if !ok || address == "" {
+		return
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return
+	}
+	record, ok := e.Database.Lookup(ip)
+	if !ok {
+		return
+	}
+	if record.Country != "" {
+		msg.SetProperty(PropertyGeoCountry, record.Country)
+	}
+	if record.ASN > 0 {
+		msg.SetProperty(PropertyGeoASN, record.ASN)
+	}
+	if record.ASOrg != "" {
+		msg.SetProperty(PropertyGeoASOrg, record.ASOrg)
+	}
+}
+
+// geoIPRange is a range of IP addresses (16-byte representation) with the same record
+type geoIPRange struct {
+	start  net.IP
+	end    net.IP
+	record GeoIPRecord
+}
+
+// geoIPRanges is a GeoIPDatabase of sorted, non-overlapping IP ranges
+type geoIPRanges []geoIPRange
+
+// Lookup returns the record of the range that contains the IP address
+func (ranges geoIPRanges) Lookup(ip net.IP) (GeoIPRecord, bool) {
+	ip = ip.To16()
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].end, ip) >= 0
+	})
+	if i < len(ranges) && bytes.Compare(ranges[i].start, ip) <= 0 {
+		return ranges[i].record, true
+	}
+	return GeoIPRecord{}, false
+}
+
+// LoadGeoIPCSV loads a GeoIPDatabase from IP ranges in CSV format (e.g. the free IP to ASN databases), one range per
+// line: "range_start,range_end,country,asn,as_org". Lines starting with "#", a header and unrouted ranges (ASN 0
+// without country) are ignored.
+func LoadGeoIPCSV(r io.Reader) (GeoIPDatabase, error) {
+	var ranges geoIPRanges
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ",", 5)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %v: expected at least 4 fields", lineNumber)
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			if lineNumber == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %v: invalid IP range %v - %v", lineNumber, fields[0], fields[1])
+		}
+		asn, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(fields[3]), "AS"))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid ASN %v", lineNumber, fields[3])
+		}
+		record := GeoIPRecord{Country: fields[2], ASN: asn}
+		if strings.EqualFold(record.Country, "none") {
+			record.Country = ""
+		}
+		if len(fields) > 4 {
+			record.ASOrg = fields[4]
+		}
+		if asn == 0 && record.Country == "" {
+			continue
+		}
+		ranges = append(ranges, geoIPRange{start: start.To16(), end: end.To16(), record: record})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+	return ranges, nil
+}
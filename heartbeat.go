@@ -0,0 +1,84 @@
+package logthing
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MsgTypeHeartbeat is the message type of the heartbeat messages (see WithHeartbeat)
+const MsgTypeHeartbeat = "logthing_heartbeat"
+
+// DispatcherStats contains statistics of the dispatcher
+type DispatcherStats struct {
+	QueueLength      int       // number of currently queued messages
+	QueueCapacity    int       // size of the queue
+	Overflows        uint64    // number of messages dropped because the queue was full
+	Shed             uint64    // number of messages shed because of the memory limit (see WithMemoryLimit)
+	Stale            uint64    // number of messages dropped because they have been queued too long (see WithMaxMessageAge)
+	RetainedBytes    int64     // approximate size of the queued messages (only tracked with WithMemoryLimit)
+	ActiveWriters    int       // number of writers that haven't been disabled
+	ThrottledWriters int       // number of writers that are throttled by their service (see logwriter.Throttled)
+	Batches          uint64    // number of written batches
+	MessagesWritten  uint64    // number of messages that have been successfully written by at least one writer
+	WriteErrors      uint64    // number of failed writes
+	LastWrite        time.Time // time of the last successful write
+}
+
+// stats returns the current dispatcher statistics
+func (ld *logDispatcher) stats() DispatcherStats {
+	ld.queueMutex.RLock()
+	queueLength, queueCapacity := len(ld.logMessageCh), cap(ld.logMessageCh)
+	ld.queueMutex.RUnlock()
+	stats := DispatcherStats{
+		QueueLength:      queueLength,
+		QueueCapacity:    queueCapacity,
+		Overflows:        atomic.LoadUint64(&ld.overflowCounter),
+		Shed:             atomic.LoadUint64(&ld.shedCounter),
+		Stale:            atomic.LoadUint64(&ld.staleCounter),
+		RetainedBytes:    atomic.LoadInt64(&ld.retainedBytes),
+		ActiveWriters:    int(atomic.LoadInt32(&ld.activeWriters)),
+		ThrottledWriters: int(atomic.LoadInt32(&ld.throttledWriters)),
+		Batches:          atomic.LoadUint64(&ld.batchIDCounter),
+		MessagesWritten:  atomic.LoadUint64(&ld.messagesWritten),
+		WriteErrors:      atomic.LoadUint64(&ld.writeErrors),
+	}
+	if lastWrite := atomic.LoadInt64(&ld.lastWrite); lastWrite != 0 {
+		stats.LastWrite = time.Unix(0, lastWrite)
+	}
+	return stats
+}
+
+// heartbeatMsg returns heartbeat message with the dispatcher statistics
+func heartbeatMsg(stats DispatcherStats) LogMsg {
+	msg := NewLogMsg(MsgTypeHeartbeat, WithWhitelistFlag()).
+		SetSeverity(SeverityInfo).
+		SetProperty("queue_length", stats.QueueLength).
+		SetProperty("queue_capacity", stats.QueueCapacity).
+		SetProperty("overflows", stats.Overflows).
+		SetProperty("shed", stats.Shed).
+		SetProperty("stale", stats.Stale).
+		SetProperty("active_writers", stats.ActiveWriters).
+		SetProperty("throttled_writers", stats.ThrottledWriters).
+		SetProperty("batches", stats.Batches).
+		SetProperty("messages_written", stats.MessagesWritten).
+		SetProperty("write_errors", stats.WriteErrors)
+	if !stats.LastWrite.IsZero() {
+		msg.SetProperty("last_write", UTCTime(stats.LastWrite))
+	}
+	return msg
+}
+
+// heartbeat periodically logs heartbeat messages until the dispatcher is stopped
+func (ld *logDispatcher) heartbeat(interval time.Duration) {
+	defer ld.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ld.log(1, heartbeatMsg(ld.stats()))
+		case <-ld.stop:
+			return
+		}
+	}
+}
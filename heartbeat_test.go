@@ -0,0 +1,47 @@
+package logthing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestHeartbeat(t *testing.T) {
+	writer := &recordingWriter{}
+	interval := 20 * time.Millisecond
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithQueueSize(100),
+		WithHeartbeat(interval))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * interval)
+	closeWithin(t, ld)
+	records := recordedTypes(t, writer, MsgTypeHeartbeat)
+	if len(records) < 2 {
+		t.Fatalf("expected periodic heartbeats, got %v messages", len(records))
+	}
+	if records[0]["queue_capacity"] != float64(100) || records[0]["active_writers"] != float64(1) ||
+		records[0]["write_errors"] != float64(0) {
+		t.Errorf("unexpected heartbeat: %v", records[0])
+	}
+	assertInterval(t, records, interval)
+}
+
+func TestHeartbeatMsg(t *testing.T) {
+	lastWrite := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	msg := heartbeatMsg(DispatcherStats{QueueLength: 3, Overflows: 2, Batches: 5, MessagesWritten: 42, LastWrite: lastWrite})
+	if msg.Type() != MsgTypeHeartbeat || msg.Severity() != SeverityInfo {
+		t.Errorf("unexpected heartbeat type %v or severity %v", msg.Type(), msg.Severity())
+	}
+	expected := map[string]interface{}{"queue_length": 3, "overflows": uint64(2), "batches": uint64(5),
+		"messages_written": uint64(42), "last_write": UTCTime(lastWrite)}
+	for property, value := range expected {
+		if msg.Property(property) != value {
+			t.Errorf("expected %v for %v, got %#v", value, property, msg.Property(property))
+		}
+	}
+	if msg := heartbeatMsg(DispatcherStats{}); msg.Property("last_write") != nil {
+		t.Errorf("expected no last write, got %v", msg.Property("last_write"))
+	}
+}
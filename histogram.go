@@ -0,0 +1,94 @@
+package logthing
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// durationHistogram is an exponential histogram of durations with base 2 bucket boundaries in milliseconds
+type durationHistogram struct {
+	count   uint64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+	buckets map[int]uint64 // exponent of the bucket's upper bound (2^exponent ms) -> count
+}
+
+type histogramBucket struct {
+	LE    float64 `json:"le_ms"`
+	Count uint64  `json:"count"`
+}
+
+// record adds the duration to the histogram
+func (h *durationHistogram) record(d time.Duration) {
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+	ms := float64(d) / float64(time.Millisecond)
+	exponent := math.MinInt16
+	if ms > 0 {
+		exponent = int(math.Ceil(math.Log2(ms)))
+	}
+	if h.buckets == nil {
+		h.buckets = map[int]uint64{}
+	}
+	h.buckets[exponent]++
+}
+
+// clone returns a copy of the histogram, which isn't affected by further recorded durations
+func (h *durationHistogram) clone() *durationHistogram {
+	c := *h
+	c.buckets = make(map[int]uint64, len(h.buckets))
+	for exponent, count := range h.buckets {
+		c.buckets[exponent] = count
+	}
+	return &c
+}
+
+// MarshalJSON marshals the histogram with count, sum, min, max and its non-empty buckets
+func (h *durationHistogram) MarshalJSON() ([]byte, error) {
+	exponents := make([]int, 0, len(h.buckets))
+	for exponent := range h.buckets {
+		exponents = append(exponents, exponent)
+	}
+	sort.Ints(exponents)
+	buckets := make([]histogramBucket, len(exponents))
+	for i, exponent := range exponents {
+		le := 0.0
+		if exponent != math.MinInt16 {
+			le = math.Pow(2, float64(exponent))
+		}
+		buckets[i] = histogramBucket{LE: le, Count: h.buckets[exponent]}
+	}
+	toMs := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return json.Marshal(map[string]interface{}{
+		"count":   h.count,
+		"sum_ms":  toMs(h.sum),
+		"min_ms":  toMs(h.min),
+		"max_ms":  toMs(h.max),
+		"buckets": buckets,
+	})
+}
+
+// RecordDuration records the duration in an exponential histogram (base 2 bucket boundaries in milliseconds) that is logged
+// as property with the given key, containing count, sum, min, max and the bucket counts. This allows backends to compute
+// percentiles without shipping every single timing event.
+func (lm *logMsg) RecordDuration(key string, d time.Duration) LogMsg {
+	if lm == nil {
+		return lm
+	}
+	h, ok := lm.Properties()[key].(*durationHistogram)
+	if !ok {
+		h = &durationHistogram{}
+		lm.SetProperty(key, h)
+	}
+	h.record(d)
+	return lm.Self()
+}
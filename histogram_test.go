@@ -0,0 +1,36 @@
+package logthing
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRecordDuration(t *testing.T) {
+	msg := NewLogMsg("test")
+	for _, d := range []time.Duration{time.Millisecond, 3 * time.Millisecond, 4 * time.Millisecond, 100 * time.Millisecond} {
+		msg.RecordDuration("latency", d)
+	}
+	data, err := json.Marshal(msg.Property("latency"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"buckets":[{"le_ms":1,"count":1},{"le_ms":4,"count":2},{"le_ms":128,"count":1}],"count":4,"max_ms":100,"min_ms":1,"sum_ms":108}`
+	if string(data) != expected {
+		t.Errorf("unexpected histogram: %s", data)
+	}
+}
+
+func TestRecordDurationCopy(t *testing.T) {
+	msg := NewLogMsg("test").RecordDuration("latency", time.Millisecond)
+	copied := copyMsg(msg)
+	msg.RecordDuration("latency", time.Second)
+	data, err := json.Marshal(copied.Property("latency"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"buckets":[{"le_ms":1,"count":1}],"count":1,"max_ms":1,"min_ms":1,"sum_ms":1}`
+	if string(data) != expected {
+		t.Errorf("expected copied histogram to be unaffected, got %s", data)
+	}
+}
@@ -0,0 +1,103 @@
+package logthing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	trackingIDGeneratorMutex sync.RWMutex
+	trackingIDGenerator      = NewUUIDv7
+)
+
+// SetTrackingIDGenerator sets the generator that is used by NewTrackingID (default: NewUUIDv7)
+func SetTrackingIDGenerator(generator func() string) {
+	trackingIDGeneratorMutex.Lock()
+	defer trackingIDGeneratorMutex.Unlock()
+	if generator != nil {
+		trackingIDGenerator = generator
+	}
+}
+
+// NewTrackingID returns new tracking ID created by the configured generator (see SetTrackingIDGenerator). By default
+// time sortable UUIDv7 are created, so that IDs sort by time in Kusto.
+func NewTrackingID() string {
+	trackingIDGeneratorMutex.RLock()
+	generator := trackingIDGenerator
+	trackingIDGeneratorMutex.RUnlock()
+	return generator()
+}
+
+// NewUUIDv7 returns new time sortable UUID version 7 (RFC 9562)
+func NewUUIDv7() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	u[2] = byte(ms >> 24)
+	u[3] = byte(ms >> 16)
+	u[4] = byte(ms >> 8)
+	u[5] = byte(ms)
+	u[6] = (u[6] & 0x0f) | 0x70 // version 7
+	u[8] = (u[8] & 0x3f) | 0x80 // variant 10
+	s := hex.EncodeToString(u[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns new time sortable ULID (48 bit millisecond timestamp and 80 bit randomness, Crockford base32 encoded)
+func NewULID() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(ms)
+		ms >>= 8
+	}
+	// 128 bits are encoded in 26 characters of 5 bits with 2 leading zero bits
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	var b strings.Builder
+	for i := 25; i >= 0; i-- {
+		shift := uint(i * 5)
+		var v uint64
+		switch {
+		case shift >= 64:
+			v = hi >> (shift - 64)
+		case shift > 59:
+			v = lo>>shift | hi<<(64-shift)
+		default:
+			v = lo >> shift
+		}
+		b.WriteByte(crockfordAlphabet[v&0x1f])
+	}
+	return b.String()
+}
+
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	ksuidEpoch     = 1400000000
+)
+
+// NewKSUID returns new time sortable KSUID (32 bit timestamp and 128 bit randomness, base62 encoded)
+func NewKSUID() string {
+	var u [20]byte
+	binary.BigEndian.PutUint32(u[:4], uint32(time.Now().Unix()-ksuidEpoch))
+	rand.Read(u[4:])
+	n := new(big.Int).SetBytes(u[:])
+	base := big.NewInt(62)
+	mod := new(big.Int)
+	encoded := make([]byte, 27)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		encoded[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(encoded)
+}
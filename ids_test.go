@@ -0,0 +1,29 @@
+package logthing_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing"
+)
+
+func TestIDs(t *testing.T) {
+	for name, test := range map[string]struct {
+		generator func() string
+		pattern   string
+	}{
+		"uuidv7": {logthing.NewUUIDv7, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		"ulid":   {logthing.NewULID, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		"ksuid":  {logthing.NewKSUID, `^[0-9A-Za-z]{27}$`},
+	} {
+		first := test.generator()
+		if !regexp.MustCompile(test.pattern).MatchString(first) {
+			t.Errorf("%v: invalid id %q", name, first)
+		}
+		time.Sleep(2 * time.Millisecond)
+		if name != "ksuid" && test.generator() <= first {
+			t.Errorf("%v: ids don't sort by time", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package logthing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// defaultImportBatchSize is the default number of messages that are written per batch by Import
+const defaultImportBatchSize = 10000
+
+// importOptions configures Import
+type importOptions struct {
+	batchSize int
+	progress  func(imported int, total int)
+}
+
+// ImportOption is an option of Import
+type ImportOption func(*importOptions)
+
+// WithImportBatchSize sets the number of messages that are written per batch (default: 10000). Batches that exceed a
+// writer's max batch bytes are split nevertheless (see logwriter.Capabilities).
+func WithImportBatchSize(size int) ImportOption {
+	return func(opt *importOptions) {
+		opt.batchSize = size
+	}
+}
+
+// WithImportProgress sets function that is called back after each written batch with the number of imported messages
+// and the total number of messages to import (nil messages are skipped and not counted)
+func WithImportProgress(progress func(imported int, total int)) ImportOption {
+	return func(opt *importOptions) {
+		opt.progress = progress
+	}
+}
+
+// importRequest is a batch of imported messages that is written by the dispatcher goroutine
+type importRequest struct {
+	logMessages []*logMsg
+	done        chan struct{}
+}
+
+// Import writes historical messages (e.g. from NDJSON archives, see ParseLogMsg) with the writers of the default
+// dispatcher in large batches. Unlike Log, imported messages aren't filtered by severity, aren't printed and keep their
+// timestamps and archived properties: Static properties, cloud metadata, enrichers, receive time and log entry IDs only
+// add properties that are absent. Import blocks until all messages have been handed to the writers or the context is done.
+func Import(ctx context.Context, msgs []LogMsg, opts ...ImportOption) error {
+	if ld == nil {
+		return ErrNotInitialized
+	}
+	options := importOptions{batchSize: defaultImportBatchSize}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.batchSize <= 0 {
+		options.batchSize = defaultImportBatchSize
+	}
+	dispatcherOptions := ld.currentOptions()
+	total := 0
+	for _, msg := range msgs {
+		if msg != nil && !msg.IsNil() {
+			total++
+		}
+	}
+	imported := 0
+	for start := 0; start < len(msgs); start += options.batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + options.batchSize
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		req := importRequest{done: make(chan struct{})}
+		for _, msg := range msgs[start:end] {
+			if msg == nil || msg.IsNil() {
+				continue
+			}
+			data := msg.msgData()
+			ld.prepare(data)
+			archived := make(map[string]interface{}, len(data.Properties()))
+			for k, v := range data.Properties() {
+				archived[k] = v
+			}
+			ld.complete(data, dispatcherOptions)
+			for k, v := range archived {
+				data.SetProperty(k, v)
+			}
+			req.logMessages = append(req.logMessages, data)
+		}
+		select {
+		case ld.importCh <- req:
+		case <-ld.done:
+			return ErrNotInitialized
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-req.done
+		imported += len(req.logMessages)
+		if options.progress != nil {
+			options.progress(imported, total)
+		}
+	}
+	return nil
+}
+
+// ParseLogMsg parses a marshalled message (e.g. a line of an NDJSON archive) to be imported (see Import). The reserved
+// properties type, timestamp, severity, trackingID and output are restored, all other properties are set as they are.
+func ParseLogMsg(data []byte) (LogMsg, error) {
+	properties := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&properties); err != nil {
+		return nil, err
+	}
+	msgType, _ := properties[PropertyType].(string)
+	msg := NewLogMsg(msgType).msgData()
+	msg.resolved = true // the output has already been resolved when the message was written
+	for key, value := range properties {
+		switch key {
+		case PropertyType:
+		case PropertyTimestamp:
+			if s, ok := value.(string); ok {
+				if timestamp, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					msg.SetTimestamp(timestamp)
+				}
+			}
+		case PropertySeverity:
+			if n, ok := value.(json.Number); ok {
+				if severity, err := n.Int64(); err == nil {
+					msg.SetSeverity(Severity(severity))
+				}
+			}
+		case PropertyTrackingID:
+			if trackingID, ok := value.(string); ok {
+				msg.SetTrackingID(trackingID)
+			}
+		case PropertyOutput:
+			if lines, ok := value.([]interface{}); ok {
+				for _, line := range lines {
+					if s, ok := line.(string); ok {
+						msg.output = append(msg.output, s)
+					}
+				}
+			}
+		default:
+			if n, ok := value.(json.Number); ok {
+				if i, err := n.Int64(); err == nil {
+					value = i
+				} else if f, err := n.Float64(); err == nil {
+					value = f
+				}
+			}
+			msg.SetProperty(key, value)
+		}
+	}
+	return msg, nil
+}
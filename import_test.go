@@ -0,0 +1,57 @@
+package logthing
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestImport(t *testing.T) {
+	writer := &soakWriter{}
+	var err error
+	ld, err = newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithSetLogEntryID(),
+		WithSetStaticProperties(map[string]interface{}{"env": "prod", "region": "eu"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	msg, err := ParseLogMsg([]byte(`{"type":"archived","timestamp":"2021-03-04T05:06:07.123Z","severity":7,"trackingID":"t1","output":["hello"],"count":3,"env":"staging","logEntryID":42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type() != "archived" || msg.Severity() != SeverityTrace || msg.TrackingID() != "t1" || msg.Property("count") != int64(3) ||
+		!msg.Timestamp().Equal(time.Date(2021, 3, 4, 5, 6, 7, 123000000, time.UTC)) || len(msg.Output()) != 1 {
+		t.Fatalf("unexpected parsed message: %v", msg.Properties())
+	}
+	msgs := []LogMsg{msg, nil}
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, NewLogMsg("archived").SetSeverity(SeverityTrace))
+	}
+	var progress []int
+	err = Import(context.Background(), msgs, WithImportBatchSize(2), WithImportProgress(func(imported int, total int) {
+		if total != 5 {
+			t.Errorf("expected total of 5 messages without nil message, got %v", total)
+		}
+		progress = append(progress, imported)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written := atomic.LoadUint64(&writer.written); written != 5 {
+		t.Errorf("expected 5 imported messages, got %v", written)
+	}
+	if len(progress) != 3 || progress[0] != 1 || progress[2] != 5 {
+		t.Errorf("unexpected progress: %v", progress)
+	}
+	if msg.Property("env") != "staging" || msg.Property(PropertyLogEntryID) != int64(42) || msg.Property("region") != "eu" {
+		t.Errorf("expected archived properties to be kept and absent ones to be added: %v", msg.Properties())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Import(ctx, msgs); err != context.Canceled {
+		t.Errorf("expected canceled import, got %v", err)
+	}
+}
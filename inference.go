@@ -0,0 +1,185 @@
+package logthing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SeverityRule assigns the severity to plain-text lines that match the pattern (see InferenceRules)
+type SeverityRule struct {
+	Pattern  *regexp.Regexp
+	Severity Severity
+}
+
+// TypeRule assigns the message type to plain-text lines that match the pattern. If Type is empty, the first submatch of
+// the pattern is used, e.g. `^\[(\w+)\]` extracts "db" from "[db] connection lost".
+type TypeRule struct {
+	Pattern *regexp.Regexp
+	Type    string
+}
+
+// TimestampRule extracts the original event time of plain-text lines: the first submatch of the pattern (or the whole
+// match) is parsed with the layout (see time.Parse) in the location (default: local time) if the layout has no zone.
+// Years that are missing in the layout (e.g. time.Stamp of syslog lines) are completed with the current year.
+type TimestampRule struct {
+	Pattern  *regexp.Regexp
+	Layout   string
+	Location *time.Location
+}
+
+// InferenceRules infer the severity, type and timestamp of plain-text lines of legacy components (see CapturePipe and
+// Collect). The rules are evaluated in order and the first rule that matches any line of a message applies.
+type InferenceRules struct {
+	Severity  []SeverityRule
+	Type      []TypeRule
+	Timestamp []TimestampRule
+}
+
+// DefaultSeverityRules detect common severity keywords like "ERROR", "WARN", "level=error" or "panic:"
+var DefaultSeverityRules = []SeverityRule{
+	{regexp.MustCompile(`\b(EMERG|EMERGENCY)\b|(?i)level=emerg`), SeverityEmergency},
+	{regexp.MustCompile(`\bALERT\b|(?i)level=alert`), SeverityAlert},
+	{regexp.MustCompile(`\b(FATAL|CRITICAL|CRIT)\b|^panic: |^fatal error: |(?i)level=(fatal|crit|critical)\b`), SeverityCritical},
+	{regexp.MustCompile(`\b(ERROR|ERR|SEVERE)\b|^Traceback \(most recent call last\)|^Exception in thread |(?i)level=(error|err)\b`), SeverityError},
+	{regexp.MustCompile(`\b(WARN|WARNING)\b|(?i)level=(warn|warning)\b`), SeverityWarning},
+	{regexp.MustCompile(`\bNOTICE\b|(?i)level=notice\b`), SeverityNotice},
+	{regexp.MustCompile(`\bINFO\b|(?i)level=info\b`), SeverityInfo},
+	{regexp.MustCompile(`\b(DEBUG|TRACE)\b|(?i)level=(debug|trace)\b`), SeverityTrace},
+}
+
+// DefaultTimestampRules extract RFC 3339, "2006-01-02 15:04:05", "2006/01/02 15:04:05" (Go's log package) and syslog
+// ("Jan _2 15:04:05") timestamps at the beginning of lines
+var DefaultTimestampRules = []TimestampRule{
+	{Pattern: regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2}))`), Layout: time.RFC3339Nano},
+	{Pattern: regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:[.,]\d+)?)`), Layout: "2006-01-02 15:04:05"},
+	{Pattern: regexp.MustCompile(`^\[?(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)`), Layout: "2006/01/02 15:04:05"},
+	{Pattern: regexp.MustCompile(`^([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2})`), Layout: time.Stamp},
+}
+
+// DefaultInferenceRules returns the rules with DefaultSeverityRules and DefaultTimestampRules
+func DefaultInferenceRules() InferenceRules {
+	return InferenceRules{Severity: DefaultSeverityRules, Timestamp: DefaultTimestampRules}
+}
+
+// ParseSeverityRule parses a severity rule given as "<regexp>=<severity>", e.g. "OutOfMemory|Segfault=critical"
+func ParseSeverityRule(rule string) (SeverityRule, error) {
+	i := strings.LastIndex(rule, "=")
+	if i < 0 {
+		return SeverityRule{}, fmt.Errorf("invalid severity rule %q, expected <regexp>=<severity>", rule)
+	}
+	severity, err := ParseSeverity(rule[i+1:])
+	if err != nil {
+		return SeverityRule{}, err
+	}
+	pattern, err := regexp.Compile(rule[:i])
+	if err != nil {
+		return SeverityRule{}, err
+	}
+	return SeverityRule{Pattern: pattern, Severity: severity}, nil
+}
+
+// ParseTimestampRule parses a timestamp rule given as "<regexp>=<layout>", e.g. `^(\d{2}\.\d{2}\.\d{4} \d{2}:\d{2})=02.01.2006 15:04`
+func ParseTimestampRule(rule string) (TimestampRule, error) {
+	i := strings.LastIndex(rule, "=")
+	if i < 0 || strings.TrimSpace(rule[i+1:]) == "" {
+		return TimestampRule{}, fmt.Errorf("invalid timestamp rule %q, expected <regexp>=<layout>", rule)
+	}
+	pattern, err := regexp.Compile(rule[:i])
+	if err != nil {
+		return TimestampRule{}, err
+	}
+	return TimestampRule{Pattern: pattern, Layout: rule[i+1:]}, nil
+}
+
+// Infer returns the severity and type of the lines, or the given defaults if no rule matches
+func (r InferenceRules) Infer(lines []string, defaultSeverity Severity, defaultType string) (severity Severity, msgType string) {
+	severity, msgType = defaultSeverity, defaultType
+severityRules:
+	for _, rule := range r.Severity {
+		for _, line := range lines {
+			if rule.Pattern.MatchString(line) {
+				severity = rule.Severity
+				break severityRules
+			}
+		}
+	}
+	for _, rule := range r.Type {
+		for _, line := range lines {
+			if t, ok := rule.match(line); ok {
+				return severity, t
+			}
+		}
+	}
+	return severity, msgType
+}
+
+// InferTimestamp returns the event time of the lines according to the first timestamp rule that matches the first
+// line. Further lines (e.g. of stack traces or embedded payloads) aren't considered, as their timestamps don't denote
+// the event time.
+func (r InferenceRules) InferTimestamp(lines []string) (time.Time, bool) {
+	if len(lines) == 0 {
+		return time.Time{}, false
+	}
+	for _, rule := range r.Timestamp {
+		if timestamp, ok := rule.match(lines[0]); ok {
+			return timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// match returns the timestamp if the line matches the rule
+func (rule TimestampRule) match(line string) (time.Time, bool) {
+	match := rule.Pattern.FindStringSubmatch(line)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+	location := rule.Location
+	if location == nil {
+		location = time.Local
+	}
+	timestamp, err := time.ParseInLocation(rule.Layout, value, location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if timestamp.Year() == 0 {
+		now := time.Now().In(location)
+		timestamp = timestamp.AddDate(now.Year(), 0, 0)
+		if timestamp.After(now.Add(24 * time.Hour)) {
+			// e.g. lines of December read in January
+			timestamp = timestamp.AddDate(-1, 0, 0)
+		}
+	}
+	return timestamp, true
+}
+
+// match returns the type if the line matches the rule
+func (rule TypeRule) match(line string) (string, bool) {
+	match := rule.Pattern.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+	if rule.Type != "" {
+		return rule.Type, true
+	}
+	if len(match) > 1 && match[1] != "" {
+		return match[1], true
+	}
+	return "", false
+}
+
+// isContinuation returns true if the line continues the previous line, e.g. a stack trace frame (see LineGrouper)
+func isContinuation(line string) bool {
+	for _, pattern := range continuationPatterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
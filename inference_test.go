@@ -0,0 +1,73 @@
+package logthing
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestInferenceRules(t *testing.T) {
+	rule, err := ParseSeverityRule("OutOfMemory=critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := InferenceRules{
+		Severity: append([]SeverityRule{rule}, DefaultSeverityRules...),
+		Type:     []TypeRule{{Pattern: regexp.MustCompile(`^\[(\w+)\]`)}},
+	}
+	tests := []struct {
+		lines    []string
+		severity Severity
+		msgType  string
+	}{
+		{[]string{"2020-01-01 ERROR connection refused"}, SeverityError, "legacy"},
+		{[]string{"[db] WARN slow query"}, SeverityWarning, "db"},
+		{[]string{"panic: boom", "", "goroutine 1 [running]:"}, SeverityCritical, "legacy"},
+		{[]string{`time=now level=error msg="failed"`}, SeverityError, "legacy"},
+		{[]string{"java.lang.OutOfMemoryError: heap"}, SeverityCritical, "legacy"},
+		{[]string{"no errors found"}, SeverityInfo, "legacy"},
+	}
+	for _, test := range tests {
+		severity, msgType := rules.Infer(test.lines, SeverityInfo, "legacy")
+		if severity != test.severity || msgType != test.msgType {
+			t.Errorf("%q: expected %v/%v, got %v/%v", test.lines, test.severity, test.msgType, severity, msgType)
+		}
+	}
+	if _, err := ParseSeverityRule("ERROR"); err == nil {
+		t.Error("expected error for rule without severity")
+	}
+}
+
+func TestInferTimestamp(t *testing.T) {
+	rule, err := ParseTimestampRule(`^(\d{2}\.\d{2}\.\d{4} \d{2}:\d{2})=02.01.2006 15:04`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.Location = time.UTC
+	rules := InferenceRules{Timestamp: append([]TimestampRule{rule}, DefaultTimestampRules...)}
+	tests := []struct {
+		line      string
+		timestamp time.Time
+	}{
+		{"2020-03-04T05:06:07.5Z INFO started", time.Date(2020, 3, 4, 5, 6, 7, 5e8, time.UTC)},
+		{"[2020-03-04T05:06:07+01:00] started", time.Date(2020, 3, 4, 4, 6, 7, 0, time.UTC)},
+		{"2020/03/04 05:06:07 started", time.Date(2020, 3, 4, 5, 6, 7, 0, time.Local)},
+		{"2020-03-04 05:06:07,250 ERROR failed", time.Date(2020, 3, 4, 5, 6, 7, 25e7, time.Local)},
+		{"04.03.2020 05:06 started", time.Date(2020, 3, 4, 5, 6, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		timestamp, ok := rules.InferTimestamp([]string{test.line})
+		if !ok || !timestamp.Equal(test.timestamp) {
+			t.Errorf("%q: expected %v, got %v (%v)", test.line, test.timestamp, timestamp, ok)
+		}
+	}
+	if timestamp, ok := rules.InferTimestamp([]string{"Mar  4 05:06:07 host app: started"}); !ok || timestamp.Year() < time.Now().Year()-1 {
+		t.Errorf("expected syslog timestamp with current year, got %v (%v)", timestamp, ok)
+	}
+	if _, ok := rules.InferTimestamp([]string{"started"}); ok {
+		t.Error("expected no timestamp")
+	}
+	if _, ok := rules.InferTimestamp([]string{"request failed", "2020-03-04T05:06:07Z payload"}); ok {
+		t.Error("expected timestamps of further lines to be ignored")
+	}
+}
@@ -0,0 +1,149 @@
+package logthing
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrIngestSocketInUse is returned by ServeIngest if another server listens on the socket
+var ErrIngestSocketInUse = errors.New("ingest socket in use")
+
+// ServeIngest listens on the given Unix domain socket and writes the messages that are received from other processes
+// (see logwriter.NewIngestWriter) with the writers of the default dispatcher. This way several small processes on one
+// host can funnel their messages through one dispatcher that holds the writer credentials.
+//
+// Clients send batches of NDJSON lines (see ParseLogMsg), each batch is terminated by an empty line and acknowledged
+// with "ok" or "error: <reason>". Received messages were already filtered and printed by the client and are therefore
+// neither filtered by severity nor printed again. Messages that can't be queued (e.g. ErrChannelFull) fail the batch,
+// so that the client's dispatcher reports the write error. The client doesn't retry failed batches, the messages of a
+// failed batch that have been queued are written nevertheless. The socket is only accessible by the user of the
+// process (mode 0600), it's created in a private directory and moved into place afterwards. A stale socket file is
+// removed, but ServeIngest fails with ErrIngestSocketInUse if another server still listens on it. ServeIngest blocks
+// until the dispatcher is closed and removes the socket file then.
+func ServeIngest(socketPath string) error {
+	d := ld
+	if d == nil {
+		return ErrNotInitialized
+	}
+	if info, err := os.Stat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+			conn.Close()
+			return ErrIngestSocketInUse
+		}
+		os.Remove(socketPath)
+	}
+	listener, err := listenIngest(socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	var wg sync.WaitGroup
+	conns := map[net.Conn]struct{}{}
+	var connsMutex sync.Mutex
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-d.stop:
+		case <-stopped:
+		}
+		listener.Close()
+		connsMutex.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		connsMutex.Unlock()
+	}()
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-d.stop:
+				return nil
+			default:
+			}
+			return err
+		}
+		connsMutex.Lock()
+		conns[conn] = struct{}{}
+		connsMutex.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.serveIngestConn(conn)
+			connsMutex.Lock()
+			delete(conns, conn)
+			connsMutex.Unlock()
+		}()
+	}
+}
+
+// listenIngest listens on the socket with mode 0600. The socket is created in a private temporary directory and
+// renamed to the socket path afterwards, so that other users can't connect before its mode has been set.
+func listenIngest(socketPath string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".ingest-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false) // the socket file is renamed
+	if err = os.Chmod(tmpPath, 0600); err == nil {
+		err = os.Rename(tmpPath, socketPath)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serveIngestConn reads message batches from the connection until it's closed
+func (ld *logDispatcher) serveIngestConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var batchErr error
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			if err := ld.ingest(line); err != nil && batchErr == nil {
+				batchErr = err
+			}
+			continue
+		}
+		response := "ok\n"
+		if batchErr != nil {
+			response = fmt.Sprintf("error: %v\n", batchErr)
+		}
+		batchErr = nil
+		if _, err := conn.Write([]byte(response)); err != nil {
+			return
+		}
+	}
+}
+
+// ingest parses a received message and queues it to be written
+func (ld *logDispatcher) ingest(line []byte) error {
+	msg, err := ParseLogMsg(line)
+	if err != nil {
+		return fmt.Errorf("parsing message failed: %w", err)
+	}
+	data := msg.msgData()
+	options := ld.currentOptions()
+	ld.prepare(data)
+	ld.complete(data, options)
+	return ld.enqueue(data, options)
+}
@@ -0,0 +1,74 @@
+package logthing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestServeIngest(t *testing.T) {
+	writer := &soakWriter{}
+	var err error
+	ld, err = newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(t.TempDir(), "ingest.sock")
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeIngest(socketPath)
+	}()
+	t.Setenv("LOGTHING_INGEST_SOCKET", socketPath)
+	client := logwriter.NewIngestWriter()
+	if err := client.Init(logwriter.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 100; i++ {
+		if err = client.(logwriter.Validator).Validate(context.Background()); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(socketPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected socket with mode 0600: %v", err)
+	}
+	if err := ServeIngest(socketPath); err != ErrIngestSocketInUse {
+		t.Errorf("expected socket in use error, got %v", err)
+	}
+	now := time.Now()
+	msgs := []json.RawMessage{
+		json.RawMessage(`{"type":"forwarded","severity":3,"output":["first"]}`),
+		json.RawMessage(`{"type":"forwarded","severity":7,"output":["second"]}`),
+	}
+	if err := client.WriteLogMessages(msgs, []time.Time{now, now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteLogMessages([]json.RawMessage{json.RawMessage(`{invalid`)}, []time.Time{now}); err == nil {
+		t.Error("expected invalid message to be rejected")
+	}
+	Close()
+	if written := atomic.LoadUint64(&writer.written); written != 2 {
+		t.Errorf("expected 2 ingested messages, got %v", written)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("ServeIngest didn't return after Close")
+	}
+	if entries, err := os.ReadDir(filepath.Dir(socketPath)); err != nil || len(entries) != 0 {
+		t.Errorf("expected socket file to be removed, got %v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,70 @@
+package logadmin
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-kusto-go/kusto"
+	"github.com/Azure/azure-kusto-go/kusto/kql"
+	"github.com/mfmayer/logthing"
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// dataExplorerDatabase is the database of the Azure Data Explorer writer
+const dataExplorerDatabase = "logs"
+
+type dataExplorer struct {
+	client *kusto.Client
+	table  string
+}
+
+// NewDataExplorerBackend returns Backend that purges messages from the log table of the Azure Data Explorer writer
+// with the ".purge" command. It reuses the cluster and AAD application of the writer (LOGTHING_DATA_EXPLORER_*), which
+// needs the "AllDatabasesAdmin" role. Purges are executed by the data management endpoint of the cluster
+// ("ingest-" prefixed cluster URL).
+func NewDataExplorerBackend() (Backend, error) {
+	client, err := logwriter.NewDataExplorerClient(dataManagementURL(logwriter.Getenv("LOGTHING_DATA_EXPLORER_CLUSTER_URL")))
+	if err != nil {
+		return nil, err
+	}
+	return &dataExplorer{client: client, table: logthing.ConfigLogName()}, nil
+}
+
+// dataManagementURL returns the data management endpoint of the cluster
+func dataManagementURL(clusterURL string) string {
+	if clusterURL == "" || strings.Contains(clusterURL, "://ingest-") {
+		return clusterURL
+	}
+	return strings.Replace(clusterURL, "://", "://ingest-", 1)
+}
+
+// Name returns the name of the backend
+func (de *dataExplorer) Name() string {
+	return "dataexplorer"
+}
+
+// DeleteByTrackingID purges the records with given tracking ID from the log table
+func (de *dataExplorer) DeleteByTrackingID(ctx context.Context, trackingID string) (operationID string, err error) {
+	query := kql.New(".purge table ").AddTable(de.table).AddUnsafe(" records in database ").AddDatabase(dataExplorerDatabase).
+		AddUnsafe(" with (noregrets='true') <| where ").AddColumn(logthing.PropertyTrackingID).AddUnsafe(" == ").AddString(trackingID)
+	_, err = de.client.Mgmt(ctx, dataExplorerDatabase, query)
+	return "", err
+}
+
+// Close closes the client
+func (de *dataExplorer) Close() error {
+	return de.client.Close()
+}
+
+// DropByTag drops the extents of the log table that have been tagged with the "drop-by:" tag (see
+// logwriter.WithDropByTags), e.g. all data of a deployment or batch
+func DropByTag(ctx context.Context, tag string) error {
+	client, err := logwriter.NewDataExplorerClient("")
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	query := kql.New(".drop extents <| .show table ").AddTable(logthing.ConfigLogName()).AddUnsafe(" extents where tags has ").AddString("drop-by:" + tag)
+	_, err = client.Mgmt(ctx, dataExplorerDatabase, query)
+	return err
+}
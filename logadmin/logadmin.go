@@ -0,0 +1,99 @@
+// Package logadmin provides administrative operations on the logs that have been written with logthing, e.g. to
+// automate data-subject deletion requests (GDPR).
+//
+// The following environment variables are used to configure the backends:
+// LOGTHING_AZURE_SUBSCRIPTION_ID  - Azure subscription of the Log Analytics workspace
+// LOGTHING_AZURE_RESOURCE_GROUP   - Resource group of the Log Analytics workspace
+// LOGTHING_AZURE_WORKSPACE_NAME   - Name of the Log Analytics workspace
+// LOGTHING_DATA_EXPLORER_*        - Cluster and AAD application of the Azure Data Explorer writer (see logwriter.NewAzureDataExplorerWriter)
+//
+// The log table is derived from LOGTHING_LOG_NAME like the writers do.
+package logadmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// ErrNoBackend is returned if no backend has been configured
+var ErrNoBackend = errors.New("no backend configured")
+
+// Backend deletes messages from a log store
+type Backend interface {
+	Name() string
+	// DeleteByTrackingID deletes the messages with given tracking ID and returns the ID of the (asynchronous) operation
+	DeleteByTrackingID(ctx context.Context, trackingID string) (operationID string, err error)
+}
+
+// DeleteResult is the result of a delete request to a backend
+type DeleteResult struct {
+	Backend     string
+	OperationID string // ID of the purge operation, whose status can be checked with the backend (if provided)
+	Err         error
+}
+
+// Backends returns the backends that are configured by environment variables: Log Analytics if
+// LOGTHING_AZURE_SUBSCRIPTION_ID is set and Azure Data Explorer if LOGTHING_DATA_EXPLORER_CLUSTER_URL is set
+func Backends() (backends []Backend, err error) {
+	if logwriter.Getenv("LOGTHING_AZURE_SUBSCRIPTION_ID") != "" {
+		backend, err := NewLogAnalyticsBackend()
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	if logwriter.Getenv("LOGTHING_DATA_EXPLORER_CLUSTER_URL") != "" {
+		backend, err := NewDataExplorerBackend()
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	return backends, nil
+}
+
+// DeleteByTrackingID issues the purge commands for the messages with given tracking ID to all configured backends (see
+// Backends), e.g. to automate data-subject deletion requests. Purges are executed asynchronously by the backends, the
+// results contain the operation IDs if provided.
+func DeleteByTrackingID(ctx context.Context, trackingID string) ([]DeleteResult, error) {
+	backends, err := Backends()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, backend := range backends {
+			if closer, ok := backend.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}()
+	return DeleteByTrackingIDFrom(ctx, trackingID, backends...)
+}
+
+// DeleteByTrackingIDFrom is like DeleteByTrackingID but with given backends. An error is returned if the delete request
+// failed for any backend, the results contain the error of each backend.
+func DeleteByTrackingIDFrom(ctx context.Context, trackingID string, backends ...Backend) (results []DeleteResult, err error) {
+	if strings.TrimSpace(trackingID) == "" {
+		return nil, fmt.Errorf("tracking ID must not be empty")
+	}
+	if len(backends) == 0 {
+		return nil, ErrNoBackend
+	}
+	var failed []string
+	for _, backend := range backends {
+		operationID, err := backend.DeleteByTrackingID(ctx, trackingID)
+		results = append(results, DeleteResult{Backend: backend.Name(), OperationID: operationID, Err: err})
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", backend.Name(), err))
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("deleting tracking ID failed: %v", strings.Join(failed, "; "))
+	}
+	return results, nil
+}
@@ -0,0 +1,116 @@
+package logadmin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/mfmayer/logthing"
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+const (
+	managementScope        = "https://management.azure.com/.default"
+	purgeAPIVersion        = "2020-08-01"
+	trackingIDColumnSuffix = "_s" // string columns of the data collector API have the suffix "_s"
+)
+
+// managementURL is the Azure Resource Manager endpoint (variable to be replaced by tests)
+var managementURL = "https://management.azure.com"
+
+type logAnalytics struct {
+	workspacePath string
+	table         string
+	token         *logwriter.RefreshingToken
+	httpClient    *http.Client
+}
+
+// NewLogAnalyticsBackend returns Backend that purges messages from the Log Analytics workspace with the purge API. The
+// Azure Monitor writer authenticates with the workspace key, which isn't allowed to purge, therefore the workspace is
+// identified by LOGTHING_AZURE_SUBSCRIPTION_ID, LOGTHING_AZURE_RESOURCE_GROUP and LOGTHING_AZURE_WORKSPACE_NAME and the
+// requests are authenticated with AAD (see azidentity.NewDefaultAzureCredential). The identity needs the "Data Purger"
+// role on the workspace.
+func NewLogAnalyticsBackend() (Backend, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create AAD credential: %w", err)
+	}
+	return newLogAnalytics(func(ctx context.Context) (logwriter.Token, error) {
+		token, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{managementScope}})
+		if err != nil {
+			return logwriter.Token{}, err
+		}
+		return logwriter.Token{Value: "Bearer " + token.Token, ExpiresOn: token.ExpiresOn}, nil
+	})
+}
+
+func newLogAnalytics(tokenSource logwriter.TokenSource) (*logAnalytics, error) {
+	subscriptionID := logwriter.Getenv("LOGTHING_AZURE_SUBSCRIPTION_ID")
+	resourceGroup := logwriter.Getenv("LOGTHING_AZURE_RESOURCE_GROUP")
+	workspace := logwriter.Getenv("LOGTHING_AZURE_WORKSPACE_NAME")
+	if subscriptionID == "" || resourceGroup == "" || workspace == "" {
+		return nil, fmt.Errorf("environment variables \"LOGTHING_AZURE_SUBSCRIPTION_ID\", \"LOGTHING_AZURE_RESOURCE_GROUP\" and \"LOGTHING_AZURE_WORKSPACE_NAME\" must be set")
+	}
+	logName := logthing.ConfigLogName()
+	if logName == "" {
+		return nil, fmt.Errorf("environment variable \"LOGTHING_LOG_NAME\" must be set")
+	}
+	return &logAnalytics{
+		workspacePath: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.OperationalInsights/workspaces/%s",
+			url.PathEscape(subscriptionID), url.PathEscape(resourceGroup), url.PathEscape(workspace)),
+		table:      logName + "_CL",
+		token:      logwriter.NewRefreshingToken(tokenSource, 5*time.Minute),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Name returns the name of the backend
+func (la *logAnalytics) Name() string {
+	return "loganalytics"
+}
+
+// DeleteByTrackingID requests the purge of the messages with given tracking ID from the log table
+func (la *logAnalytics) DeleteByTrackingID(ctx context.Context, trackingID string) (operationID string, err error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"table": la.table,
+		"filters": []map[string]interface{}{{
+			"column":   logthing.PropertyTrackingID + trackingIDColumnSuffix,
+			"operator": "==",
+			"value":    trackingID,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	token, err := la.token.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot get AAD token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, managementURL+la.workspacePath+"/purge?api-version="+purgeAPIVersion, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	resp, err := la.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("purge request failed with status %v: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	var result struct {
+		OperationID string `json:"operationId"`
+	}
+	json.Unmarshal(respBody, &result)
+	return result.OperationID, nil
+}
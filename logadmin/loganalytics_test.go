@@ -0,0 +1,65 @@
+package logadmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mfmayer/logthing"
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestLogAnalyticsPurge(t *testing.T) {
+	t.Setenv("LOGTHING_LOG_NAME", "app")
+	t.Setenv("LOGTHING_AZURE_SUBSCRIPTION_ID", "sub")
+	t.Setenv("LOGTHING_AZURE_RESOURCE_GROUP", "rg")
+	t.Setenv("LOGTHING_AZURE_WORKSPACE_NAME", "ws")
+	logthing.ReloadConfig()
+	defer logthing.ReloadConfig()
+
+	var purge struct {
+		Table   string `json:"table"`
+		Filters []struct {
+			Column   string `json:"column"`
+			Operator string `json:"operator"`
+			Value    string `json:"value"`
+		} `json:"filters"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.OperationalInsights/workspaces/ws/purge" {
+			t.Errorf("unexpected path %v", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("unexpected authorization %q", auth)
+		}
+		json.NewDecoder(r.Body).Decode(&purge)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"operationId":"purge-1"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { managementURL = url }(managementURL)
+	managementURL = server.URL
+
+	backend, err := newLogAnalytics(func(ctx context.Context) (logwriter.Token, error) {
+		return logwriter.Token{Value: "Bearer token"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := DeleteByTrackingIDFrom(context.Background(), "user-42", backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].OperationID != "purge-1" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if purge.Table != "app_CL" || len(purge.Filters) != 1 || purge.Filters[0].Column != "trackingID_s" || purge.Filters[0].Value != "user-42" {
+		t.Errorf("unexpected purge request %+v", purge)
+	}
+
+	if _, err := DeleteByTrackingIDFrom(context.Background(), "", backend); err == nil {
+		t.Error("expected error for empty tracking ID")
+	}
+}
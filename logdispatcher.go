@@ -1,14 +1,16 @@
 package logthing
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"path/filepath"
-	"runtime"
-	"sort"
+	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,12 +18,45 @@ import (
 )
 
 type dispatcherOptions struct {
-	dispatchInterval time.Duration
-	queueSize        int
-	dispatchCallback func(msg LogMsg)
-	overflowCallback func(droppedMsg LogMsg, overflowCount uint64)
-	setEntryID       bool
-	staticProperties map[string]interface{}
+	dispatchInterval          time.Duration
+	queueSize                 int
+	dispatchCallback          func(msg LogMsg)
+	overflowCallback          func(droppedMsg LogMsg, overflowCount uint64)
+	writerObserver            func(writerName string, batchSize int, duration time.Duration, err error)
+	setEntryID                bool
+	idGenerator               func() string
+	maxMessageAge             time.Duration
+	flushSeverity             Severity
+	typeIntervals             map[string]time.Duration
+	maxBatchSize              int
+	fallbackSeverity          Severity
+	strictConfig              bool
+	filteredRingSize          int
+	recentMessages            int
+	metricsInterval           time.Duration
+	heartbeatInterval         time.Duration
+	denyLogTypes              typeMatcher
+	staticProperties          map[string]interface{}
+	companion                 companionOptions
+	budget                    budgetOptions
+	schemaDriftPolicy         SchemaDriftPolicy
+	writerSchemaDriftPolicies map[logwriter.LogWriter]SchemaDriftPolicy
+	credentialRefresh         time.Duration
+	signer                    *messageSigner
+	encoder                   Encoder
+	classification            classificationOptions
+	cloudMetadata             bool
+	memoryLimit               int64
+	queueCompression          int
+	flattenSeparator          string
+	writerConcurrency         map[logwriter.LogWriter]int
+	checkpointFile            string
+	disabledCallback          func(name string, err error)
+	classifier                func(LogMsg) []string
+	labelSampleRates          map[string]float64
+	routing                   *RoutingRules
+	receiveTime               bool
+	enrichers                 []orderedEnricher
 }
 
 // logDispatcher can be created using newLogDispatcher and can be used to write log messages to various cloud logging services
@@ -34,12 +69,39 @@ type dispatcherOptions struct {
 // Must be closed when no longer needed, to ensure that all log messages have been written, user writers are closed and resources are freed.
 type logDispatcher struct {
 	schema            map[string]logwriter.Kind
+	schemaMutex       sync.Mutex               // guards schema, which is also extended by transactional writes (see LogTx)
+	schemaPending     bool                     // schema has been extended by a transactional write, guarded by schemaMutex
+	budgets           map[int]*ingestionBudget // ingestion budgets by writer index, accessed by run goroutine only
+	writerPools       map[logwriter.LogWriter]*writerPool
 	options           dispatcherOptions
+	optionsMutex      sync.RWMutex
 	logMessageCh      chan *logMsg
+	queueMutex        sync.RWMutex // guards sending to and swapping of logMessageCh
+	queueClosed       bool         // logMessageCh has been closed, guarded by queueMutex
+	reconfigureCh     chan struct{}
+	writersCh         chan chan []logwriter.LogWriter // requests for a copy of the registered writers (see Validate)
+	importCh          chan importRequest
+	filteredRing      *msgRing
+	checkpoints       *checkpointStore
+	recentRing        *msgRing       // copies of the last dispatched messages (see WithRecentMessages)
+	stop              chan struct{}  // closed to stop background goroutines
+	background        sync.WaitGroup // background goroutines that log messages
 	logWriters        []logwriter.LogWriter
+	txWriters         []logwriter.TxWriter // writers that support transactional writes (see LogTx)
 	done              chan bool
+	errorCh           chan DispatchError
 	overflowCounter   uint64
 	logEntryIDCounter uint64
+	batchIDCounter    uint64
+	messagesWritten   uint64
+	writeErrors       uint64
+	lastWrite         int64 // unix nano
+	activeWriters     int32
+	throttledWriters  int32
+	cloudMetadata     atomic.Value // map[string]interface{} with cloud metadata properties (see WithCloudMetadataEnrichment)
+	retainedBytes     int64        // approximate size of queued messages (see WithMemoryLimit)
+	shedCounter       uint64
+	staleCounter      uint64
 }
 
 // NewLogDispatcher returns a new LogDispatcher
@@ -47,64 +109,347 @@ func newLogDispatcher(logWriters []logwriter.LogWriter, opts ...func(*dispatcher
 	options := dispatcherOptions{
 		dispatchInterval: 5 * time.Second,
 		queueSize:        8192,
+		flushSeverity:    SeverityNotApplied,
+		fallbackSeverity: SeverityNotApplied,
+
+		credentialRefresh: time.Minute,
 	}
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if options.routing == nil {
+		if text := logwriter.Getenv("LOGTHING_ROUTES"); text != "" {
+			if options.routing, err = ParseRoutingRules(text); err != nil {
+				return nil, fmt.Errorf("LOGTHING_ROUTES: %w", err)
+			}
+		}
+	}
+	if options.routing != nil {
+		if err := options.routing.validateWriters(logWriters); err != nil {
+			return nil, fmt.Errorf("routing rules: %w", err)
+		}
+	}
+	if options.strictConfig {
+		var configErrors []error
+		for _, issue := range ValidateConfig() {
+			if !issue.Warning {
+				configErrors = append(configErrors, issue)
+			}
+		}
+		if len(configErrors) > 0 {
+			return nil, fmt.Errorf("invalid configuration: %v", configErrors)
+		}
+	}
 
 	ld = &logDispatcher{
-		schema:       map[string]logwriter.Kind{},
-		options:      options,
-		logMessageCh: make(chan *logMsg, options.queueSize),
-		done:         make(chan bool),
+		schema:        map[string]logwriter.Kind{},
+		options:       options,
+		logMessageCh:  make(chan *logMsg, options.queueSize),
+		done:          make(chan bool),
+		reconfigureCh: make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		writersCh:     make(chan chan []logwriter.LogWriter),
+		importCh:      make(chan importRequest),
+		errorCh:       make(chan DispatchError, 64),
+	}
+	if options.filteredRingSize > 0 {
+		ld.filteredRing = newMsgRing(options.filteredRingSize)
+		atomic.AddInt32(&retainFilteredOutput, 1)
+	}
+	if options.recentMessages > 0 {
+		ld.recentRing = newMsgRing(options.recentMessages)
 	}
 	lwConfig := logwriter.Config{
-		LogName: config.logName,
+		LogName: currentConfig().logName,
+	}
+	if options.signer != nil {
+		lwConfig.Resign = resign(options.encoder, options.signer)
+	}
+	var lwInitErrors WriterInitErrors
+	if options.checkpointFile != "" {
+		if ld.checkpoints, err = loadCheckpoints(options.checkpointFile); err != nil {
+			Error.Printf("Error while loading checkpoints: %v", err)
+			ld.reportError(DispatchError{Phase: PhaseInit, Err: err})
+			err = nil
+		}
 	}
-	var lwInitErrors []error
 	for _, logWriter := range logWriters {
-		lwInitError := logWriter.Init(lwConfig)
+		writerConfig, name := lwConfig, writerName(logWriter)
+		writerConfig.ReportError = func(err error) {
+			ld.reportError(DispatchError{Phase: PhaseWrite, Writer: name, Err: err})
+		}
+		lwInitError := logWriter.Init(writerConfig)
+		if lwInitError == nil {
+			lwInitError = ld.checkpoints.resume(logWriter)
+		}
 		if lwInitError == nil {
 			ld.logWriters = append(ld.logWriters, logWriter)
+			if txWriter, ok := logWriter.(logwriter.TxWriter); ok {
+				ld.txWriters = append(ld.txWriters, txWriter)
+			}
 		} else {
-			lwInitErrors = append(lwInitErrors, lwInitError)
+			lwInitErrors = append(lwInitErrors, WriterInitError{Name: writerName(logWriter), Err: lwInitError})
+			ld.reportError(DispatchError{Phase: PhaseInit, Writer: writerName(logWriter), Err: lwInitError})
 		}
 	}
 	if len(lwInitErrors) > 0 {
-		err = fmt.Errorf("init of writers failed: %v", lwInitErrors)
-	}
-
-	go func(ld *logDispatcher) {
-		ticker := time.NewTicker(options.dispatchInterval)
-		var logMessages []*logMsg
-		for {
-			select {
-			case <-ticker.C:
-				ld.writeLogMessages(logMessages)
-				logMessages = nil
-			case msg, more := <-ld.logMessageCh:
-				if msg != nil {
-					logMessages = append(logMessages, msg)
+		err = lwInitErrors
+	}
+
+	for lw, n := range options.writerConcurrency {
+		if _, checkpointed := lw.(logwriter.CheckpointedWriter); checkpointed && n > 1 {
+			Warning.Printf("Writer concurrency of checkpointed writer %v ignored: batches are written sequentially", writerName(lw))
+		}
+	}
+
+	atomic.StoreInt32(&ld.activeWriters, int32(len(ld.logWriters)))
+	go ld.run()
+	if options.heartbeatInterval > 0 {
+		ld.background.Add(1)
+		go ld.heartbeat(options.heartbeatInterval)
+	}
+	if options.metricsInterval > 0 {
+		ld.background.Add(1)
+		go ld.reportRuntimeMetrics(options.metricsInterval)
+	}
+	if options.cloudMetadata {
+		ld.background.Add(1)
+		go ld.loadCloudMetadata()
+	}
+	if credentials := writerCredentials(ld.logWriters); options.credentialRefresh > 0 && len(credentials) > 0 {
+		ld.background.Add(1)
+		go ld.refreshCredentials(options.credentialRefresh, credentials)
+	}
+	return
+}
+
+// batchClass buffers the messages of all types that share the same dispatch interval
+type batchClass struct {
+	interval    time.Duration
+	next        time.Time
+	logMessages []*logMsg
+}
+
+// gcdDuration returns the greatest common divisor of both durations
+func gcdDuration(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// run collects queued messages in batches per dispatch interval and writes them when their interval elapsed
+func (ld *logDispatcher) run() {
+	options := ld.currentOptions()
+	classes := map[time.Duration]*batchClass{}
+	classOf := func(interval time.Duration) *batchClass {
+		class, ok := classes[interval]
+		if !ok {
+			class = &batchClass{interval: interval, next: time.Now().Add(interval)}
+			classes[interval] = class
+		}
+		return class
+	}
+	flushAll := func() {
+		for _, class := range classes {
+			ld.writeLogMessages(class.logMessages)
+			class.logMessages = nil
+		}
+	}
+	// newTicker returns ticker that ticks with the greatest common divisor of all batch class intervals
+	newTicker := func() *time.Ticker {
+		tick := options.dispatchInterval
+		for _, interval := range options.typeIntervals {
+			tick = gcdDuration(tick, interval)
+		}
+		for interval := range classes {
+			tick = gcdDuration(tick, interval)
+		}
+		return time.NewTicker(tick)
+	}
+	addMsg := func(msg *logMsg) {
+		interval, ok := options.typeIntervals[msg.logMessageType]
+		if !ok {
+			interval = options.dispatchInterval
+		}
+		class := classOf(interval)
+		class.logMessages = append(class.logMessages, msg)
+		if options.flushSeverity != SeverityNotApplied && msg.severity <= options.flushSeverity {
+			flushAll()
+		} else if options.maxBatchSize > 0 && len(class.logMessages) >= options.maxBatchSize {
+			ld.writeLogMessages(class.logMessages)
+			class.logMessages = nil
+		}
+	}
+	logMessageCh := ld.logMessageCh
+	ticker := newTicker()
+	defer func() { ticker.Stop() }()
+	for {
+		select {
+		case t := <-ticker.C:
+			for _, class := range classes {
+				if t.Before(class.next) {
+					continue
 				}
-				if !more {
-					ld.writeLogMessages(logMessages)
-					logMessages = nil
-					close(ld.done)
-					return
+				ld.writeLogMessages(class.logMessages)
+				class.logMessages = nil
+				for !t.Before(class.next) {
+					class.next = class.next.Add(class.interval)
 				}
 			}
+		case writers := <-ld.writersCh:
+			writers <- append([]logwriter.LogWriter{}, ld.logWriters...)
+		case req := <-ld.importCh:
+			ld.writeLogMessages(req.logMessages)
+			close(req.done)
+		case <-ld.reconfigureCh:
+			options = ld.currentOptions()
+			if options.queueSize != cap(logMessageCh) {
+				// swap queue and move already queued messages into the batches
+				ld.queueMutex.Lock()
+				ld.logMessageCh = make(chan *logMsg, options.queueSize)
+				ld.queueMutex.Unlock()
+				for len(logMessageCh) > 0 {
+					if msg := <-logMessageCh; msg != nil {
+						addMsg(msg)
+					}
+				}
+				logMessageCh = ld.logMessageCh
+			}
+			now := time.Now()
+			for _, class := range classes {
+				class.next = now.Add(class.interval)
+			}
+			ticker.Stop()
+			ticker = newTicker()
+		case msg, more := <-logMessageCh:
+			if msg != nil {
+				addMsg(msg)
+			}
+			if !more {
+				flushAll()
+				close(ld.done)
+				return
+			}
+		}
+	}
+}
+
+// WriterValidation is the result of the validation of a single writer. See Validate()
+type WriterValidation struct {
+	Writer  string // name of the writer
+	Skipped bool   // true if the writer doesn't support validation (see logwriter.Validator)
+	Err     error  // validation error
+}
+
+// validate validates all writers that implement logwriter.Validator. The dispatcher goroutine only hands out the
+// registered writers, which are validated on the caller's goroutine, so that probes don't block dispatching.
+// Validation therefore runs concurrently with writes (see logwriter.Validator).
+func (ld *logDispatcher) validate(ctx context.Context) (results []WriterValidation, err error) {
+	writers := make(chan []logwriter.LogWriter, 1)
+	select {
+	case ld.writersCh <- writers:
+	case <-ld.done:
+		return nil, ErrNotInitialized
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for _, lw := range <-writers {
+		if lw == nil {
+			continue
+		}
+		result := WriterValidation{Writer: writerName(lw)}
+		if validator, ok := lw.(logwriter.Validator); ok {
+			result.Err = validator.Validate(ctx)
+		} else {
+			result.Skipped = true
 		}
-	}(ld)
+		results = append(results, result)
+	}
 	return
 }
 
+// currentOptions returns a copy of the current options
+func (ld *logDispatcher) currentOptions() dispatcherOptions {
+	ld.optionsMutex.RLock()
+	defer ld.optionsMutex.RUnlock()
+	return ld.options
+}
+
+// setOptions applies the given options to the running dispatcher
+func (ld *logDispatcher) setOptions(opts ...func(*dispatcherOptions)) error {
+	ld.optionsMutex.Lock()
+	options := ld.options
+	// copy maps that may be modified by options
+	typeIntervals := map[string]time.Duration{}
+	for k, v := range options.typeIntervals {
+		typeIntervals[k] = v
+	}
+	options.typeIntervals = typeIntervals
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.queueSize <= 0 {
+		options.queueSize = ld.options.queueSize
+	}
+	if options.dispatchInterval <= 0 {
+		options.dispatchInterval = ld.options.dispatchInterval
+	}
+	if changed := changedInitOnlyOptions(ld.options, options); len(changed) > 0 {
+		ld.optionsMutex.Unlock()
+		return fmt.Errorf("%w: %v", ErrInitOnlyOption, strings.Join(changed, ", "))
+	}
+	ld.options = options
+	ld.optionsMutex.Unlock()
+	select {
+	case ld.reconfigureCh <- struct{}{}:
+	default: // reconfiguration already pending
+	}
+	return nil
+}
+
+// changedInitOnlyOptions returns the names of the changed options that are only applied when the dispatcher is created
+func changedInitOnlyOptions(old dispatcherOptions, new dispatcherOptions) (changed []string) {
+	options := []struct {
+		name    string
+		changed bool
+	}{
+		{"WithStrictConfig", old.strictConfig != new.strictConfig},
+		{"WithFilteredContextRing", old.filteredRingSize != new.filteredRingSize},
+		{"WithRecentMessages", old.recentMessages != new.recentMessages},
+		{"WithRuntimeMetrics", old.metricsInterval != new.metricsInterval},
+		{"WithHeartbeat", old.heartbeatInterval != new.heartbeatInterval},
+		{"WithCredentialRefreshInterval", old.credentialRefresh != new.credentialRefresh},
+		{"WithCloudMetadataEnrichment", old.cloudMetadata != new.cloudMetadata},
+		{"WithMessageSigning", !reflect.DeepEqual(old.signer, new.signer)},
+		{"WithEncoder", !reflect.DeepEqual(old.encoder, new.encoder)},
+		{"WithCheckpointFile", old.checkpointFile != new.checkpointFile},
+		{"WithWriterConcurrency", !reflect.DeepEqual(old.writerConcurrency, new.writerConcurrency)},
+	}
+	for _, option := range options {
+		if option.changed {
+			changed = append(changed, option.name)
+		}
+	}
+	return changed
+}
+
 // close flushes all logMessages, closes all writers and ends the dispatcher
 func (ld *logDispatcher) close() {
 	if ld == nil {
 		return
 	}
+	close(ld.stop)
+	ld.background.Wait() // wait until background goroutines stopped logging
+
+	ld.queueMutex.Lock()
 	close(ld.logMessageCh)
+	ld.queueClosed = true
+	ld.queueMutex.Unlock()
 	<-ld.done // wait until dispatcher finished writing all logMessages
+	for _, pool := range ld.writerPools {
+		pool.close() // wait until batches in flight have been written
+	}
 
 	// Close the writers
 	for _, lw := range ld.logWriters {
@@ -112,6 +457,18 @@ func (ld *logDispatcher) close() {
 			lw.Close()
 		}
 	}
+	close(ld.errorCh)
+	if ld.filteredRing != nil {
+		atomic.AddInt32(&retainFilteredOutput, -1)
+	}
+}
+
+// reportError sends the error to the error channel. If nobody is receiving and the channel is full, the error is dropped.
+func (ld *logDispatcher) reportError(err DispatchError) {
+	select {
+	case ld.errorCh <- err:
+	default:
+	}
 }
 
 // writeLogMessages pre-marshals the log message and forwards it to all registered writers
@@ -120,105 +477,357 @@ func (ld *logDispatcher) writeLogMessages(logMessages []*logMsg) {
 		return
 	}
 
-	sort.Slice(logMessages, func(i, j int) bool {
-		return time.Time(logMessages[i].timestamp).Before(time.Time(logMessages[j].timestamp))
-	})
+	defer ld.release(retainedSize(logMessages))
+	options := ld.currentOptions()
+	if options.maxMessageAge > 0 {
+		queued := len(logMessages)
+		logMessages = dropStaleMessages(logMessages, time.Now().Add(-options.maxMessageAge))
+		if dropped := queued - len(logMessages); dropped > 0 {
+			atomic.AddUint64(&ld.staleCounter, uint64(dropped))
+			ld.reportError(DispatchError{Phase: PhaseQueue, Err: fmt.Errorf("%w: %v messages dropped", ErrStaleMessage, dropped)})
+		}
+		if len(logMessages) <= 0 {
+			return
+		}
+	}
+
+	ordered := orderByTimestamp(logMessages, !ld.writersOrderInsensitive())
 
+	batchID := atomic.AddUint64(&ld.batchIDCounter, 1)
 	rawLogMessages := make([]json.RawMessage, len(logMessages))
 	timestamps := make([]time.Time, len(logMessages))
+	severities := make([]Severity, len(logMessages))
+	companions := make([]bool, len(logMessages))
+	hasCompanions := false
+	classified := make([]*classifiedMsg, len(logMessages))
+	hasClassified := false
+	hasDrifts := false
+	routes := make([][]string, len(logMessages))
+	hasRoutes := false
 	j := 0
-	schemaChanged := false
+	ld.schemaMutex.Lock()
+	schemaChanged := ld.schemaPending
+	ld.schemaPending = false
 	for _, logMessage := range logMessages {
-		msgProperties := logMessage.Properties()
-		// marshal message
-		rawLogMessage, err := json.Marshal(msgProperties)
+		msgProperties := renderProperties(logMessage.Properties())
+		// migrate messages with older schema versions
+		migrateSchema(msgProperties)
+		// detect drifted property kinds, which are handled by the writers' policies
+		drifts := ld.schemaDrifts(msgProperties, options.flattenSeparator)
+		// marshal (and sign) message
+		rawLogMessage, err := options.marshal(msgProperties)
 		if err != nil {
 			Error.Printf("Error while marshalling log message: %v", err)
+			ld.reportError(DispatchError{Phase: PhaseMarshal, BatchID: batchID, Err: err})
 			continue
 		}
 		// check schema
-		for propName, propValue := range msgProperties {
-			if _, ok := ld.schema[propName]; !ok {
-				kind := logwriter.Unknown
-				switch propValue.(type) {
-				case string:
-					kind = logwriter.String
-				case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-					kind = logwriter.Integer
-				case float32, float64:
-					kind = logwriter.Number
-				case bool:
-					kind = logwriter.Boolean
-				case time.Time, UTCTime:
-					kind = logwriter.DateTime
-				default:
-					kind = logwriter.Unknown
-				}
-				ld.schema[propName] = kind
+		if ld.recordSchema(msgProperties, options) {
+			schemaChanged = true
+		}
+		if len(drifts) > 0 && options.overflowsSchemaDrift() {
+			if _, ok := ld.schema[PropertySchemaOverflow]; !ok {
+				ld.schema[PropertySchemaOverflow] = logwriter.Object
 				schemaChanged = true
 			}
 		}
 		// append raw log message
 		rawLogMessages[j] = rawLogMessage
 		timestamps[j] = logMessage.Timestamp()
+		severities[j] = logMessage.severity
+		companions[j] = logMessage.companion
+		hasCompanions = hasCompanions || logMessage.companion
+		routes[j] = logMessage.routes
+		hasRoutes = hasRoutes || logMessage.routes != nil
+		isClassified := len(logMessage.classifications) > 0 && len(options.classification.policies) > 0
+		if isClassified || len(drifts) > 0 {
+			classified[j] = &classifiedMsg{properties: msgProperties, classifications: logMessage.classifications, drifts: drifts}
+			hasClassified = hasClassified || isClassified
+			hasDrifts = hasDrifts || len(drifts) > 0
+		}
 		j++
 	}
 	rawLogMessages = rawLogMessages[:j]
 	timestamps = timestamps[:j]
+	severities = severities[:j]
+	classified = classified[:j]
+	routes = routes[:j]
+	if schemaChanged {
+		for _, lw := range ld.logWriters {
+			if lw != nil {
+				ld.notifySchemaChanged(lw, batchID)
+			}
+		}
+	}
+	ld.schemaMutex.Unlock()
+	// primary messages without companion messages for non-archive writers
+	primaryLogMessages, primaryTimestamps, primarySeverities, primaryClassified := rawLogMessages, timestamps, severities, classified
+	primaryRoutes := routes
+	if hasCompanions {
+		primaryLogMessages, primaryTimestamps, primarySeverities, primaryClassified, primaryRoutes = nil, nil, nil, nil, nil
+		for i := range rawLogMessages {
+			if !companions[i] {
+				primaryLogMessages = append(primaryLogMessages, rawLogMessages[i])
+				primaryTimestamps = append(primaryTimestamps, timestamps[i])
+				primarySeverities = append(primarySeverities, severities[i])
+				primaryClassified = append(primaryClassified, classified[i])
+				primaryRoutes = append(primaryRoutes, routes[i])
+			}
+		}
+	}
+	// the batch counts as written when the first writer wrote it successfully (also asynchronously by a writer pool)
+	var recorded int32
+	recordWritten := func() {
+		if atomic.CompareAndSwapInt32(&recorded, 0, 1) {
+			atomic.AddUint64(&ld.messagesWritten, uint64(len(rawLogMessages)))
+			atomic.StoreInt64(&ld.lastWrite, time.Now().UnixNano())
+		}
+	}
 	for i, lw := range ld.logWriters {
+		if pool, ok := ld.writerPools[lw]; ok {
+			if err := pool.disabledErr(); err != nil {
+				ld.disableWriter(i, err, options)
+				continue
+			}
+		}
 		if lw != nil {
-			if schemaChanged {
-				err := lw.PropertiesSchemaChanged(ld.schema)
-				if err != nil {
-					Error.Println(err.Error())
-				}
+			writerLogMessages, writerTimestamps, writerSeverities, writerClassified := primaryLogMessages, primaryTimestamps, primarySeverities, primaryClassified
+			writerRoutes := primaryRoutes
+			if hasCompanions && options.companion.isArchiveWriter(lw) {
+				writerLogMessages, writerTimestamps, writerSeverities, writerClassified = rawLogMessages, timestamps, severities, classified
+				writerRoutes = routes
 			}
-			err := lw.WriteLogMessages(rawLogMessages, timestamps)
-			if err != nil {
-				Error.Printf("Error while writing log message: %v", err)
-				if errors.Is(err, logwriter.ErrWriterDisable) { // if writer returns ErrWriterStop, it is closed and removed from registered writers
-					lw.Close()
-					ld.logWriters[i] = nil
-				}
+			writerLogMessages, writerTimestamps, writerSeverities, _ = ld.transformMessages(lw, batchID, writerLogMessages, writerTimestamps, writerSeverities, writerClassified, writerRoutes, transforms{routes: hasRoutes, classified: hasClassified, drifts: hasDrifts}, options)
+			budget := ld.writerBudget(i, lw, options)
+			if budget != nil {
+				writerLogMessages, writerTimestamps = budget.degrade(writerLogMessages, writerTimestamps, writerSeverities, options.budget, options.marshal)
+			}
+			if len(writerLogMessages) == 0 {
+				continue
+			}
+			batch := logwriter.Batch{LogMessages: writerLogMessages, Timestamps: writerTimestamps, Ordered: ordered}
+			if pool := ld.writerPool(lw, options); pool != nil {
+				// batch is written asynchronously by the writer's workers (see WithWriterConcurrency)
+				lw := lw
+				pool.submit(lw, batch, func(chunk logwriter.Batch, duration time.Duration, err error) {
+					if ld.handleWriteResult(lw, batchID, chunk.Len(), duration, err, options) {
+						pool.disable(err)
+					}
+				}, func(err error) {
+					if err != nil {
+						return
+					}
+					if budget != nil && budget.add(writerLogMessages, options.budget) {
+						ld.log(1, budgetExceededMsg(writerName(lw), budget, options.budget))
+					}
+					recordWritten()
+				})
+				continue
+			}
+			start := time.Now()
+			err := writeBatch(lw, batch, ld.checkpoints)
+			if err == nil && budget != nil && budget.add(writerLogMessages, options.budget) {
+				ld.log(1, budgetExceededMsg(writerName(lw), budget, options.budget))
 			}
+			if ld.handleWriteResult(lw, batchID, len(writerLogMessages), time.Since(start), err, options) {
+				ld.disableWriter(i, err, options)
+			} else if err == nil {
+				recordWritten()
+			}
+		}
+	}
+	atomic.StoreInt32(&ld.throttledWriters, int32(ld.countThrottledWriters()))
+	if options.fallbackSeverity != SeverityNotApplied && !ld.hasWriters() {
+		for i, rawLogMessage := range rawLogMessages {
+			if severities[i] <= options.fallbackSeverity {
+				fmt.Fprintf(consoleFallbackOutput, "%s\n", rawLogMessage)
+			}
+		}
+	}
+}
+
+// recordSchema records the kinds of yet unknown properties in the schema and returns true if the schema changed.
+// Must be called with schemaMutex locked.
+func (ld *logDispatcher) recordSchema(properties map[string]interface{}, options dispatcherOptions) (changed bool) {
+	if options.flattenSeparator != "" {
+		properties = FlattenProperties(properties, options.flattenSeparator)
+	}
+	for propName, propValue := range properties {
+		if _, ok := ld.schema[propName]; !ok {
+			ld.schema[propName] = propertyKind(propValue)
+			changed = true
+		}
+	}
+	return
+}
+
+// notifySchemaChanged informs the writer about the changed schema. Must be called with schemaMutex locked.
+func (ld *logDispatcher) notifySchemaChanged(lw logwriter.LogWriter, batchID uint64) {
+	if err := lw.PropertiesSchemaChanged(ld.schema); err != nil {
+		Error.Println(err.Error())
+		ld.reportError(DispatchError{Phase: PhaseSchema, Writer: writerName(lw), BatchID: batchID, Err: err})
+	}
+}
+
+// transforms declares which per writer transformations are needed for a batch (see transformMessages)
+type transforms struct {
+	routes     bool // messages have routes (see WithRoutingRules)
+	classified bool // messages have classified properties (see WithClassificationPolicy)
+	drifts     bool // messages have drifted property kinds (see WithWriterSchemaDriftPolicy)
+}
+
+// transformMessages returns the messages that are routed to the writer with applied schema drift and classification
+// policies and flattened properties (see WithRoutingRules, WithWriterSchemaDriftPolicy, WithClassificationPolicy and
+// WithFlattenedProperties). Messages that are rejected by the schema drift policy or whose classification policies
+// can't be applied are dropped. The errors are reported and the first one is returned.
+func (ld *logDispatcher) transformMessages(lw logwriter.LogWriter, batchID uint64, logMessages []json.RawMessage, timestamps []time.Time, severities []Severity, classified []*classifiedMsg, routes [][]string, needed transforms, options dispatcherOptions) ([]json.RawMessage, []time.Time, []Severity, error) {
+	var firstErr error
+	report := func(phase DispatchPhase, err error) {
+		ld.reportError(DispatchError{Phase: phase, Writer: writerName(lw), BatchID: batchID, Err: err})
+		if firstErr == nil {
+			firstErr = err
 		}
 	}
+	if needed.routes {
+		logMessages, timestamps, severities, classified = routeMessages(writerName(lw), routes, logMessages, timestamps, severities, classified)
+	}
+	if policy := options.writerSchemaDriftPolicy(lw); needed.drifts && policy != SchemaDriftIgnore {
+		var errs []error
+		logMessages, timestamps, severities, classified, errs = applySchemaDrift(policy, logMessages, timestamps, severities, classified, options.marshal)
+		for _, err := range errs {
+			report(PhaseSchema, err)
+		}
+	}
+	if needed.classified {
+		var err error
+		logMessages, timestamps, severities, err = options.classification.apply(lw, logMessages, timestamps, severities, classified, options.marshal)
+		if err != nil {
+			Error.Printf("Error while applying classification policies: %v", err)
+			report(PhaseMarshal, err)
+		}
+	}
+	if options.flattenSeparator != "" && logwriter.CapabilitiesOf(lw).Columnar {
+		logMessages = flattenMessages(logMessages, options.flattenSeparator, options.marshal)
+	}
+	return logMessages, timestamps, severities, firstErr
+}
+
+// handleWriteResult notifies the writer observer and reports write errors. It returns true if the writer shall be disabled.
+func (ld *logDispatcher) handleWriteResult(lw logwriter.LogWriter, batchID uint64, batchSize int, duration time.Duration, err error, options dispatcherOptions) (disable bool) {
+	if options.writerObserver != nil {
+		options.writerObserver(writerName(lw), batchSize, duration, err)
+	}
+	if err == nil || errors.Is(err, logwriter.ErrWriterThrottled) {
+		// a throttled batch has been buffered by the writer and is sent when the throttling ended
+		return false
+	}
+	atomic.AddUint64(&ld.writeErrors, 1)
+	Error.Printf("Error while writing log message: %v", err)
+	ld.reportError(DispatchError{
+		Phase:     PhaseWrite,
+		Writer:    writerName(lw),
+		BatchID:   batchID,
+		Retryable: !errors.Is(err, logwriter.ErrWriterDisable),
+		Err:       err,
+	})
+	// if writer returns ErrWriterDisable, it is closed and removed from registered writers
+	return errors.Is(err, logwriter.ErrWriterDisable)
+}
+
+// disableWriter closes the writer and removes it from the registered writers. The disabled callback is called and a
+// message of type "logthing_writer_disabled" is logged with the reason.
+func (ld *logDispatcher) disableWriter(index int, err error, options dispatcherOptions) {
+	lw := ld.logWriters[index]
+	if pool, ok := ld.writerPools[lw]; ok {
+		pool.close()
+		delete(ld.writerPools, lw)
+	}
+	lw.Close()
+	ld.logWriters[index] = nil
+	atomic.AddInt32(&ld.activeWriters, -1)
+	if options.disabledCallback != nil {
+		options.disabledCallback(writerName(lw), err)
+	}
+	ld.log(1, writerDisabledMsg(writerName(lw), err))
+}
+
+// consoleFallbackOutput is the output for messages that are printed as NDJSON when all writers are lost (see WithConsoleFallback)
+var consoleFallbackOutput io.Writer = os.Stderr
+
+// hasWriters returns true if there is at least one writer that hasn't been disabled
+func (ld *logDispatcher) hasWriters() bool {
+	for _, lw := range ld.logWriters {
+		if lw != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// countThrottledWriters returns the number of writers that are currently throttled (see logwriter.Throttled)
+func (ld *logDispatcher) countThrottledWriters() (count int) {
+	now := time.Now()
+	for _, lw := range ld.logWriters {
+		if throttled, ok := lw.(logwriter.Throttled); ok && now.Before(throttled.ThrottledUntil()) {
+			count++
+		}
+	}
+	return
+}
+
+// dropStaleMessages drops messages that have been queued before given time. Messages with severity <= SeverityError are always kept.
+func dropStaleMessages(logMessages []*logMsg, queuedBefore time.Time) []*logMsg {
+	kept := logMessages[:0]
+	for _, msg := range logMessages {
+		if msg.severity <= SeverityError || !msg.queuedAt.Before(queuedBefore) {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// writerName returns the name of the writer. Writers can provide their name by implementing a Name() string method,
+// otherwise the writer's type name is used.
+func writerName(lw logwriter.LogWriter) string {
+	if named, ok := lw.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", lw)
 }
 
 // printLogMsg formats and prints the log message's properties and given output
 func printLogMsg(calldepth int, msg *logMsg) {
+	config := currentConfig()
 	if msg == nil {
 		return
 	}
-	output := msg.Output()
+	output := templateOutput(msg)
 	if len(output) > 0 {
 		var lg *log.Logger
 		if msg.severity < SeverityNotApplied {
 			lg = *loggers[msg.severity]
 		}
-		_, file, line, ok := runtime.Caller(calldepth)
-		if !ok {
-			file = "???"
-			line = 0
-		} else {
-			file = filepath.Base(file)
+		outputProperties := []string{}
+		if !config.callerDisabledTypes.matches(msg.logMessageType) {
+			outputProperties = append(outputProperties, callerAt(calldepth).location)
 		}
-		outputProperties := []string{fmt.Sprintf("%v:%v", file, line)}
 		for outputProperty := range config.printOutputProperties {
 			if outputPropertyValue := msg.Property(outputProperty); outputPropertyValue != nil {
-				v := fmt.Sprintf("%v:%v", outputProperty, outputPropertyValue)
+				v := fmt.Sprintf("%v:%v", outputProperty, renderOutput(outputPropertyValue))
 				if len(v) > 0 {
 					outputProperties = append(outputProperties, v)
 				}
 			}
 		}
 		calldepth++
+		output = foldOutput(output, msg.severity)
+		spacer := outputSpacer(lg)
 		logString := ""
-		logString += strings.Join(output, newLineSpacer)
+		logString += strings.Join(output, spacer)
 		if len(outputProperties) > 0 {
 			outputPropertiesString := fmt.Sprintf("(%v)", outputProperties)
 			if len(output) > 1 {
-				lg.Output(calldepth, outputPropertiesString+newLineSpacer+logString)
+				lg.Output(calldepth, outputPropertiesString+spacer+logString)
 			} else {
 				lg.Output(calldepth, logString+" "+outputPropertiesString)
 			}
@@ -228,10 +837,70 @@ func printLogMsg(calldepth int, msg *logMsg) {
 	}
 }
 
+// outputSpacer returns the line separator that indents continuation lines to the column where the logger's first line
+// starts (after prefix and date/time)
+func outputSpacer(lg *log.Logger) string {
+	width := len(lg.Prefix())
+	if lg.Flags()&(log.Ldate|log.Ltime) == log.Ldate|log.Ltime {
+		width += len("2006/01/02 15:04:05 ")
+	} else if tw, ok := lg.Writer().(*timestampWriter); ok {
+		width += tw.width()
+	}
+	return "\n" + strings.Repeat(" ", width)
+}
+
+// foldOutput prepares the output lines for printing: Tabs (e.g. of stack traces) are replaced by spaces and if
+// LOGTHING_PRINT_FOLD_LINES is set, the indented lines of multi-line output values are folded to the first N lines
+// followed by the number of omitted lines. Messages with severity <= LOGTHING_PRINT_EXPAND_SEVERITY are never folded.
+func foldOutput(output []string, severity Severity) []string {
+	config := currentConfig()
+	foldLines := config.printFoldLines
+	if severity <= config.printExpandSeverity {
+		foldLines = 0
+	}
+	folded := make([]string, 0, len(output))
+	continuationLines := 0
+	omittedLines := 0
+	flushOmitted := func() {
+		if omittedLines > 0 {
+			folded = append(folded, fmt.Sprintf("  … %v more lines", omittedLines))
+			omittedLines = 0
+		}
+	}
+	for _, line := range output {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if !strings.HasPrefix(line, "  ") { // first line of a new output value
+			flushOmitted()
+			continuationLines = 0
+			folded = append(folded, line)
+			continue
+		}
+		continuationLines++
+		if foldLines > 0 && continuationLines > foldLines {
+			omittedLines++
+			continue
+		}
+		folded = append(folded, line)
+	}
+	flushOmitted()
+	return folded
+}
+
 // log prints the log message and queues it to be written
 func (ld *logDispatcher) log(calldepth int, logMessage LogMsg) error {
-	if ld.options.dispatchCallback != nil {
-		ld.options.dispatchCallback(logMessage)
+	options := ld.currentOptions()
+	msg, err := ld.admit(calldepth+1, logMessage, options)
+	if err != nil {
+		return err
+	}
+	return ld.enqueue(msg, options)
+}
+
+// admit filters, prepares, completes and prints the log message. Messages that shall not be written are dropped with an error.
+func (ld *logDispatcher) admit(calldepth int, logMessage LogMsg, options dispatcherOptions) (*logMsg, error) {
+	config := currentConfig()
+	if options.dispatchCallback != nil {
+		options.dispatchCallback(logMessage)
 	}
 
 	// msg, ok := logMessage.(*logMsg)
@@ -243,17 +912,81 @@ func (ld *logDispatcher) log(calldepth int, logMessage LogMsg) error {
 	// Set at least trace severity
 	msg.SetSeverity(SeverityTrace)
 
+	// Drop message if its logType is denied
+	if config.isDenied(msg.logMessageType) || options.denyLogTypes.matches(msg.logMessageType) {
+		return nil, ErrDenied
+	}
+
+	// Fully whitelist message if its tracking ID has been marked verbose
+	if !msg.whitelisted && isVerboseTrackingID(msg.trackingID) {
+		msg.whitelisted = true
+		msg.SetProperty(PropertyWhitelist, msg.whitelisted)
+	}
+
 	// Drop message if severity is greater than configured logSeverity and according logType is not explicitely whitelisted
 	whitelisted := config.isWhitelisted(msg.logMessageType) || msg.whitelisted
 	if !config.meetsLogMaxSeverity(msg.Severity()) {
 		if !whitelisted {
-			return ErrSeverityAboveMax
+			if ld.filteredRing != nil {
+				ld.filteredRing.add(copyMsg(msg).msgData()) // the caller may reuse the message
+			}
+			return nil, ErrSeverityAboveMax
+		}
+	}
+	ld.prepare(msg)
+	if options.classifier != nil {
+		classify(msg, options.classifier)
+	}
+	ld.complete(msg, options)
+
+	// Print msg to stdout/stderr
+	if (whitelisted || config.meetsPrintMaxSeverity(msg.Severity())) && !msg.printed {
+		printLogMsg(calldepth+1, msg)
+	}
+
+	// Drop messages according to the sample rates of their labels
+	if len(options.labelSampleRates) > 0 && !sampleLabels(msg, options.labelSampleRates) {
+		return nil, ErrSampledOut
+	}
+
+	// Apply the first matching routing rule
+	if options.routing != nil {
+		routes, err := options.routing.route(msg)
+		if err != nil {
+			return nil, err
+		}
+		msg.routes = routes
+	}
+
+	// Queue filtered messages of the ring with the same tracking ID as context of errors. Messages without tracking ID
+	// aren't related to each other.
+	if ld.filteredRing != nil && msg.severity <= SeverityError && msg.trackingID != "" {
+		contextMsgs := ld.filteredRing.drainTrackingID(msg.trackingID)
+		for i, contextMsg := range contextMsgs {
+			ld.prepare(contextMsg)
+			contextMsg.SetProperty(PropertyFilteredContext, true)
+			ld.complete(contextMsg, options)
+			if err := ld.enqueue(contextMsg, options); err != nil {
+				// the remaining context messages wouldn't fit into the queue either
+				ld.reportError(DispatchError{Phase: PhaseQueue, Err: fmt.Errorf("queueing filtered context of tracking ID %q failed, %v messages dropped: %w", msg.trackingID, len(contextMsgs)-i, err)})
+				break
+			}
 		}
 	}
+	return msg, nil
+}
+
+// prepare removes non-whitelisted and denied properties and ensures that timestamp and reserved properties are set
+func (ld *logDispatcher) prepare(msg *logMsg) {
+	config := currentConfig()
 	// Ensure that non-whitelisted properties are cleared/deleted
 	{
 		propertiesMap := msg.Properties()
 		for key, _ := range propertiesMap {
+			if config.isDeniedProperty(key) {
+				delete(propertiesMap, key)
+				continue
+			}
 			if msg.whitelisted {
 				continue
 			}
@@ -276,34 +1009,95 @@ func (ld *logDispatcher) log(calldepth int, logMessage LogMsg) error {
 	if msg.trackingID != "" {
 		msg.SetProperty(PropertyTrackingID, msg.trackingID)
 	}
+	stampSchemaVersion(msg)
+}
 
-	// Print msg to stdout/stderr
-	if whitelisted || config.meetsPrintMaxSeverity(msg.Severity()) {
-		printLogMsg(calldepth+1, msg)
+// enqueue queues the prepared and completed message to be written
+func (ld *logDispatcher) enqueue(msg *logMsg, options dispatcherOptions) error {
+	publish(msg)
+	if ld.recentRing != nil {
+		ld.recentRing.add(copyMsg(msg).msgData())
 	}
+	companion := ld.splitCompanion(msg, options)
+	if err := ld.send(msg, options); err != nil {
+		return err
+	}
+	if companion != nil {
+		return ld.send(companion, options)
+	}
+	return nil
+}
 
-	// Also make msg output part of its properties
-	msg.SetProperty("output", msg.output)
+// complete sets the remaining properties (log entry id, static and enriched properties) and the output with resolved
+// placeholders
+func (ld *logDispatcher) complete(msg *logMsg, options dispatcherOptions) {
+	// Set receive time
+	if options.receiveTime {
+		msg.SetProperty(PropertyReceivedAt, UTCTime(time.Now()))
+	}
 
 	// Set log entry id
-	if ld.options.setEntryID {
-		msg.SetProperty("logEntryID", atomic.AddUint64(&ld.logEntryIDCounter, 1))
+	if options.idGenerator != nil {
+		msg.SetProperty(PropertyLogEntryID, options.idGenerator())
+	} else if options.setEntryID {
+		msg.SetProperty(PropertyLogEntryID, atomic.AddUint64(&ld.logEntryIDCounter, 1))
+	}
+
+	// Set cloud metadata properties
+	if cloudMetadata, ok := ld.cloudMetadata.Load().(map[string]interface{}); ok {
+		for k, v := range cloudMetadata {
+			msg.SetProperty(k, v)
+		}
 	}
 
 	// Set static propertise
-	if ld.options.staticProperties != nil {
-		for k, v := range ld.options.staticProperties {
+	if options.staticProperties != nil {
+		for k, v := range options.staticProperties {
 			msg.SetProperty(k, v)
 		}
 	}
 
+	// Enrich message
+	enrich(msg, options.enrichers)
+
+	// Resolve output placeholders like "{prop:orderID}" once and make msg output part of its properties
+	if !msg.resolved {
+		if output, ok := resolvePlaceholders(msg.output, msg.Properties()); ok {
+			msg.output = output
+		}
+		msg.resolved = true
+	}
+	msg.SetProperty(PropertyOutput, msg.output)
+}
+
+// send queues the message to be written
+func (ld *logDispatcher) send(msg *logMsg, options dispatcherOptions) error {
+	msg.queuedAt = time.Now()
+	if options.queueCompression > 0 {
+		msg.compressQueued(options.queueCompression)
+	}
+	if !ld.retain(msg, options) {
+		ld.reportError(DispatchError{Phase: PhaseQueue, Retryable: true, Err: ErrMemoryLimit})
+		return ErrMemoryLimit
+	}
+	ld.queueMutex.RLock()
+	if ld.queueClosed {
+		// e.g. messages logged by the dispatcher itself while flushing on close
+		ld.queueMutex.RUnlock()
+		ld.release(msg.retainedBytes)
+		return ErrNotInitialized
+	}
 	select {
 	case ld.logMessageCh <- msg:
+		ld.queueMutex.RUnlock()
 	default:
+		ld.queueMutex.RUnlock()
+		ld.release(msg.retainedBytes)
 		overflowCount := atomic.AddUint64(&ld.overflowCounter, 1)
-		if ld.options.overflowCallback != nil {
-			ld.options.overflowCallback(msg, overflowCount)
+		if options.overflowCallback != nil {
+			options.overflowCallback(msg, overflowCount)
 		}
+		ld.reportError(DispatchError{Phase: PhaseQueue, Retryable: true, Err: ErrChannelFull})
 		return ErrChannelFull
 	}
 	return nil
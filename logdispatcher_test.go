@@ -0,0 +1,159 @@
+package logthing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// waitWritten waits until the writer has written n messages
+func waitWritten(t *testing.T, writer *soakWriter, n uint64) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadUint64(&writer.written) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v written messages, got %v", n, atomic.LoadUint64(&writer.written))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestImmediateFlushSeverity(t *testing.T) {
+	writer := &soakWriter{}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour),
+		WithImmediateFlushSeverity(SeverityCritical))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	ld.log(1, NewLogMsg("test").Error("not flushed"))
+	time.Sleep(50 * time.Millisecond)
+	if written := atomic.LoadUint64(&writer.written); written != 0 {
+		t.Errorf("expected error message to wait for the dispatch interval, got %v written", written)
+	}
+	ld.log(1, NewLogMsg("test").Critical("flushed"))
+	waitWritten(t, writer, 2) // the critical message flushes the queued batch
+}
+
+func TestTypeDispatchInterval(t *testing.T) {
+	writer := &soakWriter{}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour),
+		WithTypeDispatchInterval("audit", 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("trace").Info("default interval"))
+	ld.log(1, NewLogMsg("audit").Info("short interval"))
+	waitWritten(t, writer, 1)
+	time.Sleep(50 * time.Millisecond)
+	if written := atomic.LoadUint64(&writer.written); written != 1 {
+		t.Errorf("expected only the audit message to be written, got %v written", written)
+	}
+	ld.close()
+	if written := atomic.LoadUint64(&writer.written); written != 2 {
+		t.Errorf("expected all messages to be written on close, got %v", written)
+	}
+}
+
+func TestSetOptionsKeepsQueuedMessages(t *testing.T) {
+	writer := &soakWriter{delay: 100 * time.Millisecond}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithQueueSize(4),
+		WithImmediateFlushSeverity(SeverityCritical))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Critical("keeps the dispatcher busy writing"))
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := ld.log(1, NewLogMsg("test").Info("queued", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ld.setOptions(WithQueueSize(16)) // the queue is swapped while the messages are still queued
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		ld.queueMutex.RLock()
+		size := cap(ld.logMessageCh)
+		ld.queueMutex.RUnlock()
+		if size == 16 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected queue to be swapped, got size %v", size)
+		}
+	}
+	ld.close()
+	if written := atomic.LoadUint64(&writer.written); written != 4 {
+		t.Errorf("expected queued messages to be kept, got %v written", written)
+	}
+}
+
+func TestSetOptionsInitOnly(t *testing.T) {
+	writer := &soakWriter{}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	err = ld.setOptions(WithMaxBatchSize(10), WithWriterConcurrency(writer, 4), WithRecentMessages(10))
+	if !errors.Is(err, ErrInitOnlyOption) || !strings.Contains(err.Error(), "WithRecentMessages, WithWriterConcurrency") {
+		t.Errorf("expected init only options to be rejected, got %v", err)
+	}
+	if options := ld.currentOptions(); options.maxBatchSize != 0 || options.writerConcurrency != nil {
+		t.Errorf("expected options to be unchanged, got %+v", options)
+	}
+	if err := ld.setOptions(WithMaxBatchSize(10)); err != nil || ld.currentOptions().maxBatchSize != 10 {
+		t.Errorf("expected max batch size to be set, got %v", err)
+	}
+}
+
+func TestConsoleFallback(t *testing.T) {
+	var output bytes.Buffer
+	defer func(previous io.Writer) { consoleFallbackOutput = previous }(consoleFallbackOutput)
+	consoleFallbackOutput = &output
+	writer := &failingWriter{err: fmt.Errorf("invalid credentials: %w", logwriter.ErrWriterDisable)}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour),
+		WithConsoleFallback(SeverityError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Error("important"))
+	ld.log(1, NewLogMsg("test").Info("unimportant"))
+	ld.close()
+	line := strings.TrimSpace(output.String())
+	if !json.Valid([]byte(line)) || !strings.Contains(line, "important") || strings.Contains(line, "unimportant") {
+		t.Errorf("expected only the error message to be printed as NDJSON, got %q", output.String())
+	}
+}
+
+func TestMaxMessageAge(t *testing.T) {
+	writer := &soakWriter{}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithMaxMessageAge(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Info("stale"))
+	ld.log(1, NewLogMsg("test").Warning("stale"))
+	ld.log(1, NewLogMsg("test").Error("kept although stale"))
+	time.Sleep(50 * time.Millisecond)
+	ld.close()
+	if written := atomic.LoadUint64(&writer.written); written != 1 {
+		t.Errorf("expected only the error message to be written, got %v written", written)
+	}
+	if stale := ld.stats().Stale; stale != 2 {
+		t.Errorf("expected 2 stale messages, got %v", stale)
+	}
+	var dispatchErrors []DispatchError
+	for dispatchErr := range ld.errorCh {
+		dispatchErrors = append(dispatchErrors, dispatchErr)
+	}
+	if len(dispatchErrors) != 1 || dispatchErrors[0].Phase != PhaseQueue || !errors.Is(dispatchErrors[0], ErrStaleMessage) {
+		t.Errorf("expected stale messages to be reported, got %v", dispatchErrors)
+	}
+}
@@ -3,10 +3,11 @@ package logthing
 import (
 	"encoding/json"
 	"fmt"
-	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // Severity to declare log message severities
@@ -43,8 +44,49 @@ const (
 	PropertyOutput = "output"
 	// PropertyWhitelist explicitely whitelists the message
 	PropertyWhitelist = "whitelisted"
+	// PropertyFilteredContext marks messages that were filtered by severity but are logged as context of an error (see WithFilteredContextRing)
+	PropertyFilteredContext = "filteredContext"
+	// PropertyCallerFile contains the file name where output has been appended first (see LOGTHING_CALLER_PROPERTIES)
+	PropertyCallerFile = "caller.file"
+	// PropertyCallerLine contains the line number where output has been appended first (see LOGTHING_CALLER_PROPERTIES)
+	PropertyCallerLine = "caller.line"
+	// PropertyCallerFunc contains the function name where output has been appended first (see LOGTHING_CALLER_PROPERTIES)
+	PropertyCallerFunc = "caller.func"
 )
 
+var severityNames = map[string]Severity{
+	"emergency":  SeverityEmergency,
+	"emerg":      SeverityEmergency,
+	"alert":      SeverityAlert,
+	"critical":   SeverityCritical,
+	"crit":       SeverityCritical,
+	"error":      SeverityError,
+	"err":        SeverityError,
+	"warning":    SeverityWarning,
+	"warn":       SeverityWarning,
+	"notice":     SeverityNotice,
+	"info":       SeverityInfo,
+	"trace":      SeverityTrace,
+	"notapplied": SeverityNotApplied,
+	"none":       SeverityNotApplied,
+}
+
+// ParseSeverity parses severity from its number (e.g. "4") or its case-insensitive name (e.g. "warning" or "warn")
+func ParseSeverity(s string) (Severity, error) {
+	s = strings.TrimSpace(s)
+	if severity, ok := severityNames[strings.ToLower(s)]; ok {
+		return severity, nil
+	}
+	severity, err := strconv.Atoi(s)
+	if err != nil {
+		return SeverityNotApplied, fmt.Errorf("invalid severity %q", s)
+	}
+	if severity < int(SeverityEmergency) || severity > int(SeverityNotApplied) {
+		return SeverityNotApplied, fmt.Errorf("severity %v out of range", severity)
+	}
+	return Severity(severity), nil
+}
+
 // logMsg type consists of multiple log entries
 type logMsg struct {
 	self           LogMsg
@@ -55,6 +97,20 @@ type logMsg struct {
 	output         []string
 	properties     interface{} //map[string]interface{}
 	whitelisted    bool
+	queuedAt       time.Time
+	outputBytes    int  // total size of output lines (without truncation marker)
+	truncatedLines int  // number of output lines dropped due to LOGTHING_MAX_OUTPUT_LINES / LOGTHING_MAX_OUTPUT_BYTES
+	deepCopy       bool // SetProperty deep-copies values (see WithDeepCopy)
+	companion      bool // companion message that is only written to archive writers (see WithCompanionRecords)
+	printed        bool // printed before the dispatcher has been initialized (see SetPreInitBuffer)
+	pprofLabels    bool // operations set pprof labels on the goroutine (see WithPprofLabels)
+	resolved       bool // output placeholders have been resolved (see resolvePlaceholders)
+
+	classifications map[string]Classification // classified properties (see SetClassifiedProperty)
+	fields          []Field                   // typed properties that haven't been set yet (see Field)
+	retainedBytes   int64                     // approximate size while queued (see WithMemoryLimit)
+	queued          *queuedMsg                // compressed properties while queued (see WithQueueCompression)
+	routes          []string                  // names of the writers that receive the message, nil: all (see WithRoutingRules)
 }
 
 type nilLogMsg struct {
@@ -83,6 +139,8 @@ type LogMsg interface {
 	Properties() map[string]interface{}                           // returns property map
 	SetProperty(key string, value interface{}) LogMsg             // sets property value for given key. NOTE: "timestamp", "type", "severtiy", "trackingID", "output", "whitelisted" and "logEntryID" are reserved keys. They do have separate set functions.
 	SetSProperty(key string, value interface{}) LogMsg            // like SetProperty but stringifies the value will be stringified
+	SetPropertyCopy(key string, value interface{}) LogMsg         // like SetProperty but deep-copies maps and slices at set time
+	RecordDuration(key string, d time.Duration) LogMsg            // records duration in an exponential histogram property with given key
 	Output() []string                                             // returns output data
 	Trace(output ...interface{}) LogMsg                           // appends output data to be printed and implicitly sets appropriate severity level
 	Tracef(format string, v ...interface{}) LogMsg                // appends output data to be printed and implicitly sets appropriate severity level
@@ -102,6 +160,28 @@ type LogMsg interface {
 	Emergencyf(format string, v ...interface{}) LogMsg            // appends output data to be printed and implicitly sets appropriate severity level
 	AppendOutput(severity Severity, output ...interface{}) LogMsg // appends information to be printed and sets given severity level
 	Log() error                                                   // is a convenience function for Log(Loggable) / LogMsgWithCalldepth(calldepth, LogMessage)
+	// like SetProperty but tags the property with a data classification, e.g. PII (see WithClassificationPolicy)
+	SetClassifiedProperty(key string, value interface{}, classification Classification) LogMsg
+	// typed variants of the output methods that neither box the output nor the fields (see Field)
+	TraceStr(output string) LogMsg
+	TraceKV(output string, fields ...Field) LogMsg
+	InfoStr(output string) LogMsg
+	InfoKV(output string, fields ...Field) LogMsg
+	NoticeStr(output string) LogMsg
+	NoticeKV(output string, fields ...Field) LogMsg
+	WarningStr(output string) LogMsg
+	WarningKV(output string, fields ...Field) LogMsg
+	ErrorStr(output string) LogMsg
+	ErrorKV(output string, fields ...Field) LogMsg
+	CriticalStr(output string) LogMsg
+	CriticalKV(output string, fields ...Field) LogMsg
+	AlertStr(output string) LogMsg
+	AlertKV(output string, fields ...Field) LogMsg
+	EmergencyStr(output string) LogMsg
+	EmergencyKV(output string, fields ...Field) LogMsg
+	AppendOutputKV(severity Severity, output string, fields ...Field) LogMsg
+	// sets flow ID, step name and the flow's auto-incremented step number as properties (see EndFlow)
+	SetFlow(flowID string, step string) LogMsg
 	msgData() *logMsg
 }
 
@@ -128,10 +208,11 @@ func WithWhitelistFlag() Option {
 	}
 }
 
-// NewLogMsg creates new log message and sets the given type and options
-func NewLogMsg(messageType string, options ...Option) LogMsg {
+// NewLogMsg creates new log message and sets the given type (a string or a registered MessageType, see
+// RegisterMessageType) and options
+func NewLogMsg[T ~string](messageType T, options ...Option) LogMsg {
 	msg := &logMsg{
-		logMessageType: messageType,
+		logMessageType: string(messageType),
 		severity:       SeverityTrace,
 		whitelisted:    false,
 	}
@@ -237,6 +318,13 @@ func (lm *logMsg) Timestamp() time.Time {
 // SetProperty allows to add any structured information to the log message that can be marshalled to JSON
 // NOTE: keys "timestamp", "type", "severtiy", "trackingID", "output" are reserved keys and will be overwritten eventually
 func (lm *logMsg) SetProperty(key string, value interface{}) LogMsg {
+	if lm != nil && lm.deepCopy {
+		return lm.SetPropertyCopy(key, value)
+	}
+	return lm.setProperty(key, value)
+}
+
+func (lm *logMsg) setProperty(key string, value interface{}) LogMsg {
 	if lm != nil {
 		lmp := lm.Properties()
 		if lmp != nil {
@@ -253,7 +341,8 @@ type sProp struct {
 
 // MarshalJSON creates stringified version of
 func (sp sProp) MarshalJSON() (ret []byte, err error) {
-	ret, err = json.Marshal(sp.value)
+	value, _ := renderProperty(sp.value)
+	ret, err = json.Marshal(value)
 	if err == nil {
 		ret, err = json.Marshal(string(ret))
 	}
@@ -284,16 +373,42 @@ func (lm *logMsg) Property(key string) interface{} {
 // Properties returns properties
 func (lm *logMsg) Properties() map[string]interface{} {
 	if lm != nil {
+		if lm.queued != nil {
+			lm.restoreQueued()
+		}
 		lmp, ok := lm.properties.(map[string]interface{})
 		if !ok || lmp == nil {
 			lmp = map[string]interface{}{}
 			lm.properties = lmp
 		}
+		if len(lm.fields) > 0 {
+			lm.applyFields(lmp)
+		}
 		return lmp
 	}
 	return nil
 }
 
+// PropertyAs returns the message's property value with given key as type T. If the property isn't found or isn't of type T,
+// the zero value and false are returned.
+//
+//	if customerID, ok := logthing.PropertyAs[string](msg, "customerID"); ok {
+func PropertyAs[T any](lm LogMsg, key string) (value T, ok bool) {
+	if lm == nil || lm.IsNil() {
+		return
+	}
+	value, ok = lm.Property(key).(T)
+	return
+}
+
+// SetTyped sets the message's property value with given key like SetProperty, but with compile-time checked value type
+func SetTyped[T any](lm LogMsg, key string, value T) LogMsg {
+	if lm == nil || lm.IsNil() {
+		return lm
+	}
+	return lm.SetProperty(key, value)
+}
+
 func (lm *logMsg) Output() []string {
 	if lm != nil {
 		return lm.output
@@ -308,7 +423,7 @@ func (lm *logMsg) Trace(v ...interface{}) LogMsg {
 
 // Tracef appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Tracef(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityTrace, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityTrace, formatOutput(format, v...))
 }
 
 // Info appends output data to be printed and implicitly sets appropriate severity level
@@ -318,7 +433,7 @@ func (lm *logMsg) Info(v ...interface{}) LogMsg {
 
 // Infof appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Infof(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityInfo, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityInfo, formatOutput(format, v...))
 }
 
 // Notice appends output data to be printed and implicitly sets appropriate severity level
@@ -328,7 +443,7 @@ func (lm *logMsg) Notice(v ...interface{}) LogMsg {
 
 // Noticef appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Noticef(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityNotice, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityNotice, formatOutput(format, v...))
 }
 
 // Warning appends output data to be printed and implicitly sets appropriate severity level
@@ -338,7 +453,7 @@ func (lm *logMsg) Warning(v ...interface{}) LogMsg {
 
 // Warningf appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Warningf(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityWarning, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityWarning, formatOutput(format, v...))
 }
 
 // Error appends output data to be printed and implicitly sets appropriate severity level
@@ -348,7 +463,7 @@ func (lm *logMsg) Error(v ...interface{}) LogMsg {
 
 // Errorf appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Errorf(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityError, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityError, formatOutput(format, v...))
 }
 
 // Critical appends output data to be printed and implicitly sets appropriate severity level
@@ -358,7 +473,7 @@ func (lm *logMsg) Critical(v ...interface{}) LogMsg {
 
 // Criticalf appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Criticalf(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityCritical, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityCritical, formatOutput(format, v...))
 }
 
 // Alert appends output data to be printed and implicitly sets appropriate severity level
@@ -368,7 +483,7 @@ func (lm *logMsg) Alert(v ...interface{}) LogMsg {
 
 // Alertf appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Alertf(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityAlert, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityAlert, formatOutput(format, v...))
 }
 
 // Emergency appends output data to be printed and implicitly sets appropriate severity level
@@ -378,7 +493,7 @@ func (lm *logMsg) Emergency(v ...interface{}) LogMsg {
 
 // Emergencyf appends output data to be printed and implicitly sets appropriate severity level
 func (lm *logMsg) Emergencyf(format string, v ...interface{}) LogMsg {
-	return lm.appendOutput(2, SeverityEmergency, fmt.Sprintf(format, v...))
+	return lm.appendOutput(2, SeverityEmergency, formatOutput(format, v...))
 }
 
 // AppendOutput appends information to be printed and sets given severity level
@@ -386,40 +501,247 @@ func (lm *logMsg) AppendOutput(severity Severity, output ...interface{}) LogMsg
 	return lm.appendOutput(2, severity, output...)
 }
 
-func (lm *logMsg) appendOutput(calldepth int, severity Severity, values ...interface{}) (l LogMsg) {
+// TraceStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) TraceStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityTrace, output)
+}
+
+// TraceKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) TraceKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityTrace, output, fields...)
+}
+
+// InfoStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) InfoStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityInfo, output)
+}
+
+// InfoKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) InfoKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityInfo, output, fields...)
+}
+
+// NoticeStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) NoticeStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityNotice, output)
+}
+
+// NoticeKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) NoticeKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityNotice, output, fields...)
+}
+
+// WarningStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) WarningStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityWarning, output)
+}
+
+// WarningKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) WarningKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityWarning, output, fields...)
+}
+
+// ErrorStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) ErrorStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityError, output)
+}
+
+// ErrorKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) ErrorKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityError, output, fields...)
+}
+
+// CriticalStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) CriticalStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityCritical, output)
+}
+
+// CriticalKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) CriticalKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityCritical, output, fields...)
+}
+
+// AlertStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) AlertStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityAlert, output)
+}
+
+// AlertKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) AlertKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityAlert, output, fields...)
+}
+
+// EmergencyStr appends the output string without boxing it and implicitly sets appropriate severity level
+func (lm *logMsg) EmergencyStr(output string) LogMsg {
+	return lm.appendOutputStr(2, SeverityEmergency, output)
+}
+
+// EmergencyKV appends the output string, sets the fields as properties and implicitly sets appropriate severity level
+func (lm *logMsg) EmergencyKV(output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, SeverityEmergency, output, fields...)
+}
+
+// AppendOutputKV appends the output string, sets the fields as properties and sets given severity level
+func (lm *logMsg) AppendOutputKV(severity Severity, output string, fields ...Field) LogMsg {
+	return lm.appendOutputKV(2, severity, output, fields...)
+}
+
+func (lm *logMsg) appendOutputKV(calldepth int, severity Severity, output string, fields ...Field) LogMsg {
+	if lm != nil {
+		lm.fields = append(lm.fields, fields...)
+	}
+	if output == "" {
+		return lm.SetSeverity(severity)
+	}
+	return lm.appendOutputStr(calldepth+1, severity, output)
+}
+
+func (lm *logMsg) appendOutputStr(calldepth int, severity Severity, output string) (l LogMsg) {
 	l = lm.Self()
 	if lm == nil {
 		return
 	}
-	if len(values) <= 0 {
+	callerRecorded, ok := lm.acceptOutput(calldepth+1, severity)
+	if !ok {
 		return
 	}
-	lm.SetSeverity(severity)
-	if len(values) <= 0 {
+	lm.addOutputLines(calldepth+1, callerRecorded, strings.Split(escapePlaceholders(output), "\n"))
+	return
+}
+
+func (lm *logMsg) appendOutput(calldepth int, severity Severity, values ...interface{}) (l LogMsg) {
+	l = lm.Self()
+	if lm == nil {
 		return
 	}
-	if !config.meetsPrintMaxSeverity(severity) && !config.isWhitelisted(lm.logMessageType) && !lm.whitelisted {
+	if len(values) <= 0 {
 		return
 	}
-	_, file, line, ok := runtime.Caller(calldepth)
+	callerRecorded, ok := lm.acceptOutput(calldepth+1, severity)
 	if !ok {
-		file = "???"
-		line = 0
-	} else {
-		file = filepath.Base(file)
+		return
 	}
 	outputLines := []string{}
 	for _, value := range values {
-		lines := strings.Split(fmt.Sprint(value), "\n")
+		text, ok := value.(placeholderText)
+		if !ok {
+			text = placeholderText(escapePlaceholders(renderOutput(value)))
+		}
+		lines := strings.Split(string(text), "\n")
 		outputLines = append(outputLines, lines...)
 	}
+	lm.addOutputLines(calldepth+1, callerRecorded, outputLines)
+	return
+}
+
+// acceptOutput sets the severity, records the caller if configured and returns false if the output won't be printed
+func (lm *logMsg) acceptOutput(calldepth int, severity Severity) (callerRecorded bool, ok bool) {
+	config := currentConfig()
+	lm.SetSeverity(severity)
+	if config.callerProperties && !config.callerDisabledTypes.matches(lm.logMessageType) && lm.Property(PropertyCallerFile) == nil {
+		lm.recordCaller(calldepth + 1)
+		callerRecorded = true
+	}
+	if !config.meetsPrintMaxSeverity(severity) && !config.isWhitelisted(lm.logMessageType) && !lm.whitelisted && !isVerboseTrackingID(lm.trackingID) &&
+		atomic.LoadInt32(&retainFilteredOutput) == 0 {
+		return callerRecorded, false
+	}
+	return callerRecorded, true
+}
+
+// addOutputLines appends the output lines, prefixed with the caller location if configured (LOGTHING_OUTPUT_CALLER)
+func (lm *logMsg) addOutputLines(calldepth int, callerRecorded bool, outputLines []string) {
+	config := currentConfig()
+	if !config.outputCaller || config.callerDisabledTypes.matches(lm.logMessageType) {
+		for i, outputLine := range outputLines {
+			if i > 0 {
+				outputLine = "  " + outputLine
+			}
+			lm.addOutputLine(outputLine)
+		}
+		return
+	}
+	var location string
+	if callerRecorded {
+		file, _ := lm.Property(PropertyCallerFile).(string)
+		line, _ := lm.Property(PropertyCallerLine).(int)
+		location = fmt.Sprintf("%v:%v", file, line)
+		if function, _ := lm.Property(PropertyCallerFunc).(string); function != "" {
+			location += " " + function
+		}
+	} else {
+		location = callerAt(calldepth).location
+	}
 	if len(outputLines) == 1 {
-		lm.output = append(lm.output, fmt.Sprintf("[%v:%v]: %v", file, line, outputLines[0]))
+		lm.addOutputLine(fmt.Sprintf("[%v]: %v", location, outputLines[0]))
 	} else {
-		lm.output = append(lm.output, fmt.Sprintf("[%v:%v]:", file, line))
+		lm.addOutputLine(fmt.Sprintf("[%v]:", location))
 		for _, outputLine := range outputLines {
-			lm.output = append(lm.output, "  "+outputLine)
+			lm.addOutputLine("  " + outputLine)
 		}
 	}
-	return
+}
+
+// recordCaller sets the caller properties (file, line and function name) of the given call depth
+func (lm *logMsg) recordCaller(calldepth int) {
+	file, line, function := callerInfo(calldepth)
+	lm.SetProperty(PropertyCallerFile, file)
+	lm.SetProperty(PropertyCallerLine, line)
+	if function != "" {
+		lm.SetProperty(PropertyCallerFunc, function)
+	}
+}
+
+// callerInfo returns file name, line and short function name (pkg.Func) of the given call depth (like runtime.Caller)
+func callerInfo(calldepth int) (file string, line int, function string) {
+	c := callerAt(calldepth + 1)
+	return c.file, c.line, c.function
+}
+
+// shortFuncName returns the function name without package path (e.g. "logthing.(*logMsg).Log")
+func shortFuncName(function string) string {
+	if i := strings.LastIndex(function, "/"); i >= 0 {
+		function = function[i+1:]
+	}
+	return function
+}
+
+// addOutputLine appends the line to the output unless the configured output caps (LOGTHING_MAX_OUTPUT_LINES and
+// LOGTHING_MAX_OUTPUT_BYTES) are exceeded. Dropped lines are counted by a trailing "… N lines truncated" marker line,
+// which counts against the caps as well: The last lines are dropped to make room for it. An oversized first line is
+// cut instead of dropped, so that the output keeps its beginning.
+func (lm *logMsg) addOutputLine(line string) {
+	config := currentConfig()
+	if lm.truncatedLines == 0 {
+		if config.meetsOutputCaps(len(lm.output), lm.outputBytes+len(line)) {
+			lm.output = append(lm.output, line)
+			lm.outputBytes += len(line)
+			return
+		}
+		if len(lm.output) == 0 && config.maxOutputBytes > 0 {
+			// keep room for a marker of further dropped lines
+			if n := config.maxOutputBytes - len(outputTruncationMarker(999)) - len("…"); n > 0 {
+				for n > 0 && !utf8.RuneStart(line[n]) {
+					n--
+				}
+				lm.output = append(lm.output, line[:n]+"…")
+				lm.outputBytes += n + len("…")
+				return
+			}
+		}
+		lm.output = append(lm.output, "")
+	}
+	lm.truncatedLines++
+	for len(lm.output) > 1 && !config.meetsOutputCaps(len(lm.output)-1, lm.outputBytes+len(outputTruncationMarker(lm.truncatedLines))) {
+		dropped := lm.output[len(lm.output)-2]
+		lm.output = append(lm.output[:len(lm.output)-2], "")
+		lm.outputBytes -= len(dropped)
+		lm.truncatedLines++
+	}
+	lm.output[len(lm.output)-1] = outputTruncationMarker(lm.truncatedLines)
+}
+
+// outputTruncationMarker returns the marker line that counts the dropped output lines
+func outputTruncationMarker(truncatedLines int) string {
+	return fmt.Sprintf("… %v lines truncated", truncatedLines)
 }
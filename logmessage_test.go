@@ -0,0 +1,116 @@
+package logthing_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mfmayer/logthing"
+)
+
+func TestPropertyAs(t *testing.T) {
+	msg := logthing.NewLogMsg("test")
+	logthing.SetTyped(msg, "count", 42)
+	msg.SetSProperty("name", "foo")
+	if count, ok := logthing.PropertyAs[int](msg, "count"); !ok || count != 42 {
+		t.Errorf("expected count 42, got %v (%v)", count, ok)
+	}
+	if name, ok := logthing.PropertyAs[string](msg, "name"); !ok || name != "foo" {
+		t.Errorf("expected name foo, got %q (%v)", name, ok)
+	}
+	if _, ok := logthing.PropertyAs[string](msg, "count"); ok {
+		t.Errorf("expected type mismatch")
+	}
+	if _, ok := logthing.PropertyAs[int](logthing.NilLogMessage, "count"); ok {
+		t.Errorf("expected no property for nil message")
+	}
+}
+
+func TestSetPropertyCopy(t *testing.T) {
+	tags := map[string]interface{}{"env": "prod", "regions": []string{"eu"}}
+	msg := logthing.NewLogMsg("test").SetPropertyCopy("tags", tags)
+	tags["env"] = "dev"
+	tags["regions"].([]string)[0] = "us"
+	copied := msg.Property("tags").(map[string]interface{})
+	if copied["env"] != "prod" || copied["regions"].([]string)[0] != "eu" {
+		t.Errorf("expected snapshot of tags, got %v", copied)
+	}
+	ids := []int{1, 2}
+	msg = logthing.NewLogMsg("test", logthing.WithDeepCopy()).SetProperty("ids", ids)
+	ids[0] = 3
+	if msg.Property("ids").([]int)[0] != 1 {
+		t.Errorf("expected snapshot of ids, got %v", msg.Property("ids"))
+	}
+}
+
+func TestKVOutput(t *testing.T) {
+	err := errors.New("timeout")
+	msg := logthing.NewLogMsg("test").ErrorKV("request failed", logthing.FString("path", "/"), logthing.FInt("status", 504),
+		logthing.FFloat64("ratio", 0.5), logthing.FBool("retry", true), logthing.FErr(err))
+	if msg.Severity() != logthing.SeverityError || len(msg.Output()) != 1 || !strings.HasSuffix(msg.Output()[0], "request failed") {
+		t.Errorf("unexpected severity %v or output %v", msg.Severity(), msg.Output())
+	}
+	expected := map[string]interface{}{"path": "/", "status": int64(504), "ratio": 0.5, "retry": true, "error": "timeout"}
+	for key, value := range expected {
+		if msg.Property(key) != value {
+			t.Errorf("expected %v for %v, got %#v", value, key, msg.Property(key))
+		}
+	}
+}
+
+func TestStrOutputAllocs(t *testing.T) {
+	msg := logthing.NewLogMsg("test")
+	allocs := testing.AllocsPerRun(100, func() {
+		msg.TraceStr("dropped")
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations for dropped output, got %v", allocs)
+	}
+}
+
+func BenchmarkKVOutput(b *testing.B) {
+	for _, severity := range []logthing.Severity{logthing.SeverityInfo, logthing.SeverityError} {
+		name := "unfiltered"
+		if severity > logthing.ConfigPrintMaxSeverity() {
+			name = "filtered"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				logthing.NewLogMsg("bench").AppendOutputKV(severity, "request served", logthing.FString("path", "/"),
+					logthing.FInt("status", 200), logthing.FDuration("latency", time.Millisecond))
+			}
+		})
+	}
+}
+
+func TestOutputCaps(t *testing.T) {
+	t.Cleanup(logthing.ReloadConfig)
+	t.Setenv("LOGTHING_OUTPUT_CALLER", "false")
+	t.Setenv("LOGTHING_MAX_OUTPUT_LINES", "3")
+	t.Setenv("LOGTHING_MAX_OUTPUT_BYTES", "64")
+	logthing.ReloadConfig()
+	outputBytes := func(output []string) (n int) {
+		for _, line := range output {
+			n += len(line)
+		}
+		return n
+	}
+
+	output := logthing.NewLogMsg("test").ErrorStr("a\nb\nc\nd\ne").Output()
+	if len(output) != 3 || output[0] != "a" || output[1] != "  b" || output[2] != "… 3 lines truncated" {
+		t.Errorf("expected marker within the line cap, got %q", output)
+	}
+
+	output = logthing.NewLogMsg("test").ErrorStr(strings.Repeat("x", 30) + "\n" + strings.Repeat("y", 30) + "\nz").Output()
+	if outputBytes(output) > 64 || len(output) != 2 || output[1] != "… 2 lines truncated" {
+		t.Errorf("expected marker within the byte cap, got %q", output)
+	}
+
+	output = logthing.NewLogMsg("test").ErrorStr(strings.Repeat("ä", 100) + "\nnext").Output()
+	if outputBytes(output) > 64 || len(output) != 2 || !strings.HasPrefix(output[0], "ää") || !strings.HasSuffix(output[0], "…") || !utf8.ValidString(output[0]) || output[1] != "  next" {
+		t.Errorf("expected cut first line, got %q", output)
+	}
+}
@@ -9,13 +9,33 @@
 // LOGTHING_LOG_NAME  					 - Log name under which log messages are stored (will be used as elasticsearch index or azure custom log type)
 // LOGTHING_LOG_MAX_SEVERITY     - Messages with severity > LOGTHING_LOG_MAX_SEVERITY won't be logged or printed at all and are immediately dropped
 // LOGTHING_PRINT_MAX_SEVERITY   - Messages with severity <= LOGTHING_PRINT_MAX_SEVERITY are are also printed to stdout / stderr
-// LOGTHING_WHITELIST_LOG_TYPES  - Messages that match any whitelisted log type (comma separated) are logged independently of their severity
+// LOGTHING_WHITELIST_LOG_TYPES  - Messages that match any whitelisted log type (comma separated) are logged independently of their severity (glob patterns like "payment_*" are supported)
+// LOGTHING_WHITELIST_LOG_TYPES_REGEX - Messages with log types matching this regular expression are whitelisted as well
+// LOGTHING_DENY_LOG_TYPES       - Messages that match any denied log type (comma separated, glob patterns supported) are dropped regardless of their severity
+// LOGTHING_DENY_PROPERTIES      - Message properties that match any denied property (comma separated) are removed
 // LOGTHING_PRINT_PROPERTIES     - Message properties that match any give print property (comma separated) are printed with the message output
+// LOGTHING_PRINT_FOLD_LINES     - Multi-line output values (e.g. stack traces) are folded to the first N lines when printed (default: 0 = no folding)
+// LOGTHING_PRINT_EXPAND_SEVERITY - Printed messages with severity <= LOGTHING_PRINT_EXPAND_SEVERITY are never folded (default: 3 = Error)
+// LOGTHING_PRINT_STREAMS        - Console streams per severity (comma separated severity:stream pairs, e.g. "*:stdout" or "error:stdout"), default: stderr for severities <= Error, otherwise stdout
+// LOGTHING_PRINT_TIMEZONE       - Time zone of the timestamps of printed messages, e.g. "UTC", "Local" or "Europe/Berlin" (see SetConsoleTime)
+// LOGTHING_PRINT_TIME_LAYOUT    - Layout of the timestamps of printed messages, e.g. "15:04:05.000" (default: "2006/01/02 15:04:05")
+// LOGTHING_CALLER_PROPERTIES    - If true, file, line and function name where output is appended are recorded as "caller.file", "caller.line" and "caller.func" properties (default: false)
+// LOGTHING_OUTPUT_CALLER        - If false, output strings aren't prefixed with "[file:line]:" (default: true)
+// LOGTHING_CALLER_DISABLED_TYPES - Messages that match any given log type (comma separated, glob patterns supported) neither record nor print their caller (e.g. for hot paths)
+// LOGTHING_MAX_OUTPUT_LINES     - Max number of output lines per message, further lines are truncated (default: 0 = unlimited)
+// LOGTHING_MAX_OUTPUT_BYTES     - Max number of output bytes per message, further lines are truncated (default: 0 = unlimited)
+// LOGTHING_ROUTES               - Routing rules that decide which writers receive a message, e.g. 'type=="http_access" -> sample(0.01) -> writers:[archive]' (see ParseRoutingRules)
+// LOGTHING_PRE_INIT_BUFFER      - Number of messages that are buffered when logged before InitDispatcher (default: 0 = none, see SetPreInitBuffer)
+// LOGTHING_PREFIX               - Prefix of all variables, e.g. "MYAPP_" to read MYAPP_LOGTHING_* variables (see logwriter.Env)
+// LOGTHING_PROFILE              - Profile whose variables (LOGTHING_<PROFILE>_*) take precedence (see logwriter.Env)
 //
-// Note: Severity increases with lower values (SeverityEmergency: 0 ... SeverityTrace: 7)
+// Note: Severity increases with lower values (SeverityEmergency: 0 ... SeverityTrace: 7). Severities can be also given
+// by their case-insensitive names (e.g. "warning" or "info"), see ParseSeverity.
 package logthing
 
 import (
+	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
@@ -40,7 +60,6 @@ var (
 	loggers     = []**log.Logger{&Emergency, &Alert, &Critical, &Error, &Warning, &Notice, &Info, &Trace}
 	logPrefixes = []string{"EMERG: ", "ALERT: ", "CRIT:  ", "ERROR: ", "WARN:  ", "NOTICE:", "INFO:  ", "TRACE: ", "N/A:   "}
 	// severityNames = []string{"Emergency", "Alert", "Critical", "Error", "Warnin", "Notice", "Info", "Trace"}
-	newLineSpacer = "\n       "
 )
 
 var (
@@ -69,8 +88,14 @@ var (
 	ErrSeverityAboveMax error = errors.New("LogMessage severity level above LOGTHING_LOG_MAX_SEVERITY")
 	// ErrWrongMessageType is returned whe the log message is of wrong type. Ensure that LogMessage has been created by calling NewLogMsg()
 	ErrWrongMessageType error = errors.New("LogMessage is of wrong type")
+	// ErrDenied is returned when the message's log type is denied. See LOGTHING_DENY_LOG_TYPES and DenyTypes()
+	ErrDenied error = errors.New("LogMessage type denied")
 	// ErrChannelFull is returned when there is no empty space in the LogMessage queue
 	ErrChannelFull error = errors.New("channel full")
+	// ErrStaleMessage is reported (see Errors()) when queued messages are dropped because they are stale. See WithMaxMessageAge
+	ErrStaleMessage error = errors.New("LogMessage stale")
+	// ErrInitOnlyOption is returned by SetOptions for options that can only be set when the dispatcher is initialized
+	ErrInitOnlyOption error = errors.New("option can only be set when the dispatcher is initialized")
 )
 
 // func unwrappedErrorStrings(err error) []string {
@@ -98,23 +123,37 @@ func stringSetFromSlice(slice []string) (set map[string]struct{}) {
 
 func init() {
 	initConfig()
+	initPreInitBuffer()
+	initConsoleTime()
 	isSystemD := (os.Getenv("INVOCATION_ID") != "")
 	for severityLevel := Severity(0); severityLevel < SeverityNotApplied; severityLevel++ {
-		writer := os.Stdout
-		if severityLevel <= SeverityError {
-			writer = os.Stderr
-		}
 		prefix := logPrefixes[severityLevel]
-		flag := log.LstdFlags //log.Lshortfile | log.LstdFlags
 		if isSystemD {
 			prefix = fmt.Sprintf("<%v>%v", severityLevel, logPrefixes[severityLevel])
-			flag = 0
-		}
-		*loggers[severityLevel] = log.New(writer, prefix, flag)
-		if !config.meetsPrintMaxSeverity(severityLevel) {
-			(*loggers[severityLevel]).SetOutput(io.Discard)
 		}
+		*loggers[severityLevel] = log.New(io.Discard, prefix, 0)
+	}
+	configureLoggers()
+}
+
+// loggerOutput returns the output of the logger with given severity: the output set with SetSeverityOutput or
+// LOGTHING_PRINT_STREAMS, otherwise stderr for severities <= SeverityError and stdout for the others, or io.Discard if
+// the severity isn't printed (see LOGTHING_PRINT_MAX_SEVERITY)
+func loggerOutput(severity Severity) io.Writer {
+	config := currentConfig()
+	if !config.meetsPrintMaxSeverity(severity) {
+		return io.Discard
+	}
+	if output := severityOutput(severity); output != nil {
+		return output
 	}
+	if output, ok := config.printStreams[severity]; ok {
+		return output
+	}
+	if severity <= SeverityError {
+		return os.Stderr
+	}
+	return os.Stdout
 }
 
 // func getLogPrefix(severity Severity) string {
@@ -138,6 +177,24 @@ func WithOverflowCallback(callback func(LogMsg, uint64)) func(*dispatcherOptions
 	}
 }
 
+// WithWriterDisabledCallback sets function that is called back with the writer's name and the error when a writer is
+// disabled because it returned logwriter.ErrWriterDisable. Use logwriter.ReinitMayHelp(err) to decide whether it makes
+// sense to re-initialize the writer. Additionally a message of type "logthing_writer_disabled" is logged.
+func WithWriterDisabledCallback(callback func(name string, err error)) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.disabledCallback = callback
+	}
+}
+
+// WithWriterObserver sets function that is called back after every write of a batch with the writer's name, the batch size,
+// the duration of the write and the returned error, e.g. to record write timings in an application's own metrics system.
+// Writers can provide their name by implementing a Name() string method, otherwise their type name is used.
+func WithWriterObserver(observer func(writerName string, batchSize int, duration time.Duration, err error)) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.writerObserver = observer
+	}
+}
+
 // WithDispatchInterval sets interval for how long messages that shall be dispatched are queued before (default 5 seconds)
 func WithDispatchInterval(interval time.Duration) func(*dispatcherOptions) {
 	return func(opt *dispatcherOptions) {
@@ -145,6 +202,28 @@ func WithDispatchInterval(interval time.Duration) func(*dispatcherOptions) {
 	}
 }
 
+// WithImmediateFlushSeverity sets severity level for which queued messages are written immediately instead of waiting
+// for the end of the dispatch interval. E.g. with SeverityCritical critical, alert and emergency messages trigger a flush.
+func WithImmediateFlushSeverity(severity Severity) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.flushSeverity = severity
+	}
+}
+
+// WithTypeDispatchInterval overrides the dispatch interval for messages of the given type (e.g. audit events every second,
+// trace events every 30 seconds). Messages of types that share the same interval are batched together.
+func WithTypeDispatchInterval(msgType string, interval time.Duration) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		if interval <= 0 {
+			return
+		}
+		if opt.typeIntervals == nil {
+			opt.typeIntervals = map[string]time.Duration{}
+		}
+		opt.typeIntervals[msgType] = interval
+	}
+}
+
 // WithQueueSize sets queue size how many messsages can be buffered within a dispatch interval (default 8192)
 func WithQueueSize(size int) func(*dispatcherOptions) {
 	return func(opt *dispatcherOptions) {
@@ -152,6 +231,82 @@ func WithQueueSize(size int) func(*dispatcherOptions) {
 	}
 }
 
+// WithMaxMessageAge sets how long messages may wait in the queue before they are considered stale and dropped instead of written
+// (e.g. trace spam that piled up while writers were backed up). Messages with severity <= SeverityError are always kept.
+// Dropped messages are counted in Stats().Stale and reported with ErrStaleMessage (see Errors()).
+func WithMaxMessageAge(maxAge time.Duration) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.maxMessageAge = maxAge
+	}
+}
+
+// WithMaxBatchSize sets max number of messages per batch. When a batch reaches this size it is written immediately
+// instead of waiting for the end of the dispatch interval (default 0: unlimited)
+func WithMaxBatchSize(size int) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.maxBatchSize = size
+	}
+}
+
+// WithConsoleFallback enables a console-only mode for the case that all writers are disabled or failed to init: Messages with
+// severity <= the given severity are then printed to stderr as NDJSON, so that nothing important gets lost if the cloud path is dead.
+func WithConsoleFallback(severity Severity) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.fallbackSeverity = severity
+	}
+}
+
+// WithStrictConfig lets InitDispatcher fail with an error instead of starting the dispatcher, when the environment
+// configuration is invalid (see ValidateConfig)
+func WithStrictConfig() func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.strictConfig = true
+	}
+}
+
+// DenyTypes drops all messages of the given log types (glob patterns like "thirdparty_*" are supported) regardless of their
+// severity, in addition to the types denied by LOGTHING_DENY_LOG_TYPES
+func DenyTypes(logTypes ...string) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.denyLogTypes = newTypeMatcher(append(logTypes, opt.denyLogTypes.list()...))
+	}
+}
+
+// WithFilteredContextRing keeps the last size messages that were filtered out by severity (see LOGTHING_LOG_MAX_SEVERITY) in
+// a ring buffer. When a message with severity <= SeverityError is logged, the ring's messages with the same tracking ID are
+// logged as well, marked with the "filteredContext" property. This gives post-hoc trace context for failures without
+// permanent trace ingestion. The ring keeps copies, so that filtered messages may be reused by the caller.
+func WithFilteredContextRing(size int) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.filteredRingSize = size
+	}
+}
+
+// WithRuntimeMetrics enables a background reporter that periodically logs a message of type "logthing_runtime_metrics"
+// with goroutine count, heap stats, GC pause totals and open file descriptors (linux only)
+func WithRuntimeMetrics(interval time.Duration) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.metricsInterval = interval
+	}
+}
+
+// WithHeartbeat enables that every interval a small message of type "logthing_heartbeat" is logged, which contains the
+// dispatcher's statistics (see Stats). Backend alerting can detect "service alive but not logging" conditions by absence
+// or content of the heartbeats.
+func WithHeartbeat(interval time.Duration) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.heartbeatInterval = interval
+	}
+}
+
+// WithCredentialRefreshInterval sets the interval in which the expiring credentials of writers that implement
+// logwriter.CredentialRefresher are refreshed in the background (default: 1 minute, 0 disables proactive refreshing)
+func WithCredentialRefreshInterval(interval time.Duration) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.credentialRefresh = interval
+	}
+}
+
 // WithSetLogEntryID enables that for every log message an individual "logEntryID" property is set (counter that is atomically incremented)
 func WithSetLogEntryID() func(*dispatcherOptions) {
 	return func(opt *dispatcherOptions) {
@@ -159,6 +314,14 @@ func WithSetLogEntryID() func(*dispatcherOptions) {
 	}
 }
 
+// WithIDGenerator enables that for every log message an individual "logEntryID" property is set, which is created by the
+// given generator (e.g. NewUUIDv7, NewULID or NewKSUID for IDs that sort by time) instead of the atomically incremented counter
+func WithIDGenerator(generator func() string) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.idGenerator = generator
+	}
+}
+
 // WithSetStaticProperties enables that for every log message all given static properties are set
 func WithSetStaticProperties(staticProperties map[string]interface{}) func(*dispatcherOptions) {
 	return func(opt *dispatcherOptions) {
@@ -169,28 +332,89 @@ func WithSetStaticProperties(staticProperties map[string]interface{}) func(*disp
 	}
 }
 
+// WithMessageSigning enables that every marshalled message is signed with the given Ed25519 key, e.g. for audit streams
+// where consumers must verify the producer's authenticity. The base64 encoded signature and the key id are added as
+// "signature" and "signatureKeyID" properties. Messages can be verified with VerifySignature or "logthing verify".
+func WithMessageSigning(keyID string, key ed25519.PrivateKey) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.signer = nil
+		if len(key) == ed25519.PrivateKeySize {
+			opt.signer = &messageSigner{keyID: keyID, key: key}
+		}
+	}
+}
+
 // InitDispatcher to init logthing log message dispatcher with given writers.
 // When logthing isn't needed anymore (e.g. when the application exits) Close() must be called.
+// If writers failed to init, WriterInitErrors is returned and the dispatcher is started with the remaining writers.
 func InitDispatcher(logWriters []logwriter.LogWriter, opts ...func(*dispatcherOptions)) (err error) {
 	if ld != nil {
 		ld.close()
 	}
+	loadConfigOnce()
 	ld, err = newLogDispatcher(logWriters, opts...)
+	if ld != nil {
+		preInit.replay(ld)
+	}
 	return
 }
 
+// SetOptions changes options of the running default dispatcher, e.g. queue size, dispatch intervals and batch limits,
+// so operators can react to load changes without restarting. When the queue size changes, the queue is swapped and
+// already queued messages are kept. Options that are only applied when the dispatcher is initialized (WithStrictConfig,
+// WithFilteredContextRing, WithRecentMessages, WithRuntimeMetrics, WithHeartbeat, WithCredentialRefreshInterval,
+// WithCloudMetadataEnrichment, WithMessageSigning, WithEncoder, WithCheckpointFile and WithWriterConcurrency) can't be
+// changed: In this case none of the options is set and ErrInitOnlyOption is returned.
+func SetOptions(opts ...func(*dispatcherOptions)) error {
+	if ld == nil {
+		return ErrNotInitialized
+	}
+	return ld.setOptions(opts...)
+}
+
+// Validate performs a dry-run against each writer of the default dispatcher (e.g. authentication check, probe request or
+// table existence) and returns per-writer results, so deployments can fail fast on misconfigured credentials.
+// Writers that don't implement logwriter.Validator are marked as skipped. The writers are validated on the caller's
+// goroutine, so that messages are still dispatched while slow probes are pending.
+func Validate(ctx context.Context) ([]WriterValidation, error) {
+	if ld == nil {
+		return nil, ErrNotInitialized
+	}
+	return ld.validate(ctx)
+}
+
+// Stats returns the statistics of the default dispatcher
+func Stats() DispatcherStats {
+	if ld == nil {
+		return DispatcherStats{}
+	}
+	return ld.stats()
+}
+
 // Close to flush all queued messages and close the writers
 func Close() {
 	if ld != nil {
 		ld.close()
+		ld = nil
 	}
 }
 
+// Errors returns channel that carries structured information about errors in the dispatch pipeline of the default dispatcher
+// (e.g. failed writer init, full queue, failed writes), so applications can observe and react to them programmatically.
+// Errors are dropped when the channel is full. The channel is closed when the dispatcher is closed.
+func Errors() <-chan DispatchError {
+	if ld == nil {
+		return nil
+	}
+	return ld.errorCh
+}
+
 // Log outputs and sends LogMessage with default dispatcher
 // returns:
 // ErrNotInitialized when the dispatcher hasn't been initialized
 // ErrSeverityAboveMax when the message's severity is above the max severity level. See LOGTHING_LOG_MAX_SEVERITY
 // ErrWrongMessageType whe the log message is of wrong type. Ensure that LogMessage has been created by calling NewLogMsg()
+// ErrDenied when the message's log type is denied. See LOGTHING_DENY_LOG_TYPES
 // ErrChannelFull when there is no empty space in the LogMessage queue
 func Log(msg LogMsg) (err error) {
 	return LogMsgWithCalldepth(2, msg)
@@ -201,10 +425,14 @@ func Log(msg LogMsg) (err error) {
 // ErrNotInitialized when the dispatcher hasn't been initialized
 // ErrSeverityAboveMax when the message's severity is above the max severity level. See LOGTHING_LOG_MAX_SEVERITY
 // ErrWrongMessageType whe the log message is of wrong type. Ensure that LogMessage has been created by calling NewLogMsg()
+// ErrDenied when the message's log type is denied. See LOGTHING_DENY_LOG_TYPES
 // ErrChannelFull when there is no empty space in the LogMessage queue
 func LogMsgWithCalldepth(calldepth int, msg LogMsg) (err error) {
 	if ld == nil {
-		return ErrNotInitialized
+		if msg == nil || msg.IsNil() {
+			return ErrNotInitialized
+		}
+		return preInit.add(calldepth+1, msg)
 	}
 	if msg == nil {
 		return
@@ -1,6 +1,7 @@
 package logthing_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -46,3 +47,17 @@ func TestLogthing(t *testing.T) {
 
 	logthing.Close()
 }
+
+func TestWriterInitErrors(t *testing.T) {
+	t.Setenv("LOGTHING_AZURE_WORKSPACE_ID", "")
+	err := logthing.InitDispatcher([]logwriter.LogWriter{logwriter.NewAzureMonitorWriter()})
+	defer logthing.Close()
+	var initErrors logthing.WriterInitErrors
+	if !errors.As(err, &initErrors) || len(initErrors) != 1 || initErrors[0].Name == "" {
+		t.Fatalf("expected writer init errors, got %v", err)
+	}
+	var initError logthing.WriterInitError
+	if !errors.As(err, &initError) || !errors.Is(err, initErrors[0].Err) {
+		t.Errorf("expected errors of the writers to be matched, got %v", err)
+	}
+}
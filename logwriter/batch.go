@@ -0,0 +1,63 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Batch contains the marshalled LogMessages of a write and their timestamps with corresponding indices
+type Batch struct {
+	LogMessages []json.RawMessage
+	Timestamps  []time.Time
+	Ordered     bool // true if the LogMessages are ordered by their timestamps
+}
+
+// Len returns the number of LogMessages
+func (b Batch) Len() int {
+	return len(b.LogMessages)
+}
+
+// Range calls fn for the LogMessages in timestamp order until fn returns false. If the batch isn't ordered, the order
+// is determined without changing the batch.
+func (b Batch) Range(fn func(logMessage json.RawMessage, timestamp time.Time) bool) {
+	if b.Ordered {
+		for i, logMessage := range b.LogMessages {
+			if !fn(logMessage, b.Timestamps[i]) {
+				return
+			}
+		}
+		return
+	}
+	indices := make([]int, len(b.LogMessages))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return b.Timestamps[indices[i]].Before(b.Timestamps[indices[j]])
+	})
+	for _, i := range indices {
+		if !fn(b.LogMessages[i], b.Timestamps[i]) {
+			return
+		}
+	}
+}
+
+// BatchWriter is implemented by writers that want to know whether the LogMessages are ordered by their timestamps. The
+// dispatcher calls WriteBatch instead of WriteLogMessages.
+type BatchWriter interface {
+	WriteBatch(batch Batch) error
+}
+
+// OrderInsensitive is implemented by writers for which the order of the LogMessages within a batch doesn't matter (e.g.
+// because the service indexes them by timestamp). The dispatcher skips sorting the batches if all writers are order
+// insensitive.
+type OrderInsensitive interface {
+	OrderInsensitive() bool
+}
+
+// isOrderInsensitive returns true if the writer implements OrderInsensitive and its order doesn't matter
+func isOrderInsensitive(lw LogWriter) bool {
+	oi, ok := lw.(OrderInsensitive)
+	return ok && oi.OrderInsensitive()
+}
@@ -0,0 +1,29 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBatchRange(t *testing.T) {
+	now := time.Now()
+	batch := Batch{
+		LogMessages: []json.RawMessage{json.RawMessage(`"b"`), json.RawMessage(`"a"`), json.RawMessage(`"c"`)},
+		Timestamps:  []time.Time{now.Add(time.Second), now, now.Add(2 * time.Second)},
+	}
+	ranged := ""
+	batch.Range(func(logMessage json.RawMessage, timestamp time.Time) bool {
+		ranged += string(logMessage)
+		return true
+	})
+	if ranged != `"a""b""c"` {
+		t.Errorf("expected messages in timestamp order, got %v", ranged)
+	}
+	if string(batch.LogMessages[0]) != `"b"` {
+		t.Errorf("expected batch to be unchanged")
+	}
+	if !isOrderInsensitive(NewShardedWriter([]LogWriter{NewElasticsearchWriter()}, ShardByTrackingID)) || isOrderInsensitive(&testWriter{}) {
+		t.Errorf("unexpected order insensitivity")
+	}
+}
@@ -0,0 +1,49 @@
+package logwriter
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize is the max capacity of buffers that are returned to the pool, so that the buffers of single large
+// batches don't stay in memory
+const maxPooledBufferSize = 8 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// GetBuffer returns an empty buffer of the pool that is shared by the dispatcher and the writers for body assembly,
+// signing and compression. It should be returned with PutBuffer when it's no longer used.
+func GetBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// PutBuffer returns the buffer to the pool. Neither the buffer nor its bytes must be used afterwards.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// pooledBody is a request body that returns its buffer to the pool when it's closed. The http transport closes the
+// request body when it's done with it, which may be after the response has been returned.
+type pooledBody struct {
+	*bytes.Reader
+	buf  *bytes.Buffer
+	once sync.Once
+}
+
+// newPooledBody returns a request body that reads the buffer and returns it to the pool when it's closed. The request's
+// ContentLength must be set to Len(), since it's unknown to http.NewRequest.
+func newPooledBody(buf *bytes.Buffer) *pooledBody {
+	return &pooledBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() { PutBuffer(b.buf) })
+	return nil
+}
@@ -0,0 +1,21 @@
+package logwriter
+
+import (
+	"io"
+	"testing"
+)
+
+func TestPooledBody(t *testing.T) {
+	buf := GetBuffer()
+	buf.WriteString("body")
+	body := newPooledBody(buf)
+	data, _ := io.ReadAll(body)
+	if string(data) != "body" {
+		t.Errorf("unexpected body %q", data)
+	}
+	body.Close()
+	body.Close() // closing twice must not put the buffer twice
+	if GetBuffer().Len() != 0 {
+		t.Errorf("expected reset buffer from pool")
+	}
+}
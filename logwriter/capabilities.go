@@ -0,0 +1,38 @@
+package logwriter
+
+// BodyFormat declares how a writer assembles the LogMessages of a batch
+type BodyFormat string
+
+const (
+	// FormatAny if the writer doesn't assemble the LogMessages into a single body (e.g. one message per record)
+	FormatAny BodyFormat = ""
+	// FormatJSONArray if the LogMessages are sent as JSON array
+	FormatJSONArray BodyFormat = "array"
+	// FormatNDJSON if the LogMessages are sent as newline delimited JSON
+	FormatNDJSON BodyFormat = "ndjson"
+)
+
+// Capabilities describes the features and limits of a writer, so that the dispatcher and writer middleware can adapt
+// per writer (e.g. chunking of batches) instead of hard-coding the behaviour
+type Capabilities struct {
+	Compression   bool       // batches can be compressed (see CompressibleWriter)
+	MaxBatchBytes int        // max size of the LogMessages of a single write in bytes (0: unlimited), larger batches are split by the dispatcher
+	Format        BodyFormat // how the LogMessages of a batch are assembled
+	Schema        bool       // the writer uses the properties schema (see PropertiesSchemaChanged)
+	Columnar      bool       // properties are stored in columns, so nested objects can be flattened by the dispatcher
+}
+
+// CapabilityReporter is implemented by writers that report their capabilities
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the capabilities of the writer. For writers that don't implement CapabilityReporter, only
+// Compression is derived (see CompressibleWriter).
+func CapabilitiesOf(lw LogWriter) Capabilities {
+	if reporter, ok := lw.(CapabilityReporter); ok {
+		return reporter.Capabilities()
+	}
+	_, compressible := lw.(CompressibleWriter)
+	return Capabilities{Compression: compressible}
+}
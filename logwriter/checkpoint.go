@@ -0,0 +1,14 @@
+package logwriter
+
+// Checkpoint is an opaque token of a writer that identifies its last durably written batch (e.g. a sequence number or
+// an offset)
+type Checkpoint string
+
+// CheckpointedWriter is implemented by writers that return a durable checkpoint for every written batch. The dispatcher
+// calls WriteBatchCheckpointed instead of WriteLogMessages and persists the returned checkpoints (see
+// logthing.WithCheckpointFile). After a restart, the writer is resumed with its last persisted checkpoint after Init,
+// so that it can continue shipping from the last durable point without duplicating or losing batches.
+type CheckpointedWriter interface {
+	WriteBatchCheckpointed(batch Batch) (Checkpoint, error)
+	Resume(checkpoint Checkpoint) error
+}
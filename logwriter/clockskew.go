@@ -0,0 +1,117 @@
+package logwriter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PropertyDeviceTimestamp contains the original timestamp of messages whose timestamp has been corrected by a measured
+// clock offset
+const PropertyDeviceTimestamp = "deviceTimestamp"
+
+// minClockOffset is the min offset that is corrected, because the HTTP Date header has a resolution of one second
+const minClockOffset = 2 * time.Second
+
+// MeasureClockOffset measures the offset of the local clock to the clock of the server at given url by the Date header
+// of a HEAD request. The offset has to be added to local times to get server times. Its resolution is one second.
+func MeasureClockOffset(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	end := time.Now()
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header: %w", err)
+	}
+	// the server time has been taken somewhere within the round trip, the Date header is truncated to seconds
+	local := start.Add(end.Sub(start) / 2)
+	return serverTime.Add(500 * time.Millisecond).Sub(local), nil
+}
+
+// clockSkewCorrector measures the clock offset (at most once per minute) and corrects the timestamps of replayed
+// batches
+type clockSkewCorrector struct {
+	mutex      sync.Mutex
+	url        string
+	client     *http.Client
+	offset     time.Duration
+	measuredAt time.Time
+}
+
+func newClockSkewCorrector(url string) *clockSkewCorrector {
+	return &clockSkewCorrector{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// currentOffset returns the measured clock offset or the last one if the measurement fails
+func (c *clockSkewCorrector) currentOffset() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Since(c.measuredAt) < time.Minute {
+		return c.offset
+	}
+	c.measuredAt = time.Now()
+	if offset, err := MeasureClockOffset(context.Background(), c.client, c.url); err == nil {
+		c.offset = offset
+	}
+	return c.offset
+}
+
+// correct returns the NDJSON batch with timestamps that are corrected by the measured clock offset
+func (c *clockSkewCorrector) correct(batch []byte) []byte {
+	offset := c.currentOffset()
+	if offset > -minClockOffset && offset < minClockOffset {
+		return batch
+	}
+	return correctTimestamps(batch, offset)
+}
+
+// correctTimestamps adds the offset to the "timestamp" of each message of the NDJSON batch and keeps the original
+// timestamp as "deviceTimestamp". Lines that can't be corrected are kept.
+func correctTimestamps(batch []byte, offset time.Duration) []byte {
+	var corrected bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(batch))
+	scanner.Buffer(make([]byte, 64*1024), len(batch)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if corrected.Len() > 0 {
+			corrected.WriteByte('\n')
+		}
+		var msg map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&msg); err != nil {
+			corrected.Write(line)
+			continue
+		}
+		timestampValue, _ := msg["timestamp"].(string)
+		timestamp, err := time.Parse(time.RFC3339Nano, timestampValue)
+		if err != nil {
+			corrected.Write(line)
+			continue
+		}
+		if _, ok := msg[PropertyDeviceTimestamp]; !ok {
+			msg[PropertyDeviceTimestamp] = timestampValue
+		}
+		msg["timestamp"] = timestamp.Add(offset).UTC().Format("2006-01-02T15:04:05.999999Z")
+		rawMsg, err := json.Marshal(msg)
+		if err != nil {
+			corrected.Write(line)
+			continue
+		}
+		corrected.Write(rawMsg)
+	}
+	return corrected.Bytes()
+}
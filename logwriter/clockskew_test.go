@@ -0,0 +1,45 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeasureClockOffset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	offset, err := MeasureClockOffset(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < time.Hour-2*time.Second || offset > time.Hour+2*time.Second {
+		t.Errorf("expected offset of about 1h, got %v", offset)
+	}
+}
+
+func TestCorrectTimestamps(t *testing.T) {
+	batch := []byte(`{"timestamp":"2020-01-01T00:00:00Z","count":12345678901234}` + "\n" + `not json`)
+	lines := strings.Split(string(correctTimestamps(batch, time.Hour)), "\n")
+	if len(lines) != 2 || lines[1] != "not json" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	var msg map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(lines[0]))
+	decoder.UseNumber()
+	if err := decoder.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["timestamp"] != "2020-01-01T01:00:00Z" || msg[PropertyDeviceTimestamp] != "2020-01-01T00:00:00Z" {
+		t.Errorf("unexpected corrected message %v", msg)
+	}
+	if msg["count"].(json.Number).String() != "12345678901234" {
+		t.Errorf("expected numbers to be kept, got %v", msg["count"])
+	}
+}
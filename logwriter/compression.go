@@ -0,0 +1,147 @@
+package logwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Compression declares the content encoding that is used to compress batches
+type Compression string
+
+const (
+	// NoCompression sends batches uncompressed
+	NoCompression Compression = ""
+	// CompressionGzip compresses batches with gzip
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses batches with zstd (a compressor must be registered with RegisterCompressor)
+	CompressionZstd Compression = "zstd"
+)
+
+var (
+	compressorsMutex sync.RWMutex
+	compressors      = map[Compression]func(w io.Writer) (io.WriteCloser, error){
+		CompressionGzip: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	}
+)
+
+// RegisterCompressor registers a compressor for the given content encoding. Since the standard library doesn't
+// provide a zstd encoder, it must be registered to use CompressionZstd, e.g. with github.com/klauspost/compress/zstd:
+//
+//	logwriter.RegisterCompressor(logwriter.CompressionZstd, func(w io.Writer) (io.WriteCloser, error) {
+//		return zstd.NewWriter(w)
+//	})
+func RegisterCompressor(compression Compression, newWriter func(w io.Writer) (io.WriteCloser, error)) {
+	compressorsMutex.Lock()
+	defer compressorsMutex.Unlock()
+	compressors[compression] = newWriter
+}
+
+func compressor(compression Compression) (newWriter func(w io.Writer) (io.WriteCloser, error), ok bool) {
+	compressorsMutex.RLock()
+	defer compressorsMutex.RUnlock()
+	newWriter, ok = compressors[compression]
+	return
+}
+
+// CompressibleWriter is implemented by writers that are able to compress their batches (e.g. HTTP based writers)
+type CompressibleWriter interface {
+	LogWriter
+	// SetCompression sets the compression of the batches. An error is returned if the compression is not supported.
+	SetCompression(compression Compression) error
+}
+
+// WithCompression enables compression of the serialized batches for the given writer, if it implements CompressibleWriter
+// (currently the Pulsar and Service Bus writers). Writers that don't support compression (e.g. the Elasticsearch,
+// OpenSearch and Azure Monitor writers or non-HTTP writers) are returned unchanged. An error is returned if the
+// compression isn't supported by the writer or not registered (see RegisterCompressor).
+func WithCompression(lw LogWriter, compression Compression) (LogWriter, error) {
+	if cw, ok := lw.(CompressibleWriter); ok {
+		if err := cw.SetCompression(compression); err != nil {
+			return lw, err
+		}
+	}
+	return lw, nil
+}
+
+// httpCompression can be embedded by HTTP based writers to compress request bodies and set the according Content-Encoding
+// header. The compression is guarded by a mutex, since batches may be written concurrently (see
+// logthing.WithWriterConcurrency) and the 415 fallback disables it while writing.
+type httpCompression struct {
+	mutex       sync.RWMutex
+	compression Compression
+}
+
+func (hc *httpCompression) SetCompression(compression Compression) error {
+	if compression != NoCompression {
+		if _, ok := compressor(compression); !ok {
+			return fmt.Errorf("compression %q not registered", compression)
+		}
+	}
+	hc.setCompression(compression)
+	return nil
+}
+
+func (hc *httpCompression) setCompression(compression Compression) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.compression = compression
+}
+
+func (hc *httpCompression) currentCompression() Compression {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	return hc.compression
+}
+
+// compress compresses the data with given compression into the buffer
+func compress(compression Compression, data []byte, buf *bytes.Buffer) error {
+	newWriter, ok := compressor(compression)
+	if !ok {
+		return fmt.Errorf("compression %q not registered", compression)
+	}
+	w, err := newWriter(buf)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// do creates a request with newRequest and the (compressed) body and sends it with given client. If the server rejects
+// the content encoding (415 Unsupported Media Type), compression is disabled and the request is sent again uncompressed.
+func (hc *httpCompression) do(client *http.Client, newRequest func(body io.Reader) (*http.Request, error), body []byte) (*http.Response, error) {
+	if compression := hc.currentCompression(); compression != NoCompression {
+		compressed := GetBuffer()
+		if err := compress(compression, body, compressed); err != nil {
+			PutBuffer(compressed)
+			return nil, fmt.Errorf("Compressing request body failed: %w", err)
+		}
+		pooledBody := newPooledBody(compressed)
+		req, err := newRequest(pooledBody)
+		if err != nil {
+			pooledBody.Close()
+			return nil, err
+		}
+		req.ContentLength = int64(compressed.Len())
+		req.Header.Set("Content-Encoding", string(compression))
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+			return resp, err
+		}
+		resp.Body.Close()
+		hc.setCompression(NoCompression)
+	}
+	req, err := newRequest(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,82 @@
+package logwriter
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCompressionNegotiation(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer server.Close()
+
+	hc := &httpCompression{}
+	if err := hc.SetCompression(CompressionZstd); err == nil {
+		t.Errorf("expected error for unregistered compression")
+	}
+	if _, err := WithCompression(NewPulsarWriter(), CompressionZstd); err == nil {
+		t.Errorf("expected error for unregistered compression of compressible writer")
+	}
+	if _, err := WithCompression(NewGELFWriter(), CompressionZstd); err != nil {
+		t.Errorf("expected incompressible writer to be returned unchanged, got %v", err)
+	}
+	if err := hc.SetCompression(CompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+	newRequest := func(body io.Reader) (*http.Request, error) {
+		return http.NewRequest("POST", server.URL, body)
+	}
+	resp, err := hc.do(server.Client(), newRequest, bytes.Repeat([]byte("a"), 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(encodings) != 2 || encodings[0] != "gzip" || encodings[1] != "" {
+		t.Errorf("expected uncompressed retry after 415, got %v", encodings)
+	}
+	if hc.currentCompression() != NoCompression {
+		t.Errorf("expected compression to be disabled")
+	}
+}
+
+func TestCompressionConcurrentFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer server.Close()
+	hc := &httpCompression{}
+	if err := hc.SetCompression(CompressionGzip); err != nil {
+		t.Fatal(err)
+	}
+	newRequest := func(body io.Reader) (*http.Request, error) {
+		return http.NewRequest("POST", server.URL, body)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := hc.do(server.Client(), newRequest, []byte("body"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status %v", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,84 @@
+package logwriter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Token is an expiring credential (e.g. AAD bearer token, SAS token or a HEC token fetched from Vault)
+type Token struct {
+	Value     string
+	ExpiresOn time.Time // zero if the token never expires
+}
+
+// TokenSource fetches a new token
+type TokenSource func(ctx context.Context) (Token, error)
+
+// RefreshingToken caches the token of a TokenSource and refreshes it when it's about to expire. Writers register their
+// RefreshingTokens by implementing CredentialRefresher, so that the dispatcher can refresh them proactively in the
+// background instead of delaying a write.
+type RefreshingToken struct {
+	mutex  sync.Mutex
+	source TokenSource
+	margin time.Duration
+	token  Token
+}
+
+// NewRefreshingToken returns new RefreshingToken for given source. The token is refreshed when it expires within margin.
+func NewRefreshingToken(source TokenSource, margin time.Duration) *RefreshingToken {
+	return &RefreshingToken{source: source, margin: margin}
+}
+
+// refreshDue returns whether the token must be refreshed
+func (rt *RefreshingToken) refreshDue(now time.Time) bool {
+	if rt.token.Value == "" {
+		return true
+	}
+	return !rt.token.ExpiresOn.IsZero() && now.Add(rt.margin).After(rt.token.ExpiresOn)
+}
+
+// Token returns the cached token value and refreshes it before if it's about to expire
+func (rt *RefreshingToken) Token(ctx context.Context) (string, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if rt.refreshDue(time.Now()) {
+		if err := rt.refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+	return rt.token.Value, nil
+}
+
+// Refresh refreshes the token if it's about to expire
+func (rt *RefreshingToken) Refresh(ctx context.Context) error {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if !rt.refreshDue(time.Now()) {
+		return nil
+	}
+	return rt.refresh(ctx)
+}
+
+func (rt *RefreshingToken) refresh(ctx context.Context) error {
+	token, err := rt.source(ctx)
+	if err != nil {
+		return err
+	}
+	rt.token = token
+	return nil
+}
+
+// CredentialRefresher is implemented by writers that use expiring credentials. The dispatcher periodically refreshes the
+// returned tokens in the background. Writers that wrap other writers return the tokens of all wrapped writers.
+type CredentialRefresher interface {
+	Credentials() []*RefreshingToken
+}
+
+// credentialsOf returns the registered tokens of the writer or nil if it doesn't implement CredentialRefresher
+func credentialsOf(lw LogWriter) []*RefreshingToken {
+	if refresher, ok := lw.(CredentialRefresher); ok {
+		return refresher.Credentials()
+	}
+	return nil
+}
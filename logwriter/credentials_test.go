@@ -0,0 +1,39 @@
+package logwriter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRefreshingToken(t *testing.T) {
+	fetches := 0
+	expiresOn := time.Now().Add(time.Hour)
+	token := NewRefreshingToken(func(ctx context.Context) (Token, error) {
+		fetches++
+		return Token{Value: strconv.Itoa(fetches), ExpiresOn: expiresOn}, nil
+	}, 5*time.Minute)
+	for i := 0; i < 2; i++ {
+		if value, err := token.Token(context.Background()); err != nil || value != "1" {
+			t.Fatalf("unexpected token: %v %v", value, err)
+		}
+	}
+	token.Refresh(context.Background())
+	if fetches != 1 {
+		t.Errorf("token refreshed although not about to expire")
+	}
+	token.token.ExpiresOn = time.Now().Add(time.Minute)
+	token.Refresh(context.Background())
+	if value, _ := token.Token(context.Background()); value != "2" {
+		t.Errorf("token not refreshed before expiry: %v", value)
+	}
+}
+
+func TestCredentialsOfWrapper(t *testing.T) {
+	sb := &serviceBus{token: NewRefreshingToken(nil, 0)}
+	lw := NewFailoverWriter(WithStringifyPolicy(sb, StringifyPolicy{}), &testWriter{})
+	if tokens := credentialsOf(lw); len(tokens) != 1 || tokens[0] != sb.token {
+		t.Errorf("unexpected credentials: %v", tokens)
+	}
+}
@@ -0,0 +1,120 @@
+package logwriter
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// Env determines how LOGTHING_* environment variables are looked up. With a prefix, e.g. "MYAPP_", the variables are
+// read as MYAPP_LOGTHING_*. With a profile, e.g. "audit", the variables are read as LOGTHING_AUDIT_* and fall back to
+// LOGTHING_*. This allows multiple libraries that embed logthing in the same process to configure their writers
+// independently (see WithEnv). The configuration of the logthing package itself (e.g. LOGTHING_LOG_MAX_SEVERITY) is
+// global to the process and always read with the current Env (see SetEnv).
+// Values (keyed by LOGTHING_* name) take precedence over the environment, e.g. for writers created from a config file.
+type Env struct {
+	Prefix  string
+	Profile string
+	Values  map[string]string
+}
+
+var (
+	envMutex       sync.RWMutex
+	currentEnv     *Env       // nil until set or first used
+	constructMutex sync.Mutex // serializes WithEnv
+)
+
+// names returns the names under which the LOGTHING_* variable is looked up (in order of precedence)
+func (e Env) names(name string) []string {
+	rest := strings.TrimPrefix(name, "LOGTHING_")
+	if rest == name {
+		return []string{name}
+	}
+	names := make([]string, 0, 2)
+	if e.Profile != "" {
+		names = append(names, e.Prefix+"LOGTHING_"+strings.ToUpper(e.Profile)+"_"+rest)
+	}
+	return append(names, e.Prefix+"LOGTHING_"+rest)
+}
+
+// Lookup looks up the LOGTHING_* environment variable with the prefix and profile of the Env
+func (e Env) Lookup(name string) (string, bool) {
+	if value, ok := e.Values[name]; ok {
+		return value, true
+	}
+	for _, n := range e.names(name) {
+		if value, ok := os.LookupEnv(n); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Getenv returns the value of the LOGTHING_* environment variable with the prefix and profile of the Env
+func (e Env) Getenv(name string) string {
+	value, _ := e.Lookup(name)
+	return value
+}
+
+// Canonical returns the LOGTHING_* name of given environment variable name that contains the prefix and/or profile of
+// the Env and false if the name doesn't belong to the Env.
+func (e Env) Canonical(name string) (string, bool) {
+	if !strings.HasPrefix(name, e.Prefix+"LOGTHING_") {
+		return "", false
+	}
+	rest := strings.TrimPrefix(name, e.Prefix+"LOGTHING_")
+	if profile := strings.ToUpper(e.Profile) + "_"; e.Profile != "" && strings.HasPrefix(rest, profile) {
+		rest = strings.TrimPrefix(rest, profile)
+	}
+	return "LOGTHING_" + rest, true
+}
+
+// CurrentEnv returns the Env that is used to look up environment variables. Unless set with SetEnv, the prefix and profile
+// are read from the LOGTHING_PREFIX and LOGTHING_PROFILE environment variables.
+func CurrentEnv() Env {
+	envMutex.RLock()
+	e := currentEnv
+	envMutex.RUnlock()
+	if e != nil {
+		return *e
+	}
+	return Env{Prefix: os.Getenv("LOGTHING_PREFIX"), Profile: os.Getenv("LOGTHING_PROFILE")}
+}
+
+// SetEnv sets the Env that is used to look up environment variables. It must be set before writers are created and
+// the dispatcher is initialized.
+func SetEnv(e Env) {
+	envMutex.Lock()
+	defer envMutex.Unlock()
+	currentEnv = &e
+}
+
+// WithEnv creates a writer with newWriter while environment variables are looked up with the given Env, e.g. to
+// configure writers of different libraries with different profiles. The Env only applies to the created writer, not to
+// the configuration of the logthing package:
+//
+//	writer := logwriter.WithEnv(logwriter.Env{Profile: "audit"}, logwriter.NewAzureMonitorWriter)
+func WithEnv(e Env, newWriter func() LogWriter) LogWriter {
+	constructMutex.Lock()
+	defer constructMutex.Unlock()
+	envMutex.Lock()
+	previous := currentEnv
+	currentEnv = &e
+	envMutex.Unlock()
+	defer func() {
+		envMutex.Lock()
+		currentEnv = previous
+		envMutex.Unlock()
+	}()
+	return newWriter()
+}
+
+// Getenv returns the value of the LOGTHING_* environment variable looked up with the current Env (see CurrentEnv)
+func Getenv(name string) string {
+	return CurrentEnv().Getenv(name)
+}
+
+// LookupEnv looks up the LOGTHING_* environment variable with the current Env (see CurrentEnv)
+func LookupEnv(name string) (string, bool) {
+	return CurrentEnv().Lookup(name)
+}
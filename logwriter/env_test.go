@@ -0,0 +1,29 @@
+package logwriter
+
+import "testing"
+
+func TestEnvPrefixAndProfile(t *testing.T) {
+	t.Setenv("MYAPP_LOGTHING_GELF_ADDRESS", "prefixed:12201")
+	t.Setenv("MYAPP_LOGTHING_AUDIT_GELF_ADDRESS", "audit:12201")
+
+	if value := (Env{Prefix: "MYAPP_"}).Getenv("LOGTHING_GELF_ADDRESS"); value != "prefixed:12201" {
+		t.Errorf("unexpected prefixed value: %v", value)
+	}
+	if value := (Env{Prefix: "MYAPP_", Profile: "audit"}).Getenv("LOGTHING_GELF_ADDRESS"); value != "audit:12201" {
+		t.Errorf("unexpected profile value: %v", value)
+	}
+	if value := (Env{Prefix: "MYAPP_", Profile: "other"}).Getenv("LOGTHING_GELF_ADDRESS"); value != "prefixed:12201" {
+		t.Errorf("profile doesn't fall back: %v", value)
+	}
+	if name, ok := (Env{Prefix: "MYAPP_", Profile: "audit"}).Canonical("MYAPP_LOGTHING_AUDIT_GELF_ADDRESS"); !ok || name != "LOGTHING_GELF_ADDRESS" {
+		t.Errorf("unexpected canonical name: %v", name)
+	}
+
+	lw := WithEnv(Env{Prefix: "MYAPP_", Profile: "audit"}, NewGELFWriter)
+	if address := lw.(*gelf).address; address != "audit:12201" {
+		t.Errorf("writer not created with env: %v", address)
+	}
+	if Getenv("LOGTHING_GELF_ADDRESS") == "audit:12201" {
+		t.Errorf("env not restored after WithEnv")
+	}
+}
@@ -3,6 +3,7 @@ package logwriter
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -23,6 +24,24 @@ const (
 	DateTime
 )
 
+var kindNames = [...]string{
+	Unknown:  "unknown",
+	String:   "string",
+	Number:   "number",
+	Integer:  "integer",
+	Boolean:  "boolean",
+	Object:   "object",
+	Array:    "array",
+	DateTime: "datetime",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
 func init() {
 	godotenv.Load()
 }
@@ -30,6 +49,13 @@ func init() {
 // Config provides writer relevant information from dispatcher
 type Config struct {
 	LogName string
+	// Resign signs a message again that has been rewritten by the writer (e.g. stringified or truncated properties),
+	// so that its signature stays valid. It's nil if messages aren't signed (see logthing.WithMessageSigning).
+	Resign func(logMessage json.RawMessage) (json.RawMessage, error)
+	// ReportError reports an error that occurred outside of the writer's method calls, e.g. while writing in the
+	// background (see logthing.Errors). It's nil if the writer isn't initialized by the dispatcher and must not be
+	// called after Close returned.
+	ReportError func(err error)
 }
 
 // LogWriter interface that can be used ny the logDispatcher to write logs.
@@ -54,3 +80,107 @@ type LogWriter interface {
 // ErrWriterDisable is returned when there is an unrecoverable error detected
 // and writing log messages will never succeed. Dispatcher will close and disbale the writer.
 var ErrWriterDisable = errors.New("Writer disbaled")
+
+// disableError is an ErrWriterDisable error with a hint whether re-initializing the writer might help (see DisableError)
+type disableError struct {
+	err    error
+	reinit bool
+}
+
+func (e *disableError) Error() string        { return e.err.Error() }
+func (e *disableError) Unwrap() error        { return e.err }
+func (e *disableError) Is(target error) bool { return target == ErrWriterDisable }
+func (e *disableError) ReinitMayHelp() bool  { return e.reinit }
+
+// DisableError returns an error that disables the writer (see ErrWriterDisable) with a hint whether re-initializing
+// the writer might help (e.g. after rotated credentials), in contrast to permanent misconfigurations
+func DisableError(err error, reinitMayHelp bool) error {
+	return &disableError{err: err, reinit: reinitMayHelp}
+}
+
+// ReinitMayHelp returns true if the error has been created with DisableError and re-initializing might help
+func ReinitMayHelp(err error) bool {
+	var hint interface{ ReinitMayHelp() bool }
+	return errors.As(err, &hint) && hint.ReinitMayHelp()
+}
+
+// ErrWriterThrottled is returned (wrapped) when a writer has been throttled by the service and buffered the batch
+// instead of sending it. Buffered batches are sent when the throttling ended.
+var ErrWriterThrottled = errors.New("Writer throttled")
+
+// Throttled is implemented by writers that pause sending while they are throttled by the service
+type Throttled interface {
+	// ThrottledUntil returns the time until sending is paused (zero if not throttled)
+	ThrottledUntil() time.Time
+}
+
+// environmentVariables lists all environment variables that are used by the writers of this package
+var environmentVariables = []string{
+	"LOGTHING_AZURE_WORKSPACE_ID",
+	"LOGTHING_AZURE_WORKSPACE_KEY",
+	"LOGTHING_AZURE_MONITOR_DOMAIN",
+	"LOGTHING_AZURE_STRINGIFY_PROPERTIES",
+	"LOGTHING_AZURE_STRINGIFY_UNKNOWN",
+	"LOGTHING_AZURE_REQUEST_TIMEOUT",
+	"LOGTHING_AZURE_LOG_TYPE_PROPERTY",
+	"LOGTHING_AZURE_THROTTLE_MAX_BUFFER",
+	"LOGTHING_AZURE_MAX_FIELDS",
+	"LOGTHING_DATA_EXPLORER_CLUSTER_URL",
+	"LOGTHING_DATA_EXPLORER_APP_ID",
+	"LOGTHING_DATA_EXPLORER_APP_KEY",
+	"LOGTHING_DATA_EXPLORER_AUTHORITY_ID",
+	"LOGTHING_DATA_EXPLORER_RETRY_MAX_MEMORY",
+	"LOGTHING_DATA_EXPLORER_RETRY_DIR",
+	"LOGTHING_DATA_EXPLORER_INGEST_BY_TAGS",
+	"LOGTHING_DATA_EXPLORER_DROP_BY_TAGS",
+	"LOGTHING_DATA_EXPLORER_CLOCK_SKEW_CORRECTION",
+	"LOGTHING_ELASTICSEARCH_URL",
+	"LOGTHING_ELASTICSEARCH_USER",
+	"LOGTHING_ELASTICSEARCH_PWD",
+	"LOGTHING_ELASTICSEARCH_DATA_STREAM",
+	"LOGTHING_ELASTICSEARCH_ILM_POLICY",
+	"LOGTHING_ELASTICSEARCH_RETENTION",
+	"LOGTHING_ELASTICSEARCH_MAX_RETRIES",
+	"LOGTHING_ELASTICSEARCH_DEAD_LETTER_INDEX",
+	"LOGTHING_FAILOVER_FAILBACK_INTERVAL",
+	"LOGTHING_FLUENT_ADDRESS",
+	"LOGTHING_FLUENT_TAG",
+	"LOGTHING_FLUENT_TLS",
+	"LOGTHING_FLUENT_SHARED_KEY",
+	"LOGTHING_FLUENT_USERNAME",
+	"LOGTHING_FLUENT_PASSWORD",
+	"LOGTHING_GELF_ADDRESS",
+	"LOGTHING_GELF_PROTOCOL",
+	"LOGTHING_GELF_TLS",
+	"LOGTHING_INGEST_SOCKET",
+	"LOGTHING_INGEST_TIMEOUT",
+	"LOGTHING_OPENSEARCH_URL",
+	"LOGTHING_OPENSEARCH_REGION",
+	"LOGTHING_OPENSEARCH_SERVICE",
+	"LOGTHING_OPENSEARCH_USER",
+	"LOGTHING_OPENSEARCH_PWD",
+	"LOGTHING_OPENSEARCH_DATA_STREAM",
+	"LOGTHING_OPENSEARCH_MAX_RETRIES",
+	"LOGTHING_OPENSEARCH_DEAD_LETTER_INDEX",
+	"LOGTHING_OUTBOX_TABLE",
+	"LOGTHING_OUTBOX_PLACEHOLDER",
+	"LOGTHING_OUTBOX_POLL_INTERVAL",
+	"LOGTHING_OUTBOX_BATCH_SIZE",
+	"LOGTHING_PLUGIN_COMMAND",
+	"LOGTHING_PLUGIN_TIMEOUT",
+	"LOGTHING_PULSAR_WEB_SERVICE_URL",
+	"LOGTHING_PULSAR_TOPIC",
+	"LOGTHING_PULSAR_TOKEN",
+	"LOGTHING_PULSAR_VALUE_SCHEMA",
+	"LOGTHING_REPLICATION_MAX_PENDING_BATCHES",
+	"LOGTHING_SERVICEBUS_CONNECTION_STRING",
+	"LOGTHING_SERVICEBUS_NAMESPACE",
+	"LOGTHING_SERVICEBUS_ENTITY",
+	"LOGTHING_SERVICEBUS_TIER",
+	"LOGTHING_SERVICEBUS_MAX_MESSAGE_SIZE",
+}
+
+// EnvironmentVariables returns the names of all environment variables that are used by the writers of this package
+func EnvironmentVariables() []string {
+	return append([]string{}, environmentVariables...)
+}
@@ -0,0 +1,346 @@
+package logwriter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// msgpackEncoder writes a minimal subset of MessagePack (see https://github.com/msgpack/msgpack/blob/master/spec.md)
+// that is sufficient to transport log messages that have been premarshalled to JSON
+type msgpackEncoder struct {
+	w   io.Writer
+	buf [9]byte
+	err error
+}
+
+func newMsgpackEncoder(w io.Writer) *msgpackEncoder {
+	return &msgpackEncoder{w: w}
+}
+
+func (e *msgpackEncoder) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
+func (e *msgpackEncoder) writeHeader(code byte, n uint64, size int) {
+	e.buf[0] = code
+	switch size {
+	case 1:
+		e.buf[1] = byte(n)
+	case 2:
+		binary.BigEndian.PutUint16(e.buf[1:], uint16(n))
+	case 4:
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(n))
+	case 8:
+		binary.BigEndian.PutUint64(e.buf[1:], n)
+	}
+	e.write(e.buf[:1+size])
+}
+
+func (e *msgpackEncoder) encodeNil() {
+	e.write([]byte{0xc0})
+}
+
+func (e *msgpackEncoder) encodeBool(b bool) {
+	if b {
+		e.write([]byte{0xc3})
+	} else {
+		e.write([]byte{0xc2})
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.encodeUint(uint64(i))
+	case i >= -32:
+		e.write([]byte{byte(i)})
+	case i >= math.MinInt8:
+		e.writeHeader(0xd0, uint64(i), 1)
+	case i >= math.MinInt16:
+		e.writeHeader(0xd1, uint64(i), 2)
+	case i >= math.MinInt32:
+		e.writeHeader(0xd2, uint64(i), 4)
+	default:
+		e.writeHeader(0xd3, uint64(i), 8)
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.write([]byte{byte(u)})
+	case u <= math.MaxUint8:
+		e.writeHeader(0xcc, u, 1)
+	case u <= math.MaxUint16:
+		e.writeHeader(0xcd, u, 2)
+	case u <= math.MaxUint32:
+		e.writeHeader(0xce, u, 4)
+	default:
+		e.writeHeader(0xcf, u, 8)
+	}
+}
+
+func (e *msgpackEncoder) encodeFloat(f float64) {
+	e.writeHeader(0xcb, math.Float64bits(f), 8)
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	n := uint64(len(s))
+	switch {
+	case n <= 31:
+		e.write([]byte{0xa0 | byte(n)})
+	case n <= math.MaxUint8:
+		e.writeHeader(0xd9, n, 1)
+	case n <= math.MaxUint16:
+		e.writeHeader(0xda, n, 2)
+	default:
+		e.writeHeader(0xdb, n, 4)
+	}
+	e.write([]byte(s))
+}
+
+func (e *msgpackEncoder) encodeBinary(b []byte) {
+	n := uint64(len(b))
+	switch {
+	case n <= math.MaxUint8:
+		e.writeHeader(0xc4, n, 1)
+	case n <= math.MaxUint16:
+		e.writeHeader(0xc5, n, 2)
+	default:
+		e.writeHeader(0xc6, n, 4)
+	}
+	e.write(b)
+}
+
+func (e *msgpackEncoder) encodeArrayHeader(n int) {
+	switch {
+	case n <= 15:
+		e.write([]byte{0x90 | byte(n)})
+	case n <= math.MaxUint16:
+		e.writeHeader(0xdc, uint64(n), 2)
+	default:
+		e.writeHeader(0xdd, uint64(n), 4)
+	}
+}
+
+func (e *msgpackEncoder) encodeMapHeader(n int) {
+	switch {
+	case n <= 15:
+		e.write([]byte{0x80 | byte(n)})
+	case n <= math.MaxUint16:
+		e.writeHeader(0xde, uint64(n), 2)
+	default:
+		e.writeHeader(0xdf, uint64(n), 4)
+	}
+}
+
+// encodeEventTime encodes the fluentd EventTime extension type (ext type 0 with seconds and nanoseconds)
+func (e *msgpackEncoder) encodeEventTime(t time.Time) {
+	e.write([]byte{0xd7, 0x00})
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	e.write(b[:])
+}
+
+// encode encodes values as they result from json.Unmarshal (with UseNumber) and a few additional basic types
+func (e *msgpackEncoder) encode(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.encodeNil()
+	case bool:
+		e.encodeBool(v)
+	case int:
+		e.encodeInt(int64(v))
+	case int64:
+		e.encodeInt(v)
+	case uint64:
+		e.encodeUint(v)
+	case float64:
+		e.encodeFloat(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			e.encodeInt(i)
+		} else if f, err := v.Float64(); err == nil {
+			e.encodeFloat(f)
+		} else {
+			e.encodeString(v.String())
+		}
+	case string:
+		e.encodeString(v)
+	case []byte:
+		e.encodeBinary(v)
+	case []interface{}:
+		e.encodeArrayHeader(len(v))
+		for _, item := range v {
+			e.encode(item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.encodeMapHeader(len(v))
+		for _, k := range keys {
+			e.encodeString(k)
+			e.encode(v[k])
+		}
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("msgpack: unsupported type %T", v)
+		}
+	}
+}
+
+// msgpackDecoder reads the subset of MessagePack that is written by msgpackEncoder.
+// Strings and binaries are both decoded to string, arrays to []interface{}, maps to map[string]interface{}
+// and fluentd EventTime to time.Time.
+type msgpackDecoder struct {
+	r *bufio.Reader
+}
+
+func newMsgpackDecoder(r io.Reader) *msgpackDecoder {
+	return &msgpackDecoder{r: bufio.NewReader(r)}
+}
+
+func (d *msgpackDecoder) readN(n uint64) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.readN(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *msgpackDecoder) decodeArray(n uint64) (interface{}, error) {
+	arr := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n uint64) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	code, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.decodeMap(uint64(code & 0x0f))
+	case code&0xf0 == 0x90:
+		return d.decodeArray(uint64(code & 0x0f))
+	case code&0xe0 == 0xa0:
+		b, err := d.readN(uint64(code & 0x1f))
+		return string(b), err
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		size := map[byte]int{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}[code]
+		n, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readN(n)
+		return string(b), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (code - 0xcc))
+		return int64(u), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		u, err := d.readUint(1 << (code - 0xd0))
+		if err != nil {
+			return nil, err
+		}
+		switch code {
+		case 0xd0:
+			return int64(int8(u)), nil
+		case 0xd1:
+			return int64(int16(u)), nil
+		case 0xd2:
+			return int64(int32(u)), nil
+		}
+		return int64(u), nil
+	case 0xca:
+		u, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.readUint(8)
+		return math.Float64frombits(u), err
+	case 0xd7:
+		b, err := d.readN(9)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != 0x00 {
+			return nil, fmt.Errorf("msgpack: unsupported ext type %v", b[0])
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(b[1:5])), int64(binary.BigEndian.Uint32(b[5:]))), nil
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%02x", code)
+}
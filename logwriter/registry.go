@@ -0,0 +1,91 @@
+package logwriter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates a writer from the given config (e.g. declared in a config file)
+type Factory func(cfg map[string]string) (LogWriter, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Factory{}
+)
+
+func init() {
+	Register("azuremonitor", EnvFactory(NewAzureMonitorWriter))
+	Register("dataexplorer", EnvFactory(func() LogWriter { return NewAzureDataExplorerWriter() }))
+	Register("elasticsearch", EnvFactory(NewElasticsearchWriter))
+	Register("opensearch", EnvFactory(NewOpenSearchWriter))
+	Register("fluentforward", EnvFactory(NewFluentForwardWriter))
+	Register("gelf", EnvFactory(NewGELFWriter))
+	Register("ingest", EnvFactory(NewIngestWriter))
+	Register("plugin", EnvFactory(NewPluginWriter))
+	Register("pulsar", EnvFactory(NewPulsarWriter))
+	Register("servicebus", EnvFactory(NewServiceBusWriter))
+}
+
+// Register makes a writer factory available by the provided (case-insensitive) name, e.g. for the declarative config
+// loader. Writer packages can register themselves in their init function. Register panics if the factory is nil or
+// a factory with the same name is already registered.
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	name = strings.ToLower(name)
+	if factory == nil {
+		panic("logwriter: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("logwriter: Register called twice for writer " + name)
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the factory that is registered with given (case-insensitive) name
+func Lookup(name string) (Factory, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	factory, ok := registry[strings.ToLower(name)]
+	return factory, ok
+}
+
+// Registered returns the sorted names of all registered writers
+func Registered() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a writer with the factory that is registered with given name
+func New(name string, cfg map[string]string) (LogWriter, error) {
+	factory, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown writer type %q", name)
+	}
+	return factory(cfg)
+}
+
+// EnvFactory returns a Factory for writers that are configured by environment variables. The config contains environment
+// variables (with or without "LOGTHING_" prefix), which take precedence over the environment (see Env).
+func EnvFactory(newWriter func() LogWriter) Factory {
+	return func(cfg map[string]string) (LogWriter, error) {
+		env := CurrentEnv()
+		env.Values = map[string]string{}
+		for key, value := range cfg {
+			key = strings.ToUpper(key)
+			if !strings.HasPrefix(key, "LOGTHING_") {
+				key = "LOGTHING_" + key
+			}
+			env.Values[key] = value
+		}
+		return WithEnv(env, newWriter), nil
+	}
+}
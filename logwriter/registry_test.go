@@ -0,0 +1,31 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	Register("Custom", func(cfg map[string]string) (LogWriter, error) {
+		return &testWriter{messages: []json.RawMessage{json.RawMessage(cfg["message"])}}, nil
+	})
+	lw, err := New("custom", map[string]string{"message": "{}"})
+	if err != nil || string(lw.(*testWriter).messages[0]) != "{}" {
+		t.Fatalf("unexpected writer: %v, %v", lw, err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic for duplicate registration")
+			}
+		}()
+		Register("custom", EnvFactory(NewGELFWriter))
+	}()
+	if _, err := New("unknown", nil); err == nil {
+		t.Errorf("expected error for unknown writer")
+	}
+	lw, _ = New("gelf", map[string]string{"gelf_address": "graylog:12201"})
+	if address := lw.(*gelf).address; address != "graylog:12201" {
+		t.Errorf("writer not configured from config: %v", address)
+	}
+}
@@ -0,0 +1,209 @@
+package logwriter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsContainerCredentialsHost is the host of the container credentials endpoint for relative URIs (ECS task roles)
+const awsContainerCredentialsHost = "http://169.254.170.2"
+
+// awsCredentials are the credentials used to sign requests with AWS Signature Version 4
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// awsCredentialsFromEnv returns the AWS credentials from the standard AWS environment variables
+func awsCredentialsFromEnv() awsCredentials {
+	return awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+func (c awsCredentials) valid() bool {
+	return c.accessKeyID != "" && c.secretAccessKey != ""
+}
+
+// awsCredentialsJSON is the format of the container credentials endpoint, which is also used to pass the credentials as
+// Token value of a RefreshingToken
+type awsCredentialsJSON struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// encode returns the credentials as Token value
+func (c awsCredentials) encode() string {
+	data, _ := json.Marshal(awsCredentialsJSON{AccessKeyID: c.accessKeyID, SecretAccessKey: c.secretAccessKey, Token: c.sessionToken})
+	return string(data)
+}
+
+// decodeAWSCredentials returns the credentials of a Token value
+func decodeAWSCredentials(value string) (awsCredentials, error) {
+	var decoded awsCredentialsJSON
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid AWS credentials: %w", err)
+	}
+	return awsCredentials{accessKeyID: decoded.AccessKeyID, secretAccessKey: decoded.SecretAccessKey, sessionToken: decoded.Token}, nil
+}
+
+// awsCredentialSource returns the TokenSource of the AWS credentials (see decodeAWSCredentials) or nil if none are
+// configured. Like the AWS SDKs, credentials of the environment variables take precedence over the container
+// credentials endpoint (AWS_CONTAINER_CREDENTIALS_FULL_URI or AWS_CONTAINER_CREDENTIALS_RELATIVE_URI, e.g. ECS task
+// roles or EKS Pod Identity), whose temporary credentials expire and are therefore refreshed.
+func awsCredentialSource(httpClient *http.Client) TokenSource {
+	if credentials := awsCredentialsFromEnv(); credentials.valid() {
+		return func(ctx context.Context) (Token, error) {
+			return Token{Value: credentials.encode()}, nil
+		}
+	}
+	uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relativeURI := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri == "" && relativeURI != "" {
+		uri = awsContainerCredentialsHost + relativeURI
+	}
+	if uri == "" {
+		return nil
+	}
+	return func(ctx context.Context) (Token, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return Token{}, err
+		}
+		authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return Token{}, fmt.Errorf("reading container authorization token failed: %w", err)
+			}
+			authorization = strings.TrimSpace(string(data))
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return Token{}, fmt.Errorf("requesting AWS container credentials failed: %w", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return Token{}, fmt.Errorf("requesting AWS container credentials failed (Code: %v): %s", resp.StatusCode, body)
+		}
+		var decoded awsCredentialsJSON
+		if err := json.Unmarshal(body, &decoded); err != nil || decoded.AccessKeyID == "" || decoded.SecretAccessKey == "" {
+			return Token{}, fmt.Errorf("invalid AWS container credentials: %s", body)
+		}
+		return Token{Value: string(body), ExpiresOn: decoded.Expiration}, nil
+	}
+}
+
+// sigV4Signer signs requests with AWS Signature Version 4
+// see also: https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+type sigV4Signer struct {
+	credentials *RefreshingToken // AWS credentials (see awsCredentialSource)
+	region      string
+	service     string
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign signs the request with the current (refreshed) credentials
+func (s *sigV4Signer) sign(req *http.Request, body []byte) error {
+	value, err := s.credentials.Token(req.Context())
+	if err != nil {
+		return fmt.Errorf("getting AWS credentials failed: %w", err)
+	}
+	credentials, err := decodeAWSCredentials(value)
+	if err != nil {
+		return err
+	}
+	s.signWith(req, body, credentials, time.Now())
+	return nil
+}
+
+// signWith adds the X-Amz-Date, X-Amz-Security-Token and Authorization headers for the request with given body
+func (s *sigV4Signer) signWith(req *http.Request, body []byte, credentials awsCredentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+	if s.service == "aoss" {
+		// OpenSearch Serverless requires the payload hash header
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		headers["host"] = req.Host
+	}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if key == "authorization" || key == "user-agent" {
+			continue
+		}
+		headers[key] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	signedHeaders := make([]string, 0, len(headers))
+	for key := range headers {
+		signedHeaders = append(signedHeaders, key)
+	}
+	sort.Strings(signedHeaders)
+	var canonicalHeaders strings.Builder
+	for _, key := range signedHeaders {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		query,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+credentials.accessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+}
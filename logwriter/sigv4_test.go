@@ -0,0 +1,58 @@
+package logwriter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4Vanilla(t *testing.T) {
+	// "get-vanilla" example of the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signer := &sigV4Signer{region: "us-east-1", service: "service"}
+	credentials := awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signer.signWith(req, nil, credentials, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("unexpected authorization header: %v", auth)
+	}
+}
+
+func TestOpenSearchContainerCredentials(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests++
+		// credentials expire within the refresh margin and are therefore refreshed with every request
+		fmt.Fprintf(w, `{"AccessKeyId":"AKID%v","SecretAccessKey":"key","Token":"session","Expiration":%q}`, requests,
+			time.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL)
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "secret")
+	t.Setenv("LOGTHING_OPENSEARCH_URL", "https://search.example.com")
+	t.Setenv("LOGTHING_OPENSEARCH_REGION", "eu-central-1")
+	writer := NewOpenSearchWriter().(*openSearch)
+	if len(writer.Credentials()) != 1 {
+		t.Fatalf("expected AWS credentials to be registered for refresh")
+	}
+	writer.signer = &sigV4Signer{credentials: writer.credentials, region: writer.region, service: writer.service}
+	for i := 1; i <= 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "https://search.example.com/", nil)
+		if err := writer.signer.sign(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if auth := req.Header.Get("Authorization"); !strings.Contains(auth, fmt.Sprintf("Credential=AKID%v/", i)) ||
+			req.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("expected request signed with refreshed credentials %v, got %v", i, auth)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StringifyPolicy defines which properties are forced to strings before log messages are written. This is needed for
+// backends like Azure Monitor, which create typed columns on first ingest and drop values of mismatching type afterwards.
+type StringifyPolicy struct {
+	Properties []string // properties that are always stringified
+	Unknown    bool     // stringify all properties without primitive kind (e.g. objects and arrays)
+}
+
+// stringifier stringifies the properties of premarshalled log messages according to its policy
+type stringifier struct {
+	policy     StringifyPolicy
+	resign     func(logMessage json.RawMessage) (json.RawMessage, error) // see Config.Resign
+	mutex      sync.RWMutex
+	properties map[string]struct{}
+}
+
+func newStringifier(policy StringifyPolicy) *stringifier {
+	s := &stringifier{policy: policy}
+	s.schemaChanged(nil)
+	return s
+}
+
+// schemaChanged updates the set of stringified properties
+func (s *stringifier) schemaChanged(schema map[string]Kind) {
+	properties := map[string]struct{}{}
+	for _, property := range s.policy.Properties {
+		if property != "" {
+			properties[property] = struct{}{}
+		}
+	}
+	if s.policy.Unknown {
+		for property, kind := range schema {
+			if kind == Unknown || kind == Object || kind == Array {
+				properties[property] = struct{}{}
+			}
+		}
+	}
+	s.mutex.Lock()
+	s.properties = properties
+	s.mutex.Unlock()
+}
+
+// stringify returns the log messages with stringified properties. Messages without properties to be stringified are kept as they are.
+func (s *stringifier) stringify(logMessages []json.RawMessage) []json.RawMessage {
+	s.mutex.RLock()
+	stringifiedProperties := s.properties
+	s.mutex.RUnlock()
+	if len(stringifiedProperties) == 0 {
+		return logMessages
+	}
+	stringified := make([]json.RawMessage, len(logMessages))
+	for i, logMessage := range logMessages {
+		stringified[i] = logMessage
+		var properties map[string]json.RawMessage
+		if err := json.Unmarshal(logMessage, &properties); err != nil {
+			continue
+		}
+		changed := false
+		for property := range stringifiedProperties {
+			value, ok := properties[property]
+			if !ok || len(value) == 0 || value[0] == '"' || string(value) == "null" {
+				continue
+			}
+			properties[property], _ = json.Marshal(string(value))
+			changed = true
+		}
+		if changed {
+			raw, err := json.Marshal(properties)
+			if err == nil && s.resign != nil {
+				raw, err = s.resign(raw)
+			}
+			if err == nil {
+				stringified[i] = raw
+			}
+		}
+	}
+	return stringified
+}
+
+// Stringifying log writer
+type stringifying struct {
+	writer      LogWriter
+	stringifier *stringifier
+}
+
+// WithStringifyPolicy returns LogWriter that forces the properties selected by the policy to strings before the
+// LogMessages are written to the given writer, while other writers keep the rich property types.
+func WithStringifyPolicy(lw LogWriter, policy StringifyPolicy) LogWriter {
+	return &stringifying{
+		writer:      lw,
+		stringifier: newStringifier(policy),
+	}
+}
+
+// Name returns the name of the wrapped writer
+func (s *stringifying) Name() string {
+	if named, ok := s.writer.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", s.writer)
+}
+
+func (s *stringifying) Init(config Config) error {
+	s.stringifier.resign = config.Resign
+	return s.writer.Init(config)
+}
+
+func (s *stringifying) Close() {
+	s.writer.Close()
+}
+
+func (s *stringifying) PropertiesSchemaChanged(schema map[string]Kind) error {
+	s.stringifier.schemaChanged(schema)
+	return s.writer.PropertiesSchemaChanged(schema)
+}
+
+func (s *stringifying) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	return s.writer.WriteLogMessages(s.stringifier.stringify(logMessages), timestamps)
+}
+
+// Validate validates the wrapped writer if it implements Validator
+func (s *stringifying) Validate(ctx context.Context) error {
+	if validator, ok := s.writer.(Validator); ok {
+		return validator.Validate(ctx)
+	}
+	return nil
+}
+
+// Credentials returns the tokens of the wrapped writer if it implements CredentialRefresher
+func (s *stringifying) Credentials() []*RefreshingToken {
+	return credentialsOf(s.writer)
+}
+
+// Capabilities returns the capabilities of the wrapped writer
+func (s *stringifying) Capabilities() Capabilities {
+	return CapabilitiesOf(s.writer)
+}
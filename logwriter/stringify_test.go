@@ -0,0 +1,24 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStringifyPolicy(t *testing.T) {
+	tw := &testWriter{}
+	lw := WithStringifyPolicy(tw, StringifyPolicy{Properties: []string{"code"}, Unknown: true})
+	lw.PropertiesSchemaChanged(map[string]Kind{"code": Integer, "tags": Unknown, "count": Integer})
+	msg := json.RawMessage(`{"code":404,"count":1,"name":"foo","tags":{"env":"prod"}}`)
+	if err := lw.WriteLogMessages([]json.RawMessage{msg}, []time.Time{time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	var properties map[string]interface{}
+	if err := json.Unmarshal(tw.messages[0], &properties); err != nil {
+		t.Fatal(err)
+	}
+	if properties["code"] != "404" || properties["tags"] != `{"env":"prod"}` || properties["count"] != 1.0 || properties["name"] != "foo" {
+		t.Errorf("unexpected properties: %v", properties)
+	}
+}
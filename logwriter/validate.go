@@ -0,0 +1,11 @@
+package logwriter
+
+import "context"
+
+// Validator is implemented by writers that are able to perform a dry-run (e.g. check authentication, send a probe request or
+// check table existence) to detect misconfigurations before the first real batch is written.
+type Validator interface {
+	// Validate performs the dry-run and returns an error if the writer won't be able to write log messages. It's called
+	// concurrently with WriteLogMessages and must therefore probe with its own connection or request and honor ctx.
+	Validate(ctx context.Context) error
+}
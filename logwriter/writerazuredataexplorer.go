@@ -3,10 +3,13 @@ package logwriter
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -61,33 +64,219 @@ func alterMergeTable(kc *kusto.Client, kustoDB string, table string, schema map[
 
 // AzureMonitor log writer
 type azureDataExplorer struct {
-	client  *kusto.Client
-	logName string
+	client       *kusto.Client
+	credentials  dataExplorerCredentials
+	logName      string
+	retryQueue   *adeRetryQueue
+	tags         []string
+	ifNotExists  bool        // batches are tagged with their batch tag and skipped if already ingested (see WithIngestByTags)
+	queued       adeIngestor // queued ingestion, which is used instead of streaming ingestion for tagged extents
+	views        []adeMaterializedView
+	policies     []adeUpdatePolicy
+	bootstrapped bool
+	correctClock bool
+	clockSkew    *clockSkewCorrector
 }
 
-func getKustoClient() (client *kusto.Client, err error) {
-	clusterURL := os.Getenv("LOGTHING_DATA_EXPLORER_CLUSTER_URL")
-	if clusterURL == "" {
+// adeMaterializedView is a materialized view that is created for the log table
+type adeMaterializedView struct {
+	name  string
+	query string
+}
+
+// adeUpdatePolicy is an update policy with the log table as source
+type adeUpdatePolicy struct {
+	targetTable string
+	query       string
+}
+
+// DataExplorerOption configures the Azure Data Explorer writer
+type DataExplorerOption func(*azureDataExplorer)
+
+// adeIngestor ingests data into the log table (ingest.Ingestion or ingest.Streaming)
+type adeIngestor interface {
+	FromReader(ctx context.Context, reader io.Reader, options ...ingest.FileOption) (*ingest.Result, error)
+	Close() error
+}
+
+// WithIngestByTags sets "ingest-by:" tags on the ingested extents (e.g. deployment version) and enables that re-ingestion
+// of already ingested batches is skipped: Every batch is additionally tagged with an "ingest-by:" batch tag, that is
+// derived from the batch's data and therefore stays the same when the batch is retried (also after a restart, see
+// LOGTHING_DATA_EXPLORER_RETRY_DIR), and is only ingested if no extent with its batch tag exists. Since streaming
+// ingestion doesn't support extent tags, tagged batches are ingested with queued ingestion.
+func WithIngestByTags(tags ...string) DataExplorerOption {
+	return func(de *azureDataExplorer) {
+		de.addTags("ingest-by:", tags)
+		de.ifNotExists = true
+	}
+}
+
+// WithDropByTags sets "drop-by:" tags on the ingested extents (e.g. deployment version or batch id), which enable
+// targeted removal of data with ".drop extents" (e.g. for GDPR delete requests)
+func WithDropByTags(tags ...string) DataExplorerOption {
+	return func(de *azureDataExplorer) {
+		de.addTags("drop-by:", tags)
+	}
+}
+
+// WithClockSkewCorrection enables that the timestamps of throttled or spilled batches are corrected by the measured
+// offset of the local clock to the cluster's clock when they are replayed, so that ordered queries aren't broken by
+// devices with wrong clocks. Corrected messages keep their original timestamp as "deviceTimestamp".
+func WithClockSkewCorrection() DataExplorerOption {
+	return func(de *azureDataExplorer) {
+		de.correctClock = true
+	}
+}
+
+// WithMaterializedView creates a materialized view with given name when the writer provisions the log table. The
+// query is the KQL body of the view, in which "{table}" is replaced by the name of the log table, e.g.:
+//
+//	WithMaterializedView("SeverityPerHour", "{table} | summarize count() by severity, bin(timestamp, 1h)")
+func WithMaterializedView(name string, query string) DataExplorerOption {
+	return func(de *azureDataExplorer) {
+		de.views = append(de.views, adeMaterializedView{name: name, query: query})
+	}
+}
+
+// WithUpdatePolicy merges an update policy with the log table as source into the update policies of the (existing)
+// target table when the writer provisions the log table, so that other update policies of the target table are kept.
+// The query is the KQL body of the policy, in which "{table}" is replaced by the name of the
+// log table.
+func WithUpdatePolicy(targetTable string, query string) DataExplorerOption {
+	return func(de *azureDataExplorer) {
+		de.policies = append(de.policies, adeUpdatePolicy{targetTable: targetTable, query: query})
+	}
+}
+
+// bootstrap creates the materialized views and update policies of the log table
+func (de *azureDataExplorer) bootstrap() error {
+	commands, err := de.bootstrapCommands()
+	if err != nil {
+		return err
+	}
+	for _, command := range commands {
+		if _, err := de.client.Mgmt(context.Background(), "logs", kql.New("").AddUnsafe(command.text)); err != nil {
+			return fmt.Errorf("%s failed: %w", command.description, err)
+		}
+	}
+	return nil
+}
+
+// adeCommand is a management command with a description for error messages
+type adeCommand struct {
+	description string
+	text        string
+}
+
+// bootstrapCommands returns the management commands that create the materialized views and merge the update policies
+// into the existing update policies of the target tables
+func (de *azureDataExplorer) bootstrapCommands() ([]adeCommand, error) {
+	var commands []adeCommand
+	for _, view := range de.views {
+		body := strings.ReplaceAll(view.query, "{table}", de.logName)
+		commands = append(commands, adeCommand{
+			description: fmt.Sprintf("creating materialized view %q", view.name),
+			text:        ".create ifnotexists materialized-view " + kqlName(view.name) + " on table " + kqlName(de.logName) + " { " + body + " }",
+		})
+	}
+	for _, policy := range de.policies {
+		policyJSON, err := json.Marshal([]map[string]interface{}{{
+			"IsEnabled":       true,
+			"Source":          de.logName,
+			"Query":           strings.ReplaceAll(policy.query, "{table}", de.logName),
+			"IsTransactional": false,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, adeCommand{
+			description: fmt.Sprintf("setting update policy of table %q", policy.targetTable),
+			text:        ".alter-merge table " + kqlName(policy.targetTable) + " policy update @'" + strings.ReplaceAll(string(policyJSON), "'", "''") + "'",
+		})
+	}
+	return commands, nil
+}
+
+// kqlName returns the quoted name of a KQL entity (e.g. table)
+func kqlName(name string) string {
+	return "['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name) + "']"
+}
+
+// batchTag returns the tag of the batch, which is derived from its data to stay the same when the batch is retried
+func batchTag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "batch-" + hex.EncodeToString(sum[:16])
+}
+
+// ingestTags returns the extent tags of the batch with given batch tag and the tag that has to be absent for the
+// batch to be ingested (empty if the batch is always ingested)
+func (de *azureDataExplorer) ingestTags(batchTag string) (tags []string, ifNotExists string) {
+	tags = de.tags
+	if de.ifNotExists {
+		tags = append(append([]string{}, de.tags...), "ingest-by:"+batchTag)
+		ifNotExists = batchTag
+	}
+	return tags, ifNotExists
+}
+
+// addTags adds the non-empty tags with given prefix
+func (de *azureDataExplorer) addTags(prefix string, tags []string) {
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			de.tags = append(de.tags, prefix+tag)
+		}
+	}
+}
+
+// dataExplorerCredentials are the cluster URL and AAD application of the Azure Data Explorer writer
+type dataExplorerCredentials struct {
+	clusterURL  string
+	appID       string
+	appKey      string
+	authorityID string
+}
+
+// dataExplorerCredentialsFromEnv reads the credentials from the LOGTHING_DATA_EXPLORER_* environment variables
+func dataExplorerCredentialsFromEnv() dataExplorerCredentials {
+	return dataExplorerCredentials{
+		clusterURL:  Getenv("LOGTHING_DATA_EXPLORER_CLUSTER_URL"),
+		appID:       Getenv("LOGTHING_DATA_EXPLORER_APP_ID"),
+		appKey:      Getenv("LOGTHING_DATA_EXPLORER_APP_KEY"),
+		authorityID: Getenv("LOGTHING_DATA_EXPLORER_AUTHORITY_ID"),
+	}
+}
+
+// NewDataExplorerClient returns a Kusto client for given endpoint (empty: LOGTHING_DATA_EXPLORER_CLUSTER_URL) that is
+// authenticated with the AAD application of the Azure Data Explorer writer, e.g. to run management commands on the
+// log table.
+func NewDataExplorerClient(endpoint string) (client *kusto.Client, err error) {
+	credentials := dataExplorerCredentialsFromEnv()
+	if endpoint != "" {
+		credentials.clusterURL = endpoint
+	}
+	return credentials.newClient()
+}
+
+// newClient returns a Kusto client that is authenticated with the AAD application
+func (c dataExplorerCredentials) newClient() (client *kusto.Client, err error) {
+	if c.clusterURL == "" {
 		err = fmt.Errorf("missing LOGTHING_DATA_EXPLORER_CLUSTER_URL")
 		return
 	}
-	appID := os.Getenv("LOGTHING_DATA_EXPLORER_APP_ID")
-	if appID == "" {
+	if c.appID == "" {
 		err = fmt.Errorf("missing LOGTHING_DATA_EXPLORER_APP_ID")
 		return
 	}
-	appKey := os.Getenv("LOGTHING_DATA_EXPLORER_APP_KEY")
-	if appKey == "" {
+	if c.appKey == "" {
 		err = fmt.Errorf("missing LOGTHING_DATA_EXPLORER_APP_KEY")
 		return
 	}
-	authorityID := os.Getenv("LOGTHING_DATA_EXPLORER_AUTHORITY_ID")
-	if authorityID == "" {
+	if c.authorityID == "" {
 		err = fmt.Errorf("missing LOGTHING_DATA_EXPLORER_AUTHORITY_ID")
 		return
 	}
-	kcs := kusto.NewConnectionStringBuilder(clusterURL)
-	kcs.WithAadAppKey(appID, appKey, authorityID)
+	kcs := kusto.NewConnectionStringBuilder(c.clusterURL)
+	kcs.WithAadAppKey(c.appID, c.appKey, c.authorityID)
 
 	client, err = kusto.New(kcs)
 	if err != nil {
@@ -96,56 +285,172 @@ func getKustoClient() (client *kusto.Client, err error) {
 	return
 }
 
-func NewAzureDataExplorerWriter() LogWriter {
-	return &azureDataExplorer{}
+// NewAzureDataExplorerWriter returns new LogWriter that streams LogMessages into an Azure Data Explorer (Kusto) table.
+// Batches that are throttled by the service (HTTP 429) are kept in a retry queue and re-attempted with the delay hinted
+// by the service. The retry queue keeps batches in memory up to a capped size and spills further batches to disk, if a
+// retry directory is configured.
+// Materialized views and update policies of the log table can be created automatically with WithMaterializedView and
+// WithUpdatePolicy.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_DATA_EXPLORER_CLUSTER_URL       - Azure Data Explorer cluster url
+// LOGTHING_DATA_EXPLORER_APP_ID            - AAD application id
+// LOGTHING_DATA_EXPLORER_APP_KEY           - AAD application key
+// LOGTHING_DATA_EXPLORER_AUTHORITY_ID      - AAD authority id
+// LOGTHING_DATA_EXPLORER_RETRY_MAX_MEMORY  - (optional) max size in bytes of throttled batches kept in memory (default: 8MiB)
+// LOGTHING_DATA_EXPLORER_RETRY_DIR         - (optional) directory where throttled batches are spilled to when the memory is exhausted
+// LOGTHING_DATA_EXPLORER_INGEST_BY_TAGS    - (optional) "ingest-by:" extent tags (comma separated), enables skipping already ingested batches, see also WithIngestByTags
+// LOGTHING_DATA_EXPLORER_DROP_BY_TAGS      - (optional) "drop-by:" extent tags (comma separated), see also WithDropByTags
+// LOGTHING_DATA_EXPLORER_CLOCK_SKEW_CORRECTION - (optional) if true, timestamps of replayed batches are corrected by the measured clock offset, see also WithClockSkewCorrection
+func NewAzureDataExplorerWriter(options ...DataExplorerOption) LogWriter {
+	writer := &azureDataExplorer{
+		credentials: dataExplorerCredentialsFromEnv(),
+		retryQueue:  newADERetryQueue(),
+	}
+	if ingestByTags := Getenv("LOGTHING_DATA_EXPLORER_INGEST_BY_TAGS"); ingestByTags != "" {
+		WithIngestByTags(strings.Split(ingestByTags, ",")...)(writer)
+	}
+	writer.addTags("drop-by:", strings.Split(Getenv("LOGTHING_DATA_EXPLORER_DROP_BY_TAGS"), ","))
+	writer.correctClock, _ = strconv.ParseBool(Getenv("LOGTHING_DATA_EXPLORER_CLOCK_SKEW_CORRECTION"))
+	for _, option := range options {
+		option(writer)
+	}
+	return writer
 }
 
 func (de *azureDataExplorer) Init(config Config) (err error) {
 	de.logName = config.LogName
-	de.client, err = getKustoClient()
+	de.client, err = de.credentials.newClient()
 	if err != nil {
 		return
 	}
-	return
+	if de.correctClock {
+		de.clockSkew = newClockSkewCorrector(de.credentials.clusterURL)
+	}
+	if len(de.tags) > 0 || de.ifNotExists {
+		if de.queued, err = ingest.New(de.client, "logs", de.logName); err != nil {
+			return
+		}
+	}
+	return de.retryQueue.init()
 }
 
-func (de *azureDataExplorer) PropertiesSchemaChanged(schema map[string]Kind) error {
+// Validate checks that the log table exists
+func (de *azureDataExplorer) Validate(ctx context.Context) error {
 	if de.client == nil {
 		return fmt.Errorf("invalid client")
 	}
-	return alterMergeTable(de.client, "logs", de.logName, schema)
+	_, err := de.client.Mgmt(ctx, "logs", kql.New(".show table ").AddTable(de.logName))
+	return err
 }
 
-func (de *azureDataExplorer) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) (err error) {
+func (de *azureDataExplorer) PropertiesSchemaChanged(schema map[string]Kind) error {
 	if de.client == nil {
 		return fmt.Errorf("invalid client")
 	}
-	in, err := ingest.NewStreaming(de.client, "logs", de.logName)
-	if err != nil {
+	if err := alterMergeTable(de.client, "logs", de.logName, schema); err != nil {
 		return err
 	}
-	readers := make([]io.Reader, len(logMessages))
+	if !de.bootstrapped {
+		if err := de.bootstrap(); err != nil {
+			return err
+		}
+		de.bootstrapped = true
+	}
+	return nil
+}
+
+// Capabilities returns the capabilities of the Azure Data Explorer writer
+func (de *azureDataExplorer) Capabilities() Capabilities {
+	return Capabilities{Format: FormatNDJSON, Schema: true, Columnar: true}
+}
+
+func (de *azureDataExplorer) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) (err error) {
+	if de.client == nil {
+		return fmt.Errorf("invalid client")
+	}
+	batch := make([][]byte, len(logMessages))
 	for i, msg := range logMessages {
-		readers[i] = bytes.NewReader(msg)
+		batch[i] = msg
 	}
-	reader := io.MultiReader(readers...)
+	data := bytes.Join(batch, []byte("\n"))
 
-	res, err := in.FromReader(context.Background(), reader, ingest.FileFormat(ingest.MultiJSON))
+	// keep order: new batches are queued as long as throttled batches are pending
+	retryErr := de.retryQueue.drain(de.replay)
+	if !de.retryQueue.empty() {
+		if err = de.retryQueue.push(data); err != nil {
+			return err
+		}
+		return retryErr
+	}
+	err = de.ingest(data, batchTag(data))
+	if delay, throttled := throttleDelay(err); throttled {
+		de.retryQueue.retryAt = time.Now().Add(delay)
+		if pushErr := de.retryQueue.push(data); pushErr != nil {
+			return fmt.Errorf("%v: %w", pushErr, err)
+		}
+		return retryErr
+	}
 	if err != nil {
 		return err
 	}
-	test := res.Wait(context.Background())
-	resErr := <-test
-	if resErr != nil {
-		return resErr
+	return retryErr
+}
+
+// ThrottledUntil returns the time until throttled batches are retried
+func (de *azureDataExplorer) ThrottledUntil() time.Time {
+	return de.retryQueue.retryAt
+}
+
+// replay ingests the NDJSON data of the retry queue with corrected timestamps (see WithClockSkewCorrection). The
+// batch tag is derived from the original data, so that it's the same as in the first attempt.
+func (de *azureDataExplorer) replay(data []byte) error {
+	tag := batchTag(data)
+	if de.clockSkew != nil {
+		data = de.clockSkew.correct(data)
 	}
+	return de.ingest(data, tag)
+}
 
-	return
+// ingest streams the NDJSON data into the log table or queues it for ingestion if extents are tagged (see
+// WithIngestByTags and WithDropByTags)
+func (de *azureDataExplorer) ingest(data []byte, batchTag string) error {
+	var in adeIngestor = de.queued
+	if in == nil {
+		streaming, err := ingest.NewStreaming(de.client, "logs", de.logName)
+		if err != nil {
+			return err
+		}
+		in = streaming
+	}
+	fileOptions := []ingest.FileOption{ingest.FileFormat(ingest.MultiJSON)}
+	tags, ifNotExists := de.ingestTags(batchTag)
+	if len(tags) > 0 {
+		fileOptions = append(fileOptions, ingest.Tags(tags))
+	}
+	if ifNotExists != "" {
+		fileOptions = append(fileOptions, ingest.IfNotExists(ifNotExists))
+	}
+	res, err := in.FromReader(context.Background(), bytes.NewReader(data), fileOptions...)
+	if err != nil {
+		return err
+	}
+	return <-res.Wait(context.Background())
 }
 
+// Close retries the throttled batches once more. Remaining batches are spilled to disk (if a retry directory is
+// configured) to be retried after the next start.
 func (de *azureDataExplorer) Close() {
 	if de.client == nil {
 		return
 	}
+	de.retryQueue.retryAt = time.Time{}
+	de.retryQueue.drain(de.replay)
+	if err := de.retryQueue.persist(); err != nil {
+		fmt.Fprintf(os.Stderr, "Azure Data Explorer: %v (%v throttled batches lost)\n", err, len(de.retryQueue.memory))
+	}
+	if de.queued != nil {
+		de.queued.Close()
+	}
 	de.client.Close()
 }
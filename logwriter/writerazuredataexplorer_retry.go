@@ -0,0 +1,180 @@
+package logwriter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	kustoerrors "github.com/Azure/azure-kusto-go/kusto/data/errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// adeDefaultThrottleDelay is used if the service doesn't hint the retry delay of a throttled request
+const adeDefaultThrottleDelay = 10 * time.Second
+
+var retryAfterRegexp = regexp.MustCompile(`(?i)retry-after\D{0,3}(\d+)`)
+
+// throttleDelay returns the retry delay if the error signals that the request has been throttled (HTTP 429 of the
+// cluster or of the storage the batches are uploaded to). The delay is taken from the Retry-After header or hint.
+func throttleDelay(err error) (time.Duration, bool) {
+	var httpErr *kustoerrors.HttpError
+	var responseErr *azcore.ResponseError
+	switch {
+	case errors.As(err, &httpErr) && httpErr.IsThrottled():
+	case errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusTooManyRequests:
+		if responseErr.RawResponse != nil {
+			if seconds, convErr := strconv.Atoi(responseErr.RawResponse.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	default:
+		return 0, false
+	}
+	if match := retryAfterRegexp.FindStringSubmatch(err.Error()); match != nil {
+		if seconds, convErr := strconv.Atoi(match[1]); convErr == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return adeDefaultThrottleDelay, true
+}
+
+// adeRetryQueue keeps throttled batches (as NDJSON) in memory up to a capped size and spills further batches to disk.
+// Spilled batches survive restarts and are retried after the next start.
+type adeRetryQueue struct {
+	memory      [][]byte
+	memoryBytes int
+	maxMemory   int
+	dir         string
+	files       []string
+	seq         int
+	retryAt     time.Time
+}
+
+// newADERetryQueue returns new retry queue configured by LOGTHING_DATA_EXPLORER_RETRY_MAX_MEMORY and LOGTHING_DATA_EXPLORER_RETRY_DIR
+func newADERetryQueue() *adeRetryQueue {
+	q := &adeRetryQueue{
+		maxMemory: 8 << 20,
+		dir:       Getenv("LOGTHING_DATA_EXPLORER_RETRY_DIR"),
+	}
+	if n, err := strconv.Atoi(Getenv("LOGTHING_DATA_EXPLORER_RETRY_MAX_MEMORY")); err == nil && n >= 0 {
+		q.maxMemory = n
+	}
+	return q
+}
+
+// init creates the spill directory and loads the batches that have been spilled before
+func (q *adeRetryQueue) init() error {
+	if q.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(q.dir, 0o700); err != nil {
+		return fmt.Errorf("creating retry directory failed: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.ndjson"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	q.files = files
+	return nil
+}
+
+func (q *adeRetryQueue) empty() bool {
+	return len(q.memory) == 0 && len(q.files) == 0
+}
+
+// push appends the batch to the queue. Returns an error if the batch had to be dropped.
+func (q *adeRetryQueue) push(batch []byte) error {
+	if len(q.files) == 0 && q.memoryBytes+len(batch) <= q.maxMemory {
+		q.memory = append(q.memory, batch)
+		q.memoryBytes += len(batch)
+		return nil
+	}
+	if q.dir == "" {
+		return fmt.Errorf("retry queue full, dropped batch of %v bytes", len(batch))
+	}
+	q.seq++
+	file := filepath.Join(q.dir, fmt.Sprintf("%020d-%06d.ndjson", time.Now().UnixNano(), q.seq))
+	if err := os.WriteFile(file, batch, 0o600); err != nil {
+		return fmt.Errorf("spilling batch to disk failed: %w", err)
+	}
+	q.files = append(q.files, file)
+	return nil
+}
+
+// peek returns the oldest batch of the queue
+func (q *adeRetryQueue) peek() ([]byte, error) {
+	if len(q.memory) > 0 {
+		return q.memory[0], nil
+	}
+	if len(q.files) > 0 {
+		return os.ReadFile(q.files[0])
+	}
+	return nil, nil
+}
+
+// pop removes the oldest batch from the queue
+func (q *adeRetryQueue) pop() {
+	if len(q.memory) > 0 {
+		q.memoryBytes -= len(q.memory[0])
+		q.memory = q.memory[1:]
+		return
+	}
+	if len(q.files) > 0 {
+		os.Remove(q.files[0])
+		q.files = q.files[1:]
+	}
+}
+
+// persist spills the batches that are kept in memory to disk, so that they are retried after the next start. As new
+// batches are only kept in memory while nothing is spilled, the memory batches are the oldest ones and named to be sorted first.
+func (q *adeRetryQueue) persist() error {
+	if q.dir == "" || len(q.memory) == 0 {
+		return nil
+	}
+	var files []string
+	for i, batch := range q.memory {
+		file := filepath.Join(q.dir, fmt.Sprintf("%020d-%06d.ndjson", 0, i))
+		if err := os.WriteFile(file, batch, 0o600); err != nil {
+			return fmt.Errorf("spilling batch to disk failed: %w", err)
+		}
+		files = append(files, file)
+	}
+	q.files = append(files, q.files...)
+	q.memory = nil
+	q.memoryBytes = 0
+	return nil
+}
+
+// drain retries the queued batches in order with the given ingest function until the queue is empty or a batch is
+// throttled again. Batches that fail with other errors are dropped.
+func (q *adeRetryQueue) drain(ingest func([]byte) error) error {
+	if time.Now().Before(q.retryAt) {
+		return nil
+	}
+	var errs []error
+	for !q.empty() {
+		batch, err := q.peek()
+		if err == nil {
+			err = ingest(batch)
+		}
+		if delay, throttled := throttleDelay(err); throttled {
+			q.retryAt = time.Now().Add(delay)
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		q.pop()
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("retrying throttled batches failed: %v", errs)
+	}
+	return nil
+}
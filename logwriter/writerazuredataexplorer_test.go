@@ -1,9 +1,137 @@
 package logwriter
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
+
+	kustoerrors "github.com/Azure/azure-kusto-go/kusto/data/errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 )
 
 func TestDataExplorer(t *testing.T) {
 
 }
+
+func TestDataExplorerEnv(t *testing.T) {
+	t.Setenv("LOGTHING_DATA_EXPLORER_CLUSTER_URL", "https://default.kusto.windows.net")
+	t.Setenv("LOGTHING_AUDIT_DATA_EXPLORER_CLUSTER_URL", "https://audit.kusto.windows.net")
+	t.Setenv("LOGTHING_AUDIT_DATA_EXPLORER_APP_ID", "audit-app")
+	lw := WithEnv(Env{Profile: "audit"}, func() LogWriter { return NewAzureDataExplorerWriter() })
+	credentials := lw.(*azureDataExplorer).credentials
+	if credentials.clusterURL != "https://audit.kusto.windows.net" || credentials.appID != "audit-app" {
+		t.Errorf("expected credentials of the profile, got %+v", credentials)
+	}
+	// Init runs outside of WithEnv and must use the credentials that were read when the writer was created
+	if err := lw.Init(Config{LogName: "test"}); err == nil || err.Error() != "missing LOGTHING_DATA_EXPLORER_APP_KEY" {
+		t.Errorf("expected missing app key, got %v", err)
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	throttled := &kustoerrors.HttpError{StatusCode: http.StatusTooManyRequests}
+	if delay, ok := throttleDelay(fmt.Errorf("ingestion failed: %w", throttled)); !ok || delay != adeDefaultThrottleDelay {
+		t.Errorf("unexpected delay %v (%v)", delay, ok)
+	}
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+	storageErr := &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, RawResponse: &http.Response{Header: header}}
+	if delay, ok := throttleDelay(storageErr); !ok || delay != 30*time.Second {
+		t.Errorf("unexpected delay %v (%v)", delay, ok)
+	}
+	for _, err := range []error{
+		nil,
+		errors.New("status 429 Too Many Requests"), // not a typed HTTP error
+		errors.New("request 4290 failed after 429 bytes"),
+		&kustoerrors.HttpError{StatusCode: http.StatusBadRequest},
+		&azcore.ResponseError{StatusCode: http.StatusForbidden},
+	} {
+		if _, ok := throttleDelay(err); ok {
+			t.Errorf("expected error not to be throttling: %v", err)
+		}
+	}
+}
+
+func TestADERetryQueue(t *testing.T) {
+	q := &adeRetryQueue{maxMemory: 4, dir: t.TempDir()}
+	if err := q.init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range []string{"ab", "cd", "ef"} {
+		if err := q.push([]byte(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(q.memory) != 2 || len(q.files) != 1 {
+		t.Errorf("expected last batch to be spilled: %v %v", len(q.memory), len(q.files))
+	}
+	if err := q.persist(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := &adeRetryQueue{dir: q.dir}
+	if err := restarted.init(); err != nil {
+		t.Fatal(err)
+	}
+	var ingested string
+	throttled := false
+	err := restarted.drain(func(batch []byte) error {
+		if string(batch) == "ef" && !throttled {
+			throttled = true
+			return &kustoerrors.HttpError{StatusCode: http.StatusTooManyRequests}
+		}
+		ingested += string(batch)
+		return nil
+	})
+	if err != nil || ingested != "abcd" || restarted.empty() || restarted.retryAt.IsZero() {
+		t.Errorf("unexpected drain result: %v %q", err, ingested)
+	}
+}
+
+func TestDataExplorerTags(t *testing.T) {
+	de := NewAzureDataExplorerWriter(WithIngestByTags("v1.2", ""), WithDropByTags("batch-1")).(*azureDataExplorer)
+	if len(de.tags) != 2 || de.tags[0] != "ingest-by:v1.2" || de.tags[1] != "drop-by:batch-1" {
+		t.Errorf("unexpected tags: %v", de.tags)
+	}
+	data := []byte(`{"output":"a"}`)
+	tag := batchTag(data)
+	if tag != batchTag([]byte(`{"output":"a"}`)) || tag == batchTag([]byte(`{"output":"b"}`)) {
+		t.Errorf("expected batch tag derived from data: %v", tag)
+	}
+	tags, ifNotExists := de.ingestTags(tag)
+	if len(tags) != 3 || tags[2] != "ingest-by:"+tag || ifNotExists != tag || len(de.tags) != 2 {
+		t.Errorf("unexpected ingest tags: %v %v", tags, ifNotExists)
+	}
+	dropOnly := NewAzureDataExplorerWriter(WithDropByTags("batch-1")).(*azureDataExplorer)
+	if tags, ifNotExists := dropOnly.ingestTags(tag); len(tags) != 1 || ifNotExists != "" {
+		t.Errorf("expected batches without ingest-by tags to be always ingested: %v %v", tags, ifNotExists)
+	}
+}
+
+func TestDataExplorerBootstrapCommands(t *testing.T) {
+	de := NewAzureDataExplorerWriter(
+		WithMaterializedView("SeverityPerHour", "{table} | summarize count() by severity, bin(timestamp, 1h)"),
+		WithUpdatePolicy("Errors", "{table} | where severity == 'Error'"),
+	).(*azureDataExplorer)
+	de.logName = "logs"
+	commands, err := de.bootstrapCommands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`.create ifnotexists materialized-view ['SeverityPerHour'] on table ['logs'] { logs | summarize count() by severity, bin(timestamp, 1h) }`,
+		`.alter-merge table ['Errors'] policy update @'[{"IsEnabled":true,"IsTransactional":false,"Query":"logs | where severity == ''Error''","Source":"logs"}]'`,
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("unexpected commands: %v", commands)
+	}
+	for i, command := range commands {
+		if command.text != expected[i] {
+			t.Errorf("unexpected command:\n%s\nexpected:\n%s", command.text, expected[i])
+		}
+	}
+	if name := kqlName(`it's\`); name != `['it\'s\\']` {
+		t.Errorf("unexpected quoted name: %s", name)
+	}
+}
@@ -2,16 +2,23 @@ package logwriter
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
+	"io"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,11 +26,84 @@ import (
 type azureMonitor struct {
 	azWorkspaceID string
 	azKey         string
+	disabled      int32 // set to 1 if signing failed, accessed atomically
 	azLogType     string
+	logTypeProp   string // property that overrides the log type per message
+	maxPostSize   int
+	maxFields     int
+	maxFieldSize  int
+	throttle      azThrottle
 	azDomain      string
 	azURL         string
 	httpClient    *http.Client
-	azHMAC        hash.Hash
+	timeout       time.Duration // per request timeout
+	azHMACPool    sync.Pool     // pool of *azSigner with the workspace key
+	stringifier   *stringifier
+	resign        func(logMessage json.RawMessage) (json.RawMessage, error) // see Config.Resign
+	reportError   func(err error)                                           // reports posts that are dropped on close (see Config.ReportError)
+}
+
+// azSigner computes signatures with reusable HMAC and buffers
+type azSigner struct {
+	mac hash.Hash
+	buf []byte
+	sum []byte
+}
+
+// azMaxPostSize is the maximum size of a single post to the data collector api
+const azMaxPostSize = 30 * 1024 * 1024
+
+// AzureMonitorError is returned when a post to the data collector api failed. It carries the client request id that was
+// sent as "x-ms-client-request-id" header and the response headers (e.g. "x-ms-request-id"), so that support tickets
+// can reference the exact failed ingestion request. Use errors.As to retrieve it from the dispatcher's errors.
+type AzureMonitorError struct {
+	ClientRequestID string
+	StatusCode      int         // 0 if no response has been received
+	Header          http.Header // response headers (nil if no response has been received)
+	Body            string      // response body
+	Err             error       // transport error (nil if a response has been received)
+}
+
+func (e *AzureMonitorError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Sending LogMessage to azure failed (x-ms-client-request-id: %v): %v", e.ClientRequestID, e.Err)
+	}
+	return fmt.Sprintf("Sending LogMessage to azure failed (Code: %v, x-ms-client-request-id: %v, x-ms-request-id: %v): %v",
+		e.StatusCode, e.ClientRequestID, e.Header.Get("x-ms-request-id"), e.Body)
+}
+
+func (e *AzureMonitorError) Unwrap() error {
+	return e.Err
+}
+
+// newClientRequestID returns new random UUID (version 4) to identify a request
+func newClientRequestID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // variant 10
+	s := hex.EncodeToString(u[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+// newAzureMonitorHTTPClient returns http client with timeouts and keep-alive tuning for frequent posts to the same host
+func newAzureMonitorHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          10,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
 }
 
 // NewAzureMonitorWriter returns new LogWriter that writes LogMessages to Azure Monitor (Azure Log Analytics Workspace)
@@ -39,44 +119,89 @@ type azureMonitor struct {
 // LOGTHING_AZURE_WORKSPACE_ID    - Azure log analytics workspace id
 // LOGTHING_AZURE_WORKSPACE_KEY   - Azure log analytics worksoace key
 // LOGTHING_AZURE_MONITOR_DOMAIN 	- (optional) to overwrite the default azure monitor domain e.g. in China
+// LOGTHING_AZURE_STRINGIFY_PROPERTIES - (optional) properties (comma separated) that are always written as strings
+// LOGTHING_AZURE_STRINGIFY_UNKNOWN    - (optional) if true, all properties without primitive type (e.g. objects and arrays) are written as strings
+// LOGTHING_AZURE_REQUEST_TIMEOUT      - (optional) timeout of a single post (e.g. "30s", default: 10s)
+// LOGTHING_AZURE_LOG_TYPE_PROPERTY    - (optional) property whose (string) value overrides the log type per message (e.g. for multi-tenant routing)
+// LOGTHING_AZURE_THROTTLE_MAX_BUFFER  - (optional) max bytes of posts that are buffered while throttled (default: 32MiB)
+// LOGTHING_AZURE_MAX_FIELDS           - (optional) max number of fields per record (default: 50, 0 = unlimited)
+//
+// Records are validated against the data collector limits before they are posted: Field values that exceed 32KB are
+// truncated and fields that exceed the max number of fields are moved as JSON string into the "overflowProperties" field.
+// Log types from LOGTHING_AZURE_LOG_TYPE_PROPERTY with invalid characters are sanitized (only letters, numbers and
+// underscore up to 100 characters are allowed).
+//
+// When Azure responds with 429 or 503, sending is paused for the duration of the Retry-After header and subsequent
+// posts are buffered (up to the max buffer size, oldest posts are dropped first). Buffered posts are sent when the
+// throttling ended or when the writer is closed. While throttled, WriteLogMessages returns an error that wraps
+// ErrWriterThrottled.
+//
+// Messages are grouped by their log type and every group is posted in chunks that don't exceed the 30MB limit of the
+// data collector api.
+//
+// Log Analytics creates typed columns on first ingest and drops values of mismatching type afterwards, which can be
+// prevented by stringifying the according properties (see also StringifyPolicy).
 func NewAzureMonitorWriter() LogWriter {
-	azWorkspaceID := os.Getenv("LOGTHING_AZURE_WORKSPACE_ID")
-	azWorkspaceKey := os.Getenv("LOGTHING_AZURE_WORKSPACE_KEY")
+	azWorkspaceID := Getenv("LOGTHING_AZURE_WORKSPACE_ID")
+	azWorkspaceKey := Getenv("LOGTHING_AZURE_WORKSPACE_KEY")
 	azMonitorDomain := "ods.opinsights.azure.com"
-	if amd := os.Getenv("LOGTHING_AZURE_MONITOR_DOMAIN"); amd != "" {
+	if amd := Getenv("LOGTHING_AZURE_MONITOR_DOMAIN"); amd != "" {
 		azMonitorDomain = amd
 	}
+	stringifyUnknown, _ := strconv.ParseBool(Getenv("LOGTHING_AZURE_STRINGIFY_UNKNOWN"))
 	writer := &azureMonitor{
 		azWorkspaceID: azWorkspaceID,
 		azKey:         azWorkspaceKey,
-		httpClient:    http.DefaultClient,
+		httpClient:    newAzureMonitorHTTPClient(),
+		timeout:       10 * time.Second,
+		logTypeProp:   Getenv("LOGTHING_AZURE_LOG_TYPE_PROPERTY"),
+		maxPostSize:   azMaxPostSize,
+		maxFields:     azDefaultMaxFields,
+		maxFieldSize:  azMaxFieldSize,
+		throttle:      azThrottle{maxBuffer: 32 << 20},
 		azDomain:      azMonitorDomain,
+		stringifier: newStringifier(StringifyPolicy{
+			Properties: strings.Split(Getenv("LOGTHING_AZURE_STRINGIFY_PROPERTIES"), ","),
+			Unknown:    stringifyUnknown,
+		}),
+	}
+	if n, err := strconv.Atoi(Getenv("LOGTHING_AZURE_MAX_FIELDS")); err == nil && n >= 0 {
+		writer.maxFields = n
+	}
+	if n, err := strconv.Atoi(Getenv("LOGTHING_AZURE_THROTTLE_MAX_BUFFER")); err == nil && n >= 0 {
+		writer.throttle.maxBuffer = n
+	}
+	if timeout, err := time.ParseDuration(Getenv("LOGTHING_AZURE_REQUEST_TIMEOUT")); err == nil && timeout > 0 {
+		writer.timeout = timeout
 	}
 	return writer
 }
 
-// azCreateSignatureString creates azure signature string (not thread safe)
+// azCreateSignatureString creates azure signature string. It's thread safe and uses pooled HMACs and buffers.
 func (am *azureMonitor) azCreateSignatureString(contentLength int) (signature string, msDate string, err error) {
-	if am.azHMAC == nil {
-		if keyBytes, decodeErr := base64.StdEncoding.DecodeString(am.azKey); decodeErr == nil {
-			am.azHMAC = hmac.New(sha256.New, keyBytes)
-		} else {
-			// disable azure logging
-			err = fmt.Errorf("AZURE_MONITOR_KEY invalid: %w", decodeErr)
-			return
-		}
+	signer, ok := am.azHMACPool.Get().(*azSigner)
+	if !ok {
+		err = fmt.Errorf("AZURE_MONITOR_KEY invalid")
+		return
 	}
-	dateString := time.Now().UTC().Format(time.RFC1123)
-	msDate = strings.Replace(dateString, "UTC", "GMT", -1)
-	signatureString := "POST\n" + strconv.Itoa(contentLength) + "\napplication/json\n" + "x-ms-date:" + msDate + "\n/api/logs"
-	am.azHMAC.Reset()
-	am.azHMAC.Write([]byte(signatureString))
-	signature = base64.StdEncoding.EncodeToString(am.azHMAC.Sum(nil))
+	defer am.azHMACPool.Put(signer)
+	msDate = time.Now().UTC().Format(http.TimeFormat)
+	signer.buf = append(signer.buf[:0], "POST\n"...)
+	signer.buf = strconv.AppendInt(signer.buf, int64(contentLength), 10)
+	signer.buf = append(signer.buf, "\napplication/json\nx-ms-date:"...)
+	signer.buf = append(signer.buf, msDate...)
+	signer.buf = append(signer.buf, "\n/api/logs"...)
+	signer.mac.Reset()
+	signer.mac.Write(signer.buf)
+	signer.sum = signer.mac.Sum(signer.sum[:0])
+	signature = base64.StdEncoding.EncodeToString(signer.sum)
 	return
 }
 
 func (am *azureMonitor) Init(config Config) error {
 	am.azLogType = config.LogName
+	am.resign = config.Resign
+	am.reportError = config.ReportError
 	if am.azWorkspaceID == "" {
 		return fmt.Errorf("envrionment variable \"LOGTHING_AZURE_WORKSPACE_ID\" must be set")
 	}
@@ -86,62 +211,301 @@ func (am *azureMonitor) Init(config Config) error {
 	if am.azLogType == "" {
 		return fmt.Errorf("environment varibale \"LOGTHING_LOG_NAME\" must be set")
 	}
+	if !validLogType(am.azLogType) {
+		return fmt.Errorf("environment variable \"LOGTHING_LOG_NAME\" invalid: Azure log types can only contain letters, numbers and underscore and may not exceed 100 characters")
+	}
 	if am.azDomain == "" {
 		return fmt.Errorf("envrionment variable \"LOGTHING_AZURE_MONITOR_DOMAIN\" mustn't be empty or not set at all")
 	}
+	keyBytes, err := base64.StdEncoding.DecodeString(am.azKey)
+	if err != nil {
+		return fmt.Errorf("environment variable \"LOGTHING_AZURE_WORKSPACE_KEY\" invalid: %w", err)
+	}
+	am.azHMACPool.New = func() interface{} {
+		return &azSigner{mac: hmac.New(sha256.New, keyBytes)}
+	}
 	am.azURL = "https://" + am.azWorkspaceID + "." + am.azDomain + "/api/logs?api-version=2016-04-01"
 	return nil
 }
 
+// Close sends the buffered posts regardless of the throttling. Posts that can't be sent are reported as dropped (see
+// Config.ReportError).
 func (am *azureMonitor) Close() {
+	am.throttle.release()
+	if !am.throttle.pending(time.Now()) {
+		return
+	}
+	err := am.send(nil)
+	if errors.Is(err, ErrWriterThrottled) {
+		err = nil
+	}
+	if posts := am.throttle.takeBuffered(); len(posts) > 0 {
+		err = fmt.Errorf("%v posts throttled by Azure Monitor dropped on close", len(posts))
+	}
+	if err != nil && am.reportError != nil {
+		am.reportError(err)
+	}
 }
 
 func (am *azureMonitor) PropertiesSchemaChanged(schema map[string]Kind) error {
+	am.stringifier.schemaChanged(schema)
 	return nil
 }
 
 func (am *azureMonitor) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
-	if len(am.azKey) == 0 || len(am.azWorkspaceID) == 0 {
+	if len(am.azKey) == 0 || len(am.azWorkspaceID) == 0 || atomic.LoadInt32(&am.disabled) == 1 {
 		return ErrWriterDisable
 	}
 
-	postData, _ := json.Marshal(logMessages)
+	records := make([]json.RawMessage, len(logMessages))
+	for i, logMessage := range am.stringifier.stringify(logMessages) {
+		records[i] = preflight(logMessage, am.maxFields, am.maxFieldSize)
+		if am.resign != nil && !bytes.Equal(records[i], logMessages[i]) {
+			// signed messages that have been stringified or truncated are signed again
+			if resigned, err := am.resign(records[i]); err == nil {
+				records[i] = resigned
+			}
+		}
+	}
+	var posts []azPost
+	for _, group := range am.groupByLogType(records) {
+		for _, postData := range chunkJSONArray(group.logMessages, am.maxPostSize) {
+			posts = append(posts, azPost{logType: group.logType, data: postData})
+		}
+	}
+	return am.send(posts)
+}
+
+// send sends the buffered posts and the given posts. While throttled, the posts are buffered.
+func (am *azureMonitor) send(posts []azPost) error {
+	if until, throttled := am.throttle.bufferIfThrottled(time.Now(), posts...); throttled {
+		return fmt.Errorf("Azure Monitor throttled until %v: %w", until.Format(time.RFC3339), ErrWriterThrottled)
+	}
+	posts = append(am.throttle.takeBuffered(), posts...)
+	var errs []error
+	for i, p := range posts {
+		err := am.post(context.Background(), p.logType, p.data)
+		var amErr *AzureMonitorError
+		if errors.As(err, &amErr) && (amErr.StatusCode == http.StatusTooManyRequests || amErr.StatusCode == http.StatusServiceUnavailable) {
+			am.throttle.throttle(time.Now().Add(retryAfter(amErr.Header.Get("Retry-After"), time.Now())), posts[i:]...)
+			return fmt.Errorf("%v: %w", err, ErrWriterThrottled)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%v of the posts failed: %v", len(errs), errs)
+	}
+}
+
+// ThrottledUntil returns the time until sending is paused because Azure throttled the writer
+func (am *azureMonitor) ThrottledUntil() time.Time {
+	return am.throttle.throttledUntil()
+}
+
+// OrderInsensitive returns true, since Azure Monitor orders the records by their TimeGenerated field
+func (am *azureMonitor) OrderInsensitive() bool {
+	return true
+}
+
+// Capabilities returns the capabilities of the Azure Monitor writer
+func (am *azureMonitor) Capabilities() Capabilities {
+	return Capabilities{MaxBatchBytes: am.maxPostSize, Format: FormatJSONArray, Schema: true, Columnar: true}
+}
+
+// azPost is a post of a log type
+type azPost struct {
+	logType string
+	data    []byte
+}
+
+// azThrottle buffers posts while the writer is throttled. It's safe for concurrent use.
+type azThrottle struct {
+	mutex     sync.Mutex
+	until     time.Time
+	posts     []azPost
+	size      int
+	maxBuffer int
+}
+
+// throttledUntil returns the time until sending is paused
+func (t *azThrottle) throttledUntil() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.until
+}
+
+// throttle pauses sending until the given time and buffers the posts
+func (t *azThrottle) throttle(until time.Time, posts ...azPost) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.until = until
+	t.buffer(posts...)
+}
+
+// release ends the pause of sending
+func (t *azThrottle) release() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.until = time.Time{}
+}
+
+// bufferIfThrottled buffers the posts and returns true if sending is paused
+func (t *azThrottle) bufferIfThrottled(now time.Time, posts ...azPost) (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !now.Before(t.until) {
+		return t.until, false
+	}
+	t.buffer(posts...)
+	return t.until, true
+}
+
+// pending returns true if posts are buffered and sending isn't paused anymore
+func (t *azThrottle) pending(now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.posts) > 0 && !now.Before(t.until)
+}
+
+// buffer buffers the posts and drops the oldest posts if the max buffer size is exceeded. Must be called with the
+// mutex locked.
+func (t *azThrottle) buffer(posts ...azPost) {
+	for _, p := range posts {
+		t.posts = append(t.posts, p)
+		t.size += len(p.data)
+	}
+	for t.size > t.maxBuffer && len(t.posts) > 0 {
+		t.size -= len(t.posts[0].data)
+		t.posts = t.posts[1:]
+	}
+}
+
+// takeBuffered returns and removes the buffered posts
+func (t *azThrottle) takeBuffered() []azPost {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	posts := t.posts
+	t.posts, t.size = nil, 0
+	return posts
+}
+
+// azDefaultRetryAfter is used if a throttled response has no valid Retry-After header
+const azDefaultRetryAfter = 30 * time.Second
+
+// retryAfter parses the Retry-After header value (seconds or HTTP date)
+func retryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return azDefaultRetryAfter
+}
+
+// logTypeGroup contains the messages of a log type
+type logTypeGroup struct {
+	logType     string
+	logMessages []json.RawMessage
+}
+
+// groupByLogType groups the messages by their log type (see LOGTHING_AZURE_LOG_TYPE_PROPERTY) in order of their first occurrence
+func (am *azureMonitor) groupByLogType(logMessages []json.RawMessage) (groups []*logTypeGroup) {
+	if am.logTypeProp == "" {
+		return []*logTypeGroup{{logType: am.azLogType, logMessages: logMessages}}
+	}
+	byLogType := map[string]*logTypeGroup{}
+	for _, logMessage := range logMessages {
+		logType := am.azLogType
+		var properties map[string]json.RawMessage
+		if json.Unmarshal(logMessage, &properties) == nil {
+			var value string
+			if json.Unmarshal(properties[am.logTypeProp], &value) == nil && value != "" {
+				logType = sanitizeLogType(value)
+			}
+		}
+		group, ok := byLogType[logType]
+		if !ok {
+			group = &logTypeGroup{logType: logType}
+			byLogType[logType] = group
+			groups = append(groups, group)
+		}
+		group.logMessages = append(group.logMessages, logMessage)
+	}
+	return groups
+}
+
+// chunkJSONArray returns JSON arrays of the messages that don't exceed maxSize (if possible, a single message that
+// exceeds maxSize is returned in its own array)
+func chunkJSONArray(logMessages []json.RawMessage, maxSize int) (chunks [][]byte) {
+	chunk := []byte{'['}
+	for _, logMessage := range logMessages {
+		if len(chunk) > 1 && len(chunk)+len(logMessage)+2 > maxSize {
+			chunks = append(chunks, append(chunk, ']'))
+			chunk = []byte{'['}
+		}
+		if len(chunk) > 1 {
+			chunk = append(chunk, ',')
+		}
+		chunk = append(chunk, logMessage...)
+	}
+	if len(chunk) > 1 {
+		chunks = append(chunks, append(chunk, ']'))
+	}
+	return chunks
+}
+
+// Validate posts an empty batch to check the workspace credentials
+func (am *azureMonitor) Validate(ctx context.Context) error {
+	return am.post(ctx, am.azLogType, []byte("[]"))
+}
+
+// post signs and posts the data to the data collector api. The post is cancelled when the context is done or the
+// request timeout elapsed.
+func (am *azureMonitor) post(ctx context.Context, logType string, postData []byte) error {
+	if am.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, am.timeout)
+		defer cancel()
+	}
 	postDataLength := len(postData)
 
 	signature, msDate, err := am.azCreateSignatureString(postDataLength)
 	if err != nil {
-		am.azKey = "" // disable azure logging by resetting azKey
+		atomic.StoreInt32(&am.disabled, 1) // disable azure logging
 		return fmt.Errorf("Creting signature failed: %v: %w", err, ErrWriterDisable)
 	}
 	authorizationString := "SharedKey " + am.azWorkspaceID + ":" + signature
 
-	req, err := http.NewRequest("POST", am.azURL, bytes.NewReader(postData))
+	req, err := http.NewRequestWithContext(ctx, "POST", am.azURL, bytes.NewReader(postData))
 	if err != nil {
 		return fmt.Errorf("Creating POST request failed: %v: %w", err, ErrWriterDisable)
 	}
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Log-Type", am.azLogType)
+	req.Header.Add("Log-Type", logType)
 	req.Header.Add("Authorization", authorizationString)
 	req.Header.Add("x-ms-date", msDate)
 	req.Header.Add("time-generated-field", "timestamp")
 	req.Header.Add("Content-Type", "application/json")
+	clientRequestID := newClientRequestID()
+	req.Header.Add("x-ms-client-request-id", clientRequestID)
+	req.Header.Add("return-client-request-id", "true")
 
 	resp, err := am.httpClient.Do(req)
-	defer func() {
-		if resp != nil {
-			resp.Body.Close()
-		}
-	}()
 	if err != nil {
-		return fmt.Errorf("Sending LogMessage to azure failed: %w", err)
-	}
-	if resp == nil {
-		return fmt.Errorf("Invalid service response")
+		return &AzureMonitorError{ClientRequestID: clientRequestID, Err: err}
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		var body []byte
-		resp.Body.Read(body)
-		return fmt.Errorf("Sending LogMessage to azure failed (Code: %v): %v", resp.StatusCode, body)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &AzureMonitorError{ClientRequestID: clientRequestID, StatusCode: resp.StatusCode, Header: resp.Header, Body: string(body)}
 	}
 	return nil
 }
@@ -0,0 +1,134 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// azDefaultMaxFields is the default max number of fields per record (see LOGTHING_AZURE_MAX_FIELDS)
+	azDefaultMaxFields = 50
+	// azMaxFieldSize is the max size of a field value that is accepted by the data collector api
+	azMaxFieldSize = 32 * 1024
+	// azMaxLogTypeLength is the max length of the Log-Type header
+	azMaxLogTypeLength = 100
+	// azOverflowProperty contains the fields (as JSON string) that exceed the max number of fields
+	azOverflowProperty = "overflowProperties"
+	// azTruncatedMarker is appended to truncated field values
+	azTruncatedMarker = "…[truncated]"
+)
+
+// azPreferredFields are kept when records have too many fields
+var azPreferredFields = []string{"timestamp", "type", "severity", "trackingID", "output", "signature", "signatureKeyID"}
+
+var azLogTypeRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,100}$`)
+var azInvalidLogTypeChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// validLogType returns whether the log type only contains letters, numbers and underscore and doesn't exceed 100 characters
+func validLogType(logType string) bool {
+	return azLogTypeRegexp.MatchString(logType)
+}
+
+// sanitizeLogType replaces invalid characters of the log type with underscores and truncates it to 100 characters
+func sanitizeLogType(logType string) string {
+	logType = azInvalidLogTypeChars.ReplaceAllString(logType, "_")
+	if len(logType) > azMaxLogTypeLength {
+		logType = logType[:azMaxLogTypeLength]
+	}
+	return logType
+}
+
+// truncateString truncates the string to maxSize bytes (at a rune boundary, including the truncation marker)
+func truncateString(s string, maxSize int) string {
+	if len(s) <= maxSize {
+		return s
+	}
+	n := maxSize - len(azTruncatedMarker)
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + azTruncatedMarker
+}
+
+// preflight fixes up the record to comply with the data collector limits, instead of having the whole post rejected:
+// Field values that exceed maxFieldSize are truncated (objects and arrays are stringified before) and fields that exceed
+// maxFields are moved as JSON string into the "overflowProperties" field. Limits of 0 are unlimited.
+func preflight(logMessage json.RawMessage, maxFields int, maxFieldSize int) json.RawMessage {
+	if maxFields <= 0 && (maxFieldSize <= 0 || len(logMessage) <= maxFieldSize) {
+		return logMessage
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(logMessage, &fields); err != nil {
+		return logMessage
+	}
+	changed := false
+	if maxFields > 0 && len(fields) > maxFields {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		kept := make([]string, 0, maxFields)
+		for _, key := range azPreferredFields {
+			if _, ok := fields[key]; ok {
+				kept = append(kept, key)
+			}
+		}
+		for _, key := range keys {
+			if len(kept) >= maxFields-1 {
+				break
+			}
+			if !containsString(kept, key) {
+				kept = append(kept, key)
+			}
+		}
+		overflow := map[string]json.RawMessage{}
+		for _, key := range keys {
+			if !containsString(kept, key) {
+				overflow[key] = fields[key]
+				delete(fields, key)
+			}
+		}
+		overflowJSON, _ := json.Marshal(overflow)
+		fields[azOverflowProperty], _ = json.Marshal(string(overflowJSON))
+		changed = true
+	}
+	if maxFieldSize > 0 {
+		for key, value := range fields {
+			if len(value) <= maxFieldSize {
+				continue
+			}
+			var s string
+			if json.Unmarshal(value, &s) != nil {
+				s = string(value)
+			}
+			if len(s) <= maxFieldSize {
+				continue
+			}
+			fields[key], _ = json.Marshal(truncateString(s, maxFieldSize))
+			changed = true
+		}
+	}
+	if !changed {
+		return logMessage
+	}
+	fixed, err := json.Marshal(fields)
+	if err != nil {
+		return logMessage
+	}
+	return fixed
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
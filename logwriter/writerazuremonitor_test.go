@@ -0,0 +1,320 @@
+package logwriter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAzureMonitorSignatureConcurrent(t *testing.T) {
+	key := []byte("workspace-key")
+	am := &azureMonitor{azWorkspaceID: "id", azKey: base64.StdEncoding.EncodeToString(key), azDomain: "example.com"}
+	if err := am.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(contentLength int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				signature, msDate, err := am.azCreateSignatureString(contentLength)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mac := hmac.New(sha256.New, key)
+				mac.Write([]byte("POST\n" + strconv.Itoa(contentLength) + "\napplication/json\nx-ms-date:" + msDate + "\n/api/logs"))
+				if expected := base64.StdEncoding.EncodeToString(mac.Sum(nil)); signature != expected {
+					t.Errorf("unexpected signature %v, expected %v", signature, expected)
+					return
+				}
+			}
+		}(i * 100)
+	}
+	wg.Wait()
+}
+
+func TestAzureMonitorRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client(), timeout: 50 * time.Millisecond}
+	if err := am.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	am.azURL = server.URL
+	am.stringifier = newStringifier(StringifyPolicy{})
+	start := time.Now()
+	if err := am.WriteLogMessages([]json.RawMessage{json.RawMessage(`{}`)}, []time.Time{start}); err == nil {
+		t.Errorf("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("post wasn't cancelled after timeout: %v", elapsed)
+	}
+}
+
+func TestAzureMonitorError(t *testing.T) {
+	var clientRequestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientRequestID = r.Header.Get("x-ms-client-request-id")
+		w.Header().Set("x-ms-request-id", "request-1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"Error":"InvalidLogType"}`))
+	}))
+	defer server.Close()
+
+	am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client()}
+	if err := am.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	am.azURL = server.URL
+	var amErr *AzureMonitorError
+	if err := am.Validate(context.Background()); !errors.As(err, &amErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clientRequestID == "" || amErr.ClientRequestID != clientRequestID || amErr.Header.Get("x-ms-request-id") != "request-1" ||
+		amErr.StatusCode != http.StatusBadRequest || amErr.Body != `{"Error":"InvalidLogType"}` {
+		t.Errorf("unexpected error details: %+v", amErr)
+	}
+}
+
+func TestAzureMonitorLogTypeGroups(t *testing.T) {
+	var posts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		posts = append(posts, r.Header.Get("Log-Type")+" "+string(body))
+	}))
+	defer server.Close()
+
+	am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client(),
+		logTypeProp: "tenant", maxPostSize: 30, stringifier: newStringifier(StringifyPolicy{})}
+	if err := am.Init(Config{LogName: "logs"}); err != nil {
+		t.Fatal(err)
+	}
+	am.azURL = server.URL
+	logMessages := []json.RawMessage{
+		json.RawMessage(`{"n":1}`),
+		json.RawMessage(`{"n":2,"tenant":"a"}`),
+		json.RawMessage(`{"n":3}`),
+		json.RawMessage(`{"n":4,"tenant":"a"}`),
+	}
+	if err := am.WriteLogMessages(logMessages, make([]time.Time, len(logMessages))); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`logs [{"n":1},{"n":3}]`,
+		`a [{"n":2,"tenant":"a"}]`,
+		`a [{"n":4,"tenant":"a"}]`,
+	}
+	if strings.Join(posts, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected posts:\n%v", strings.Join(posts, "\n"))
+	}
+}
+
+func TestAzureMonitorThrottling(t *testing.T) {
+	var posts []string
+	throttle := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttle {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		posts = append(posts, string(body))
+	}))
+	defer server.Close()
+
+	am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client(),
+		maxPostSize: azMaxPostSize, throttle: azThrottle{maxBuffer: 20}, stringifier: newStringifier(StringifyPolicy{})}
+	if err := am.Init(Config{LogName: "logs"}); err != nil {
+		t.Fatal(err)
+	}
+	am.azURL = server.URL
+	for i := 1; i <= 3; i++ {
+		err := am.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":` + strconv.Itoa(i) + `}`)}, []time.Time{{}})
+		if !errors.Is(err, ErrWriterThrottled) {
+			t.Fatalf("expected throttled error, got: %v", err)
+		}
+	}
+	if until := am.ThrottledUntil(); time.Until(until) < 59*time.Minute {
+		t.Errorf("Retry-After not honored: %v", until)
+	}
+	throttle = false
+	am.throttle.until = time.Time{}
+	if err := am.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":4}`)}, []time.Time{{}}); err != nil {
+		t.Fatal(err)
+	}
+	// buffer is capped at 20 bytes, so that the oldest post got dropped
+	if expected := `[{"n":2}] [{"n":3}] [{"n":4}]`; strings.Join(posts, " ") != expected {
+		t.Errorf("unexpected posts: %v", posts)
+	}
+}
+
+func TestAzureMonitorCloseFlushesThrottled(t *testing.T) {
+	var posts []string
+	throttle := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttle {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		posts = append(posts, string(body))
+	}))
+	defer server.Close()
+
+	var reported []error
+	newWriter := func() *azureMonitor {
+		am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client(),
+			maxPostSize: azMaxPostSize, throttle: azThrottle{maxBuffer: 1 << 20}, stringifier: newStringifier(StringifyPolicy{})}
+		if err := am.Init(Config{LogName: "logs", ReportError: func(err error) { reported = append(reported, err) }}); err != nil {
+			t.Fatal(err)
+		}
+		am.azURL = server.URL
+		for i := 1; i <= 2; i++ {
+			err := am.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":` + strconv.Itoa(i) + `}`)}, []time.Time{{}})
+			if !errors.Is(err, ErrWriterThrottled) {
+				t.Fatalf("expected throttled error, got: %v", err)
+			}
+		}
+		return am
+	}
+
+	// still throttled on close: the buffered posts are reported as dropped
+	newWriter().Close()
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "2 posts throttled by Azure Monitor dropped on close") {
+		t.Errorf("expected dropped posts to be reported, got %v", reported)
+	}
+
+	// throttling ended on the service side: the buffered posts are sent although the Retry-After hasn't elapsed
+	reported = nil
+	am := newWriter()
+	throttle = false
+	am.Close()
+	if expected := `[{"n":1}] [{"n":2}]`; strings.Join(posts, " ") != expected || len(reported) != 0 {
+		t.Errorf("unexpected posts %v or reported errors %v", posts, reported)
+	}
+}
+
+func TestAzureMonitorConcurrentThrottling(t *testing.T) {
+	var requests, received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1)%3 == 0 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		atomic.AddInt64(&received, int64(strings.Count(string(body), `"n"`)))
+	}))
+	defer server.Close()
+
+	am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client(),
+		maxPostSize: azMaxPostSize, throttle: azThrottle{maxBuffer: 1 << 20}, stringifier: newStringifier(StringifyPolicy{})}
+	if err := am.Init(Config{LogName: "logs"}); err != nil {
+		t.Fatal(err)
+	}
+	am.azURL = server.URL
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				err := am.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":1}`)}, []time.Time{{}})
+				if err != nil && !errors.Is(err, ErrWriterThrottled) {
+					t.Error(err)
+				}
+				am.ThrottledUntil()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			am.PropertiesSchemaChanged(map[string]Kind{"n": Number})
+		}
+	}()
+	wg.Wait()
+	for i := 0; i < 10 && am.throttle.pending(time.Now()); i++ {
+		am.send(nil) // sending the buffered posts may be throttled again
+	}
+	if n := atomic.LoadInt64(&received); n != 160 {
+		t.Errorf("expected all 160 records to be posted eventually, got %v", n)
+	}
+}
+
+func TestAzureMonitorPreflight(t *testing.T) {
+	record := json.RawMessage(`{"timestamp":"t","type":"x","a":1,"b":2,"c":3,"long":"` + strings.Repeat("ä", 20) + `"}`)
+	fixed := preflight(record, 4, 20)
+	var fields map[string]interface{}
+	if err := json.Unmarshal(fixed, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 4 || fields["timestamp"] != "t" || fields["type"] != "x" || fields["a"] != 1.0 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	// overflow fields are truncated as well
+	if overflow := fields[azOverflowProperty]; overflow != `{"b":2`+azTruncatedMarker {
+		t.Errorf("unexpected overflow: %v", overflow)
+	}
+	if sanitized := sanitizeLogType("tenant-a.logs"); sanitized != "tenant_a_logs" {
+		t.Errorf("unexpected sanitized log type: %v", sanitized)
+	}
+}
+
+func TestAzureMonitorResign(t *testing.T) {
+	var posts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		posts = append(posts, string(body))
+	}))
+	defer server.Close()
+
+	am := &azureMonitor{azWorkspaceID: "id", azKey: "a2V5", azDomain: "example.com", httpClient: server.Client(),
+		maxPostSize: azMaxPostSize, maxFieldSize: 8, stringifier: newStringifier(StringifyPolicy{Properties: []string{"n"}})}
+	resign := func(logMessage json.RawMessage) (json.RawMessage, error) {
+		return append(logMessage[:len(logMessage)-1:len(logMessage)-1], `,"signature":"new"}`...), nil
+	}
+	if err := am.Init(Config{LogName: "logs", Resign: resign}); err != nil {
+		t.Fatal(err)
+	}
+	am.azURL = server.URL
+	logMessages := []json.RawMessage{
+		json.RawMessage(`{"s":"a"}`),                               // unchanged
+		json.RawMessage(`{"n":1}`),                                 // stringified
+		json.RawMessage(`{"s":"` + strings.Repeat("a", 16) + `"}`), // truncated
+	}
+	if err := am.WriteLogMessages(logMessages, make([]time.Time, len(logMessages))); err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]interface{}
+	if len(posts) != 1 || json.Unmarshal([]byte(posts[0]), &records) != nil || len(records) != 3 {
+		t.Fatalf("unexpected posts: %v", posts)
+	}
+	for i, resigned := range []bool{false, true, true} {
+		if (records[i]["signature"] == "new") != resigned {
+			t.Errorf("expected record %v to be resigned: %v, got %v", i, resigned, records[i])
+		}
+	}
+}
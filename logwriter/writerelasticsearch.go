@@ -1,7 +1,503 @@
 package logwriter
 
-// elasticsearchURL:  os.Getenv("LOGTHING_ELASTICSEARCH_URL"),
-// elasticsearchUser: os.Getenv("LOGTHING_ELASTICSEARCH_USER"),
-// elasticsearchPwd:  os.Getenv("LOGTHING_ELASTICSEARCH_PWD"),
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
-// To be implemented
+// Elasticsearch log writer
+type elasticsearch struct {
+	url        string
+	user       string
+	pwd        string
+	index      string
+	dataStream bool
+	ilmPolicy  string
+	retention  string
+	httpClient *http.Client
+	signer     *sigV4Signer // if set, requests are signed with AWS SigV4 instead of basic authentication
+
+	deadLetterIndex string
+	maxRetries      int
+	retryBackoff    time.Duration
+	retry           esRetry         // documents that are retried after the backoff
+	reportError     func(err error) // reports documents that are dropped on close (see Config.ReportError)
+}
+
+// NewElasticsearchWriter returns new LogWriter that writes LogMessages to Elasticsearch using the bulk API
+// see also: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+//
+// The lower case log name is used as index name. In data stream mode, an index template for the data stream is
+// bootstrapped and LogMessages are written via the data stream API (with their timestamp as "@timestamp"), so that
+// rollover and retention can be handled by an ILM policy instead of unbounded index growth.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_LOG_NAME                 - Log name under which log messages are stored (used as index or data stream name)
+// LOGTHING_ELASTICSEARCH_URL        - Elasticsearch URL (e.g. "https://localhost:9200")
+// LOGTHING_ELASTICSEARCH_USER       - (optional) user for basic authentication
+// LOGTHING_ELASTICSEARCH_PWD        - (optional) password for basic authentication
+// LOGTHING_ELASTICSEARCH_DATA_STREAM - (optional) if true, LogMessages are written to a data stream
+// LOGTHING_ELASTICSEARCH_ILM_POLICY - (optional) name of the ILM policy that is set in the data stream's index template
+// LOGTHING_ELASTICSEARCH_RETENTION  - (optional) if set (e.g. "30d"), the ILM policy is bootstrapped with rollover and deletion after the retention
+// LOGTHING_ELASTICSEARCH_MAX_RETRIES - (optional) how often documents rejected with 429 are retried (default: 3)
+// LOGTHING_ELASTICSEARCH_DEAD_LETTER_INDEX - (optional) index for permanently rejected documents (default: "<index>-deadletter", "-" disables dead-lettering)
+//
+// The bulk response is checked item by item: Documents that are rejected temporarily (429 queue full) are buffered and
+// the writer is throttled with exponential backoff (see ErrWriterThrottled). The buffered documents are retried with
+// the next batch after the backoff or when the writer is closed. Documents that are rejected permanently (e.g.
+// mapping errors) are written as string with the rejection error as "deadLetterError" property to the dead letter
+// index. Unauthorized (401) and forbidden (403) responses disable the writer (see ErrWriterDisable).
+func NewElasticsearchWriter() LogWriter {
+	dataStream, _ := strconv.ParseBool(Getenv("LOGTHING_ELASTICSEARCH_DATA_STREAM"))
+	writer := &elasticsearch{
+		url:        strings.TrimSuffix(Getenv("LOGTHING_ELASTICSEARCH_URL"), "/"),
+		user:       Getenv("LOGTHING_ELASTICSEARCH_USER"),
+		pwd:        Getenv("LOGTHING_ELASTICSEARCH_PWD"),
+		dataStream: dataStream,
+		ilmPolicy:  Getenv("LOGTHING_ELASTICSEARCH_ILM_POLICY"),
+		retention:  Getenv("LOGTHING_ELASTICSEARCH_RETENTION"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+
+		deadLetterIndex: strings.ToLower(Getenv("LOGTHING_ELASTICSEARCH_DEAD_LETTER_INDEX")),
+		maxRetries:      3,
+		retryBackoff:    500 * time.Millisecond,
+	}
+	if n, err := strconv.Atoi(Getenv("LOGTHING_ELASTICSEARCH_MAX_RETRIES")); err == nil && n >= 0 {
+		writer.maxRetries = n
+	}
+	return writer
+}
+
+func (es *elasticsearch) Init(config Config) error {
+	if es.url == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_ELASTICSEARCH_URL\" must be set")
+	}
+	if config.LogName == "" {
+		return fmt.Errorf("environment varibale \"LOGTHING_LOG_NAME\" must be set")
+	}
+	if es.retention != "" && es.ilmPolicy == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_ELASTICSEARCH_ILM_POLICY\" must be set to bootstrap retention")
+	}
+	es.index = strings.ToLower(config.LogName)
+	es.reportError = config.ReportError
+	switch es.deadLetterIndex {
+	case "":
+		es.deadLetterIndex = es.index + "-deadletter"
+	case "-":
+		es.deadLetterIndex = ""
+	}
+	if es.dataStream {
+		return es.bootstrap(context.Background())
+	}
+	return nil
+}
+
+// Close sends the buffered documents regardless of the backoff. Documents that are still rejected are reported as
+// dropped (see Config.ReportError).
+func (es *elasticsearch) Close() {
+	es.retry.release()
+	if !es.retry.pending(time.Now()) {
+		return
+	}
+	err := es.writeDocs(nil)
+	if errors.Is(err, ErrWriterThrottled) {
+		err = nil
+	}
+	if docs := es.retry.takeBuffered(); len(docs) > 0 {
+		err = fmt.Errorf("%v documents rejected with 429 dropped on close", len(docs))
+	}
+	if err != nil && es.reportError != nil {
+		es.reportError(err)
+	}
+}
+
+func (es *elasticsearch) PropertiesSchemaChanged(schema map[string]Kind) error {
+	return nil
+}
+
+// OrderInsensitive returns true, since the documents are indexed with their timestamp
+func (es *elasticsearch) OrderInsensitive() bool {
+	return true
+}
+
+// Capabilities returns the capabilities of the Elasticsearch writer
+func (es *elasticsearch) Capabilities() Capabilities {
+	return Capabilities{Format: FormatNDJSON}
+}
+
+// bootstrap creates the ILM policy (if a retention is set) and the index template of the data stream
+func (es *elasticsearch) bootstrap(ctx context.Context) error {
+	if es.retention != "" {
+		policy := map[string]interface{}{
+			"policy": map[string]interface{}{
+				"phases": map[string]interface{}{
+					"hot": map[string]interface{}{
+						"actions": map[string]interface{}{
+							"rollover": map[string]interface{}{"max_primary_shard_size": "50gb", "max_age": "1d"},
+						},
+					},
+					"delete": map[string]interface{}{
+						"min_age": es.retention,
+						"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+					},
+				},
+			},
+		}
+		if err := es.putJSON(ctx, "/_ilm/policy/"+es.ilmPolicy, policy); err != nil {
+			return fmt.Errorf("creating ILM policy failed: %w", err)
+		}
+	}
+	template := map[string]interface{}{
+		"index_patterns": []string{es.index},
+		"data_stream":    map[string]interface{}{},
+		"priority":       200,
+	}
+	if es.ilmPolicy != "" {
+		template["template"] = map[string]interface{}{
+			"settings": map[string]interface{}{"index.lifecycle.name": es.ilmPolicy},
+		}
+	}
+	if err := es.putJSON(ctx, "/_index_template/"+es.index, template); err != nil {
+		return fmt.Errorf("creating index template failed: %w", err)
+	}
+	return nil
+}
+
+// putJSON puts the JSON marshalled body to the given path
+func (es *elasticsearch) putJSON(ctx context.Context, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = es.do(ctx, http.MethodPut, path, "application/json", data)
+	return err
+}
+
+// do sends the request and returns the response body. Returns an error for non 2xx responses.
+func (es *elasticsearch) do(ctx context.Context, method string, path string, contentType string, body []byte) ([]byte, error) {
+	return es.send(ctx, method, path, contentType, body, bytes.NewReader(body))
+}
+
+// doPooled like do, but the body is a pooled buffer that is returned to the pool when the request is done
+func (es *elasticsearch) doPooled(ctx context.Context, method string, path string, contentType string, buf *bytes.Buffer) ([]byte, error) {
+	return es.send(ctx, method, path, contentType, buf.Bytes(), newPooledBody(buf))
+}
+
+// send sends the request with the reader of the body
+func (es *elasticsearch) send(ctx context.Context, method string, path string, contentType string, body []byte, bodyReader io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, es.url+path, bodyReader)
+	if err != nil {
+		if closer, ok := bodyReader.(io.Closer); ok {
+			closer.Close() // returns a pooled body to the pool
+		}
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if es.signer != nil {
+		if err := es.signer.sign(req, body); err != nil {
+			req.Body.Close()
+			return nil, err
+		}
+	} else if es.user != "" {
+		req.SetBasicAuth(es.user, es.pwd)
+	}
+	resp, err := es.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return respBody, DisableError(fmt.Errorf("%v %v failed (Code: %v): %s", method, path, resp.StatusCode, respBody), true)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return respBody, fmt.Errorf("%v %v failed (Code: %v): %s", method, path, resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
+
+// Validate requests the cluster info to check the URL and credentials
+func (es *elasticsearch) Validate(ctx context.Context) error {
+	_, err := es.do(ctx, http.MethodGet, "/", "", nil)
+	return err
+}
+
+// bulkBody writes the NDJSON body for the bulk API. Data streams only accept "create" actions and require "@timestamp".
+func bulkBody(body *bytes.Buffer, dataStream bool, logMessages []json.RawMessage, timestamps []time.Time) {
+	action := []byte(`{"index":{}}`)
+	if dataStream {
+		action = []byte(`{"create":{}}`)
+	}
+	for i, logMessage := range logMessages {
+		body.Write(action)
+		body.WriteByte('\n')
+		if dataStream && len(logMessage) > 1 && logMessage[0] == '{' {
+			body.WriteString(`{"@timestamp":"` + timestamps[i].UTC().Format(time.RFC3339Nano) + `"`)
+			if rest := bytes.TrimSpace(logMessage[1:]); len(rest) > 0 && rest[0] != '}' {
+				body.WriteByte(',')
+			}
+			body.Write(logMessage[1:])
+		} else {
+			body.Write(logMessage)
+		}
+		body.WriteByte('\n')
+	}
+}
+
+// bulkItemResult is the result of a single document of a bulk request
+type bulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// bulkResponse is the response of a bulk request
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+// bulk sends the bulk request and returns the indices of the documents that have been rejected temporarily (429) and
+// the errors of the documents that have been rejected permanently
+func (es *elasticsearch) bulk(index string, dataStream bool, logMessages []json.RawMessage, timestamps []time.Time) (retry []int, rejected map[int]json.RawMessage, err error) {
+	body := GetBuffer()
+	bulkBody(body, dataStream, logMessages, timestamps)
+	respBody, err := es.doPooled(context.Background(), http.MethodPost, "/"+index+"/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var result bulkResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, nil, fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil, nil
+	}
+	if len(result.Items) != len(logMessages) {
+		return nil, nil, fmt.Errorf("bulk request partially failed: %s", respBody)
+	}
+	rejected = map[int]json.RawMessage{}
+	for i, item := range result.Items {
+		for _, itemResult := range item {
+			switch {
+			case itemResult.Status == http.StatusTooManyRequests:
+				retry = append(retry, i)
+			case itemResult.Status < 200 || itemResult.Status >= 300:
+				rejected[i] = itemResult.Error
+			}
+		}
+	}
+	return retry, rejected, nil
+}
+
+// deadLetter writes the rejected documents as string with the rejection error as "deadLetterError" property to the
+// dead letter index, so that they don't hit the same mapping error again
+func (es *elasticsearch) deadLetter(logMessages []json.RawMessage, timestamps []time.Time, rejected map[int]json.RawMessage) error {
+	if es.deadLetterIndex == "" || len(rejected) == 0 {
+		return nil
+	}
+	var deadLetters []json.RawMessage
+	var deadLetterTimestamps []time.Time
+	for i, rejectionErr := range rejected {
+		deadLetter, err := json.Marshal(map[string]interface{}{
+			"timestamp":       timestamps[i].UTC().Format(time.RFC3339Nano),
+			"document":        string(logMessages[i]),
+			"deadLetterError": rejectionErr,
+		})
+		if err != nil {
+			continue
+		}
+		deadLetters = append(deadLetters, deadLetter)
+		deadLetterTimestamps = append(deadLetterTimestamps, timestamps[i])
+	}
+	_, stillRejected, err := es.bulk(es.deadLetterIndex, false, deadLetters, deadLetterTimestamps)
+	if err == nil && len(stillRejected) > 0 {
+		err = fmt.Errorf("%v documents couldn't be dead-lettered", len(stillRejected))
+	}
+	return err
+}
+
+// WriteLogMessages writes the LogMessages with the bulk API. Documents that are rejected temporarily (429) are
+// buffered and retried with the next batch after the backoff, documents that are rejected permanently (e.g. mapping
+// errors) are written to the dead letter index. While throttled, WriteLogMessages buffers the LogMessages and returns
+// an error that wraps ErrWriterThrottled.
+func (es *elasticsearch) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	docs := make([]esRetryDoc, len(logMessages))
+	for i := range logMessages {
+		docs[i] = esRetryDoc{logMessage: logMessages[i], timestamp: timestamps[i]}
+	}
+	return es.writeDocs(docs)
+}
+
+// writeDocs writes the buffered documents and the given documents. While throttled, the documents are buffered.
+func (es *elasticsearch) writeDocs(docs []esRetryDoc) error {
+	if until, dropped, throttled := es.retry.bufferIfThrottled(time.Now(), docs...); throttled {
+		if dropped > 0 {
+			return fmt.Errorf("retry buffer full, %v documents dropped", dropped)
+		}
+		return fmt.Errorf("Elasticsearch throttled until %v: %w", until.Format(time.RFC3339), ErrWriterThrottled)
+	}
+	docs = append(es.retry.takeBuffered(), docs...)
+	if len(docs) == 0 {
+		return nil
+	}
+	logMessages := make([]json.RawMessage, len(docs))
+	timestamps := make([]time.Time, len(docs))
+	for i, doc := range docs {
+		logMessages[i], timestamps[i] = doc.logMessage, doc.timestamp
+	}
+	retry, rejected, err := es.bulk(es.index, es.dataStream, logMessages, timestamps)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	if len(rejected) > 0 {
+		if err := es.deadLetter(logMessages, timestamps, rejected); err != nil {
+			errs = append(errs, fmt.Sprintf("dead-lettering %v rejected documents failed: %v", len(rejected), err))
+		}
+	}
+	if len(retry) == 0 {
+		es.retry.reset()
+	}
+	var retryDocs []esRetryDoc
+	exhausted := 0
+	for _, i := range retry {
+		if docs[i].attempts >= es.maxRetries {
+			exhausted++
+			continue
+		}
+		doc := docs[i]
+		doc.attempts++
+		retryDocs = append(retryDocs, doc)
+	}
+	if exhausted > 0 {
+		errs = append(errs, fmt.Sprintf("%v documents rejected after %v retries", exhausted, es.maxRetries))
+	}
+	var until time.Time
+	if len(retryDocs) > 0 {
+		var dropped int
+		until, dropped = es.retry.throttle(time.Now(), es.retryBackoff, retryDocs...)
+		if dropped > 0 {
+			errs = append(errs, fmt.Sprintf("retry buffer full, %v documents dropped", dropped))
+		}
+	}
+	switch {
+	case len(errs) > 0:
+		return errors.New(strings.Join(errs, ", "))
+	case len(retryDocs) > 0:
+		return fmt.Errorf("%v documents rejected with 429, retrying after %v: %w", len(retryDocs), until.Format(time.RFC3339), ErrWriterThrottled)
+	default:
+		return nil
+	}
+}
+
+// ThrottledUntil returns the time until sending is paused because documents have been rejected with 429
+func (es *elasticsearch) ThrottledUntil() time.Time {
+	return es.retry.throttledUntil()
+}
+
+// esRetryMaxBuffer is the max size in bytes of the documents that are buffered for retry
+const esRetryMaxBuffer = 32 << 20
+
+// esRetryDoc is a document that is retried after it has been rejected temporarily (429)
+type esRetryDoc struct {
+	logMessage json.RawMessage
+	timestamp  time.Time
+	attempts   int // number of retries so far
+}
+
+// esRetry buffers documents while the writer is throttled. The backoff is doubled with every consecutive throttling.
+// It's safe for concurrent use.
+type esRetry struct {
+	mutex   sync.Mutex
+	until   time.Time
+	backoff time.Duration
+	docs    []esRetryDoc
+	size    int
+}
+
+// throttledUntil returns the time until sending is paused
+func (r *esRetry) throttledUntil() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.until
+}
+
+// throttle pauses sending for the backoff, buffers the documents and returns the end of the backoff and the number of
+// dropped documents
+func (r *esRetry) throttle(now time.Time, initialBackoff time.Duration, docs ...esRetryDoc) (time.Time, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.backoff == 0 {
+		r.backoff = initialBackoff
+	}
+	r.until = now.Add(r.backoff)
+	r.backoff *= 2
+	return r.until, r.buffer(docs...)
+}
+
+// bufferIfThrottled buffers the documents and returns true if sending is paused
+func (r *esRetry) bufferIfThrottled(now time.Time, docs ...esRetryDoc) (until time.Time, dropped int, throttled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !now.Before(r.until) {
+		return r.until, 0, false
+	}
+	return r.until, r.buffer(docs...), true
+}
+
+// pending returns true if documents are buffered and sending isn't paused anymore
+func (r *esRetry) pending(now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.docs) > 0 && !now.Before(r.until)
+}
+
+// release ends the pause of sending
+func (r *esRetry) release() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.until = time.Time{}
+}
+
+// reset resets the backoff after documents have been accepted without throttling
+func (r *esRetry) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.backoff = 0
+}
+
+// buffer buffers the documents, drops the oldest documents if the max buffer size is exceeded and returns the number
+// of dropped documents. Must be called with the mutex locked.
+func (r *esRetry) buffer(docs ...esRetryDoc) (dropped int) {
+	for _, doc := range docs {
+		r.docs = append(r.docs, doc)
+		r.size += len(doc.logMessage)
+	}
+	for r.size > esRetryMaxBuffer && len(r.docs) > 0 {
+		r.size -= len(r.docs[0].logMessage)
+		r.docs = r.docs[1:]
+		dropped++
+	}
+	return dropped
+}
+
+// takeBuffered returns and removes the buffered documents
+func (r *esRetry) takeBuffered() []esRetryDoc {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	docs := r.docs
+	r.docs, r.size = nil, 0
+	return docs
+}
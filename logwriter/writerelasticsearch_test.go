@@ -0,0 +1,137 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestElasticsearchDataStream(t *testing.T) {
+	var requests []string
+	var bulkBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			body, _ := io.ReadAll(r.Body)
+			bulkBody = string(body)
+			w.Write([]byte(`{"errors":false,"items":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	es := &elasticsearch{url: server.URL, dataStream: true, ilmPolicy: "logs-30d", retention: "30d", httpClient: server.Client()}
+	if err := es.Init(Config{LogName: "MyLogs"}); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := es.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"type":"test"}`), json.RawMessage(`{}`)}, []time.Time{timestamp, timestamp}); err != nil {
+		t.Fatal(err)
+	}
+	expectedRequests := "PUT /_ilm/policy/logs-30d,PUT /_index_template/mylogs,POST /mylogs/_bulk"
+	if strings.Join(requests, ",") != expectedRequests {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+	expectedBody := `{"create":{}}` + "\n" + `{"@timestamp":"2022-01-02T03:04:05Z","type":"test"}` + "\n" +
+		`{"create":{}}` + "\n" + `{"@timestamp":"2022-01-02T03:04:05Z"}` + "\n"
+	if bulkBody != expectedBody {
+		t.Errorf("unexpected bulk body:\n%v", bulkBody)
+	}
+}
+
+func TestElasticsearchPartialFailure(t *testing.T) {
+	var bulkBodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bulkBodies = append(bulkBodies, r.URL.Path+"\n"+string(body))
+		switch len(bulkBodies) {
+		case 1:
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429}},` +
+				`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+		default:
+			w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	es := &elasticsearch{url: server.URL, maxRetries: 3, retryBackoff: 50 * time.Millisecond, httpClient: server.Client()}
+	if err := es.Init(Config{LogName: "logs"}); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	logMessages := []json.RawMessage{json.RawMessage(`{"n":1}`), json.RawMessage(`{"n":2}`), json.RawMessage(`{"n":3}`)}
+	if err := es.WriteLogMessages(logMessages, []time.Time{timestamp, timestamp, timestamp}); !errors.Is(err, ErrWriterThrottled) {
+		t.Fatalf("expected throttled writer, got %v", err)
+	}
+	if !time.Now().Before(es.ThrottledUntil()) {
+		t.Errorf("expected backoff, got %v", es.ThrottledUntil())
+	}
+	if len(bulkBodies) != 2 {
+		t.Fatalf("unexpected number of bulk requests: %v", bulkBodies)
+	}
+	expectedDeadLetter := "/logs-deadletter/_bulk\n" + `{"index":{}}` + "\n" +
+		`{"deadLetterError":{"type":"mapper_parsing_exception"},"document":"{\"n\":3}","timestamp":"2022-01-02T03:04:05Z"}` + "\n"
+	if bulkBodies[1] != expectedDeadLetter {
+		t.Errorf("unexpected dead letter request:\n%v", bulkBodies[1])
+	}
+
+	// documents are buffered during the backoff and retried with the next batch
+	if err := es.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":4}`)}, []time.Time{timestamp}); !errors.Is(err, ErrWriterThrottled) {
+		t.Fatalf("expected buffered batch, got %v", err)
+	}
+	time.Sleep(time.Until(es.ThrottledUntil()))
+	if err := es.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":5}`)}, []time.Time{timestamp}); err != nil {
+		t.Fatal(err)
+	}
+	expectedRetry := "/logs/_bulk\n" + `{"index":{}}` + "\n" + `{"n":2}` + "\n" + `{"index":{}}` + "\n" + `{"n":4}` + "\n" +
+		`{"index":{}}` + "\n" + `{"n":5}` + "\n"
+	if len(bulkBodies) != 3 || bulkBodies[2] != expectedRetry {
+		t.Errorf("unexpected retry request:\n%v", bulkBodies[2:])
+	}
+}
+
+func TestElasticsearchCloseFlushesRetries(t *testing.T) {
+	var bulkBodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bulkBodies = append(bulkBodies, string(body))
+		w.Write([]byte(`{"errors":true,"items":[{"index":{"status":429}}]}`))
+	}))
+	defer server.Close()
+
+	var reported []error
+	es := &elasticsearch{url: server.URL, maxRetries: 3, retryBackoff: time.Hour, httpClient: server.Client()}
+	if err := es.Init(Config{LogName: "logs", ReportError: func(err error) { reported = append(reported, err) }}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.WriteLogMessages([]json.RawMessage{json.RawMessage(`{"n":1}`)}, []time.Time{time.Now()}); !errors.Is(err, ErrWriterThrottled) {
+		t.Fatalf("expected throttled writer, got %v", err)
+	}
+	es.Close()
+	if len(bulkBodies) != 2 {
+		t.Errorf("expected buffered documents to be sent on close regardless of the backoff, got %v requests", len(bulkBodies))
+	}
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "1 documents rejected with 429 dropped on close") {
+		t.Errorf("expected dropped documents to be reported, got %v", reported)
+	}
+}
+
+func TestElasticsearchUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	es := &elasticsearch{url: server.URL, httpClient: server.Client()}
+	if err := es.Init(Config{LogName: "logs"}); err != nil {
+		t.Fatal(err)
+	}
+	err := es.WriteLogMessages([]json.RawMessage{json.RawMessage(`{}`)}, []time.Time{time.Now()})
+	if !errors.Is(err, ErrWriterDisable) || !ReinitMayHelp(err) {
+		t.Errorf("expected disabled writer, got %v", err)
+	}
+}
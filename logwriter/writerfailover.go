@@ -0,0 +1,179 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// failoverEntry is a writer of the failover writer with its failure state
+type failoverEntry struct {
+	writer      LogWriter
+	failedUntil time.Time
+}
+
+// Failover log writer
+type failover struct {
+	entries          []*failoverEntry
+	mutex            sync.Mutex // guards entries and their failure state, which are accessed concurrently with writes (e.g. by Validate)
+	failbackInterval time.Duration
+}
+
+// NewFailoverWriter returns new LogWriter that writes LogMessages only to the first healthy of the given writers.
+// In contrast to the dispatcher, which hands all LogMessages to all of its writers, the failover writer writes to the
+// primary writer and only falls back to the next writer when the primary returns an error. A failed writer is skipped
+// for the failback interval, after which it is tried again first (automatic fail-back).
+// Writers that fail to init or return ErrWriterDisable are closed and removed.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_FAILOVER_FAILBACK_INTERVAL - (optional) duration how long a failed writer is skipped (default: "1m")
+func NewFailoverWriter(primary LogWriter, fallbacks ...LogWriter) LogWriter {
+	writer := &failover{
+		failbackInterval: time.Minute,
+	}
+	if d, err := time.ParseDuration(Getenv("LOGTHING_FAILOVER_FAILBACK_INTERVAL")); err == nil && d > 0 {
+		writer.failbackInterval = d
+	}
+	for _, lw := range append([]LogWriter{primary}, fallbacks...) {
+		if lw != nil {
+			writer.entries = append(writer.entries, &failoverEntry{writer: lw})
+		}
+	}
+	return writer
+}
+
+func (f *failover) Init(config Config) error {
+	var entries []*failoverEntry
+	var initErrors []error
+	for _, entry := range f.entries {
+		if err := entry.writer.Init(config); err != nil {
+			initErrors = append(initErrors, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	f.mutex.Lock()
+	f.entries = entries
+	f.mutex.Unlock()
+	if len(entries) == 0 {
+		return fmt.Errorf("init of all failover writers failed: %v", initErrors)
+	}
+	return nil
+}
+
+// currentEntries returns a snapshot of the entries
+func (f *failover) currentEntries() []*failoverEntry {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.entries
+}
+
+func (f *failover) Close() {
+	for _, entry := range f.currentEntries() {
+		entry.writer.Close()
+	}
+	f.mutex.Lock()
+	f.entries = nil
+	f.mutex.Unlock()
+}
+
+func (f *failover) PropertiesSchemaChanged(schema map[string]Kind) error {
+	var errs []error
+	for _, entry := range f.currentEntries() {
+		if err := entry.writer.PropertiesSchemaChanged(schema); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("schema change of failover writers failed: %v", errs)
+	}
+	return nil
+}
+
+// Validate validates all writers that implement Validator. Writers that fail the validation are tried last.
+// An error is only returned if none of the writers is valid.
+func (f *failover) Validate(ctx context.Context) error {
+	entries := f.currentEntries()
+	var errs []error
+	for _, entry := range entries {
+		if validator, ok := entry.writer.(Validator); ok {
+			if err := validator.Validate(ctx); err != nil {
+				errs = append(errs, err)
+				f.setFailedUntil(entry, time.Now().Add(f.failbackInterval))
+			}
+		}
+	}
+	if len(errs) == len(entries) && len(errs) > 0 {
+		return fmt.Errorf("validation of all failover writers failed: %v", errs)
+	}
+	return nil
+}
+
+// candidates returns the entries in the order they shall be tried. Entries that failed recently are tried last.
+func (f *failover) candidates(now time.Time) []*failoverEntry {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	healthy := make([]*failoverEntry, 0, len(f.entries))
+	var failed []*failoverEntry
+	for _, entry := range f.entries {
+		if now.Before(entry.failedUntil) {
+			failed = append(failed, entry)
+		} else {
+			healthy = append(healthy, entry)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// setFailedUntil sets the time until which the entry is tried last
+func (f *failover) setFailedUntil(entry *failoverEntry, failedUntil time.Time) {
+	f.mutex.Lock()
+	entry.failedUntil = failedUntil
+	f.mutex.Unlock()
+}
+
+func (f *failover) remove(removed *failoverEntry) {
+	removed.writer.Close()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	entries := make([]*failoverEntry, 0, len(f.entries))
+	for _, entry := range f.entries {
+		if entry != removed {
+			entries = append(entries, entry)
+		}
+	}
+	f.entries = entries
+}
+
+func (f *failover) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	now := time.Now()
+	var errs []error
+	for _, entry := range f.candidates(now) {
+		err := entry.writer.WriteLogMessages(logMessages, timestamps)
+		if err == nil {
+			f.setFailedUntil(entry, time.Time{})
+			return nil
+		}
+		errs = append(errs, err)
+		if errors.Is(err, ErrWriterDisable) {
+			f.remove(entry)
+			continue
+		}
+		f.setFailedUntil(entry, now.Add(f.failbackInterval))
+	}
+	if len(f.currentEntries()) == 0 {
+		return fmt.Errorf("all failover writers disabled: %v: %w", errs, ErrWriterDisable)
+	}
+	return fmt.Errorf("all failover writers failed: %v", errs)
+}
+
+// Credentials returns the tokens of all writers that implement CredentialRefresher
+func (f *failover) Credentials() (tokens []*RefreshingToken) {
+	for _, entry := range f.currentEntries() {
+		tokens = append(tokens, credentialsOf(entry.writer)...)
+	}
+	return tokens
+}
@@ -0,0 +1,92 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testWriter struct {
+	err      error
+	written  int
+	messages []json.RawMessage
+	closed   bool
+}
+
+func (tw *testWriter) Init(config Config) error                             { return nil }
+func (tw *testWriter) Close()                                               { tw.closed = true }
+func (tw *testWriter) PropertiesSchemaChanged(schema map[string]Kind) error { return nil }
+func (tw *testWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	if tw.err != nil {
+		return tw.err
+	}
+	tw.written += len(logMessages)
+	tw.messages = append(tw.messages, logMessages...)
+	return nil
+}
+
+func TestFailoverWriter(t *testing.T) {
+	primary := &testWriter{err: errors.New("unavailable")}
+	secondary := &testWriter{}
+	lw := NewFailoverWriter(primary, secondary)
+	if err := lw.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := []json.RawMessage{json.RawMessage(`{}`)}
+	timestamps := []time.Time{time.Now()}
+	if err := lw.WriteLogMessages(msgs, timestamps); err != nil {
+		t.Fatal(err)
+	}
+	if secondary.written != 1 {
+		t.Errorf("expected fallback to secondary writer")
+	}
+	// primary is skipped until failback interval elapsed
+	primary.err = nil
+	lw.WriteLogMessages(msgs, timestamps)
+	if primary.written != 0 || secondary.written != 2 {
+		t.Errorf("expected failed primary to be skipped")
+	}
+	lw.(*failover).entries[0].failedUntil = time.Now().Add(-time.Second)
+	lw.WriteLogMessages(msgs, timestamps)
+	if primary.written != 1 {
+		t.Errorf("expected fail-back to primary writer")
+	}
+	// disabled writers are removed
+	primary.err = ErrWriterDisable
+	lw.WriteLogMessages(msgs, timestamps)
+	if !primary.closed || len(lw.(*failover).entries) != 1 {
+		t.Errorf("expected disabled primary to be closed and removed")
+	}
+}
+
+// readConcurrently calls the reading methods of the writer concurrently to write, which removes disabled writers
+func readConcurrently(t *testing.T, lw LogWriter, write func()) {
+	t.Helper()
+	if err := lw.Init(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			lw.PropertiesSchemaChanged(map[string]Kind{})
+			lw.(CredentialRefresher).Credentials()
+			lw.(Validator).Validate(context.Background())
+			isOrderInsensitive(lw)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		write()
+	}
+	<-done
+	lw.Close()
+}
+
+func TestFailoverWriterConcurrentReads(t *testing.T) {
+	lw := NewFailoverWriter(&testWriter{err: ErrWriterDisable}, &testWriter{})
+	readConcurrently(t, lw, func() {
+		lw.WriteLogMessages([]json.RawMessage{json.RawMessage(`{}`)}, []time.Time{time.Now()})
+	})
+}
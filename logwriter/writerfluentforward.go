@@ -0,0 +1,248 @@
+package logwriter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Fluent forward log writer
+type fluentForward struct {
+	address   string
+	tag       string
+	useTLS    bool
+	sharedKey string
+	username  string
+	password  string
+	hostname  string
+	timeout   time.Duration
+	conn      net.Conn
+}
+
+// NewFluentForwardWriter returns new LogWriter that writes LogMessages to Fluentd or Fluent Bit using the forward protocol
+// see also: https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+//
+// Log messages are sent in forward mode as msgpack over TCP (optionally TLS) and every chunk must be acknowledged by the receiver.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_LOG_NAME              - Used as fluent tag if LOGTHING_FLUENT_TAG is not set
+// LOGTHING_FLUENT_ADDRESS        - (optional) host:port of the fluent forward input (default: "localhost:24224")
+// LOGTHING_FLUENT_TAG            - (optional) tag under which the log messages are forwarded
+// LOGTHING_FLUENT_TLS            - (optional) set to "true" to connect via TLS
+// LOGTHING_FLUENT_SHARED_KEY     - (optional) shared key to authenticate with the receiver
+// LOGTHING_FLUENT_USERNAME       - (optional) username for user authentication (requires shared key)
+// LOGTHING_FLUENT_PASSWORD       - (optional) password for user authentication (requires shared key)
+func NewFluentForwardWriter() LogWriter {
+	address := "localhost:24224"
+	if addr := Getenv("LOGTHING_FLUENT_ADDRESS"); addr != "" {
+		address = addr
+	}
+	useTLS, _ := strconv.ParseBool(Getenv("LOGTHING_FLUENT_TLS"))
+	hostname, _ := os.Hostname()
+	writer := &fluentForward{
+		address:   address,
+		tag:       Getenv("LOGTHING_FLUENT_TAG"),
+		useTLS:    useTLS,
+		sharedKey: Getenv("LOGTHING_FLUENT_SHARED_KEY"),
+		username:  Getenv("LOGTHING_FLUENT_USERNAME"),
+		password:  Getenv("LOGTHING_FLUENT_PASSWORD"),
+		hostname:  hostname,
+		timeout:   10 * time.Second,
+	}
+	return writer
+}
+
+func (ff *fluentForward) Init(config Config) error {
+	if ff.tag == "" {
+		ff.tag = config.LogName
+	}
+	if ff.tag == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_FLUENT_TAG\" or \"LOGTHING_LOG_NAME\" must be set")
+	}
+	if ff.username != "" && ff.sharedKey == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_FLUENT_SHARED_KEY\" must be set for user authentication")
+	}
+	return nil
+}
+
+func (ff *fluentForward) Close() {
+	if ff.conn != nil {
+		ff.conn.Close()
+		ff.conn = nil
+	}
+}
+
+func (ff *fluentForward) PropertiesSchemaChanged(schema map[string]Kind) error {
+	return nil
+}
+
+// Validate connects to the fluent forward input with a separate probe connection and performs the handshake
+func (ff *fluentForward) Validate(ctx context.Context) error {
+	conn, err := ff.dial(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// connect establishes the connection that is used to write log messages
+func (ff *fluentForward) connect() (err error) {
+	ff.conn, err = ff.dial(context.Background())
+	return err
+}
+
+// dial establishes a connection and performs the handshake in case a shared key is configured
+func (ff *fluentForward) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: ff.timeout}
+	var conn net.Conn
+	var err error
+	if ff.useTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{}}).DialContext(ctx, "tcp", ff.address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", ff.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Connecting to fluent forward input failed: %w", err)
+	}
+	if ff.sharedKey != "" {
+		deadline := time.Now().Add(ff.timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetDeadline(deadline)
+		if err = ff.handshake(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func sha512Hex(parts ...string) string {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handshake authenticates the client with the shared key (HELO, PING, PONG)
+func (ff *fluentForward) handshake(conn net.Conn) error {
+	dec := newMsgpackDecoder(conn)
+	helo, err := dec.decode()
+	if err != nil {
+		return fmt.Errorf("Reading HELO failed: %w", err)
+	}
+	heloArr, ok := helo.([]interface{})
+	if !ok || len(heloArr) < 2 || heloArr[0] != "HELO" {
+		return fmt.Errorf("Invalid HELO message: %v: %w", helo, ErrWriterDisable)
+	}
+	heloOpts, _ := heloArr[1].(map[string]interface{})
+	nonce, _ := heloOpts["nonce"].(string)
+	authSalt, _ := heloOpts["auth"].(string)
+
+	saltBytes := make([]byte, 16)
+	rand.Read(saltBytes)
+	salt := hex.EncodeToString(saltBytes)
+	password := ff.password
+	if authSalt != "" {
+		password = sha512Hex(authSalt, ff.username, ff.password)
+	}
+
+	var buf bytes.Buffer
+	enc := newMsgpackEncoder(&buf)
+	enc.encodeArrayHeader(6)
+	enc.encodeString("PING")
+	enc.encodeString(ff.hostname)
+	enc.encodeString(salt)
+	enc.encodeString(sha512Hex(salt, ff.hostname, nonce, ff.sharedKey))
+	enc.encodeString(ff.username)
+	enc.encodeString(password)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("Writing PING failed: %w", err)
+	}
+
+	pong, err := dec.decode()
+	if err != nil {
+		return fmt.Errorf("Reading PONG failed: %w", err)
+	}
+	pongArr, ok := pong.([]interface{})
+	if !ok || len(pongArr) < 5 || pongArr[0] != "PONG" {
+		return fmt.Errorf("Invalid PONG message: %v: %w", pong, ErrWriterDisable)
+	}
+	if authResult, _ := pongArr[1].(bool); !authResult {
+		return fmt.Errorf("Fluent authentication failed: %v: %w", pongArr[2], ErrWriterDisable)
+	}
+	serverHostname, _ := pongArr[3].(string)
+	if pongArr[4] != sha512Hex(salt, serverHostname, nonce, ff.sharedKey) {
+		return fmt.Errorf("Fluent server shared key mismatch: %w", ErrWriterDisable)
+	}
+	return nil
+}
+
+// encodeForwardMessage encodes log messages in forward mode into the buffer: [tag, [[time, record], ...], {"size": n, "chunk": id}]
+func encodeForwardMessage(buf *bytes.Buffer, tag string, chunk string, logMessages []json.RawMessage, timestamps []time.Time) error {
+	enc := newMsgpackEncoder(buf)
+	enc.encodeArrayHeader(3)
+	enc.encodeString(tag)
+	enc.encodeArrayHeader(len(logMessages))
+	for i, msg := range logMessages {
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.UseNumber()
+		var record interface{}
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		enc.encodeArrayHeader(2)
+		enc.encodeEventTime(timestamps[i])
+		enc.encode(record)
+	}
+	enc.encodeMapHeader(2)
+	enc.encodeString("size")
+	enc.encodeUint(uint64(len(logMessages)))
+	enc.encodeString("chunk")
+	enc.encodeString(chunk)
+	return enc.err
+}
+
+func (ff *fluentForward) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	chunkBytes := make([]byte, 16)
+	rand.Read(chunkBytes)
+	chunk := base64.StdEncoding.EncodeToString(chunkBytes)
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if err := encodeForwardMessage(buf, ff.tag, chunk, logMessages, timestamps); err != nil {
+		return fmt.Errorf("Encoding forward message failed: %w", err)
+	}
+
+	if ff.conn == nil {
+		if err := ff.connect(); err != nil {
+			return err
+		}
+	}
+	ff.conn.SetDeadline(time.Now().Add(ff.timeout))
+	if _, err := ff.conn.Write(buf.Bytes()); err != nil {
+		ff.Close() // reconnect with next write
+		return fmt.Errorf("Sending LogMessages to fluent failed: %w", err)
+	}
+	ack, err := newMsgpackDecoder(ff.conn).decode()
+	if err != nil {
+		ff.Close()
+		return fmt.Errorf("Reading fluent ack failed: %w", err)
+	}
+	if ackMap, ok := ack.(map[string]interface{}); !ok || ackMap["ack"] != chunk {
+		ff.Close()
+		return fmt.Errorf("Invalid fluent ack: %v", ack)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package logwriter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFluentForwardEncoding(t *testing.T) {
+	logMessages := []json.RawMessage{
+		json.RawMessage(`{"type":"foo","severity":6,"rain":10.5,"output":["a","b"]}`),
+	}
+	timestamp := time.Unix(1600000000, 123000)
+	var buf bytes.Buffer
+	err := encodeForwardMessage(&buf, "tag", "chunk", logMessages, []time.Time{timestamp})
+	data := buf.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := newMsgpackDecoder(bytes.NewReader(data)).decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := decoded.([]interface{})
+	if msg[0] != "tag" {
+		t.Errorf("unexpected tag: %v", msg[0])
+	}
+	entry := msg[1].([]interface{})[0].([]interface{})
+	if !entry[0].(time.Time).Equal(timestamp) {
+		t.Errorf("unexpected event time: %v", entry[0])
+	}
+	record := entry[1].(map[string]interface{})
+	if record["type"] != "foo" || record["severity"] != int64(6) || record["rain"] != 10.5 || len(record["output"].([]interface{})) != 2 {
+		t.Errorf("unexpected record: %v", record)
+	}
+	option := msg[2].(map[string]interface{})
+	if option["chunk"] != "chunk" || option["size"] != int64(1) {
+		t.Errorf("unexpected option: %v", option)
+	}
+}
+
+func TestFluentForwardValidate(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // never sends HELO
+		}
+	}()
+	ff := &fluentForward{address: listener.Addr().String(), timeout: time.Minute}
+	if err := ff.Validate(context.Background()); err != nil || ff.conn != nil {
+		t.Errorf("expected validation with separate probe connection, got %v", err)
+	}
+	ff.sharedKey = "key"
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ff.Validate(ctx); err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("expected handshake to be cancelled by the context, got %v after %v", err, time.Since(start))
+	}
+}
@@ -0,0 +1,233 @@
+package logwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gelfChunkSize     = 1420 // conservative chunk size that also works across WAN
+	gelfMaxChunkCount = 128
+)
+
+// GELF (Graylog) log writer
+type gelf struct {
+	address  string
+	protocol string
+	useTLS   bool
+	hostname string
+	timeout  time.Duration
+	conn     net.Conn
+}
+
+// NewGELFWriter returns new LogWriter that writes LogMessages in Graylog Extended Log Format (GELF)
+// see also: https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+//
+// Via UDP messages are gzip compressed and chunked if necessary. Via TCP messages are null byte delimited and may be sent using TLS.
+// The message severity is used as GELF level, the output as short_message and full_message and all other
+// properties are sent as additional fields (objects and arrays are stringified).
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_GELF_ADDRESS          - host:port of the GELF input
+// LOGTHING_GELF_PROTOCOL         - (optional) "udp" (default) or "tcp"
+// LOGTHING_GELF_TLS              - (optional) set to "true" to connect via TLS (only with tcp)
+func NewGELFWriter() LogWriter {
+	protocol := "udp"
+	if p := Getenv("LOGTHING_GELF_PROTOCOL"); p != "" {
+		protocol = strings.ToLower(p)
+	}
+	useTLS, _ := strconv.ParseBool(Getenv("LOGTHING_GELF_TLS"))
+	hostname, _ := os.Hostname()
+	writer := &gelf{
+		address:  Getenv("LOGTHING_GELF_ADDRESS"),
+		protocol: protocol,
+		useTLS:   useTLS,
+		hostname: hostname,
+		timeout:  10 * time.Second,
+	}
+	return writer
+}
+
+func (g *gelf) Init(config Config) error {
+	if g.address == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_GELF_ADDRESS\" must be set")
+	}
+	if g.protocol != "udp" && g.protocol != "tcp" {
+		return fmt.Errorf("environment variable \"LOGTHING_GELF_PROTOCOL\" must be \"udp\" or \"tcp\"")
+	}
+	if g.useTLS && g.protocol != "tcp" {
+		return fmt.Errorf("environment variable \"LOGTHING_GELF_TLS\" requires tcp protocol")
+	}
+	if g.hostname == "" {
+		g.hostname = config.LogName
+	}
+	return nil
+}
+
+func (g *gelf) Close() {
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+func (g *gelf) PropertiesSchemaChanged(schema map[string]Kind) error {
+	return nil
+}
+
+// gelfMessage converts premarshalled log message into GELF message
+func gelfMessage(logMessage json.RawMessage, timestamp time.Time, host string) ([]byte, error) {
+	var properties map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(logMessage))
+	dec.UseNumber()
+	if err := dec.Decode(&properties); err != nil {
+		return nil, err
+	}
+	msg := map[string]interface{}{
+		"version":   "1.1",
+		"host":      host,
+		"timestamp": float64(timestamp.UnixNano()/int64(time.Millisecond)) / 1000,
+	}
+	var output []string
+	if o, ok := properties["output"].([]interface{}); ok {
+		for _, line := range o {
+			output = append(output, fmt.Sprint(line))
+		}
+	}
+	if len(output) > 0 {
+		msg["short_message"] = output[0]
+		if len(output) > 1 {
+			msg["full_message"] = strings.Join(output, "\n")
+		}
+	} else {
+		msg["short_message"] = fmt.Sprint(properties["type"])
+	}
+	if severity, ok := properties["severity"].(json.Number); ok {
+		msg["level"] = severity
+	}
+	for k, v := range properties {
+		switch k {
+		case "output", "severity", "timestamp":
+			continue
+		case "id":
+			k = "log_id" // "_id" is reserved
+		}
+		switch v.(type) {
+		case string, json.Number:
+			msg["_"+k] = v
+		case nil:
+			continue
+		default:
+			b, _ := json.Marshal(v)
+			msg["_"+k] = string(b)
+		}
+	}
+	return json.Marshal(msg)
+}
+
+// Validate connects to the GELF input with a separate probe connection
+func (g *gelf) Validate(ctx context.Context) error {
+	conn, err := g.dial(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// connect establishes the connection that is used to write log messages
+func (g *gelf) connect() (err error) {
+	g.conn, err = g.dial(context.Background())
+	return err
+}
+
+func (g *gelf) dial(ctx context.Context) (conn net.Conn, err error) {
+	dialer := &net.Dialer{Timeout: g.timeout}
+	if g.useTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{}}).DialContext(ctx, g.protocol, g.address)
+	} else {
+		conn, err = dialer.DialContext(ctx, g.protocol, g.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Connecting to GELF input failed: %w", err)
+	}
+	return conn, nil
+}
+
+// gelfChunks gzip compresses the message into the buffer and splits it into GELF chunks if it exceeds the chunk size.
+// The chunks may refer to the buffer.
+func gelfChunks(buf *bytes.Buffer, msg []byte) ([][]byte, error) {
+	zw := gzip.NewWriter(buf)
+	zw.Write(msg)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if len(data) <= gelfChunkSize {
+		return [][]byte{data}, nil
+	}
+	dataSize := gelfChunkSize - 12
+	count := (len(data) + dataSize - 1) / dataSize
+	if count > gelfMaxChunkCount {
+		return nil, fmt.Errorf("GELF message too large (%v bytes compressed)", len(data))
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, data[i*dataSize:end]...))
+	}
+	return chunks, nil
+}
+
+func (g *gelf) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	if g.conn == nil {
+		if err := g.connect(); err != nil {
+			return err
+		}
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(g.timeout))
+	var chunkErr error
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	for i, logMessage := range logMessages {
+		msg, err := gelfMessage(logMessage, timestamps[i], g.hostname)
+		if err != nil {
+			return fmt.Errorf("Creating GELF message failed: %w", err)
+		}
+		if g.protocol == "tcp" {
+			if _, err := g.conn.Write(append(msg, 0)); err != nil {
+				g.Close() // reconnect with next write
+				return fmt.Errorf("Sending LogMessage to GELF input failed: %w", err)
+			}
+			continue
+		}
+		buf.Reset()
+		chunks, err := gelfChunks(buf, msg)
+		if err != nil {
+			chunkErr = err // skip message but continue with the remaining ones
+			continue
+		}
+		for _, chunk := range chunks {
+			if _, err := g.conn.Write(chunk); err != nil {
+				return fmt.Errorf("Sending LogMessage to GELF input failed: %w", err)
+			}
+		}
+	}
+	return chunkErr
+}
@@ -0,0 +1,122 @@
+package logwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestGELFMessage(t *testing.T) {
+	timestamp := time.Unix(1600000000, 123456789)
+	tests := []struct {
+		name       string
+		logMessage string
+		expected   map[string]interface{}
+		absent     []string
+	}{
+		{
+			name:       "single output line",
+			logMessage: `{"type":"foo","severity":3,"output":["failed"],"count":2}`,
+			expected: map[string]interface{}{"version": "1.1", "host": "host", "timestamp": 1600000000.123, "short_message": "failed",
+				"level": 3.0, "_type": "foo", "_count": 2.0},
+			absent: []string{"full_message", "_output", "_severity"},
+		},
+		{
+			name:       "multiple output lines",
+			logMessage: `{"type":"foo","severity":7,"output":["a","b"]}`,
+			expected:   map[string]interface{}{"short_message": "a", "full_message": "a\nb", "level": 7.0},
+		},
+		{
+			name:       "type as short message without output",
+			logMessage: `{"type":"foo"}`,
+			expected:   map[string]interface{}{"short_message": "foo"},
+			absent:     []string{"level"},
+		},
+		{
+			name:       "reserved id and nested values",
+			logMessage: `{"type":"foo","id":"x","nested":{"a":1},"list":[1,2],"empty":null}`,
+			expected:   map[string]interface{}{"_log_id": "x", "_nested": `{"a":1}`, "_list": "[1,2]"},
+			absent:     []string{"_id", "_empty"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := gelfMessage(json.RawMessage(test.logMessage), timestamp, "host")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range test.expected {
+				if msg[k] != v {
+					t.Errorf("expected %v to be %v, got %v", k, v, msg[k])
+				}
+			}
+			for _, k := range test.absent {
+				if _, ok := msg[k]; ok {
+					t.Errorf("expected %v to be absent, got %v", k, msg[k])
+				}
+			}
+		})
+	}
+	if _, err := gelfMessage(json.RawMessage(`not json`), timestamp, "host"); err == nil {
+		t.Errorf("expected error for invalid log message")
+	}
+}
+
+// randomBytes returns incompressible data
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+func TestGELFChunks(t *testing.T) {
+	var buf bytes.Buffer
+	chunks, err := gelfChunks(&buf, []byte(`{"short_message":"small"}`))
+	if err != nil || len(chunks) != 1 || chunks[0][0] != 0x1f || chunks[0][1] != 0x8b {
+		t.Fatalf("expected single gzip compressed message, got %v chunks: %v", len(chunks), err)
+	}
+
+	msg := randomBytes(5 * gelfChunkSize)
+	buf.Reset()
+	chunks, err = gelfChunks(&buf, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 6 {
+		t.Fatalf("expected 6 chunks, got %v", len(chunks))
+	}
+	var compressed []byte
+	for i, chunk := range chunks {
+		if len(chunk) > gelfChunkSize {
+			t.Errorf("chunk %v exceeds chunk size: %v", i, len(chunk))
+		}
+		if chunk[0] != 0x1e || chunk[1] != 0x0f || !bytes.Equal(chunk[2:10], chunks[0][2:10]) || chunk[10] != byte(i) || chunk[11] != byte(len(chunks)) {
+			t.Errorf("unexpected header of chunk %v: % x", i, chunk[:12])
+		}
+		compressed = append(compressed, chunk[12:]...)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decompressed, err := io.ReadAll(zr); err != nil || !bytes.Equal(decompressed, msg) {
+		t.Errorf("expected reassembled chunks to be the message: %v", err)
+	}
+
+	buf.Reset()
+	if _, err := gelfChunks(&buf, randomBytes(gelfMaxChunkCount*(gelfChunkSize-12)+1)); err == nil {
+		t.Errorf("expected error for message that exceeds %v chunks", gelfMaxChunkCount)
+	}
+	buf.Reset()
+	if chunks, err := gelfChunks(&buf, randomBytes((gelfMaxChunkCount-1)*(gelfChunkSize-12))); err != nil || len(chunks) != gelfMaxChunkCount {
+		t.Errorf("expected %v chunks, got %v: %v", gelfMaxChunkCount, len(chunks), err)
+	}
+}
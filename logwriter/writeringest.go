@@ -0,0 +1,122 @@
+package logwriter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ingestClient log writer that forwards messages to a logthing.ServeIngest server on the same host
+type ingestClient struct {
+	socketPath string
+	timeout    time.Duration
+	conn       net.Conn
+	reader     *bufio.Reader
+}
+
+// NewIngestWriter returns new LogWriter that forwards LogMessages via Unix domain socket to another process that
+// serves them with logthing.ServeIngest. Several small processes on one host can so funnel their messages through one
+// dispatcher that holds the writer credentials instead of each process talking to the log backend directly.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_INGEST_SOCKET         - path of the Unix domain socket the server listens on
+// LOGTHING_INGEST_TIMEOUT        - (optional) timeout to send a batch and await its acknowledgement (default: 10s)
+func NewIngestWriter() LogWriter {
+	writer := &ingestClient{
+		socketPath: Getenv("LOGTHING_INGEST_SOCKET"),
+		timeout:    10 * time.Second,
+	}
+	if timeout, err := time.ParseDuration(Getenv("LOGTHING_INGEST_TIMEOUT")); err == nil && timeout > 0 {
+		writer.timeout = timeout
+	}
+	return writer
+}
+
+func (w *ingestClient) Init(config Config) error {
+	if w.socketPath == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_INGEST_SOCKET\" must be set")
+	}
+	return nil
+}
+
+func (w *ingestClient) Close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+		w.reader = nil
+	}
+}
+
+func (w *ingestClient) PropertiesSchemaChanged(schema map[string]Kind) error {
+	return nil
+}
+
+func (w *ingestClient) connect() error {
+	conn, err := w.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	w.reader = bufio.NewReader(conn)
+	return nil
+}
+
+func (w *ingestClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: w.timeout}
+	conn, err := dialer.DialContext(ctx, "unix", w.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ingest socket failed: %w", err)
+	}
+	return conn, nil
+}
+
+func (w *ingestClient) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return err
+		}
+	}
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	for _, logMessage := range logMessages {
+		if bytes.IndexByte(logMessage, '\n') >= 0 {
+			// every message must be a single line
+			if err := json.Compact(buf, logMessage); err != nil {
+				return err
+			}
+		} else {
+			buf.Write(logMessage)
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n') // empty line terminates the batch
+	w.conn.SetDeadline(time.Now().Add(w.timeout))
+	if _, err := w.conn.Write(buf.Bytes()); err != nil {
+		w.Close() // reconnect with next write
+		return fmt.Errorf("sending LogMessages to ingest socket failed: %w", err)
+	}
+	response, err := w.reader.ReadString('\n')
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("reading ingest acknowledgement failed: %w", err)
+	}
+	response = strings.TrimSpace(response)
+	if response != "ok" {
+		return fmt.Errorf("ingesting LogMessages failed: %v", strings.TrimPrefix(response, "error: "))
+	}
+	return nil
+}
+
+// Validate connects to the ingest socket with a separate probe connection, which is closed again
+func (w *ingestClient) Validate(ctx context.Context) error {
+	conn, err := w.dial(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,94 @@
+package logwriter
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenSearch log writer (Elasticsearch writer with AWS SigV4 signing)
+type openSearch struct {
+	*elasticsearch
+	credentials *RefreshingToken // AWS credentials, nil if basic authentication is used
+	region      string
+	service     string
+}
+
+// NewOpenSearchWriter returns new LogWriter that writes LogMessages to OpenSearch (e.g. Amazon OpenSearch Service) using
+// the bulk API. It behaves like the Elasticsearch writer (see NewElasticsearchWriter), but requests are signed with AWS
+// Signature Version 4 when AWS credentials are available. Otherwise basic authentication is used (e.g. for the internal
+// user database of fine-grained access control).
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_LOG_NAME                    - Log name under which log messages are stored (used as index or data stream name)
+// LOGTHING_OPENSEARCH_URL              - OpenSearch domain endpoint (e.g. "https://search-mydomain.eu-central-1.es.amazonaws.com")
+// LOGTHING_OPENSEARCH_REGION           - (optional) AWS region of the domain (default: AWS_REGION)
+// LOGTHING_OPENSEARCH_SERVICE          - (optional) service name used for signing ("es" (default) or "aoss" for OpenSearch Serverless)
+// LOGTHING_OPENSEARCH_USER             - (optional) user for basic authentication if no AWS credentials are available
+// LOGTHING_OPENSEARCH_PWD              - (optional) password for basic authentication
+// LOGTHING_OPENSEARCH_DATA_STREAM      - (optional) if true, LogMessages are written to a data stream
+// LOGTHING_OPENSEARCH_MAX_RETRIES      - (optional) how often documents rejected with 429 are retried (default: 3)
+// LOGTHING_OPENSEARCH_DEAD_LETTER_INDEX - (optional) index for permanently rejected documents (default: "<index>-deadletter", "-" disables dead-lettering)
+//
+// AWS credentials are read from the standard environment variables AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// or are fetched from the container credentials endpoint (AWS_CONTAINER_CREDENTIALS_FULL_URI or
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI with AWS_CONTAINER_AUTHORIZATION_TOKEN(_FILE), e.g. ECS task roles or EKS Pod
+// Identity). Temporary credentials are refreshed before they expire (see CredentialRefresher).
+func NewOpenSearchWriter() LogWriter {
+	dataStream, _ := strconv.ParseBool(Getenv("LOGTHING_OPENSEARCH_DATA_STREAM"))
+	writer := &openSearch{
+		elasticsearch: &elasticsearch{
+			url:        strings.TrimSuffix(Getenv("LOGTHING_OPENSEARCH_URL"), "/"),
+			user:       Getenv("LOGTHING_OPENSEARCH_USER"),
+			pwd:        Getenv("LOGTHING_OPENSEARCH_PWD"),
+			dataStream: dataStream,
+			httpClient: &http.Client{Timeout: 30 * time.Second},
+
+			deadLetterIndex: strings.ToLower(Getenv("LOGTHING_OPENSEARCH_DEAD_LETTER_INDEX")),
+			maxRetries:      3,
+			retryBackoff:    500 * time.Millisecond,
+		},
+		region:  Getenv("LOGTHING_OPENSEARCH_REGION"),
+		service: Getenv("LOGTHING_OPENSEARCH_SERVICE"),
+	}
+	if writer.region == "" {
+		writer.region = os.Getenv("AWS_REGION")
+	}
+	if writer.service == "" {
+		writer.service = "es"
+	}
+	if n, err := strconv.Atoi(Getenv("LOGTHING_OPENSEARCH_MAX_RETRIES")); err == nil && n >= 0 {
+		writer.maxRetries = n
+	}
+	if source := awsCredentialSource(writer.httpClient); source != nil {
+		writer.credentials = NewRefreshingToken(source, 5*time.Minute)
+	}
+	return writer
+}
+
+func (o *openSearch) Init(config Config) error {
+	if o.url == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_OPENSEARCH_URL\" must be set")
+	}
+	switch {
+	case o.credentials != nil:
+		if o.region == "" {
+			return fmt.Errorf("environment variable \"LOGTHING_OPENSEARCH_REGION\" or \"AWS_REGION\" must be set")
+		}
+		o.signer = &sigV4Signer{credentials: o.credentials, region: o.region, service: o.service}
+	case o.user == "":
+		return fmt.Errorf("either AWS credentials or environment variable \"LOGTHING_OPENSEARCH_USER\" must be set")
+	}
+	return o.elasticsearch.Init(config)
+}
+
+// Credentials returns the AWS credentials, so that temporary credentials are refreshed in the background
+func (o *openSearch) Credentials() []*RefreshingToken {
+	if o.credentials == nil {
+		return nil
+	}
+	return []*RefreshingToken{o.credentials}
+}
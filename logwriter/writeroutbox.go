@@ -0,0 +1,237 @@
+package logwriter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TxWriter is implemented by writers that can write LogMessages within a database transaction (see NewOutboxWriter).
+// WriteLogMessagesTx and, for new properties of transactional messages, PropertiesSchemaChanged are called from the
+// goroutine of the caller, concurrently to the other methods of the writer.
+type TxWriter interface {
+	WriteLogMessagesTx(ctx context.Context, tx *sql.Tx, logMessages []json.RawMessage, timestamps []time.Time) error
+}
+
+var outboxTableRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Outbox log writer
+type outbox struct {
+	db           *sql.DB
+	target       LogWriter
+	table        string
+	dollar       bool // use $1, $2, ... instead of ? placeholders
+	pollInterval time.Duration
+	batchSize    int
+	reportError  func(err error) // reports relay errors (see Config.ReportError)
+	mutex        sync.Mutex      // serializes relaying and writes to the target writer
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// NewOutboxWriter returns new LogWriter that implements the transactional outbox pattern: LogMessages that are written
+// with WriteLogMessagesTx (see logthing.LogTx) are inserted into an outbox table of the application's database within
+// the caller's transaction, so that e.g. audit log records are committed atomically with the business data. Committed
+// records are relayed to the target writer asynchronously and deleted after they have been written. LogMessages that
+// are written without transaction are directly written to the target writer.
+//
+// The outbox table must be created by the application, e.g.:
+//
+//	CREATE TABLE logthing_outbox (id BIGSERIAL PRIMARY KEY, timestamp BIGINT NOT NULL, message TEXT NOT NULL)
+//
+// with an auto incremented id, the timestamp in unix nanoseconds and the marshalled message.
+//
+// The following environment variables are used to configure the behaviour:
+// LOGTHING_OUTBOX_TABLE         - (optional) name of the outbox table (default: "logthing_outbox")
+// LOGTHING_OUTBOX_PLACEHOLDER   - (optional) "?" (default, e.g. MySQL, SQLite) or "$" (e.g. PostgreSQL) bind parameters
+// LOGTHING_OUTBOX_POLL_INTERVAL - (optional) interval in which committed records are relayed (default: 5s)
+// LOGTHING_OUTBOX_BATCH_SIZE    - (optional) max number of records that are relayed at once (default: 500)
+func NewOutboxWriter(db *sql.DB, target LogWriter) LogWriter {
+	writer := &outbox{
+		db:           db,
+		target:       target,
+		table:        "logthing_outbox",
+		dollar:       Getenv("LOGTHING_OUTBOX_PLACEHOLDER") == "$",
+		pollInterval: 5 * time.Second,
+		batchSize:    500,
+	}
+	if table := Getenv("LOGTHING_OUTBOX_TABLE"); table != "" {
+		writer.table = table
+	}
+	if interval, err := time.ParseDuration(Getenv("LOGTHING_OUTBOX_POLL_INTERVAL")); err == nil && interval > 0 {
+		writer.pollInterval = interval
+	}
+	if batchSize, err := strconv.Atoi(Getenv("LOGTHING_OUTBOX_BATCH_SIZE")); err == nil && batchSize > 0 {
+		writer.batchSize = batchSize
+	}
+	return writer
+}
+
+// Name returns the name of the target writer
+func (o *outbox) Name() string {
+	if named, ok := o.target.(interface{ Name() string }); ok {
+		return "outbox(" + named.Name() + ")"
+	}
+	return fmt.Sprintf("outbox(%T)", o.target)
+}
+
+func (o *outbox) Init(config Config) error {
+	if o.db == nil || o.target == nil {
+		return fmt.Errorf("outbox writer needs a database and a target writer")
+	}
+	if !outboxTableRegexp.MatchString(o.table) {
+		return fmt.Errorf("invalid outbox table name %q", o.table)
+	}
+	if err := o.target.Init(config); err != nil {
+		return err
+	}
+	o.reportError = config.ReportError
+	o.stop = make(chan struct{})
+	o.done = make(chan struct{})
+	go o.run()
+	return nil
+}
+
+// run relays committed records in the poll interval until the writer is closed
+func (o *outbox) run() {
+	defer close(o.done)
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			o.relayAll()
+		}
+	}
+}
+
+// relayAll relays committed records until the outbox is drained or relaying failed. Errors are reported, since the
+// records stay in the outbox and relaying is retried in the next poll interval.
+func (o *outbox) relayAll() {
+	for {
+		n, err := o.relay(context.Background())
+		if err != nil {
+			if o.reportError != nil {
+				o.reportError(err)
+			}
+			return
+		}
+		if n < o.batchSize {
+			return
+		}
+	}
+}
+
+// placeholder returns the n-th (starting at 1) bind parameter
+func (o *outbox) placeholder(n int) string {
+	if o.dollar {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// WriteLogMessagesTx inserts the LogMessages into the outbox table within the given transaction
+func (o *outbox) WriteLogMessagesTx(ctx context.Context, tx *sql.Tx, logMessages []json.RawMessage, timestamps []time.Time) error {
+	query := fmt.Sprintf("INSERT INTO %s (timestamp, message) VALUES (%s, %s)", o.table, o.placeholder(1), o.placeholder(2))
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("preparing outbox insert failed: %w", err)
+	}
+	defer stmt.Close()
+	for i, logMessage := range logMessages {
+		if _, err := stmt.ExecContext(ctx, timestamps[i].UnixNano(), string(logMessage)); err != nil {
+			return fmt.Errorf("inserting into outbox failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// relay writes the oldest committed records to the target writer and deletes them. It returns the number of relayed records.
+func (o *outbox) relay(ctx context.Context) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	query := fmt.Sprintf("SELECT id, timestamp, message FROM %s ORDER BY id LIMIT %d", o.table, o.batchSize)
+	rows, err := o.db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("reading outbox failed: %w", err)
+	}
+	var ids []interface{}
+	var logMessages []json.RawMessage
+	var timestamps []time.Time
+	for rows.Next() {
+		var id, timestamp int64
+		var message string
+		if err := rows.Scan(&id, &timestamp, &message); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("reading outbox failed: %w", err)
+		}
+		ids = append(ids, id)
+		logMessages = append(logMessages, json.RawMessage(message))
+		timestamps = append(timestamps, time.Unix(0, timestamp))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading outbox failed: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := o.target.WriteLogMessages(logMessages, timestamps); err != nil {
+		return 0, fmt.Errorf("relaying outbox records failed: %w", err)
+	}
+	// delete relayed records by id, since records with lower ids may be committed later
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = o.placeholder(i + 1)
+	}
+	query = fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", o.table, strings.Join(placeholders, ", "))
+	if _, err := o.db.ExecContext(ctx, query, ids...); err != nil {
+		return len(ids), fmt.Errorf("deleting relayed records from outbox failed: %w", err)
+	}
+	return len(ids), nil
+}
+
+// WriteLogMessages writes LogMessages that aren't part of a transaction directly to the target writer
+func (o *outbox) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.target.WriteLogMessages(logMessages, timestamps)
+}
+
+func (o *outbox) PropertiesSchemaChanged(schema map[string]Kind) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.target.PropertiesSchemaChanged(schema)
+}
+
+// Validate validates the target writer if it implements Validator
+func (o *outbox) Validate(ctx context.Context) error {
+	if validator, ok := o.target.(Validator); ok {
+		return validator.Validate(ctx)
+	}
+	return nil
+}
+
+// Credentials returns the credentials of the target writer
+func (o *outbox) Credentials() []*RefreshingToken {
+	return credentialsOf(o.target)
+}
+
+// Close stops relaying, relays the remaining committed records and closes the target writer
+func (o *outbox) Close() {
+	if o.stop != nil {
+		close(o.stop)
+		<-o.done
+		o.stop = nil
+		o.relayAll()
+	}
+	o.target.Close()
+}
@@ -0,0 +1,175 @@
+package logwriter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// outboxTestDB is a minimal database/sql driver that only understands the outbox statements
+type outboxTestDB struct {
+	mutex  sync.Mutex
+	nextID int64
+	rows   [][]driver.Value // committed rows (id, timestamp, message)
+}
+
+func (db *outboxTestDB) Open(name string) (driver.Conn, error) { return &outboxTestConn{db: db}, nil }
+
+type outboxTestConn struct {
+	db      *outboxTestDB
+	pending [][]driver.Value // rows inserted within the transaction
+	inTx    bool
+}
+
+func (c *outboxTestConn) Prepare(query string) (driver.Stmt, error) {
+	return &outboxTestStmt{conn: c, query: query}, nil
+}
+func (c *outboxTestConn) Close() error              { return nil }
+func (c *outboxTestConn) Begin() (driver.Tx, error) { c.inTx = true; return c, nil }
+func (c *outboxTestConn) Commit() error {
+	c.db.mutex.Lock()
+	c.db.rows = append(c.db.rows, c.pending...)
+	c.db.mutex.Unlock()
+	c.pending, c.inTx = nil, false
+	return nil
+}
+func (c *outboxTestConn) Rollback() error { c.pending, c.inTx = nil, false; return nil }
+
+type outboxTestStmt struct {
+	conn  *outboxTestConn
+	query string
+}
+
+func (s *outboxTestStmt) Close() error  { return nil }
+func (s *outboxTestStmt) NumInput() int { return -1 }
+func (s *outboxTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "INSERT"):
+		db.nextID++
+		row := []driver.Value{db.nextID, args[0], args[1]}
+		if s.conn.inTx {
+			s.conn.pending = append(s.conn.pending, row)
+		} else {
+			db.rows = append(db.rows, row)
+		}
+	case strings.HasPrefix(s.query, "DELETE"):
+		kept := db.rows[:0]
+		for _, row := range db.rows {
+			deleted := false
+			for _, id := range args {
+				deleted = deleted || row[0] == id
+			}
+			if !deleted {
+				kept = append(kept, row)
+			}
+		}
+		db.rows = kept
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *outboxTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return &outboxTestRows{rows: append([][]driver.Value{}, db.rows...)}, nil
+}
+
+type outboxTestRows struct {
+	rows [][]driver.Value
+}
+
+func (r *outboxTestRows) Columns() []string { return []string{"id", "timestamp", "message"} }
+func (r *outboxTestRows) Close() error      { return nil }
+func (r *outboxTestRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestOutboxWriter(t *testing.T) {
+	testDB := &outboxTestDB{}
+	sql.Register("outboxtest", testDB)
+	db, err := sql.Open("outboxtest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Setenv("LOGTHING_OUTBOX_POLL_INTERVAL", "1h")
+	target := &testWriter{}
+	lw := NewOutboxWriter(db, target)
+	if err := lw.Init(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	txWriter := lw.(TxWriter)
+	msg := []json.RawMessage{json.RawMessage(`{"output":"committed"}`)}
+
+	tx, _ := db.Begin()
+	if err := txWriter.WriteLogMessagesTx(context.Background(), tx, msg, []time.Time{time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	tx, _ = db.Begin()
+	txWriter.WriteLogMessagesTx(context.Background(), tx, []json.RawMessage{json.RawMessage(`{"output":"rolled back"}`)}, []time.Time{time.Now()})
+	tx.Rollback()
+
+	if n, err := lw.(*outbox).relay(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected 1 relayed record, got %v (%v)", n, err)
+	}
+	if len(target.messages) != 1 || string(target.messages[0]) != string(msg[0]) {
+		t.Errorf("unexpected relayed messages: %s", target.messages)
+	}
+	if len(testDB.rows) != 0 {
+		t.Errorf("expected relayed records to be deleted, got %v", testDB.rows)
+	}
+	lw.Close()
+	if !target.closed {
+		t.Error("expected target writer to be closed")
+	}
+}
+
+func TestOutboxWriterRelayError(t *testing.T) {
+	sql.Register("outboxtest-relay", &outboxTestDB{})
+	db, err := sql.Open("outboxtest-relay", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Setenv("LOGTHING_OUTBOX_POLL_INTERVAL", "10ms")
+	target := &testWriter{err: errors.New("unavailable")}
+	lw := NewOutboxWriter(db, target)
+	errs := make(chan error, 16)
+	reportError := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	if err := lw.Init(Config{ReportError: reportError}); err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+	tx, _ := db.Begin()
+	lw.(TxWriter).WriteLogMessagesTx(context.Background(), tx, []json.RawMessage{json.RawMessage(`{}`)}, []time.Time{time.Now()})
+	tx.Commit()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, target.err) {
+			t.Errorf("expected relay error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected relay error to be reported")
+	}
+}
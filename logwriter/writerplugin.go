@@ -0,0 +1,232 @@
+package logwriter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pluginRequest is sent as JSON line to the plugin process
+type pluginRequest struct {
+	Type       string            `json:"type"` // "init", "schema", "batch" or "close"
+	LogName    string            `json:"logName,omitempty"`
+	Schema     map[string]string `json:"schema,omitempty"`
+	Messages   []json.RawMessage `json:"messages,omitempty"`
+	Timestamps []time.Time       `json:"timestamps,omitempty"`
+}
+
+// pluginResponse is expected as JSON line from the plugin process for every request
+type pluginResponse struct {
+	Error   string `json:"error,omitempty"`
+	Disable bool   `json:"disable,omitempty"` // if true, the writer is disabled (see ErrWriterDisable)
+}
+
+// Plugin log writer that forwards batches to an external process
+type plugin struct {
+	mutex     sync.Mutex
+	command   []string
+	timeout   time.Duration
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan []byte
+	stopped   chan struct{} // closed when the process is killed, so that the response reader doesn't block
+	logName   string
+	schema    map[string]string
+}
+
+// NewPluginWriter returns new LogWriter that forwards LogMessages to an external plugin process, so that writers can be
+// implemented in other languages or flaky vendor SDKs can be isolated from the main process.
+//
+// The plugin process is started with the given command and receives one JSON request per line on stdin:
+//
+//	{"type":"init","logName":"..."}
+//	{"type":"schema","schema":{"property":"string",...}}
+//	{"type":"batch","messages":[{...},...],"timestamps":["2022-01-02T03:04:05Z",...]}
+//	{"type":"close"}
+//
+// For every request it must respond with one JSON line on stdout: {} on success, {"error":"..."} on failure or
+// {"error":"...","disable":true} if the writer shall be disabled. Stderr of the plugin is forwarded to stderr. If the
+// process dies or doesn't respond within the timeout, it's killed and restarted with the next request.
+//
+// The following environment variables are used to configure the behaviour:
+// LOGTHING_PLUGIN_COMMAND - Command (with space separated arguments) to start the plugin process
+// LOGTHING_PLUGIN_TIMEOUT - (optional) max duration to wait for a response (default: 30s)
+func NewPluginWriter() LogWriter {
+	writer := &plugin{
+		command: strings.Fields(Getenv("LOGTHING_PLUGIN_COMMAND")),
+		timeout: 30 * time.Second,
+	}
+	if timeout, err := time.ParseDuration(Getenv("LOGTHING_PLUGIN_TIMEOUT")); err == nil && timeout > 0 {
+		writer.timeout = timeout
+	}
+	return writer
+}
+
+// Name returns the plugin's command as writer name
+func (p *plugin) Name() string {
+	if len(p.command) == 0 {
+		return "plugin"
+	}
+	return "plugin(" + p.command[0] + ")"
+}
+
+func (p *plugin) Init(config Config) error {
+	if len(p.command) == 0 {
+		return fmt.Errorf("environment variable \"LOGTHING_PLUGIN_COMMAND\" must be set")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.logName = config.LogName
+	if err := p.start(); err != nil {
+		p.kill()
+		return err
+	}
+	return nil
+}
+
+// start starts the plugin process and sends the init (and the last schema) request. Mutex must be locked.
+func (p *plugin) start() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting plugin process failed: %w", err)
+	}
+	responses := make(chan []byte)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(responses)
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			select {
+			case responses <- line:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+	p.cmd, p.stdin, p.responses, p.stopped = cmd, stdin, responses, stopped
+	if err := p.request(pluginRequest{Type: "init", LogName: p.logName}); err != nil {
+		return fmt.Errorf("plugin init failed: %w", err)
+	}
+	if p.schema != nil {
+		return p.request(pluginRequest{Type: "schema", Schema: p.schema})
+	}
+	return nil
+}
+
+// kill kills the plugin process. Mutex must be locked.
+func (p *plugin) kill() {
+	if p.cmd == nil {
+		return
+	}
+	close(p.stopped)
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cmd = nil
+}
+
+// request sends the request and waits for the response. If the process doesn't read the request or doesn't respond
+// within the timeout, it's killed. Mutex must be locked.
+func (p *plugin) request(req pluginRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	written := make(chan error, 1)
+	go func(stdin io.Writer) {
+		_, err := stdin.Write(append(data, '\n'))
+		written <- err // the write is unblocked by kill when the timeout expired
+	}(p.stdin)
+	select {
+	case err := <-written:
+		if err != nil {
+			p.kill()
+			return fmt.Errorf("sending request to plugin failed: %w", err)
+		}
+	case <-timer.C:
+		p.kill()
+		return fmt.Errorf("plugin didn't read the request within %v", p.timeout)
+	}
+	select {
+	case line, ok := <-p.responses:
+		if !ok {
+			p.kill()
+			return fmt.Errorf("plugin process exited")
+		}
+		var resp pluginResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return fmt.Errorf("invalid plugin response %q: %w", line, err)
+		}
+		switch {
+		case resp.Disable:
+			return fmt.Errorf("%v: %w", resp.Error, ErrWriterDisable)
+		case resp.Error != "":
+			return errors.New(resp.Error)
+		}
+		return nil
+	case <-timer.C:
+		p.kill()
+		return fmt.Errorf("plugin didn't respond within %v", p.timeout)
+	}
+}
+
+// send sends the request and (re)starts the plugin process if it isn't running
+func (p *plugin) send(req pluginRequest) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			p.kill()
+			return err
+		}
+	}
+	return p.request(req)
+}
+
+func (p *plugin) PropertiesSchemaChanged(schema map[string]Kind) error {
+	kinds := make(map[string]string, len(schema))
+	for property, kind := range schema {
+		kinds[property] = kind.String()
+	}
+	p.mutex.Lock()
+	p.schema = kinds
+	p.mutex.Unlock()
+	return p.send(pluginRequest{Type: "schema", Schema: kinds})
+}
+
+func (p *plugin) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	return p.send(pluginRequest{Type: "batch", Messages: logMessages, Timestamps: timestamps})
+}
+
+// Close sends the close request and waits until the process exited
+func (p *plugin) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cmd == nil {
+		return
+	}
+	p.request(pluginRequest{Type: "close"})
+	p.kill()
+}
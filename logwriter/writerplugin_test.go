@@ -0,0 +1,91 @@
+package logwriter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginHelperProcess isn't a real test, it's the plugin process started by TestPluginWriter. The process fails
+// the first batch with 2 messages by exiting.
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("LOGTHING_TEST_PLUGIN_PROCESS") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req pluginRequest
+		json.Unmarshal(scanner.Bytes(), &req)
+		switch {
+		case req.Type == "batch" && len(req.Messages) == 2 && os.Getenv("LOGTHING_TEST_PLUGIN_CRASH") == "1":
+			os.Exit(1)
+		case req.Type == "init" && os.Getenv("LOGTHING_TEST_PLUGIN_STUCK") == "1":
+			fmt.Println(`{}`)
+			select {} // stops reading stdin
+		case req.Type == "batch" && len(req.Messages) == 0:
+			fmt.Println(`{"error":"empty batch"}`)
+		default:
+			fmt.Println(`{}`)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestPluginWriter(t *testing.T) {
+	t.Setenv("LOGTHING_TEST_PLUGIN_PROCESS", "1")
+	t.Setenv("LOGTHING_TEST_PLUGIN_CRASH", "1")
+	t.Setenv("LOGTHING_PLUGIN_COMMAND", os.Args[0]+" -test.run=^TestPluginHelperProcess$")
+	t.Setenv("LOGTHING_PLUGIN_TIMEOUT", "5s")
+	lw := NewPluginWriter()
+	if err := lw.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+	if err := lw.PropertiesSchemaChanged(map[string]Kind{"output": String}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := []json.RawMessage{json.RawMessage(`{"output":"a"}`)}
+	if err := lw.WriteLogMessages(msgs, []time.Time{time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.WriteLogMessages(nil, nil); err == nil || err.Error() != "empty batch" {
+		t.Errorf("expected plugin error, got %v", err)
+	}
+	// plugin process crashes and is restarted with the next batch
+	msgs = append(msgs, json.RawMessage(`{"output":"b"}`))
+	if err := lw.WriteLogMessages(msgs, []time.Time{time.Now(), time.Now()}); err == nil {
+		t.Error("expected error of crashed plugin")
+	}
+	if err := lw.WriteLogMessages(msgs[:1], []time.Time{time.Now()}); err != nil {
+		t.Errorf("expected restarted plugin, got %v", err)
+	}
+}
+
+func TestPluginWriterTimeout(t *testing.T) {
+	t.Setenv("LOGTHING_TEST_PLUGIN_PROCESS", "1")
+	t.Setenv("LOGTHING_TEST_PLUGIN_STUCK", "1")
+	t.Setenv("LOGTHING_PLUGIN_COMMAND", os.Args[0]+" -test.run=^TestPluginHelperProcess$")
+	t.Setenv("LOGTHING_PLUGIN_TIMEOUT", "1s")
+	lw := NewPluginWriter()
+	if err := lw.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+	msgs := []json.RawMessage{json.RawMessage(`{"output":"a"}`)}
+	if err := lw.WriteLogMessages(msgs, []time.Time{time.Now()}); err == nil {
+		t.Error("expected timeout of stuck plugin")
+	}
+	// the batch exceeds the pipe buffer, so that writing the request blocks until the timeout expires
+	msgs = []json.RawMessage{json.RawMessage(`{"output":"` + strings.Repeat("a", 1<<20) + `"}`)}
+	start := time.Now()
+	if err := lw.WriteLogMessages(msgs, []time.Time{time.Now()}); err == nil {
+		t.Error("expected timeout of stuck plugin")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the request to time out, took %v", elapsed)
+	}
+}
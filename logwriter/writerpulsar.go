@@ -0,0 +1,172 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pulsar log writer
+type pulsar struct {
+	serviceURL   string
+	topic        string
+	token        string
+	valueSchema  string
+	producerName string
+	topicURL     string
+	adminURL     string
+	httpClient   *http.Client
+	httpCompression
+}
+
+type pulsarMessage struct {
+	Key        string            `json:"key,omitempty"`
+	Payload    string            `json:"payload"`
+	Properties map[string]string `json:"properties,omitempty"`
+	EventTime  int64             `json:"eventTime"`
+}
+
+type pulsarProducerMessages struct {
+	ProducerName string          `json:"producerName,omitempty"`
+	ValueSchema  string          `json:"valueSchema,omitempty"`
+	Messages     []pulsarMessage `json:"messages"`
+}
+
+// NewPulsarWriter returns new LogWriter that publishes LogMessages to an Apache Pulsar topic using the REST producer API
+// see also: https://pulsar.apache.org/docs/next/client-libraries-rest/
+//
+// Every log message is published as a single Pulsar message with its trackingID as message key, so that consumers
+// with Key_Shared subscription receive all messages of a tracking ID in order. The message type is set as "type" message property.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_PULSAR_WEB_SERVICE_URL - Pulsar web service URL (e.g. "http://localhost:8080")
+// LOGTHING_PULSAR_TOPIC           - Topic to publish to (e.g. "persistent://public/default/logs" or "public/default/logs")
+// LOGTHING_PULSAR_TOKEN           - (optional) JWT token for authentication
+// LOGTHING_PULSAR_VALUE_SCHEMA    - (optional) JSON encoded SchemaInfo of the message values (default: STRING schema)
+func NewPulsarWriter() LogWriter {
+	writer := &pulsar{
+		serviceURL:  strings.TrimSuffix(Getenv("LOGTHING_PULSAR_WEB_SERVICE_URL"), "/"),
+		topic:       Getenv("LOGTHING_PULSAR_TOPIC"),
+		token:       Getenv("LOGTHING_PULSAR_TOKEN"),
+		valueSchema: Getenv("LOGTHING_PULSAR_VALUE_SCHEMA"),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+	return writer
+}
+
+// pulsarTopicPath converts topic name into REST API path (e.g. "persistent/public/default/logs")
+func pulsarTopicPath(topic string) (string, error) {
+	domain := "persistent"
+	if i := strings.Index(topic, "://"); i >= 0 {
+		domain = topic[:i]
+		topic = topic[i+3:]
+	}
+	if domain != "persistent" && domain != "non-persistent" {
+		return "", fmt.Errorf("invalid topic domain %q", domain)
+	}
+	if parts := strings.Split(topic, "/"); len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("topic %q must be of format tenant/namespace/topic", topic)
+	}
+	return domain + "/" + topic, nil
+}
+
+func (p *pulsar) Init(config Config) error {
+	if p.serviceURL == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_PULSAR_WEB_SERVICE_URL\" must be set")
+	}
+	if p.topic == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_PULSAR_TOPIC\" must be set")
+	}
+	topicPath, err := pulsarTopicPath(p.topic)
+	if err != nil {
+		return fmt.Errorf("environment variable \"LOGTHING_PULSAR_TOPIC\" invalid: %w", err)
+	}
+	if p.valueSchema != "" && !json.Valid([]byte(p.valueSchema)) {
+		return fmt.Errorf("environment variable \"LOGTHING_PULSAR_VALUE_SCHEMA\" must be valid JSON")
+	}
+	p.producerName = config.LogName
+	p.topicURL = p.serviceURL + "/topics/" + topicPath
+	p.adminURL = p.serviceURL + "/admin/v2/" + topicPath
+	return nil
+}
+
+func (p *pulsar) Close() {
+}
+
+func (p *pulsar) PropertiesSchemaChanged(schema map[string]Kind) error {
+	return nil
+}
+
+// Validate requests the topic's partitioned metadata to check the authentication and that the topic can be accessed
+func (p *pulsar) Validate(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.adminURL+"/partitions", nil)
+	if err != nil {
+		return err
+	}
+	if p.token != "" {
+		req.Header.Add("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Requesting pulsar topic failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Requesting pulsar topic failed (Code: %v): %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (p *pulsar) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	producerMessages := pulsarProducerMessages{
+		ProducerName: p.producerName,
+		ValueSchema:  p.valueSchema,
+		Messages:     make([]pulsarMessage, len(logMessages)),
+	}
+	for i, logMessage := range logMessages {
+		var keys struct {
+			Type       string `json:"type"`
+			TrackingID string `json:"trackingID"`
+		}
+		json.Unmarshal(logMessage, &keys)
+		producerMessages.Messages[i] = pulsarMessage{
+			Key:        keys.TrackingID,
+			Payload:    string(logMessage),
+			Properties: map[string]string{"type": keys.Type},
+			EventTime:  timestamps[i].UnixNano() / int64(time.Millisecond),
+		}
+	}
+	postData, err := json.Marshal(producerMessages)
+	if err != nil {
+		return fmt.Errorf("Marshalling producer messages failed: %w", err)
+	}
+
+	resp, err := p.do(p.httpClient, func(body io.Reader) (*http.Request, error) {
+		req, err := http.NewRequest("POST", p.topicURL, body)
+		if err != nil {
+			return nil, fmt.Errorf("Creating POST request failed: %v: %w", err, ErrWriterDisable)
+		}
+		req.Header.Add("Content-Type", "application/json")
+		if p.token != "" {
+			req.Header.Add("Authorization", "Bearer "+p.token)
+		}
+		return req, nil
+	}, postData)
+	if err != nil {
+		return fmt.Errorf("Sending LogMessages to pulsar failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return DisableError(fmt.Errorf("Sending LogMessages to pulsar failed (Code: %v)", resp.StatusCode), true)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Sending LogMessages to pulsar failed (Code: %v): %s", resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPulsarTopicPath(t *testing.T) {
+	tests := []struct {
+		topic    string
+		expected string
+		invalid  bool
+	}{
+		{topic: "public/default/logs", expected: "persistent/public/default/logs"},
+		{topic: "persistent://public/default/logs", expected: "persistent/public/default/logs"},
+		{topic: "non-persistent://public/default/logs", expected: "non-persistent/public/default/logs"},
+		{topic: "kafka://public/default/logs", invalid: true},
+		{topic: "default/logs", invalid: true},
+		{topic: "public//logs", invalid: true},
+		{topic: "public/default/logs/extra", invalid: true},
+	}
+	for _, test := range tests {
+		path, err := pulsarTopicPath(test.topic)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("expected error for topic %q, got %q", test.topic, path)
+			}
+			continue
+		}
+		if err != nil || path != test.expected {
+			t.Errorf("expected path %q for topic %q, got %q: %v", test.expected, test.topic, path, err)
+		}
+	}
+}
+
+func TestPulsarWriteLogMessages(t *testing.T) {
+	var requests []*http.Request
+	var producerMessages pulsarProducerMessages
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &producerMessages)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	t.Setenv("LOGTHING_PULSAR_WEB_SERVICE_URL", server.URL+"/")
+	t.Setenv("LOGTHING_PULSAR_TOPIC", "public/default/logs")
+	t.Setenv("LOGTHING_PULSAR_TOKEN", "token")
+	t.Setenv("LOGTHING_PULSAR_VALUE_SCHEMA", `{"type":"JSON"}`)
+	writer := NewPulsarWriter()
+	if err := writer.Init(Config{LogName: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Unix(1600000000, 123000000)
+	logMessage := json.RawMessage(`{"type":"foo","trackingID":"t1","output":["a"]}`)
+	if err := writer.WriteLogMessages([]json.RawMessage{logMessage}, []time.Time{timestamp}); err != nil {
+		t.Fatal(err)
+	}
+	req := requests[0]
+	if req.Method != http.MethodPost || req.URL.Path != "/topics/persistent/public/default/logs" ||
+		req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected request: %v %v %v", req.Method, req.URL.Path, req.Header)
+	}
+	if producerMessages.ProducerName != "app" || producerMessages.ValueSchema != `{"type":"JSON"}` || len(producerMessages.Messages) != 1 {
+		t.Fatalf("unexpected producer messages: %+v", producerMessages)
+	}
+	msg := producerMessages.Messages[0]
+	if msg.Key != "t1" || msg.Payload != string(logMessage) || msg.Properties["type"] != "foo" || msg.EventTime != 1600000000123 {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+
+	status = http.StatusInternalServerError
+	if err := writer.WriteLogMessages([]json.RawMessage{logMessage}, []time.Time{timestamp}); err == nil || errors.Is(err, ErrWriterDisable) {
+		t.Errorf("expected retryable error, got %v", err)
+	}
+	status = http.StatusUnauthorized
+	if err := writer.WriteLogMessages([]json.RawMessage{logMessage}, []time.Time{timestamp}); !errors.Is(err, ErrWriterDisable) {
+		t.Errorf("expected writer to be disabled, got %v", err)
+	}
+	if err := writer.(Validator).Validate(context.Background()); err == nil {
+		t.Errorf("expected validation to fail")
+	}
+	status = http.StatusOK
+	if err := writer.(Validator).Validate(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if req := requests[len(requests)-1]; req.Method != http.MethodGet || req.URL.Path != "/admin/v2/persistent/public/default/logs/partitions" {
+		t.Errorf("unexpected validation request: %v %v", req.Method, req.URL.Path)
+	}
+}
@@ -0,0 +1,195 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pendingBatch is a batch that couldn't be written to a replica yet
+type pendingBatch struct {
+	logMessages []json.RawMessage
+	timestamps  []time.Time
+}
+
+// replica is a writer of the replicating writer with its own retry state
+type replica struct {
+	writer  LogWriter
+	pending []pendingBatch
+}
+
+// Replicating log writer
+type replicating struct {
+	replicas   []*replica
+	mutex      sync.Mutex // guards replacing replicas, which are read concurrently with writes (e.g. by Validate)
+	maxPending int
+}
+
+// NewReplicatingWriter returns new LogWriter that writes every batch of LogMessages to both, the primary and the replica
+// writer (e.g. workspaces in different regions), for DR requirements where the outage of a single region must not lose logs.
+// Each writer has its own retry state: Batches that fail are kept and retried before the next batch is written to that
+// writer, without affecting the other one. An error is only returned if the batch couldn't be written to any writer.
+// Writers that fail to init or return ErrWriterDisable are closed and removed.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_REPLICATION_MAX_PENDING_BATCHES - (optional) max number of failed batches that are kept per writer for retry, older ones are dropped (default: 100)
+func NewReplicatingWriter(primary LogWriter, replicaWriter LogWriter) LogWriter {
+	writer := &replicating{
+		maxPending: 100,
+	}
+	if n, err := strconv.Atoi(Getenv("LOGTHING_REPLICATION_MAX_PENDING_BATCHES")); err == nil && n >= 0 {
+		writer.maxPending = n
+	}
+	for _, lw := range []LogWriter{primary, replicaWriter} {
+		if lw != nil {
+			writer.replicas = append(writer.replicas, &replica{writer: lw})
+		}
+	}
+	return writer
+}
+
+func (r *replicating) Init(config Config) error {
+	var replicas []*replica
+	var initErrors []error
+	for _, rep := range r.replicas {
+		if err := rep.writer.Init(config); err != nil {
+			initErrors = append(initErrors, err)
+			continue
+		}
+		replicas = append(replicas, rep)
+	}
+	r.mutex.Lock()
+	r.replicas = replicas
+	r.mutex.Unlock()
+	if len(replicas) == 0 {
+		return fmt.Errorf("init of all replicating writers failed: %v", initErrors)
+	}
+	return nil
+}
+
+// currentReplicas returns a snapshot of the replicas
+func (r *replicating) currentReplicas() []*replica {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.replicas
+}
+
+// Close tries to write the pending batches once more and closes the writers
+func (r *replicating) Close() {
+	for _, rep := range r.currentReplicas() {
+		rep.retry()
+		rep.writer.Close()
+	}
+	r.mutex.Lock()
+	r.replicas = nil
+	r.mutex.Unlock()
+}
+
+func (r *replicating) PropertiesSchemaChanged(schema map[string]Kind) error {
+	var errs []error
+	for _, rep := range r.currentReplicas() {
+		if err := rep.writer.PropertiesSchemaChanged(schema); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("schema change of replicating writers failed: %v", errs)
+	}
+	return nil
+}
+
+// Validate validates all writers that implement Validator
+func (r *replicating) Validate(ctx context.Context) error {
+	replicas := r.currentReplicas()
+	var errs []error
+	for _, rep := range replicas {
+		if validator, ok := rep.writer.(Validator); ok {
+			if err := validator.Validate(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("validation of replicating writers failed: %v", errs)
+	}
+	return nil
+}
+
+// retry writes the pending batches in order until one fails
+func (rep *replica) retry() error {
+	for len(rep.pending) > 0 {
+		batch := rep.pending[0]
+		if err := rep.writer.WriteLogMessages(batch.logMessages, batch.timestamps); err != nil {
+			return err
+		}
+		rep.pending = rep.pending[1:]
+	}
+	return nil
+}
+
+// enqueue keeps the batch for retry. If more than maxPending batches are pending, the oldest is dropped.
+func (rep *replica) enqueue(batch pendingBatch, maxPending int) {
+	if maxPending <= 0 {
+		return
+	}
+	rep.pending = append(rep.pending, batch)
+	if len(rep.pending) > maxPending {
+		rep.pending = rep.pending[1:]
+	}
+}
+
+func (r *replicating) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	batch := pendingBatch{logMessages: logMessages, timestamps: timestamps}
+	var errs []error
+	var replicas []*replica
+	written := false
+	for _, rep := range r.currentReplicas() {
+		err := rep.retry()
+		if err == nil {
+			err = rep.writer.WriteLogMessages(logMessages, timestamps)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if errors.Is(err, ErrWriterDisable) {
+				rep.writer.Close()
+				continue
+			}
+			rep.enqueue(batch, r.maxPending)
+		} else {
+			written = true
+		}
+		replicas = append(replicas, rep)
+	}
+	r.mutex.Lock()
+	r.replicas = replicas
+	r.mutex.Unlock()
+	if len(replicas) == 0 {
+		return fmt.Errorf("all replicating writers disabled: %v: %w", errs, ErrWriterDisable)
+	}
+	if !written {
+		return fmt.Errorf("writing to all replicas failed: %v", errs)
+	}
+	return nil
+}
+
+// Credentials returns the tokens of all writers that implement CredentialRefresher
+func (r *replicating) Credentials() (tokens []*RefreshingToken) {
+	for _, rep := range r.currentReplicas() {
+		tokens = append(tokens, credentialsOf(rep.writer)...)
+	}
+	return tokens
+}
+
+// OrderInsensitive returns true if the order of the LogMessages doesn't matter for any replica
+func (r *replicating) OrderInsensitive() bool {
+	for _, rep := range r.currentReplicas() {
+		if !isOrderInsensitive(rep.writer) {
+			return false
+		}
+	}
+	return true
+}
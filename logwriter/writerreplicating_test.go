@@ -0,0 +1,39 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplicatingWriter(t *testing.T) {
+	primary := &testWriter{}
+	replica := &testWriter{err: errors.New("region outage")}
+	lw := NewReplicatingWriter(primary, replica)
+	if err := lw.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := []json.RawMessage{json.RawMessage(`{}`)}
+	timestamps := []time.Time{time.Now()}
+	if err := lw.WriteLogMessages(msgs, timestamps); err != nil {
+		t.Errorf("expected no error while primary is healthy: %v", err)
+	}
+	if primary.written != 1 || replica.written != 0 {
+		t.Errorf("unexpected written messages: %v %v", primary.written, replica.written)
+	}
+	replica.err = nil
+	if err := lw.WriteLogMessages(msgs, timestamps); err != nil {
+		t.Fatal(err)
+	}
+	if primary.written != 2 || replica.written != 2 {
+		t.Errorf("expected replica to catch up, got %v %v", primary.written, replica.written)
+	}
+}
+
+func TestReplicatingWriterConcurrentReads(t *testing.T) {
+	lw := NewReplicatingWriter(&testWriter{err: ErrWriterDisable}, &testWriter{})
+	readConcurrently(t, lw, func() {
+		lw.WriteLogMessages([]json.RawMessage{json.RawMessage(`{}`)}, []time.Time{time.Now()})
+	})
+}
@@ -0,0 +1,261 @@
+package logwriter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+const (
+	serviceBusStandardMaxMessageSize = 256 * 1024
+	serviceBusPremiumMaxMessageSize  = 1024 * 1024
+	serviceBusScope                  = "https://servicebus.azure.net/.default"
+)
+
+// Azure Service Bus log writer
+type serviceBus struct {
+	connectionString string
+	namespace        string
+	entity           string
+	sasKeyName       string
+	sasKey           string
+	maxMessageSize   int
+	token            *RefreshingToken
+	entityURL        string
+	httpClient       *http.Client
+	httpCompression
+}
+
+type serviceBusMessage struct {
+	Body             string                 `json:"Body"`
+	BrokerProperties map[string]interface{} `json:"BrokerProperties,omitempty"`
+	UserProperties   map[string]interface{} `json:"UserProperties,omitempty"`
+}
+
+// NewServiceBusWriter returns new LogWriter that sends LogMessages to an Azure Service Bus queue or topic
+// see also: https://learn.microsoft.com/en-us/rest/api/servicebus/send-message-batch
+//
+// Authentication happens either with shared access signature from the connection string or, if no connection string is set,
+// with AAD (see azidentity.NewDefaultAzureCredential). Log messages are sent in batches that respect the maximum message size
+// of the service bus tier (standard: 256KB, premium: 1MB). Single log messages that exceed the maximum size are dropped.
+//
+// The following environemnt variables are used be used to configure the behaviour:
+// LOGTHING_SERVICEBUS_CONNECTION_STRING - (optional) connection string with shared access key (and optionally EntityPath)
+// LOGTHING_SERVICEBUS_NAMESPACE         - (optional) fully qualified namespace for AAD authentication (e.g. "myns.servicebus.windows.net")
+// LOGTHING_SERVICEBUS_ENTITY            - Queue or topic name (if not given via EntityPath of the connection string)
+// LOGTHING_SERVICEBUS_TIER              - (optional) "standard" (default) or "premium"
+// LOGTHING_SERVICEBUS_MAX_MESSAGE_SIZE  - (optional) max message size in bytes to overwrite the tier's default
+func NewServiceBusWriter() LogWriter {
+	writer := &serviceBus{
+		connectionString: Getenv("LOGTHING_SERVICEBUS_CONNECTION_STRING"),
+		namespace:        Getenv("LOGTHING_SERVICEBUS_NAMESPACE"),
+		entity:           Getenv("LOGTHING_SERVICEBUS_ENTITY"),
+		maxMessageSize:   serviceBusStandardMaxMessageSize,
+		httpClient:       &http.Client{Timeout: 30 * time.Second},
+	}
+	if strings.EqualFold(Getenv("LOGTHING_SERVICEBUS_TIER"), "premium") {
+		writer.maxMessageSize = serviceBusPremiumMaxMessageSize
+	}
+	if size, err := strconv.Atoi(Getenv("LOGTHING_SERVICEBUS_MAX_MESSAGE_SIZE")); err == nil && size > 0 {
+		writer.maxMessageSize = size
+	}
+	return writer
+}
+
+// parseServiceBusConnectionString parses "Endpoint=sb://...;SharedAccessKeyName=...;SharedAccessKey=...;EntityPath=..."
+func parseServiceBusConnectionString(connectionString string) (namespace, keyName, key, entity string, err error) {
+	for _, part := range strings.Split(connectionString, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "endpoint":
+			u, parseErr := url.Parse(kv[1])
+			if parseErr != nil {
+				err = parseErr
+				return
+			}
+			namespace = u.Host
+		case "sharedaccesskeyname":
+			keyName = kv[1]
+		case "sharedaccesskey":
+			key = kv[1]
+		case "entitypath":
+			entity = kv[1]
+		}
+	}
+	if namespace == "" || keyName == "" || key == "" {
+		err = fmt.Errorf("Endpoint, SharedAccessKeyName and SharedAccessKey are required")
+	}
+	return
+}
+
+func (sb *serviceBus) Init(config Config) (err error) {
+	if sb.connectionString != "" {
+		var entity string
+		sb.namespace, sb.sasKeyName, sb.sasKey, entity, err = parseServiceBusConnectionString(sb.connectionString)
+		if err != nil {
+			return fmt.Errorf("environment variable \"LOGTHING_SERVICEBUS_CONNECTION_STRING\" invalid: %w", err)
+		}
+		if sb.entity == "" {
+			sb.entity = entity
+		}
+	} else {
+		if sb.namespace == "" {
+			return fmt.Errorf("environment variable \"LOGTHING_SERVICEBUS_CONNECTION_STRING\" or \"LOGTHING_SERVICEBUS_NAMESPACE\" must be set")
+		}
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return fmt.Errorf("cannot create AAD credential: %w", err)
+		}
+		sb.token = NewRefreshingToken(aadTokenSource(credential), 5*time.Minute)
+	}
+	if sb.entity == "" {
+		return fmt.Errorf("environment variable \"LOGTHING_SERVICEBUS_ENTITY\" must be set")
+	}
+	sb.entityURL = "https://" + sb.namespace + "/" + sb.entity + "/messages"
+	if sb.token == nil {
+		sb.token = NewRefreshingToken(sb.sasToken, 5*time.Minute)
+	}
+	return nil
+}
+
+// aadTokenSource returns TokenSource for AAD bearer tokens of the service bus scope
+func aadTokenSource(credential azcore.TokenCredential) TokenSource {
+	return func(ctx context.Context) (Token, error) {
+		token, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{serviceBusScope}})
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Value: "Bearer " + token.Token, ExpiresOn: token.ExpiresOn}, nil
+	}
+}
+
+// sasToken creates a shared access signature token that is valid for one hour
+func (sb *serviceBus) sasToken(ctx context.Context) (Token, error) {
+	resource := url.QueryEscape("https://" + sb.namespace + "/" + sb.entity)
+	expiresOn := time.Now().Add(time.Hour)
+	expiry := strconv.FormatInt(expiresOn.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(sb.sasKey))
+	mac.Write([]byte(resource + "\n" + expiry))
+	signature := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return Token{
+		Value:     fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", resource, signature, expiry, sb.sasKeyName),
+		ExpiresOn: expiresOn,
+	}, nil
+}
+
+// Credentials returns the token that is used for authorization
+func (sb *serviceBus) Credentials() []*RefreshingToken {
+	if sb.token == nil {
+		return nil
+	}
+	return []*RefreshingToken{sb.token}
+}
+
+func (sb *serviceBus) Close() {
+}
+
+func (sb *serviceBus) PropertiesSchemaChanged(schema map[string]Kind) error {
+	return nil
+}
+
+// Capabilities returns the capabilities of the Service Bus writer
+func (sb *serviceBus) Capabilities() Capabilities {
+	return Capabilities{Compression: true, Format: FormatJSONArray}
+}
+
+// Validate checks that an authorization (SAS token or AAD bearer token) can be created
+func (sb *serviceBus) Validate(ctx context.Context) error {
+	if _, err := sb.authorization(); err != nil {
+		return fmt.Errorf("Getting service bus authorization failed: %w", err)
+	}
+	return nil
+}
+
+// authorization returns the authorization header value (SAS token or AAD bearer token)
+func (sb *serviceBus) authorization() (string, error) {
+	return sb.token.Token(context.Background())
+}
+
+// serviceBusBatches converts log messages into service bus messages and partitions them into batches that don't exceed maxSize
+func serviceBusBatches(logMessages []json.RawMessage, maxSize int) (batches [][]json.RawMessage, dropped int) {
+	var batch []json.RawMessage
+	batchSize := 2 // enclosing brackets
+	for _, logMessage := range logMessages {
+		var keys struct {
+			Type       string `json:"type"`
+			TrackingID string `json:"trackingID"`
+		}
+		json.Unmarshal(logMessage, &keys)
+		msg := serviceBusMessage{
+			Body:           string(logMessage),
+			UserProperties: map[string]interface{}{"type": keys.Type},
+		}
+		if keys.TrackingID != "" {
+			msg.BrokerProperties = map[string]interface{}{"CorrelationId": keys.TrackingID}
+		}
+		raw, _ := json.Marshal(msg)
+		if len(raw)+2 > maxSize {
+			dropped++
+			continue
+		}
+		if len(batch) > 0 && batchSize+len(raw)+1 > maxSize {
+			batches = append(batches, batch)
+			batch = nil
+			batchSize = 2
+		}
+		batch = append(batch, raw)
+		batchSize += len(raw) + 1
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return
+}
+
+func (sb *serviceBus) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	batches, dropped := serviceBusBatches(logMessages, sb.maxMessageSize)
+	for _, batch := range batches {
+		authorization, err := sb.authorization()
+		if err != nil {
+			return fmt.Errorf("Getting service bus authorization failed: %w", err)
+		}
+		postData, _ := json.Marshal(batch)
+		resp, err := sb.do(sb.httpClient, func(body io.Reader) (*http.Request, error) {
+			req, err := http.NewRequest("POST", sb.entityURL, body)
+			if err != nil {
+				return nil, fmt.Errorf("Creating POST request failed: %v: %w", err, ErrWriterDisable)
+			}
+			req.Header.Add("Authorization", authorization)
+			req.Header.Add("Content-Type", "application/vnd.microsoft.servicebus.json")
+			return req, nil
+		}, postData)
+		if err != nil {
+			return fmt.Errorf("Sending LogMessages to service bus failed: %w", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("Sending LogMessages to service bus failed (Code: %v): %s", resp.StatusCode, body)
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("%v LogMessages exceeded max message size of %v bytes and were dropped", dropped, sb.maxMessageSize)
+	}
+	return nil
+}
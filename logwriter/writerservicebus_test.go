@@ -0,0 +1,116 @@
+package logwriter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServiceBusConnectionString(t *testing.T) {
+	tests := []struct {
+		name             string
+		connectionString string
+		namespace        string
+		keyName          string
+		key              string
+		entity           string
+		invalid          bool
+	}{
+		{
+			name:             "with entity path",
+			connectionString: "Endpoint=sb://myns.servicebus.windows.net/;SharedAccessKeyName=send;SharedAccessKey=a2V5=;EntityPath=logs",
+			namespace:        "myns.servicebus.windows.net", keyName: "send", key: "a2V5=", entity: "logs",
+		},
+		{
+			name:             "case insensitive keys without entity path",
+			connectionString: "endpoint=sb://myns.servicebus.windows.net/; sharedaccesskeyname=send;SHAREDACCESSKEY=key;",
+			namespace:        "myns.servicebus.windows.net", keyName: "send", key: "key",
+		},
+		{name: "missing key", connectionString: "Endpoint=sb://myns.servicebus.windows.net/;SharedAccessKeyName=send", invalid: true},
+		{name: "missing key name", connectionString: "Endpoint=sb://myns.servicebus.windows.net/;SharedAccessKey=key", invalid: true},
+		{name: "missing endpoint", connectionString: "SharedAccessKeyName=send;SharedAccessKey=key", invalid: true},
+		{name: "malformed endpoint", connectionString: "Endpoint=://myns;SharedAccessKeyName=send;SharedAccessKey=key", invalid: true},
+		{name: "no key value pairs", connectionString: "garbage", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			namespace, keyName, key, entity, err := parseServiceBusConnectionString(test.connectionString)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil || namespace != test.namespace || keyName != test.keyName || key != test.key || entity != test.entity {
+				t.Errorf("unexpected result %q, %q, %q, %q: %v", namespace, keyName, key, entity, err)
+			}
+		})
+	}
+}
+
+func TestServiceBusSASToken(t *testing.T) {
+	sb := &serviceBus{namespace: "myns.servicebus.windows.net", entity: "logs", sasKeyName: "send", sasKey: "secret"}
+	token, err := sb.sasToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token.Value, "SharedAccessSignature ") {
+		t.Fatalf("unexpected token: %v", token.Value)
+	}
+	values, err := url.ParseQuery(strings.TrimPrefix(token.Value, "SharedAccessSignature "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resource := "https://myns.servicebus.windows.net/logs"
+	expiry, _ := strconv.ParseInt(values.Get("se"), 10, 64)
+	if values.Get("sr") != resource || values.Get("skn") != "send" || expiry != token.ExpiresOn.Unix() ||
+		token.ExpiresOn.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("unexpected token %v expiring on %v", token.Value, token.ExpiresOn)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(url.QueryEscape(resource) + "\n" + values.Get("se")))
+	if signature := base64.StdEncoding.EncodeToString(mac.Sum(nil)); values.Get("sig") != signature {
+		t.Errorf("expected signature %v, got %v", signature, values.Get("sig"))
+	}
+}
+
+func TestServiceBusBatches(t *testing.T) {
+	logMessage := func(n int) json.RawMessage {
+		return json.RawMessage(`{"type":"foo","trackingID":"t1","output":["` + strings.Repeat("x", n) + `"]}`)
+	}
+	batches, dropped := serviceBusBatches([]json.RawMessage{logMessage(10)}, 1024)
+	if len(batches) != 1 || len(batches[0]) != 1 || dropped != 0 {
+		t.Fatalf("expected single batch, got %v (dropped %v)", len(batches), dropped)
+	}
+	var msg serviceBusMessage
+	if err := json.Unmarshal(batches[0][0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != string(logMessage(10)) || msg.UserProperties["type"] != "foo" || msg.BrokerProperties["CorrelationId"] != "t1" {
+		t.Errorf("unexpected service bus message: %+v", msg)
+	}
+
+	const maxSize = 1024
+	batches, dropped = serviceBusBatches([]json.RawMessage{logMessage(300), logMessage(300), logMessage(300), logMessage(2000), logMessage(300)}, maxSize)
+	if dropped != 1 {
+		t.Errorf("expected message that exceeds max size to be dropped, got %v", dropped)
+	}
+	count := 0
+	for _, batch := range batches {
+		data, _ := json.Marshal(batch)
+		if len(data) > maxSize {
+			t.Errorf("batch exceeds max size: %v bytes", len(data))
+		}
+		count += len(batch)
+	}
+	if len(batches) != 2 || count != 4 {
+		t.Errorf("expected 4 messages in 2 batches, got %v in %v", count, len(batches))
+	}
+}
@@ -0,0 +1,189 @@
+package logwriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ShardKeyFunc returns the key of a premarshalled log message that is used to select the shard
+type ShardKeyFunc func(logMessage json.RawMessage) string
+
+// ShardByTrackingID uses the "trackingID" property as shard key, so that all messages of a tracking ID are written to the same shard
+func ShardByTrackingID(logMessage json.RawMessage) string {
+	var keys struct {
+		TrackingID string `json:"trackingID"`
+	}
+	json.Unmarshal(logMessage, &keys)
+	return keys.TrackingID
+}
+
+// ShardByType uses the "type" property as shard key, so that all messages of a type are written to the same shard
+func ShardByType(logMessage json.RawMessage) string {
+	var keys struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(logMessage, &keys)
+	return keys.Type
+}
+
+// Sharded log writer
+type sharded struct {
+	shards []LogWriter
+	mutex  sync.Mutex // guards replacing shards, which are read concurrently with writes (e.g. by Validate)
+	keyFn  ShardKeyFunc
+}
+
+// NewShardedWriter returns new LogWriter that distributes LogMessages across the given shards (e.g. multiple
+// workspaces or clusters) by hash of the key returned by keyFn, to stay below per-destination ingestion limits.
+// If keyFn is nil, ShardByTrackingID is used. Messages with an empty key are distributed round-robin.
+// Shards that fail to init or return ErrWriterDisable are closed and removed, which redistributes their keys.
+func NewShardedWriter(shards []LogWriter, keyFn ShardKeyFunc) LogWriter {
+	if keyFn == nil {
+		keyFn = ShardByTrackingID
+	}
+	writer := &sharded{
+		keyFn: keyFn,
+	}
+	for _, shard := range shards {
+		if shard != nil {
+			writer.shards = append(writer.shards, shard)
+		}
+	}
+	return writer
+}
+
+func (s *sharded) Init(config Config) error {
+	var shards []LogWriter
+	var initErrors []error
+	for _, shard := range s.shards {
+		if err := shard.Init(config); err != nil {
+			initErrors = append(initErrors, err)
+			continue
+		}
+		shards = append(shards, shard)
+	}
+	s.mutex.Lock()
+	s.shards = shards
+	s.mutex.Unlock()
+	if len(shards) == 0 {
+		return fmt.Errorf("init of all shard writers failed: %v", initErrors)
+	}
+	return nil
+}
+
+// currentShards returns a snapshot of the shards
+func (s *sharded) currentShards() []LogWriter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.shards
+}
+
+func (s *sharded) Close() {
+	for _, shard := range s.currentShards() {
+		shard.Close()
+	}
+	s.mutex.Lock()
+	s.shards = nil
+	s.mutex.Unlock()
+}
+
+func (s *sharded) PropertiesSchemaChanged(schema map[string]Kind) error {
+	var errs []error
+	for _, shard := range s.currentShards() {
+		if err := shard.PropertiesSchemaChanged(schema); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("schema change of shard writers failed: %v", errs)
+	}
+	return nil
+}
+
+// Validate validates all shards that implement Validator
+func (s *sharded) Validate(ctx context.Context) error {
+	shards := s.currentShards()
+	var errs []error
+	for _, shard := range shards {
+		if validator, ok := shard.(Validator); ok {
+			if err := validator.Validate(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("validation of shard writers failed: %v", errs)
+	}
+	return nil
+}
+
+// shardIndex returns the shard index for the given key
+func shardIndex(key string, shardCount int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shardCount))
+}
+
+func (s *sharded) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	current := s.currentShards()
+	if len(current) == 0 {
+		return ErrWriterDisable
+	}
+	shardMessages := make([][]json.RawMessage, len(current))
+	shardTimestamps := make([][]time.Time, len(current))
+	for i, logMessage := range logMessages {
+		index := i % len(current)
+		if key := s.keyFn(logMessage); key != "" {
+			index = shardIndex(key, len(current))
+		}
+		shardMessages[index] = append(shardMessages[index], logMessage)
+		shardTimestamps[index] = append(shardTimestamps[index], timestamps[i])
+	}
+	var errs []error
+	var shards []LogWriter
+	for i, shard := range current {
+		if len(shardMessages[i]) > 0 {
+			if err := shard.WriteLogMessages(shardMessages[i], shardTimestamps[i]); err != nil {
+				errs = append(errs, err)
+				if errors.Is(err, ErrWriterDisable) {
+					shard.Close()
+					continue
+				}
+			}
+		}
+		shards = append(shards, shard)
+	}
+	s.mutex.Lock()
+	s.shards = shards
+	s.mutex.Unlock()
+	if len(errs) > 0 {
+		if len(shards) == 0 {
+			return fmt.Errorf("all shard writers disabled: %v: %w", errs, ErrWriterDisable)
+		}
+		return fmt.Errorf("writing to shards failed: %v", errs)
+	}
+	return nil
+}
+
+// Credentials returns the tokens of all shards that implement CredentialRefresher
+func (s *sharded) Credentials() (tokens []*RefreshingToken) {
+	for _, shard := range s.currentShards() {
+		tokens = append(tokens, credentialsOf(shard)...)
+	}
+	return tokens
+}
+
+// OrderInsensitive returns true if the order of the LogMessages doesn't matter for any shard
+func (s *sharded) OrderInsensitive() bool {
+	for _, shard := range s.currentShards() {
+		if !isOrderInsensitive(shard) {
+			return false
+		}
+	}
+	return true
+}
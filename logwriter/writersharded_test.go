@@ -0,0 +1,34 @@
+package logwriter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestShardedWriter(t *testing.T) {
+	shards := []*testWriter{{}, {}, {}}
+	lw := NewShardedWriter([]LogWriter{shards[0], shards[1], shards[2]}, ShardByType)
+	if err := lw.Init(Config{LogName: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := []json.RawMessage{
+		json.RawMessage(`{"type":"foo"}`),
+		json.RawMessage(`{"type":"foo"}`),
+		json.RawMessage(`{"type":"foo"}`),
+	}
+	now := time.Now()
+	if err := lw.WriteLogMessages(msgs, []time.Time{now, now, now}); err != nil {
+		t.Fatal(err)
+	}
+	if shards[shardIndex("foo", 3)].written != 3 {
+		t.Errorf("expected all messages of same type in same shard")
+	}
+}
+
+func TestShardedWriterConcurrentReads(t *testing.T) {
+	lw := NewShardedWriter([]LogWriter{&testWriter{err: ErrWriterDisable}, &testWriter{}}, nil)
+	readConcurrently(t, lw, func() {
+		lw.WriteLogMessages([]json.RawMessage{json.RawMessage(`{}`), json.RawMessage(`{}`)}, []time.Time{time.Now(), time.Now()})
+	})
+}
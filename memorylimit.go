@@ -0,0 +1,87 @@
+package logthing
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrMemoryLimit is returned when a message is shed because the queued messages exceed the memory limit (see WithMemoryLimit)
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// WithMemoryLimit sets the approximate number of bytes that queued messages may retain while waiting to be written, e.g.
+// to keep small containers from running out of memory when writers back up during an outage. Once the limit is exceeded,
+// messages are shed beginning with the lowest severity: Trace messages above the limit, Info messages above 125%, Notice
+// messages above 150% and Warning messages above 175% of the limit. Messages with severity <= SeverityError are always kept.
+func WithMemoryLimit(bytes int64) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.memoryLimit = bytes
+	}
+}
+
+// shedSeverity returns the severity from which on messages are shed with the given retained bytes
+func shedSeverity(retained int64, limit int64) Severity {
+	switch {
+	case retained < limit:
+		return SeverityNotApplied
+	case retained < limit+limit/4:
+		return SeverityTrace
+	case retained < limit+limit/2:
+		return SeverityInfo
+	case retained < limit+limit*3/4:
+		return SeverityNotice
+	}
+	return SeverityWarning
+}
+
+// retain adds the approximate size of the message to the retained bytes. It returns false if the message shall be shed.
+func (ld *logDispatcher) retain(msg *logMsg, options dispatcherOptions) bool {
+	if options.memoryLimit <= 0 {
+		return true
+	}
+	size := approxSize(msg)
+	retained := atomic.LoadInt64(&ld.retainedBytes) + size
+	if msg.severity > SeverityError && msg.severity >= shedSeverity(retained, options.memoryLimit) {
+		atomic.AddUint64(&ld.shedCounter, 1)
+		return false
+	}
+	msg.retainedBytes = size
+	atomic.AddInt64(&ld.retainedBytes, size)
+	return true
+}
+
+// release subtracts the given size from the retained bytes
+func (ld *logDispatcher) release(size int64) {
+	if size > 0 {
+		atomic.AddInt64(&ld.retainedBytes, -size)
+	}
+}
+
+// retainedSize returns the retained bytes of the messages
+func retainedSize(logMessages []*logMsg) (size int64) {
+	for _, msg := range logMessages {
+		size += msg.retainedBytes
+	}
+	return
+}
+
+// approxSize returns the approximate memory size of the message
+func approxSize(msg *logMsg) int64 {
+	size := int64(256)
+	if msg.queued != nil {
+		return size + int64(len(msg.queued.data)) + 32*int64(len(msg.queued.kinds))
+	}
+	for key, value := range msg.Properties() {
+		size += int64(len(key)) + 32
+		switch v := value.(type) {
+		case string:
+			size += int64(len(v))
+		case []string:
+			for _, s := range v {
+				size += int64(len(s)) + 16
+			}
+		case []byte:
+			size += int64(len(v))
+		}
+	}
+	return size
+}
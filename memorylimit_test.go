@@ -0,0 +1,45 @@
+package logthing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	ld, _ := newLogDispatcher(nil, WithDispatchInterval(time.Hour), WithMemoryLimit(4096))
+	defer ld.close()
+	options := ld.currentOptions()
+	send := func(severity Severity) error {
+		msg := NewLogMsg("test").AppendOutput(severity, "message").msgData()
+		ld.prepare(msg)
+		return ld.enqueue(msg, options)
+	}
+	shed := 0
+	for i := 0; i < 100; i++ {
+		if err := send(SeverityTrace); err == ErrMemoryLimit {
+			shed++
+		}
+	}
+	if shed == 0 || ld.stats().Shed != uint64(shed) {
+		t.Errorf("expected trace messages to be shed, got %v (%v)", shed, ld.stats().Shed)
+	}
+	if err := send(SeverityInfo); err != nil {
+		t.Errorf("expected info message to be kept below 125%% of the limit, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := send(SeverityError); err != nil {
+			t.Fatalf("expected error messages to be always kept, got %v", err)
+		}
+	}
+	if err := send(SeverityWarning); err != ErrMemoryLimit {
+		t.Errorf("expected warning message to be shed, got %v", err)
+	}
+}
+
+func TestShedSeverity(t *testing.T) {
+	for retained, expected := range map[int64]Severity{99: SeverityNotApplied, 100: SeverityTrace, 130: SeverityInfo, 160: SeverityNotice, 200: SeverityWarning} {
+		if severity := shedSeverity(retained, 100); severity != expected {
+			t.Errorf("expected %v for %v retained bytes, got %v", expected, retained, severity)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package logthing
+
+import "sync"
+
+// retainFilteredOutput is > 0 while a dispatcher keeps filtered messages (see WithFilteredContextRing), so that their output
+// is retained even if it won't be printed
+var retainFilteredOutput int32
+
+// msgRing is a ring buffer of the last N messages
+type msgRing struct {
+	mutex    sync.Mutex
+	messages []*logMsg
+	next     int
+	full     bool
+}
+
+func newMsgRing(size int) *msgRing {
+	return &msgRing{messages: make([]*logMsg, size)}
+}
+
+// add adds the message to the ring and overwrites the oldest message if the ring is full
+func (r *msgRing) add(msg *logMsg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.messages[r.next] = msg
+	r.next = (r.next + 1) % len(r.messages)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns all messages of the ring (oldest first)
+func (r *msgRing) snapshot() (messages []*logMsg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.full {
+		messages = append(messages, r.messages[r.next:]...)
+	}
+	return append(messages, r.messages[:r.next]...)
+}
+
+// drainTrackingID removes the messages with the given tracking ID from the ring and returns them (oldest first). The
+// order of the remaining messages is kept.
+func (r *msgRing) drainTrackingID(trackingID string) (messages []*logMsg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	all := r.messages[:r.next]
+	if r.full {
+		all = append(append([]*logMsg{}, r.messages[r.next:]...), all...)
+	}
+	remaining := make([]*logMsg, len(r.messages))
+	next := 0
+	for _, msg := range all {
+		if msg.trackingID == trackingID {
+			messages = append(messages, msg)
+		} else {
+			remaining[next] = msg
+			next++
+		}
+	}
+	r.messages = remaining
+	r.next = next % len(remaining)
+	r.full = next == len(remaining)
+	return
+}
@@ -0,0 +1,104 @@
+package logthing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMsgRingDrainTrackingID(t *testing.T) {
+	ring := newMsgRing(3)
+	for i, trackingID := range []string{"a", "b", "a", "b"} {
+		ring.add(NewLogMsg("test").SetTrackingID(trackingID).SetProperty("i", i).msgData())
+	}
+	drained := ring.drainTrackingID("a")
+	if len(drained) != 1 || drained[0].Property("i") != 2 {
+		t.Errorf("expected the remaining message of tracking ID a, got %v", drained)
+	}
+	remaining := ring.snapshot()
+	if len(remaining) != 2 || remaining[0].Property("i") != 1 || remaining[1].Property("i") != 3 {
+		t.Errorf("expected the messages of tracking ID b in order, got %v", remaining)
+	}
+	ring.add(NewLogMsg("test").SetTrackingID("c").SetProperty("i", 4).msgData())
+	ring.add(NewLogMsg("test").SetTrackingID("c").SetProperty("i", 5).msgData())
+	if remaining := ring.snapshot(); len(remaining) != 3 || remaining[0].Property("i") != 3 {
+		t.Errorf("expected oldest message to be overwritten, got %v", remaining)
+	}
+}
+
+func TestFilteredContextRing(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_LOG_MAX_SEVERITY", "info")
+	ReloadConfig()
+	var err error
+	ld, err = newLogDispatcher(nil, WithDispatchInterval(time.Hour), WithFilteredContextRing(10), WithRecentMessages(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	reused := NewLogMsg("trace").SetTrackingID("a").Trace("context of a")
+	ld.log(1, reused)
+	reused.Trace("mutated after logging")
+	ld.log(1, NewLogMsg("trace").SetTrackingID("b").Trace("context of b"))
+	if err := ld.log(1, NewLogMsg("failure").SetTrackingID("a").Error("failed")); err != nil {
+		t.Fatal(err)
+	}
+	messages := RecentMessages(MessageFilter{})
+	if len(messages) != 2 || messages[0].Property(PropertyFilteredContext) != true || messages[0].TrackingID() != "a" {
+		t.Fatalf("expected only the filtered context of tracking ID a, got %v", messages)
+	}
+	if output := messages[0].Output(); len(output) != 1 || !strings.HasSuffix(output[0], "context of a") {
+		t.Errorf("expected copy of the filtered message, got %v", output)
+	}
+	if contextMsgs := ld.filteredRing.snapshot(); len(contextMsgs) != 1 || contextMsgs[0].TrackingID() != "b" {
+		t.Errorf("expected filtered context of tracking ID b to be kept, got %v", contextMsgs)
+	}
+}
+
+func TestFilteredContextRingWithoutTrackingID(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_LOG_MAX_SEVERITY", "info")
+	ReloadConfig()
+	var err error
+	ld, err = newLogDispatcher(nil, WithDispatchInterval(time.Hour), WithFilteredContextRing(10), WithRecentMessages(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	ld.log(1, NewLogMsg("trace").Trace("unrelated"))
+	if err := ld.log(1, NewLogMsg("failure").Error("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if messages := RecentMessages(MessageFilter{}); len(messages) != 1 {
+		t.Errorf("expected no filtered context for messages without tracking ID, got %v", messages)
+	}
+	if contextMsgs := ld.filteredRing.snapshot(); len(contextMsgs) != 1 {
+		t.Errorf("expected filtered message to be kept, got %v", contextMsgs)
+	}
+}
+
+func TestFilteredContextRingMemoryLimit(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_LOG_MAX_SEVERITY", "info")
+	ReloadConfig()
+	var err error
+	ld, err = newLogDispatcher(nil, WithDispatchInterval(time.Hour), WithFilteredContextRing(10), WithMemoryLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	for i := 0; i < 3; i++ {
+		ld.log(1, NewLogMsg("trace").SetTrackingID("a").Trace("context"))
+	}
+	ld.log(1, NewLogMsg("failure").SetTrackingID("a").Error("failed"))
+	var queueErrors []DispatchError
+	for len(ld.errorCh) > 0 {
+		if err := <-ld.errorCh; err.Phase == PhaseQueue && strings.Contains(err.Error(), "messages dropped") {
+			queueErrors = append(queueErrors, err)
+		}
+	}
+	if len(queueErrors) != 1 || !errors.Is(queueErrors[0], ErrMemoryLimit) || !strings.Contains(queueErrors[0].Error(), "3 messages dropped") {
+		t.Errorf("expected error for the dropped context messages, got %v", queueErrors)
+	}
+}
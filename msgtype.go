@@ -0,0 +1,129 @@
+package logthing
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MessageType is the handle of a registered log message type (see RegisterMessageType), which can be used to create
+// messages with NewLogMsg
+type MessageType string
+
+// String returns the name of the message type
+func (t MessageType) String() string {
+	return string(t)
+}
+
+// MessageTypeOptions describe a message type (see RegisterMessageType)
+type MessageTypeOptions struct {
+	Description    string // what the messages of the type are about
+	SchemaVersion  int    // current schema version of the type (see SetTypeSchemaVersion), 0: not versioned
+	OutputTemplate string // template of the output line (see RegisterOutputTemplate)
+}
+
+// MessageTypeInfo describes a registered message type (see ListMessageTypes)
+type MessageTypeInfo struct {
+	Name string
+	MessageTypeOptions
+	RegisteredAt string // file:line of the registration
+}
+
+// reservedMessageTypes are the message types that are used by logthing itself. All types with "logthing_" prefix are
+// reserved as well.
+var reservedMessageTypes = []string{MsgTypeError, MsgTypeFatal}
+
+// messageTypes contains the registered message types
+var messageTypes = struct {
+	sync.RWMutex
+	types  map[string]MessageTypeInfo
+	strict bool
+}{
+	types:  map[string]MessageTypeInfo{},
+	strict: true,
+}
+
+// SetStrictMessageTypes sets whether RegisterMessageType panics on duplicate, reserved or invalid names (default:
+// true). Otherwise a warning is printed.
+func SetStrictMessageTypes(strict bool) {
+	messageTypes.Lock()
+	messageTypes.strict = strict
+	messageTypes.Unlock()
+}
+
+// RegisterMessageType registers the log message type with given name and returns its handle, which can be used to
+// create messages with NewLogMsg. Registering message types in package variables keeps the naming of large code bases
+// consistent and discoverable (see ListMessageTypes), e.g.:
+//
+//	var HTTPAccess = logthing.RegisterMessageType("http_access", logthing.MessageTypeOptions{Description: "served HTTP requests"})
+//	...
+//	logthing.NewLogMsg(HTTPAccess).SetProperty("status", 200).Log()
+//
+// It panics at startup if the name has been registered before, is reserved by logthing (e.g. "fatal" or with
+// "logthing_" prefix) or is invalid (empty or containing white space), unless SetStrictMessageTypes(false) has been
+// called, in which case only a warning is printed.
+func RegisterMessageType(name string, opts MessageTypeOptions) MessageType {
+	registeredAt := "unknown"
+	if _, file, line, ok := runtime.Caller(1); ok {
+		registeredAt = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	messageTypes.Lock()
+	err := registerMessageType(name, opts, registeredAt)
+	strict := messageTypes.strict
+	messageTypes.Unlock()
+	if err != nil {
+		if strict {
+			panic(err)
+		}
+		Warning.Println(err)
+		return MessageType(name)
+	}
+	if opts.SchemaVersion > 0 {
+		SetTypeSchemaVersion(name, opts.SchemaVersion)
+	}
+	if opts.OutputTemplate != "" {
+		if err := RegisterOutputTemplate(name, opts.OutputTemplate); err != nil {
+			if strict {
+				panic(fmt.Errorf("invalid output template of message type %q: %w", name, err))
+			}
+			Warning.Printf("invalid output template of message type %q: %v", name, err)
+		}
+	}
+	return MessageType(name)
+}
+
+// registerMessageType registers the message type if the name is valid
+func registerMessageType(name string, opts MessageTypeOptions, registeredAt string) error {
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid message type %q registered at %v", name, registeredAt)
+	}
+	if strings.HasPrefix(name, "logthing_") {
+		return fmt.Errorf("message type %q registered at %v is reserved", name, registeredAt)
+	}
+	for _, reserved := range reservedMessageTypes {
+		if name == reserved {
+			return fmt.Errorf("message type %q registered at %v is reserved", name, registeredAt)
+		}
+	}
+	if existing, ok := messageTypes.types[name]; ok {
+		return fmt.Errorf("message type %q registered at %v has already been registered at %v", name, registeredAt, existing.RegisteredAt)
+	}
+	messageTypes.types[name] = MessageTypeInfo{Name: name, MessageTypeOptions: opts, RegisteredAt: registeredAt}
+	return nil
+}
+
+// ListMessageTypes returns the registered message types sorted by name
+func ListMessageTypes() []MessageTypeInfo {
+	messageTypes.RLock()
+	defer messageTypes.RUnlock()
+	infos := make([]MessageTypeInfo, 0, len(messageTypes.types))
+	for _, info := range messageTypes.types {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
@@ -0,0 +1,38 @@
+package logthing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisterMessageType(t *testing.T) {
+	defer func() {
+		messageTypes.Lock()
+		delete(messageTypes.types, "payment_registry_test")
+		messageTypes.Unlock()
+	}()
+	payment := RegisterMessageType("payment_registry_test", MessageTypeOptions{Description: "payments"})
+	if msgType := NewLogMsg(payment).Type(); msgType != "payment_registry_test" {
+		t.Errorf("expected message of registered type, got %v", msgType)
+	}
+	found := false
+	for _, info := range ListMessageTypes() {
+		if info.Name == "payment_registry_test" {
+			found = info.Description == "payments" && strings.HasPrefix(info.RegisteredAt, "msgtype_test.go:")
+		}
+	}
+	if !found {
+		t.Errorf("expected registered type to be listed, got %v", ListMessageTypes())
+	}
+
+	for _, name := range []string{"payment_registry_test", "logthing_heartbeat", "fatal", "with space", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected registration of %q to panic", name)
+				}
+			}()
+			RegisterMessageType(name, MessageTypeOptions{})
+		}()
+	}
+}
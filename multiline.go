@@ -0,0 +1,96 @@
+package logthing
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// continuationPatterns match lines that continue the previous record, e.g. the frames of Java, Python and Go stack
+	// traces
+	continuationPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^\s+\S`),                  // indented lines, e.g. "\tat com.example.Main.run(Main.java:5)" or "  File \"main.py\""
+		regexp.MustCompile(`^Caused by: `),            // Java causes
+		regexp.MustCompile(`^goroutine \d+ \[`),       // Go goroutine headers
+		regexp.MustCompile(`^created by `),            // Go goroutine origins
+		regexp.MustCompile(`^\[signal `),              // Go signals
+		regexp.MustCompile(`^[\w./*()\[\]-]+\(.*\)$`), // Go function frames, e.g. "main.(*Server).Run(0xc000010000)"
+		regexp.MustCompile(`^(During handling of the above exception|The above exception was the direct cause)`), // Python chained exceptions
+	}
+	pythonTracebackPattern = regexp.MustCompile(`^Traceback \(most recent call last\):`)
+)
+
+// LineGrouper groups lines into multi-line records, so that e.g. stack traces are folded into the preceding message.
+// By default Java, Python and Go stack traces are detected. With start patterns, every line that matches any start
+// pattern starts a new record and all other lines continue the previous record.
+type LineGrouper struct {
+	startPatterns []*regexp.Regexp
+	record        []string
+	blanks        int  // blank lines after the record, which are kept if the record continues
+	traceback     bool // within a Python traceback
+}
+
+// NewLineGrouper returns new LineGrouper with given (optional) start patterns, e.g. `^\d{4}-\d{2}-\d{2}` for lines that
+// start with a date
+func NewLineGrouper(startPatterns ...*regexp.Regexp) *LineGrouper {
+	return &LineGrouper{startPatterns: startPatterns}
+}
+
+// Add adds the line and returns the previous record if the line starts a new one
+func (g *LineGrouper) Add(line string) (record []string) {
+	if strings.TrimSpace(line) == "" {
+		if len(g.record) > 0 {
+			g.blanks++
+		}
+		return nil
+	}
+	if len(g.record) > 0 && g.continues(line) {
+		for ; g.blanks > 0; g.blanks-- {
+			g.record = append(g.record, "")
+		}
+		g.record = append(g.record, line)
+		return nil
+	}
+	record = g.record
+	g.record = []string{line}
+	g.blanks = 0
+	g.traceback = len(g.startPatterns) == 0 && pythonTracebackPattern.MatchString(line)
+	return record
+}
+
+// Flush returns the current record and resets the grouper, e.g. when the input is idle or closed
+func (g *LineGrouper) Flush() (record []string) {
+	record = g.record
+	g.record = nil
+	g.blanks = 0
+	g.traceback = false
+	return record
+}
+
+// continues returns true if the line continues the current record
+func (g *LineGrouper) continues(line string) bool {
+	if len(g.startPatterns) > 0 {
+		for _, pattern := range g.startPatterns {
+			if pattern.MatchString(line) {
+				return false
+			}
+		}
+		return true
+	}
+	if pythonTracebackPattern.MatchString(line) {
+		g.traceback = true
+		return true
+	}
+	indented := line[0] == ' ' || line[0] == '\t'
+	if g.traceback && !indented {
+		// the exception line (e.g. "ValueError: invalid literal") ends the traceback
+		g.traceback = false
+		return true
+	}
+	for _, pattern := range continuationPatterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package logthing
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func groupLines(g *LineGrouper, input string) (records [][]string) {
+	for _, line := range strings.Split(input, "\n") {
+		if record := g.Add(line); record != nil {
+			records = append(records, record)
+		}
+	}
+	if record := g.Flush(); record != nil {
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLineGrouper(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		lengths []int
+	}{
+		{"java", "Exception in thread \"main\" java.lang.IllegalStateException: boom\n\tat com.example.Main.run(Main.java:5)\nCaused by: java.io.IOException\n\t... 3 more\nnext message", []int{4, 1}},
+		{"python", "ERROR failed\nTraceback (most recent call last):\n  File \"main.py\", line 3, in <module>\n    int(\"x\")\nValueError: invalid literal\nnext message", []int{5, 1}},
+		{"go", "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/src/main.go:5 +0x1d\nexit status 2", []int{5, 1}},
+		{"plain", "first\n\nsecond\nthird", []int{1, 1, 1}},
+	}
+	for _, test := range tests {
+		var lengths []int
+		for _, record := range groupLines(NewLineGrouper(), test.input) {
+			lengths = append(lengths, len(record))
+		}
+		if !reflect.DeepEqual(lengths, test.lengths) {
+			t.Errorf("%v: expected records with %v lines, got %v", test.name, test.lengths, lengths)
+		}
+	}
+
+	records := groupLines(NewLineGrouper(regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)), "2020-01-01 first\ndetail\n2020-01-02 second")
+	if len(records) != 2 || len(records[0]) != 2 {
+		t.Errorf("expected records split by start pattern, got %q", records)
+	}
+}
@@ -0,0 +1,107 @@
+package logthing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// PropertyOperationID contains the id of the operation (see StartOperation)
+	PropertyOperationID = "operationID"
+	// PropertyParentOperationID contains the id of the parent operation (see StartOperation)
+	PropertyParentOperationID = "parentOperationID"
+	// PropertyDuration contains the duration of the operation in milliseconds (see StartOperation)
+	PropertyDuration = "duration_ms"
+	// PropertyError contains the error with which the operation ended (see StartOperation)
+	PropertyError = "error"
+)
+
+type operationContextKey struct{}
+
+// Operation is a scoped log message that measures the duration of an operation and is logged as a single consolidated
+// message when the operation ends. See StartOperation
+type Operation struct {
+	LogMsg
+	id       string
+	parentID string
+	start    time.Time
+	ctx      context.Context
+	labeled  context.Context // context with the goroutine's labels before the operation (see WithPprofLabels)
+}
+
+// newOperationID returns a random operation id
+func newOperationID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// StartOperation starts a new operation with the given name, which is used as message type. Properties and output can be
+// accumulated during the operation, which is logged as single message with its duration when End() is called.
+// Operations that are started with the context of another operation (see Operation.Context) are linked to their parent
+// via the "parentOperationID" property and inherit the parent's tracking ID.
+//
+//	op := logthing.StartOperation(ctx, "charge_card")
+//	defer func() { op.End(err) }()
+func StartOperation(ctx context.Context, name string, options ...Option) *Operation {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	op := &Operation{
+		LogMsg: NewLogMsg(name, options...),
+		id:     newOperationID(),
+		start:  time.Now(),
+	}
+	if parent, ok := ctx.Value(operationContextKey{}).(*Operation); ok && parent != nil {
+		op.parentID = parent.id
+		if op.TrackingID() == "" {
+			op.SetTrackingID(parent.TrackingID())
+		}
+	}
+	op.ctx = context.WithValue(ctx, operationContextKey{}, op)
+	if op.msgData().pprofLabels {
+		op.setPprofLabels(ctx)
+	}
+	return op
+}
+
+// OperationFromContext returns the operation of the context or nil if there is none
+func OperationFromContext(ctx context.Context) *Operation {
+	if ctx == nil {
+		return nil
+	}
+	op, _ := ctx.Value(operationContextKey{}).(*Operation)
+	return op
+}
+
+// ID returns the operation's id
+func (op *Operation) ID() string {
+	return op.id
+}
+
+// Context returns context that carries the operation, to start nested operations
+func (op *Operation) Context() context.Context {
+	return op.ctx
+}
+
+// End sets the duration of the operation and logs it. If err is not nil, the error is added as output and "error" property
+// and the severity is set to SeverityError, otherwise the severity is set to at least SeverityInfo. Properties of errors
+// wrapped with WrapError are added as well.
+func (op *Operation) End(err error) error {
+	op.restorePprofLabels()
+	op.SetProperty(PropertyOperationID, op.id)
+	if op.parentID != "" {
+		op.SetProperty(PropertyParentOperationID, op.parentID)
+	}
+	op.SetProperty(PropertyDuration, float64(time.Since(op.start).Microseconds())/1000)
+	if err != nil {
+		applyErrorProperties(op, err)
+		op.SetProperty(PropertyError, err.Error())
+		op.msgData().appendOutput(2, SeverityError, err)
+	} else {
+		op.SetSeverity(SeverityInfo)
+	}
+	return LogMsgWithCalldepth(2, op.LogMsg)
+}
@@ -0,0 +1,43 @@
+package logthing_test
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/mfmayer/logthing"
+)
+
+func TestOperation(t *testing.T) {
+	op := logthing.StartOperation(context.Background(), "parent")
+	op.SetTrackingID("tracking")
+	child := logthing.StartOperation(op.Context(), "child")
+	if logthing.OperationFromContext(child.Context()) != child {
+		t.Errorf("expected child operation in context")
+	}
+	if child.TrackingID() != "tracking" {
+		t.Errorf("expected tracking ID to be inherited, got %q", child.TrackingID())
+	}
+	child.End(nil)
+	if child.Property(logthing.PropertyParentOperationID) != op.ID() {
+		t.Errorf("expected child to be linked to parent")
+	}
+	if child.Severity() != logthing.SeverityInfo {
+		t.Errorf("expected info severity, got %v", child.Severity())
+	}
+}
+
+func TestOperationPprofLabels(t *testing.T) {
+	parent := logthing.StartOperation(context.Background(), "parent")
+	parent.SetTrackingID("tracking")
+	op := logthing.StartOperation(parent.Context(), "charge_card", logthing.WithPprofLabels())
+	labels := map[string]string{}
+	pprof.ForLabels(op.Context(), func(key, value string) bool {
+		labels[key] = value
+		return true
+	})
+	if labels["msgType"] != "charge_card" || labels["trackingID"] != "tracking" || labels["operationID"] != op.ID() {
+		t.Errorf("unexpected pprof labels: %v", labels)
+	}
+	op.End(nil)
+}
@@ -0,0 +1,103 @@
+package logthing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// ErrNoTxWriter is returned by LogTx when the dispatcher has no writer that supports transactional writes
+var ErrNoTxWriter error = errors.New("no transactional writer (e.g. logwriter.NewOutboxWriter)")
+
+// LogTx outputs and writes the LogMessage within the given database transaction to all writers that support
+// transactional writes (see logwriter.TxWriter and logwriter.NewOutboxWriter) instead of queueing it. The message is
+// only shipped when the transaction is committed, e.g. to commit audit log records atomically with the business data.
+// Schema drift, routing, classification and flattening are applied like for queued messages, but the message isn't
+// accounted to the ingestion budgets. Besides the errors of Log, schema drift and writer errors are returned, which
+// should lead to a rollback of the transaction.
+func LogTx(ctx context.Context, tx *sql.Tx, msg LogMsg) error {
+	if ld == nil {
+		return ErrNotInitialized
+	}
+	if msg == nil {
+		return nil
+	}
+	return ld.logTx(ctx, 2, tx, msg)
+}
+
+// logTx prints the log message and writes it with the transactional writers
+func (ld *logDispatcher) logTx(ctx context.Context, calldepth int, tx *sql.Tx, logMessage LogMsg) error {
+	if len(ld.txWriters) == 0 {
+		return ErrNoTxWriter
+	}
+	options := ld.currentOptions()
+	msg, err := ld.admit(calldepth+1, logMessage, options)
+	if err != nil {
+		return err
+	}
+	rawLogMessage, classified, err := ld.marshalTx(msg, options)
+	if err != nil {
+		return err
+	}
+	logMessages, timestamps, severities := []json.RawMessage{rawLogMessage}, []time.Time{msg.Timestamp()}, []Severity{msg.severity}
+	needed := transforms{routes: msg.routes != nil}
+	if classified != nil {
+		needed.classified = len(classified.classifications) > 0 && len(options.classification.policies) > 0
+		needed.drifts = len(classified.drifts) > 0
+	}
+	for _, txWriter := range ld.txWriters {
+		lw := txWriter.(logwriter.LogWriter)
+		if msg.companion && !options.companion.isArchiveWriter(lw) {
+			continue
+		}
+		writerLogMessages, writerTimestamps, _, err := ld.transformMessages(lw, 0, logMessages, timestamps, severities, []*classifiedMsg{classified}, [][]string{msg.routes}, needed, options)
+		if err != nil {
+			return err
+		}
+		if len(writerLogMessages) == 0 {
+			continue
+		}
+		if err := txWriter.WriteLogMessagesTx(ctx, tx, writerLogMessages, writerTimestamps); err != nil {
+			ld.reportError(DispatchError{Phase: PhaseWrite, Writer: writerName(lw), Err: err})
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalTx migrates and marshals the properties of a transactional message like writeLogMessages. It returns the
+// message's properties for writers with classification or schema drift policies (nil if not needed). New properties are
+// recorded in the schema, which is announced to the transactional writers immediately and to all writers with the next
+// batch.
+func (ld *logDispatcher) marshalTx(msg *logMsg, options dispatcherOptions) (json.RawMessage, *classifiedMsg, error) {
+	properties := renderProperties(msg.Properties())
+	migrateSchema(properties)
+	ld.schemaMutex.Lock()
+	defer ld.schemaMutex.Unlock()
+	drifts := ld.schemaDrifts(properties, options.flattenSeparator)
+	rawLogMessage, err := options.marshal(properties)
+	if err != nil {
+		ld.reportError(DispatchError{Phase: PhaseMarshal, Err: err})
+		return nil, nil, err
+	}
+	schemaChanged := ld.recordSchema(properties, options)
+	if _, ok := ld.schema[PropertySchemaOverflow]; !ok && len(drifts) > 0 && options.overflowsSchemaDrift() {
+		ld.schema[PropertySchemaOverflow] = logwriter.Object
+		schemaChanged = true
+	}
+	if schemaChanged {
+		ld.schemaPending = true
+		for _, txWriter := range ld.txWriters {
+			ld.notifySchemaChanged(txWriter.(logwriter.LogWriter), 0)
+		}
+	}
+	var classified *classifiedMsg
+	if len(msg.classifications) > 0 || len(drifts) > 0 {
+		classified = &classifiedMsg{properties: properties, classifications: msg.classifications, drifts: drifts}
+	}
+	return rawLogMessage, classified, nil
+}
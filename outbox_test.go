@@ -0,0 +1,67 @@
+package logthing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// txWriter records the messages written within transactions
+type txWriter struct {
+	namedWriter
+	txMessages []json.RawMessage
+	schema     map[string]logwriter.Kind
+}
+
+func (w *txWriter) WriteLogMessagesTx(ctx context.Context, tx *sql.Tx, logMessages []json.RawMessage, timestamps []time.Time) error {
+	w.txMessages = append(w.txMessages, logMessages...)
+	return nil
+}
+
+func (w *txWriter) PropertiesSchemaChanged(schema map[string]logwriter.Kind) error {
+	w.schema = schema
+	return nil
+}
+
+func TestLogTx(t *testing.T) {
+	rules, err := ParseRoutingRules(`type=="audit" -> writers:[audit]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, other := &txWriter{namedWriter: namedWriter{name: "audit"}}, &txWriter{namedWriter: namedWriter{name: "other"}}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{audit, other}, WithDispatchInterval(time.Hour), WithRoutingRules(rules),
+		WithClassificationPolicy(audit, PII, ClassificationHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	msg := NewLogMsg("audit").SetClassifiedProperty("email", "jane@example.com", PII).Info("login")
+	if err := ld.logTx(context.Background(), 1, nil, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(other.txMessages) != 0 {
+		t.Errorf("expected message to be routed to audit writer only, got %s", other.txMessages)
+	}
+	if len(audit.txMessages) != 1 || strings.Contains(string(audit.txMessages[0]), "jane") {
+		t.Fatalf("expected hashed email, got %s", audit.txMessages)
+	}
+	if _, ok := audit.schema["email"]; !ok {
+		t.Errorf("expected schema change with email property, got %v", audit.schema)
+	}
+}
+
+func TestLogTxNoTxWriter(t *testing.T) {
+	ld, err := newLogDispatcher([]logwriter.LogWriter{&soakWriter{}}, WithDispatchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	if err := ld.logTx(context.Background(), 1, nil, NewLogMsg("audit").Info("login")); err != ErrNoTxWriter {
+		t.Errorf("expected ErrNoTxWriter, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package logthing
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// outputTemplates contains the output templates registered with RegisterOutputTemplate by log message type
+var outputTemplates = struct {
+	sync.RWMutex
+	types map[string]*template.Template
+}{
+	types: map[string]*template.Template{},
+}
+
+// RegisterOutputTemplate registers a Go template (see text/template) that renders a readable output line from the
+// properties of messages with the given log message type, e.g.:
+//
+//	logthing.RegisterOutputTemplate("http_access", "{{.method}} {{.path}} -> {{.status}} in {{.latency_ms}}ms")
+//
+// The template is used for the console output of messages without own output (e.g. without Infof text). Writers for
+// human-readable sinks can render their lines with RenderOutputTemplate. An empty text removes the template.
+func RegisterOutputTemplate(logMessageType string, text string) error {
+	if text == "" {
+		outputTemplates.Lock()
+		delete(outputTemplates.types, logMessageType)
+		outputTemplates.Unlock()
+		return nil
+	}
+	tmpl, err := template.New(logMessageType).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return err
+	}
+	outputTemplates.Lock()
+	outputTemplates.types[logMessageType] = tmpl
+	outputTemplates.Unlock()
+	return nil
+}
+
+// RenderOutputTemplate renders the properties with the output template registered for the log message type. It
+// returns false if there is no template for the type or rendering failed.
+func RenderOutputTemplate(logMessageType string, properties map[string]interface{}) (string, bool) {
+	outputTemplates.RLock()
+	tmpl, ok := outputTemplates.types[logMessageType]
+	outputTemplates.RUnlock()
+	if !ok {
+		return "", false
+	}
+	sb := strings.Builder{}
+	if err := tmpl.Execute(&sb, renderProperties(properties)); err != nil {
+		return "", false
+	}
+	return sb.String(), true
+}
+
+// placeholderPrefix starts an output placeholder like "{prop:orderID}" (see resolvePlaceholders)
+const placeholderPrefix = "{prop:"
+
+// placeholderMarker temporarily replaces the placeholders of a format string while it's formatted (see formatOutput)
+const placeholderMarker = "\x00prop:"
+
+// placeholderText is output text whose placeholders are resolved (see formatOutput)
+type placeholderText string
+
+// formatOutput formats the output according to the format specifier (see fmt.Sprintf). Only placeholders of the format
+// are resolved, while placeholders in the formatted values are escaped (see escapePlaceholders), so that e.g.
+// user-supplied values can't reference other properties of the message.
+func formatOutput(format string, v ...interface{}) interface{} {
+	if !strings.Contains(format, placeholderPrefix) {
+		return fmt.Sprintf(format, v...) // escaped as any other value
+	}
+	line := fmt.Sprintf(strings.ReplaceAll(format, placeholderPrefix, placeholderMarker), v...)
+	return placeholderText(strings.ReplaceAll(escapePlaceholders(line), placeholderMarker, placeholderPrefix))
+}
+
+// escapePlaceholders escapes placeholders as "{{prop:", which are output as "{prop:" and aren't resolved
+func escapePlaceholders(text string) string {
+	if !strings.Contains(text, placeholderPrefix) {
+		return text
+	}
+	return strings.ReplaceAll(text, placeholderPrefix, "{"+placeholderPrefix)
+}
+
+// resolvePlaceholders replaces placeholders like "{prop:orderID}" in the output lines by the rendered property values
+// (see renderOutput) and unescapes escaped placeholders (see escapePlaceholders). Placeholders of missing properties
+// are kept. Returns false if there aren't any placeholders.
+func resolvePlaceholders(output []string, properties map[string]interface{}) ([]string, bool) {
+	resolved := []string(nil)
+	for i, line := range output {
+		if !strings.Contains(line, placeholderPrefix) {
+			continue
+		}
+		if resolved == nil {
+			resolved = append(make([]string, 0, len(output)), output...)
+		}
+		sb := strings.Builder{}
+		for {
+			start := strings.Index(line, placeholderPrefix)
+			if start < 0 {
+				break
+			}
+			if start > 0 && line[start-1] == '{' {
+				// escaped placeholder
+				sb.WriteString(line[:start-1])
+				sb.WriteString(placeholderPrefix)
+				line = line[start+len(placeholderPrefix):]
+				continue
+			}
+			end := strings.IndexByte(line[start:], '}')
+			if end < 0 {
+				break
+			}
+			end += start
+			sb.WriteString(line[:start])
+			if value, ok := properties[line[start+len(placeholderPrefix):end]]; ok {
+				sb.WriteString(renderOutput(value))
+			} else {
+				sb.WriteString(line[start : end+1])
+			}
+			line = line[end+1:]
+		}
+		sb.WriteString(line)
+		resolved[i] = sb.String()
+	}
+	return resolved, resolved != nil
+}
+
+// templateOutput returns the message's output (with resolved placeholders) or the rendered output template if the
+// message has no output
+func templateOutput(msg *logMsg) []string {
+	output := msg.Output()
+	if len(output) > 0 {
+		if msg.resolved {
+			return output
+		}
+		if resolved, ok := resolvePlaceholders(output, msg.Properties()); ok {
+			return resolved
+		}
+		return output
+	}
+	if line, ok := RenderOutputTemplate(msg.logMessageType, msg.Properties()); ok {
+		return []string{line}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package logthing
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// WithPprofLabels sets the pprof labels "trackingID", "msgType" and "operationID" on the goroutine for the duration of
+// an operation (see StartOperation), so that CPU profiles can be sliced by the same correlation IDs that appear in logs.
+// The tracking ID is labeled if it's known when the operation starts (e.g. inherited from the parent operation).
+// The labels are also carried by the operation's context (see Operation.Context and pprof.Do), and the goroutine's
+// previous labels are restored when the operation ends.
+//
+//	op := logthing.StartOperation(ctx, "charge_card", logthing.WithPprofLabels())
+func WithPprofLabels() Option {
+	return func(lm LogMsg) {
+		if msg := lm.msgData(); msg != nil {
+			msg.pprofLabels = true
+		}
+	}
+}
+
+// setPprofLabels adds the operation's labels to the labels of the parent context and sets them on the goroutine
+func (op *Operation) setPprofLabels(parent context.Context) {
+	labels := []string{"msgType", op.Type(), "operationID", op.id}
+	if trackingID := op.TrackingID(); trackingID != "" {
+		labels = append(labels, "trackingID", trackingID)
+	}
+	op.labeled = parent
+	op.ctx = pprof.WithLabels(op.ctx, pprof.Labels(labels...))
+	pprof.SetGoroutineLabels(op.ctx)
+}
+
+// restorePprofLabels sets the goroutine's labels from before the operation
+func (op *Operation) restorePprofLabels() {
+	if op.labeled != nil {
+		pprof.SetGoroutineLabels(op.labeled)
+		op.labeled = nil
+	}
+}
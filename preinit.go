@@ -0,0 +1,85 @@
+package logthing
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// preInitBuffer buffers messages that are logged before the dispatcher has been initialized (see SetPreInitBuffer)
+type preInitBuffer struct {
+	mutex    sync.Mutex
+	size     int
+	messages []*logMsg
+}
+
+var preInit = &preInitBuffer{}
+
+// SetPreInitBuffer sets the number of messages that are buffered when they are logged before InitDispatcher has been
+// called (e.g. early startup logs), instead of returning ErrNotInitialized. Buffered messages are printed immediately and
+// written when the dispatcher has been initialized. Further messages are dropped with ErrNotInitialized. The size can
+// also be set with LOGTHING_PRE_INIT_BUFFER (default: 0 = no buffering).
+func SetPreInitBuffer(size int) {
+	preInit.mutex.Lock()
+	defer preInit.mutex.Unlock()
+	preInit.size = size
+	if len(preInit.messages) > size {
+		preInit.messages = preInit.messages[:size]
+	}
+}
+
+// initPreInitBuffer sets the buffer size from LOGTHING_PRE_INIT_BUFFER
+func initPreInitBuffer() {
+	if size, err := strconv.Atoi(strings.TrimSpace(logwriter.Getenv("LOGTHING_PRE_INIT_BUFFER"))); err == nil && size >= 0 {
+		SetPreInitBuffer(size)
+	}
+}
+
+// add prints and buffers the message. It returns ErrNotInitialized if the buffer is full or disabled.
+func (b *preInitBuffer) add(calldepth int, logMessage LogMsg) error {
+	config := currentConfig()
+	b.mutex.Lock()
+	size := b.size
+	b.mutex.Unlock()
+	if size <= 0 {
+		return ErrNotInitialized
+	}
+	msg := logMessage.msgData()
+	msg.SetSeverity(SeverityTrace)
+	if config.isDenied(msg.logMessageType) {
+		return ErrDenied
+	}
+	whitelisted := config.isWhitelisted(msg.logMessageType) || msg.whitelisted || isVerboseTrackingID(msg.trackingID)
+	if !whitelisted && !config.meetsLogMaxSeverity(msg.severity) {
+		return ErrSeverityAboveMax
+	}
+	b.mutex.Lock()
+	if len(b.messages) >= b.size {
+		b.mutex.Unlock()
+		return ErrNotInitialized
+	}
+	if time.Time(msg.timestamp).IsZero() {
+		msg.timestamp = UTCTime(time.Now())
+	}
+	msg.printed = whitelisted || config.meetsPrintMaxSeverity(msg.severity)
+	b.messages = append(b.messages, msg)
+	b.mutex.Unlock()
+	if msg.printed {
+		printLogMsg(calldepth+1, msg)
+	}
+	return nil
+}
+
+// replay logs the buffered messages with the dispatcher and empties the buffer
+func (b *preInitBuffer) replay(ld *logDispatcher) {
+	b.mutex.Lock()
+	messages := b.messages
+	b.messages = nil
+	b.mutex.Unlock()
+	for _, msg := range messages {
+		ld.log(1, msg)
+	}
+}
@@ -0,0 +1,29 @@
+package logthing
+
+import (
+	"testing"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestPreInitBuffer(t *testing.T) {
+	SetPreInitBuffer(2)
+	defer SetPreInitBuffer(0)
+	for i := 0; i < 3; i++ {
+		err := NewLogMsg("startup").SetProperty("i", i).Warning("early").Log()
+		if i < 2 && err != nil {
+			t.Errorf("expected message %v to be buffered, got %v", i, err)
+		}
+		if i == 2 && err != ErrNotInitialized {
+			t.Errorf("expected full buffer, got %v", err)
+		}
+	}
+	writer := &soakWriter{}
+	if err := InitDispatcher([]logwriter.LogWriter{writer}); err != nil {
+		t.Fatal(err)
+	}
+	Close()
+	if writer.written != 2 {
+		t.Errorf("expected 2 replayed messages, got %v", writer.written)
+	}
+}
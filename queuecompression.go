@@ -0,0 +1,109 @@
+package logthing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// WithQueueCompression enables that the properties of messages, whose marshalled size is at least threshold bytes, are
+// gzip compressed while they are waiting in the queue (e.g. with huge queues, see WithQueueSize). They are transparently
+// decompressed when the message is written. It trades CPU for less memory that is retained by the queue.
+func WithQueueCompression(threshold int) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.queueCompression = threshold
+	}
+}
+
+// queuedMsg contains the compressed properties of a queued message and their kinds to restore the values
+type queuedMsg struct {
+	data  []byte
+	kinds map[string]logwriter.Kind
+}
+
+// compressQueued compresses the message's properties if their marshalled size is at least threshold bytes
+func (lm *logMsg) compressQueued(threshold int) {
+	properties := renderProperties(lm.Properties())
+	buf := logwriter.GetBuffer()
+	defer logwriter.PutBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(properties); err != nil || buf.Len() < threshold {
+		return
+	}
+	compressed := bytes.Buffer{}
+	zw, _ := gzip.NewWriterLevel(&compressed, gzip.BestSpeed)
+	zw.Write(buf.Bytes())
+	if err := zw.Close(); err != nil {
+		return
+	}
+	queued := &queuedMsg{data: compressed.Bytes(), kinds: make(map[string]logwriter.Kind, len(properties))}
+	for key, value := range properties {
+		queued.kinds[key] = propertyKind(value)
+	}
+	lm.queued = queued
+	lm.properties = nil
+	lm.output = nil
+}
+
+// restoreQueued decompresses the properties of a compressed message
+func (lm *logMsg) restoreQueued() {
+	queued := lm.queued
+	lm.queued = nil
+	zr, err := gzip.NewReader(bytes.NewReader(queued.data))
+	if err != nil {
+		Error.Printf("Error while decompressing queued message: %v", err)
+		return
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		Error.Printf("Error while decompressing queued message: %v", err)
+		return
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		Error.Printf("Error while decompressing queued message: %v", err)
+		return
+	}
+	properties := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		properties[key] = restoreValue(value, queued.kinds[key])
+	}
+	lm.properties = properties
+}
+
+// restoreValue restores the marshalled value with its original kind, so that the schema isn't changed by compression.
+// Values of unknown kind are kept marshalled.
+func restoreValue(raw json.RawMessage, kind logwriter.Kind) interface{} {
+	switch kind {
+	case logwriter.String:
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+	case logwriter.Boolean:
+		return string(raw) == "true"
+	case logwriter.Integer:
+		if i, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(raw), 10, 64); err == nil {
+			return u
+		}
+	case logwriter.Number:
+		if f, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			return f
+		}
+	case logwriter.DateTime:
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return UTCTime(t)
+			}
+		}
+	}
+	return raw
+}
@@ -0,0 +1,43 @@
+package logthing
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueCompression(t *testing.T) {
+	msg := NewLogMsg("test").
+		SetProperty("count", 42).
+		SetProperty("ratio", 0.5).
+		SetProperty("ok", true).
+		SetProperty("at", UTCTime(time.Now())).
+		SetProperty("tags", map[string]interface{}{"env": "prod"}).
+		SetProperty("body", strings.Repeat("large body ", 100)).msgData()
+	ld := &logDispatcher{}
+	ld.prepare(msg)
+	ld.complete(msg, dispatcherOptions{})
+	expected, _ := json.Marshal(renderProperties(msg.Properties()))
+	kinds := map[string]interface{}{}
+	for key, value := range msg.Properties() {
+		kinds[key] = propertyKind(value)
+	}
+
+	msg.compressQueued(1 << 20)
+	if msg.queued != nil {
+		t.Fatal("expected message below threshold to stay uncompressed")
+	}
+	msg.compressQueued(256)
+	if msg.queued == nil || len(msg.queued.data) >= len(expected) {
+		t.Fatal("expected compressed message")
+	}
+	for key, value := range msg.Properties() {
+		if propertyKind(value) != kinds[key] {
+			t.Errorf("expected kind %v of %v, got %v", kinds[key], key, propertyKind(value))
+		}
+	}
+	if restored, _ := json.Marshal(msg.Properties()); string(restored) != string(expected) {
+		t.Errorf("expected restored message %s, got %s", expected, restored)
+	}
+}
@@ -0,0 +1,13 @@
+package logthing
+
+// PropertyReceivedAt contains the time when the message has been received by the dispatcher (see WithReceiveTime)
+const PropertyReceivedAt = "receivedAt"
+
+// WithReceiveTime enables that the time when a message is received by the dispatcher is recorded as "receivedAt"
+// property besides its own timestamp, e.g. for messages of devices whose clocks may be wrong (see SetTimestamp and
+// Import). Queries can then order by receive time or measure the clock skew of devices.
+func WithReceiveTime() func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.receiveTime = true
+	}
+}
@@ -0,0 +1,27 @@
+package logthing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestReceiveTime(t *testing.T) {
+	ld, err := newLogDispatcher([]logwriter.LogWriter{}, WithDispatchInterval(time.Hour), WithReceiveTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	deviceTime := time.Now().Add(-time.Hour)
+	msg := NewLogMsg("device").SetTimestamp(deviceTime)
+	ld.prepare(msg.msgData())
+	ld.complete(msg.msgData(), ld.currentOptions())
+	receivedAt, ok := msg.Property(PropertyReceivedAt).(UTCTime)
+	if !ok || time.Since(time.Time(receivedAt)) > time.Minute {
+		t.Errorf("expected receive time to be recorded, got %v", msg.Property(PropertyReceivedAt))
+	}
+	if !msg.Timestamp().Equal(deviceTime) {
+		t.Errorf("expected device timestamp %v to be kept, got %v", deviceTime, msg.Timestamp())
+	}
+}
@@ -0,0 +1,50 @@
+package logthing
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WithRecentMessages keeps copies of the last size dispatched messages in a ring buffer, which can be queried with
+// RecentMessages (e.g. to answer "what did this pod log in the last 2 minutes" while the log backend lags)
+func WithRecentMessages(size int) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.recentMessages = size
+	}
+}
+
+// RecentMessages returns the recently dispatched messages matching the filter (oldest first), if the default dispatcher
+// keeps them (see WithRecentMessages). The returned messages must not be modified.
+func RecentMessages(filter MessageFilter) (messages []LogMsg) {
+	if ld == nil || ld.recentRing == nil {
+		return nil
+	}
+	matcher := newMessageMatcher(filter)
+	for _, msg := range ld.recentRing.snapshot() {
+		if matcher.matches(msg) {
+			messages = append(messages, msg)
+		}
+	}
+	return
+}
+
+// RecentMessagesHandler returns a handler that responds with the recently dispatched messages (see RecentMessages) as
+// JSON array of the message properties. Messages can be filtered with the query parameters "type" (comma separated, glob
+// patterns supported), "severity" (max severity), "trackingID", "since" (duration) and "label" (comma separated), e.g.
+// /logs/recent?since=2m
+func RecentMessagesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := messageFilterFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages := RecentMessages(filter)
+		properties := make([]map[string]interface{}, 0, len(messages))
+		for _, msg := range messages {
+			properties = append(properties, renderProperties(msg.Properties()))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(properties)
+	})
+}
@@ -0,0 +1,38 @@
+package logthing
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecentMessages(t *testing.T) {
+	var err error
+	ld, err = newLogDispatcher(nil, WithDispatchInterval(time.Hour), WithRecentMessages(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	options := ld.currentOptions()
+	for i, msgType := range []string{"a", "b", "a", "b", "a"} {
+		msg := NewLogMsg(msgType).Info(i).msgData()
+		ld.prepare(msg)
+		ld.enqueue(msg, options)
+	}
+	if messages := RecentMessages(MessageFilter{}); len(messages) != 3 || messages[0].Type() != "a" || messages[1].Type() != "b" {
+		t.Errorf("expected the last 3 messages, got %v", messages)
+	}
+	if messages := RecentMessages(MessageFilter{Types: []string{"a"}}); len(messages) != 2 {
+		t.Errorf("expected 2 messages of type a, got %v", len(messages))
+	}
+	if messages := RecentMessages(MessageFilter{Since: time.Now().Add(time.Minute)}); len(messages) != 0 {
+		t.Errorf("expected no messages in the future, got %v", len(messages))
+	}
+	rec := httptest.NewRecorder()
+	RecentMessagesHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/?type=b&since=1m", nil))
+	var properties []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &properties); err != nil || len(properties) != 1 || properties[0][PropertyType] != "b" {
+		t.Errorf("unexpected response %q: %v", rec.Body.String(), err)
+	}
+}
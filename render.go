@@ -0,0 +1,127 @@
+package logthing
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// renderers contains the custom renderers registered with RegisterRenderer
+var renderers = struct {
+	sync.RWMutex
+	types      map[reflect.Type]func(interface{}) string
+	interfaces []typeRenderer
+}{
+	types: map[reflect.Type]func(interface{}) string{},
+}
+
+// typeRenderer is a renderer for an interface type
+type typeRenderer struct {
+	t      reflect.Type
+	render func(interface{}) string
+}
+
+// RegisterRenderer registers a custom renderer for values of type T, which is used to render the values in console output
+// and properties. If T is an interface type, the renderer is used for all values implementing it (in order of registration).
+// Renderers of concrete types take precedence.
+//
+//	logthing.RegisterRenderer(func(ip net.IP) string { return ip.String() })
+func RegisterRenderer[T any](render func(T) string) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	r := func(v interface{}) string {
+		return render(v.(T))
+	}
+	renderers.Lock()
+	defer renderers.Unlock()
+	if t.Kind() == reflect.Interface {
+		renderers.interfaces = append(renderers.interfaces, typeRenderer{t: t, render: r})
+		return
+	}
+	renderers.types[t] = r
+}
+
+// customRenderer returns the registered renderer for the value's type or nil if there is none
+func customRenderer(value interface{}) func(interface{}) string {
+	renderers.RLock()
+	defer renderers.RUnlock()
+	if len(renderers.types) == 0 && len(renderers.interfaces) == 0 {
+		return nil
+	}
+	t := reflect.TypeOf(value)
+	if render, ok := renderers.types[t]; ok {
+		return render
+	}
+	for _, r := range renderers.interfaces {
+		if t.Implements(r.t) {
+			return r.render
+		}
+	}
+	return nil
+}
+
+// renderOutput renders the value for the console output: Custom renderers take precedence over error, fmt.Stringer
+// and encoding.TextMarshaler. Other values are formatted with fmt.Sprint.
+func renderOutput(value interface{}) string {
+	if value == nil {
+		return fmt.Sprint(value)
+	}
+	if render := customRenderer(value); render != nil {
+		return render(value)
+	}
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case encoding.TextMarshaler:
+		if text, err := v.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// renderProperty renders the property value to be marshalled: Custom renderers take precedence, values implementing
+// json.Marshaler or encoding.TextMarshaler are kept (as they are marshalled accordingly), errors and fmt.Stringers
+// are rendered as string. Returns false if the value is kept.
+func renderProperty(value interface{}) (interface{}, bool) {
+	if value == nil {
+		return value, false
+	}
+	if render := customRenderer(value); render != nil {
+		return render(value), true
+	}
+	switch v := value.(type) {
+	case json.Marshaler, encoding.TextMarshaler:
+		return value, false
+	case error:
+		return v.Error(), true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	return value, false
+}
+
+// renderProperties returns the properties with rendered values (see renderProperty). The properties map is only
+// copied if any value is rendered.
+func renderProperties(properties map[string]interface{}) map[string]interface{} {
+	rendered := properties
+	copied := false
+	for key, value := range properties {
+		renderedValue, ok := renderProperty(value)
+		if !ok {
+			continue
+		}
+		if !copied {
+			rendered = make(map[string]interface{}, len(properties))
+			for k, v := range properties {
+				rendered[k] = v
+			}
+			copied = true
+		}
+		rendered[key] = renderedValue
+	}
+	return rendered
+}
@@ -0,0 +1,86 @@
+package logthing
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+type testID int
+
+func TestRender(t *testing.T) {
+	RegisterRenderer(func(id testID) string { return "id-" + strings.Repeat("x", int(id)) })
+	properties := map[string]interface{}{
+		"err":   errors.New("failed"),
+		"ip":    net.ParseIP("10.0.0.1"),
+		"id":    testID(2),
+		"count": 1,
+	}
+	rendered := renderProperties(properties)
+	if rendered["err"] != "failed" || rendered["id"] != "id-xx" || rendered["count"] != 1 {
+		t.Errorf("unexpected rendered properties: %v", rendered)
+	}
+	if _, ok := rendered["ip"].(net.IP); !ok {
+		t.Errorf("expected text marshaler to be kept, got %T", rendered["ip"])
+	}
+	if _, ok := properties["err"].(error); !ok {
+		t.Errorf("expected original properties to be unchanged")
+	}
+	if output := renderOutput(testID(1)); output != "id-x" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
+func TestOutputTemplate(t *testing.T) {
+	if err := RegisterOutputTemplate("http_access", "{{.method}} {{.path}} -> {{.status}} in {{.latency_ms}}ms"); err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterOutputTemplate("http_access", "")
+	msg := NewLogMsg("http_access").msgData()
+	msg.SetProperty("method", "GET").SetProperty("path", "/health").SetProperty("status", 200).SetProperty("latency_ms", 3)
+	if output := templateOutput(msg); len(output) != 1 || output[0] != "GET /health -> 200 in 3ms" {
+		t.Errorf("unexpected output: %v", output)
+	}
+	if _, ok := RenderOutputTemplate("other", msg.Properties()); ok {
+		t.Error("expected no template for other type")
+	}
+	if err := RegisterOutputTemplate("broken", "{{.method"); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestOutputPlaceholders(t *testing.T) {
+	msg := NewLogMsg("order").msgData()
+	msg.Errorf("Order {prop:orderID} shipped to {prop:address.city} by %v, {prop:missing} {{prop:escaped} {prop:unclosed",
+		"{prop:secret}")
+	msg.Error("{prop:secret}")
+	msg.SetProperty("orderID", 4711).SetProperty("address.city", "Berlin").SetProperty("secret", "s3cr3t")
+	expected := []string{"Order 4711 shipped to Berlin by {prop:secret}, {prop:missing} {prop:escaped} {prop:unclosed", "{prop:secret}"}
+	check := func(what string, output []string) {
+		if len(output) != len(expected) {
+			t.Fatalf("unexpected %v: %v", what, output)
+		}
+		for i := range expected {
+			if !strings.HasSuffix(output[i], expected[i]) {
+				t.Errorf("unexpected %v: %v", what, output[i])
+			}
+		}
+	}
+	check("printed output", templateOutput(msg))
+	if output := msg.Output(); strings.HasSuffix(output[0], expected[0]) {
+		t.Error("expected message output to be unchanged when printed")
+	}
+	// static and enriched properties are resolved as well, the output is resolved only once
+	msg = NewLogMsg("order").msgData()
+	msg.Errorf("{prop:region}: {prop:orderID}")
+	msg.SetProperty("orderID", "{prop:region}")
+	(&logDispatcher{}).complete(msg, dispatcherOptions{staticProperties: map[string]interface{}{"region": "eu"}})
+	(&logDispatcher{}).complete(msg, dispatcherOptions{})
+	if output, ok := msg.Property(PropertyOutput).([]string); !ok || len(output) != 1 || !strings.HasSuffix(output[0], "eu: {prop:region}") {
+		t.Errorf("unexpected output property: %v", msg.Property(PropertyOutput))
+	}
+	if output := templateOutput(msg); !strings.HasSuffix(output[0], "eu: {prop:region}") {
+		t.Errorf("expected printed output to match output property: %v", output)
+	}
+}
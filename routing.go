@@ -0,0 +1,492 @@
+package logthing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// RoutingRules are parsed routing rules (see ParseRoutingRules and WithRoutingRules)
+type RoutingRules struct {
+	text  string
+	rules []routingRule
+}
+
+type routingRule struct {
+	condition  routeCondition
+	sampleRate float64 // 0: not sampled
+	drop       bool
+	writers    []string // nil: all writers
+}
+
+type routeCondition func(msg *logMsg) bool
+
+// WithRoutingRules sets the rules that decide per message which writers receive it, whether it is sampled or dropped
+// (see ParseRoutingRules). The rules can be also set with the LOGTHING_ROUTES environment variable or the "routes"
+// field of the config file.
+func WithRoutingRules(rules *RoutingRules) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.routing = rules
+	}
+}
+
+// ParseRoutingRules parses routing rules separated by ";". Each rule consists of a condition and actions separated by
+// "->" (separators within quoted strings are ignored), e.g.:
+//
+//	severity<=3 AND type=="payment" -> writers:[ade,alert]; type=="http_access" -> sample(0.01) -> writers:[archive]
+//
+// Conditions compare "severity" (number or name), "type", "trackingID", "label" (see WithClassifier) or any other
+// property with ==, !=, <, <=, > or >= to numbers or quoted strings and can be combined with AND, OR, NOT and
+// parentheses. "*" matches all messages. Actions are "writers:[name,...]", "sample(rate)" and "drop". Writers provide
+// their name by implementing a Name() string method, otherwise their type name is used. The first matching rule
+// applies, messages that don't match any rule are written to all writers. InitDispatcher fails if the rules refer to
+// unknown writers.
+func ParseRoutingRules(text string) (*RoutingRules, error) {
+	rr := &RoutingRules{text: text}
+	for i, ruleText := range splitOutsideQuotes(text, ";") {
+		if strings.TrimSpace(ruleText) == "" {
+			continue
+		}
+		rule, err := parseRoutingRule(ruleText)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		rr.rules = append(rr.rules, rule)
+	}
+	return rr, nil
+}
+
+// String returns the text of the rules
+func (rr *RoutingRules) String() string {
+	if rr == nil {
+		return ""
+	}
+	return rr.text
+}
+
+// writerNames returns the names of all writers the rules refer to
+func (rr *RoutingRules) writerNames() (names []string) {
+	for _, rule := range rr.rules {
+		names = append(names, rule.writers...)
+	}
+	return names
+}
+
+// validateWriters returns an error if the rules refer to a writer that isn't one of the given writers
+func (rr *RoutingRules) validateWriters(logWriters []logwriter.LogWriter) error {
+	known := map[string]struct{}{}
+	for _, lw := range logWriters {
+		known[writerName(lw)] = struct{}{}
+	}
+	for _, name := range rr.writerNames() {
+		if _, ok := known[name]; !ok {
+			return fmt.Errorf("unknown writer %q", name)
+		}
+	}
+	return nil
+}
+
+// route applies the first matching rule to the message and returns the names of the writers that shall receive it
+// (nil: all writers)
+func (rr *RoutingRules) route(msg *logMsg) (writers []string, err error) {
+	for _, rule := range rr.rules {
+		if !rule.condition(msg) {
+			continue
+		}
+		if rule.drop {
+			return nil, ErrDenied
+		}
+		if rule.sampleRate > 0 {
+			if rand.Float64() >= rule.sampleRate {
+				return nil, ErrSampledOut
+			}
+			MarkSampled(msg, rule.sampleRate)
+		}
+		return rule.writers, nil
+	}
+	return nil, nil
+}
+
+func parseRoutingRule(text string) (rule routingRule, err error) {
+	parts := splitOutsideQuotes(text, "->")
+	p := &routeParser{}
+	if p.tokens, err = tokenizeRoute(parts[0]); err != nil {
+		return rule, err
+	}
+	if rule.condition, err = p.parseOr(); err != nil {
+		return rule, err
+	}
+	if p.pos < len(p.tokens) {
+		return rule, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	for _, action := range parts[1:] {
+		action = strings.TrimSpace(action)
+		switch {
+		case action == "drop":
+			rule.drop = true
+		case strings.HasPrefix(action, "sample(") && strings.HasSuffix(action, ")"):
+			rate, err := strconv.ParseFloat(strings.TrimSpace(action[len("sample("):len(action)-1]), 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return rule, fmt.Errorf("invalid sample rate in %q (0 < rate <= 1)", action)
+			}
+			rule.sampleRate = rate
+		case strings.HasPrefix(action, "writers:"):
+			list := strings.TrimSpace(strings.TrimPrefix(action, "writers:"))
+			if !strings.HasPrefix(list, "[") || !strings.HasSuffix(list, "]") {
+				return rule, fmt.Errorf("invalid writer list in %q, expected writers:[name,...]", action)
+			}
+			rule.writers = []string{}
+			for _, name := range strings.Split(list[1:len(list)-1], ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					rule.writers = append(rule.writers, name)
+				}
+			}
+		default:
+			return rule, fmt.Errorf("unknown action %q", action)
+		}
+	}
+	return rule, nil
+}
+
+// splitOutsideQuotes splits the text at the separators that aren't within a quoted string
+func splitOutsideQuotes(text string, sep string) (parts []string) {
+	start, quoted := 0, false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quoted && text[i] == '\\':
+			i++
+		case text[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(text[i:], sep):
+			parts = append(parts, text[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+type routeTokenKind int
+
+const (
+	tokenIdent routeTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenParen
+)
+
+type routeToken struct {
+	kind routeTokenKind
+	text string
+}
+
+func tokenizeRoute(text string) (tokens []routeToken, err error) {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, routeToken{kind: tokenParen, text: string(r)})
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string %s", string(runes[i:]))
+			}
+			value, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %w", string(runes[i:j+1]), err)
+			}
+			tokens = append(tokens, routeToken{kind: tokenString, text: value})
+			i = j + 1
+		case strings.ContainsRune("=!<>&|", r):
+			j := i + 1
+			if j < len(runes) && strings.ContainsRune("=&|", runes[j]) {
+				j++
+			}
+			op := string(runes[i:j])
+			switch op {
+			case "&&":
+				op = "AND"
+			case "||":
+				op = "OR"
+			case "!":
+				op = "NOT"
+			case "==", "!=", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator %q", op)
+			}
+			tokens = append(tokens, routeToken{kind: tokenOperator, text: op})
+			i = j
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			j := i + 1
+			for ; j < len(runes) && (runes[j] == '.' || unicode.IsDigit(runes[j])); j++ {
+			}
+			tokens = append(tokens, routeToken{kind: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case r == '*':
+			tokens = append(tokens, routeToken{kind: tokenIdent, text: "*"})
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for ; j < len(runes) && (runes[j] == '_' || runes[j] == '.' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])); j++ {
+			}
+			ident := string(runes[i:j])
+			switch strings.ToUpper(ident) {
+			case "AND", "OR", "NOT":
+				tokens = append(tokens, routeToken{kind: tokenOperator, text: strings.ToUpper(ident)})
+			default:
+				tokens = append(tokens, routeToken{kind: tokenIdent, text: ident})
+			}
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+// routeParser is a recursive descent parser of routing conditions
+type routeParser struct {
+	tokens []routeToken
+	pos    int
+}
+
+func (p *routeParser) peek(kind routeTokenKind, text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind && p.tokens[p.pos].text == text
+}
+
+func (p *routeParser) parseOr() (routeCondition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOperator, "OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(msg *logMsg) bool { return l(msg) || right(msg) }
+	}
+	return left, nil
+}
+
+func (p *routeParser) parseAnd() (routeCondition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOperator, "AND") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(msg *logMsg) bool { return l(msg) && right(msg) }
+	}
+	return left, nil
+}
+
+func (p *routeParser) parseUnary() (routeCondition, error) {
+	if p.peek(tokenOperator, "NOT") {
+		p.pos++
+		condition, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(msg *logMsg) bool { return !condition(msg) }, nil
+	}
+	if p.peek(tokenParen, "(") {
+		p.pos++
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(tokenParen, ")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return condition, nil
+	}
+	if p.peek(tokenIdent, "*") {
+		p.pos++
+		return func(*logMsg) bool { return true }, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *routeParser) parseComparison() (routeCondition, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("incomplete condition, expected <field> <operator> <value>")
+	}
+	field, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	if field.kind != tokenIdent {
+		return nil, fmt.Errorf("expected field name, got %q", field.text)
+	}
+	if op.kind != tokenOperator || op.text == "AND" || op.text == "OR" || op.text == "NOT" {
+		return nil, fmt.Errorf("expected comparison operator after %q, got %q", field.text, op.text)
+	}
+	if value.kind != tokenString && value.kind != tokenNumber {
+		return nil, fmt.Errorf("expected number or quoted string after %s %s, got %q", field.text, op.text, value.text)
+	}
+	p.pos += 3
+	switch field.text {
+	case "severity":
+		severity, err := ParseSeverity(value.text)
+		if err != nil {
+			return nil, err
+		}
+		return func(msg *logMsg) bool { return compareNumbers(float64(msg.severity), op.text, float64(severity)) }, nil
+	case "type":
+		return func(msg *logMsg) bool { return compareStrings(msg.logMessageType, op.text, value.text) }, nil
+	case "trackingID":
+		return func(msg *logMsg) bool { return compareStrings(msg.trackingID, op.text, value.text) }, nil
+	case "label":
+		if op.text != "==" && op.text != "!=" {
+			return nil, fmt.Errorf("label only supports == and !=")
+		}
+		return func(msg *logMsg) bool { return HasLabel(msg, value.text) == (op.text == "==") }, nil
+	}
+	if value.kind == tokenNumber {
+		number, err := strconv.ParseFloat(value.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", value.text)
+		}
+		return func(msg *logMsg) bool {
+			propNumber, ok := toFloat(msg.Property(field.text))
+			if !ok {
+				return op.text == "!="
+			}
+			return compareNumbers(propNumber, op.text, number)
+		}, nil
+	}
+	return func(msg *logMsg) bool {
+		propValue := msg.Property(field.text)
+		if propValue == nil {
+			return op.text == "!="
+		}
+		return compareStrings(fmt.Sprint(propValue), op.text, value.text)
+	}, nil
+}
+
+func compareNumbers(a float64, op string, b float64) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func compareStrings(a string, op string, b string) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// toFloat converts numeric property values (and numeric strings) to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// routeMessages returns the messages (and their timestamps, severities and classifications) that are routed to the
+// writer with given name
+func routeMessages(name string, routes [][]string, rawLogMessages []json.RawMessage, timestamps []time.Time, severities []Severity, classified []*classifiedMsg) ([]json.RawMessage, []time.Time, []Severity, []*classifiedMsg) {
+	var routedMessages []json.RawMessage
+	var routedTimestamps []time.Time
+	var routedSeverities []Severity
+	var routedClassified []*classifiedMsg
+	for i := range rawLogMessages {
+		if !routedTo(routes[i], name) {
+			continue
+		}
+		routedMessages = append(routedMessages, rawLogMessages[i])
+		routedTimestamps = append(routedTimestamps, timestamps[i])
+		routedSeverities = append(routedSeverities, severities[i])
+		routedClassified = append(routedClassified, classified[i])
+	}
+	return routedMessages, routedTimestamps, routedSeverities, routedClassified
+}
+
+// routedTo returns true if the message with given routes (nil: all writers) is routed to the writer with given name
+func routedTo(routes []string, name string) bool {
+	if routes == nil {
+		return true
+	}
+	for _, route := range routes {
+		if route == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package logthing
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestRoutingRules(t *testing.T) {
+	rules, err := ParseRoutingRules(`severity<=3 AND type=="payment" -> writers:[ade, alert]; type=="http_access" AND (status>=500 OR label=="slow") -> writers:[archive]; type=="noise" -> drop`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		msg     LogMsg
+		writers []string
+		err     error
+	}{
+		{NewLogMsg("payment").SetSeverity(SeverityError), []string{"ade", "alert"}, nil},
+		{NewLogMsg("payment").SetSeverity(SeverityInfo), nil, nil},
+		{NewLogMsg("http_access").SetSeverity(SeverityInfo).SetProperty("status", 503), []string{"archive"}, nil},
+		{NewLogMsg("http_access").SetSeverity(SeverityInfo).SetProperty("status", 200), nil, nil},
+		{NewLogMsg("http_access").SetSeverity(SeverityInfo).SetProperty(PropertyLabels, []string{"slow"}), []string{"archive"}, nil},
+		{NewLogMsg("noise").SetSeverity(SeverityInfo), nil, ErrDenied},
+	}
+	for i, test := range tests {
+		writers, err := rules.route(test.msg.msgData())
+		if !errors.Is(err, test.err) {
+			t.Errorf("%d: expected error %v, got %v", i, test.err, err)
+		}
+		if len(writers) != len(test.writers) || (test.writers == nil) != (writers == nil) {
+			t.Errorf("%d: expected writers %v, got %v", i, test.writers, writers)
+			continue
+		}
+		for j := range writers {
+			if writers[j] != test.writers[j] {
+				t.Errorf("%d: expected writers %v, got %v", i, test.writers, writers)
+			}
+		}
+	}
+
+	for _, invalid := range []string{`type=="a" -> fly`, `type==`, `type=="a" -> sample(2)`, `(severity<3`, `type=="a -> drop`} {
+		if _, err := ParseRoutingRules(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+// namedWriter is a soakWriter with a name that can be referenced by routing rules
+type namedWriter struct {
+	soakWriter
+	name string
+}
+
+func (w *namedWriter) Name() string {
+	return w.name
+}
+
+func TestRoutingRulesDispatch(t *testing.T) {
+	rules, err := ParseRoutingRules(`type=="audit" -> writers:[archive]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, archive := &namedWriter{name: "primary"}, &namedWriter{name: "archive"}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{primary, archive}, WithDispatchInterval(time.Hour), WithRoutingRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("audit").Info("login"))
+	ld.log(1, NewLogMsg("request").Info("GET /"))
+	ld.close()
+	if written := atomic.LoadUint64(&primary.written); written != 1 {
+		t.Errorf("expected 1 message written to primary, got %v", written)
+	}
+	if written := atomic.LoadUint64(&archive.written); written != 2 {
+		t.Errorf("expected 2 messages written to archive, got %v", written)
+	}
+}
+
+func TestRoutingRulesQuotedSeparators(t *testing.T) {
+	rules, err := ParseRoutingRules(`path=="/a;b" -> writers:[archive]; message=="x -> y" -> drop`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.rules) != 2 {
+		t.Fatalf("expected 2 rules, got %v", len(rules.rules))
+	}
+	if writers, err := rules.route(NewLogMsg("test").SetProperty("path", "/a;b").msgData()); err != nil || len(writers) != 1 || writers[0] != "archive" {
+		t.Errorf("expected message to be routed to archive, got %v, %v", writers, err)
+	}
+	if _, err := rules.route(NewLogMsg("test").SetProperty("message", "x -> y").msgData()); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected message to be dropped, got %v", err)
+	}
+}
+
+func TestRoutingRulesUnknownWriter(t *testing.T) {
+	rules, err := ParseRoutingRules(`type=="audit" -> writers:[archiv]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newLogDispatcher([]logwriter.LogWriter{&namedWriter{name: "archive"}}, WithRoutingRules(rules)); err == nil {
+		t.Errorf("expected error for unknown writer")
+	}
+}
@@ -0,0 +1,53 @@
+package logthing
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// MsgTypeRuntimeMetrics is the message type of the runtime metrics messages (see WithRuntimeMetrics)
+const MsgTypeRuntimeMetrics = "logthing_runtime_metrics"
+
+// openFileDescriptors returns the number of open file descriptors of the process or -1 if unknown (only supported on linux)
+func openFileDescriptors() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// runtimeMetricsMsg returns message with the current go runtime metrics
+func runtimeMetricsMsg() LogMsg {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	msg := NewLogMsg(MsgTypeRuntimeMetrics, WithWhitelistFlag()).
+		SetSeverity(SeverityInfo).
+		SetProperty("goroutines", runtime.NumGoroutine()).
+		SetProperty("heap_alloc_bytes", memStats.HeapAlloc).
+		SetProperty("heap_inuse_bytes", memStats.HeapInuse).
+		SetProperty("heap_objects", memStats.HeapObjects).
+		SetProperty("sys_bytes", memStats.Sys).
+		SetProperty("gc_count", memStats.NumGC).
+		SetProperty("gc_pause_total_ms", float64(memStats.PauseTotalNs)/float64(time.Millisecond))
+	if fds := openFileDescriptors(); fds >= 0 {
+		msg.SetProperty("open_fds", fds)
+	}
+	return msg
+}
+
+// reportRuntimeMetrics periodically logs runtime metrics until the dispatcher is stopped
+func (ld *logDispatcher) reportRuntimeMetrics(interval time.Duration) {
+	defer ld.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ld.log(1, runtimeMetricsMsg())
+		case <-ld.stop:
+			return
+		}
+	}
+}
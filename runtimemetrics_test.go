@@ -0,0 +1,81 @@
+package logthing
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// recordedTypes decodes the recorded messages of given type
+func recordedTypes(t *testing.T, writer *recordingWriter, msgType string) (records []map[string]interface{}) {
+	t.Helper()
+	for _, logMessage := range writer.logMessages {
+		var record map[string]interface{}
+		if err := json.Unmarshal(logMessage, &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["type"] == msgType {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// closeWithin closes the dispatcher and fails the test if the background goroutines don't stop within a second
+func closeWithin(t *testing.T, ld *logDispatcher) {
+	t.Helper()
+	closed := make(chan struct{})
+	go func() {
+		ld.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected background goroutines to stop on close")
+	}
+}
+
+// assertInterval checks that the recorded messages have been logged about interval apart
+func assertInterval(t *testing.T, records []map[string]interface{}, interval time.Duration) {
+	t.Helper()
+	var previous time.Time
+	for _, record := range records {
+		timestamp, err := time.Parse(time.RFC3339Nano, record["timestamp"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// allow for delayed ticks
+		if !previous.IsZero() && timestamp.Sub(previous) < interval/2 {
+			t.Errorf("expected messages to be logged every %v, got %v", interval, timestamp.Sub(previous))
+		}
+		previous = timestamp
+	}
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	writer := &recordingWriter{}
+	interval := 20 * time.Millisecond
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithRuntimeMetrics(interval))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * interval)
+	closeWithin(t, ld)
+	records := recordedTypes(t, writer, MsgTypeRuntimeMetrics)
+	if len(records) < 2 {
+		t.Fatalf("expected periodic runtime metrics, got %v messages", len(records))
+	}
+	for _, property := range []string{"goroutines", "heap_alloc_bytes", "heap_inuse_bytes", "heap_objects", "sys_bytes",
+		"gc_count", "gc_pause_total_ms"} {
+		if _, ok := records[0][property]; !ok {
+			t.Errorf("expected property %v, got %v", property, records[0])
+		}
+	}
+	if records[0]["goroutines"].(float64) < 1 {
+		t.Errorf("expected goroutines, got %v", records[0]["goroutines"])
+	}
+	assertInterval(t, records, interval)
+}
@@ -0,0 +1,262 @@
+package logthing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// PropertySchemaOverflow contains the drifted property values of a message (see SchemaDriftOverflow)
+const PropertySchemaOverflow = "schemaOverflow"
+
+// SchemaDriftPolicy declares how property values are handled whose kind differs from the kind the property had when
+// it was first seen (e.g. "status" switching from Integer to String), which backends with typed columns would silently mis-type.
+// Nested properties are compared by their flattened keys if properties are flattened (see WithFlattenedProperties).
+type SchemaDriftPolicy int
+
+const (
+	// SchemaDriftIgnore writes drifted values as they are (default)
+	SchemaDriftIgnore SchemaDriftPolicy = iota
+	// SchemaDriftCoerce converts drifted values to the kind the property was first seen with: any value to a string
+	// for String properties and numeric, boolean and RFC 3339 strings to Integer/Number, Boolean and DateTime
+	// properties. Messages with values that can't be converted are rejected (see SchemaDriftReject).
+	SchemaDriftCoerce
+	// SchemaDriftOverflow moves drifted values into the dynamic "schemaOverflow" property by their (flattened) keys
+	SchemaDriftOverflow
+	// SchemaDriftReject drops messages with drifted values and reports a SchemaDriftError (see Errors())
+	SchemaDriftReject
+)
+
+// SchemaDriftError is reported when a message is rejected due to a drifted property kind (see SchemaDriftReject)
+type SchemaDriftError struct {
+	Property  string         // name of the drifted property
+	Kind      logwriter.Kind // kind the property had when it was first seen
+	DriftKind logwriter.Kind // kind of the rejected value
+}
+
+func (e *SchemaDriftError) Error() string {
+	return fmt.Sprintf("kind of property %q drifted from %v to %v", e.Property, e.Kind, e.DriftKind)
+}
+
+// WithSchemaDriftPolicy sets how property values with drifted kind are handled by writers without own policy (see
+// WithWriterSchemaDriftPolicy)
+func WithSchemaDriftPolicy(policy SchemaDriftPolicy) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		opt.schemaDriftPolicy = policy
+	}
+}
+
+// WithWriterSchemaDriftPolicy sets how the given writer handles property values with drifted kind, e.g. reject them
+// for a backend with typed columns and ignore them for a document store:
+//
+//	logthing.WithWriterSchemaDriftPolicy(dataExplorer, logthing.SchemaDriftOverflow),
+func WithWriterSchemaDriftPolicy(lw logwriter.LogWriter, policy SchemaDriftPolicy) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		policies := map[logwriter.LogWriter]SchemaDriftPolicy{}
+		for w, p := range opt.writerSchemaDriftPolicies {
+			policies[w] = p
+		}
+		policies[lw] = policy
+		opt.writerSchemaDriftPolicies = policies
+	}
+}
+
+// writerSchemaDriftPolicy returns the schema drift policy of the writer
+func (opt dispatcherOptions) writerSchemaDriftPolicy(lw logwriter.LogWriter) SchemaDriftPolicy {
+	if policy, ok := opt.writerSchemaDriftPolicies[lw]; ok {
+		return policy
+	}
+	return opt.schemaDriftPolicy
+}
+
+// overflowsSchemaDrift returns true if any writer moves drifted values into the "schemaOverflow" property
+func (opt dispatcherOptions) overflowsSchemaDrift() bool {
+	if opt.schemaDriftPolicy == SchemaDriftOverflow {
+		return true
+	}
+	for _, policy := range opt.writerSchemaDriftPolicies {
+		if policy == SchemaDriftOverflow {
+			return true
+		}
+	}
+	return false
+}
+
+// propertyKind returns the schema kind of the property value
+func propertyKind(value interface{}) logwriter.Kind {
+	switch value.(type) {
+	case string:
+		return logwriter.String
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return logwriter.Integer
+	case float32, float64:
+		return logwriter.Number
+	case bool:
+		return logwriter.Boolean
+	case time.Time, UTCTime:
+		return logwriter.DateTime
+	}
+	return logwriter.Unknown
+}
+
+// isKindDrift returns true if the kind of a value isn't compatible with the recorded kind. Integers and numbers are
+// compatible and properties of unknown kind are dynamic anyway.
+func isKindDrift(kind logwriter.Kind, valueKind logwriter.Kind) bool {
+	if kind == logwriter.Unknown || kind == valueKind {
+		return false
+	}
+	if (kind == logwriter.Integer || kind == logwriter.Number) && (valueKind == logwriter.Integer || valueKind == logwriter.Number) {
+		return false
+	}
+	return true
+}
+
+// schemaDrift is a property value whose kind drifted
+type schemaDrift struct {
+	path  []string // keys of the (nested) property
+	value interface{}
+	err   *SchemaDriftError
+}
+
+// schemaDrifts returns the property values whose kind drifted from the recorded schema. Nested properties are compared
+// by their flattened keys if separator isn't empty (see FlattenProperties). Must be called with schemaMutex locked.
+func (ld *logDispatcher) schemaDrifts(properties map[string]interface{}, separator string) (drifts []schemaDrift) {
+	var walk func(prefix []string, properties map[string]interface{})
+	walk = func(prefix []string, properties map[string]interface{}) {
+		for key, value := range properties {
+			path := append(append(make([]string, 0, len(prefix)+1), prefix...), key)
+			name := strings.Join(path, separator)
+			if name == PropertySchemaOverflow {
+				continue
+			}
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 && separator != "" {
+				walk(path, nested)
+				continue
+			}
+			kind, ok := ld.schema[name]
+			if !ok || value == nil {
+				continue
+			}
+			if valueKind := propertyKind(value); isKindDrift(kind, valueKind) {
+				drifts = append(drifts, schemaDrift{path: path, value: value, err: &SchemaDriftError{Property: name, Kind: kind, DriftKind: valueKind}})
+			}
+		}
+	}
+	walk(nil, properties)
+	return drifts
+}
+
+// applySchemaDriftPolicy returns a copy of the properties with the drifted values handled according to the policy. It
+// returns an error if the message is rejected.
+func applySchemaDriftPolicy(properties map[string]interface{}, drifts []schemaDrift, policy SchemaDriftPolicy) (map[string]interface{}, error) {
+	if policy == SchemaDriftIgnore || len(drifts) == 0 {
+		return properties, nil
+	}
+	var overflow map[string]interface{}
+	for _, drift := range drifts {
+		switch policy {
+		case SchemaDriftCoerce:
+			value, ok := coerceKind(drift.value, drift.err.Kind)
+			if !ok {
+				return nil, drift.err
+			}
+			properties = setNestedProperty(properties, drift.path, value, false)
+		case SchemaDriftOverflow:
+			if overflow == nil {
+				overflow = map[string]interface{}{}
+			}
+			overflow[drift.err.Property] = drift.value
+			properties = setNestedProperty(properties, drift.path, nil, true)
+		case SchemaDriftReject:
+			return nil, drift.err
+		}
+	}
+	if overflow != nil {
+		properties = setNestedProperty(properties, []string{PropertySchemaOverflow}, overflow, false)
+	}
+	return properties, nil
+}
+
+// setNestedProperty returns a copy of the properties with the (nested) property set to value or removed. Only the maps
+// along the path are copied.
+func setNestedProperty(properties map[string]interface{}, path []string, value interface{}, remove bool) map[string]interface{} {
+	copied := make(map[string]interface{}, len(properties)+1)
+	for key, v := range properties {
+		copied[key] = v
+	}
+	if len(path) > 1 {
+		nested, _ := copied[path[0]].(map[string]interface{})
+		copied[path[0]] = setNestedProperty(nested, path[1:], value, remove)
+	} else if remove {
+		delete(copied, path[0])
+	} else {
+		copied[path[0]] = value
+	}
+	return copied
+}
+
+// coerceKind converts the value to the given kind. It returns false if the value can't be converted.
+func coerceKind(value interface{}, kind logwriter.Kind) (interface{}, bool) {
+	if kind == logwriter.String {
+		raw, err := json.Marshal(value)
+		return string(raw), err == nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+	s = strings.TrimSpace(s)
+	switch kind {
+	case logwriter.Integer, logwriter.Number:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, true
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f, true
+		}
+	case logwriter.Boolean:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, true
+		}
+	case logwriter.DateTime:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// applySchemaDrift returns the messages with the drifted property values handled according to the policy. Rejected
+// messages are dropped and their errors returned. The classified messages are returned with the handled properties,
+// so that classification policies are applied to them.
+func applySchemaDrift(policy SchemaDriftPolicy, logMessages []json.RawMessage, timestamps []time.Time, severities []Severity, classified []*classifiedMsg, marshal marshalFunc) ([]json.RawMessage, []time.Time, []Severity, []*classifiedMsg, []error) {
+	applied := make([]json.RawMessage, 0, len(logMessages))
+	appliedTimestamps := make([]time.Time, 0, len(logMessages))
+	appliedSeverities := make([]Severity, 0, len(logMessages))
+	appliedClassified := make([]*classifiedMsg, 0, len(logMessages))
+	var errs []error
+	for i, rawLogMessage := range logMessages {
+		msg := classified[i]
+		if msg != nil && len(msg.drifts) > 0 {
+			properties, err := applySchemaDriftPolicy(msg.properties, msg.drifts, policy)
+			if err == nil {
+				rawLogMessage, err = marshal(properties)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			msg = &classifiedMsg{properties: properties, classifications: msg.classifications}
+		}
+		applied = append(applied, rawLogMessage)
+		appliedTimestamps = append(appliedTimestamps, timestamps[i])
+		appliedSeverities = append(appliedSeverities, severities[i])
+		appliedClassified = append(appliedClassified, msg)
+	}
+	return applied, appliedTimestamps, appliedSeverities, appliedClassified, errs
+}
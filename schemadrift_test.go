@@ -0,0 +1,92 @@
+package logthing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestSchemaDriftPolicy(t *testing.T) {
+	ld := &logDispatcher{schema: map[string]logwriter.Kind{"status": logwriter.Integer, "name": logwriter.String}}
+	properties := func() map[string]interface{} {
+		return map[string]interface{}{"status": "403", "name": 42, "latency": 1.5}
+	}
+	drifts := ld.schemaDrifts(properties(), "")
+	if len(drifts) != 2 {
+		t.Fatalf("expected 2 drifts, got %v", drifts)
+	}
+	original := properties()
+	coerced, err := applySchemaDriftPolicy(original, drifts, SchemaDriftCoerce)
+	if err != nil || coerced["name"] != "42" || coerced["status"] != int64(403) || original["status"] != "403" {
+		t.Errorf("unexpected coerced properties: %v (%v)", coerced, err)
+	}
+	overflowed, err := applySchemaDriftPolicy(properties(), drifts, SchemaDriftOverflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overflow, _ := overflowed[PropertySchemaOverflow].(map[string]interface{})
+	if overflowed["status"] != nil || overflow["status"] != "403" || overflow["name"] != 42 || overflowed["latency"] != 1.5 {
+		t.Errorf("unexpected overflowed properties: %v", overflowed)
+	}
+	var driftErr *SchemaDriftError
+	if _, err := applySchemaDriftPolicy(properties(), drifts, SchemaDriftReject); !errors.As(err, &driftErr) {
+		t.Errorf("expected schema drift error, got %v", err)
+	}
+	// strings that aren't numbers can't be coerced to integers
+	notCoercible := map[string]interface{}{"status": "ok"}
+	if _, err := applySchemaDriftPolicy(notCoercible, ld.schemaDrifts(notCoercible, ""), SchemaDriftCoerce); !errors.As(err, &driftErr) || driftErr.Property != "status" {
+		t.Errorf("expected schema drift error, got %v", err)
+	}
+}
+
+func TestSchemaDriftFlattened(t *testing.T) {
+	ld := &logDispatcher{schema: map[string]logwriter.Kind{"http.status": logwriter.Integer}}
+	properties := map[string]interface{}{"http": map[string]interface{}{"status": "404", "method": "GET"}}
+	drifts := ld.schemaDrifts(properties, ".")
+	if len(drifts) != 1 || drifts[0].err.Property != "http.status" {
+		t.Fatalf("expected drift of flattened key, got %v", drifts)
+	}
+	coerced, err := applySchemaDriftPolicy(properties, drifts, SchemaDriftCoerce)
+	if http, _ := coerced["http"].(map[string]interface{}); err != nil || http["status"] != int64(404) || http["method"] != "GET" {
+		t.Errorf("unexpected coerced properties: %v (%v)", coerced, err)
+	}
+	if http := properties["http"].(map[string]interface{}); http["status"] != "404" {
+		t.Errorf("expected original properties to be unchanged, got %v", properties)
+	}
+	overflowed, _ := applySchemaDriftPolicy(properties, drifts, SchemaDriftOverflow)
+	if flattened := FlattenProperties(overflowed, "."); flattened["http.status"] != nil || flattened["http.method"] != "GET" ||
+		flattened[PropertySchemaOverflow].(map[string]interface{})["http.status"] != "404" {
+		t.Errorf("unexpected overflowed properties: %v", flattened)
+	}
+	if drifts := ld.schemaDrifts(properties, ""); len(drifts) != 0 {
+		t.Errorf("expected nested objects not to be compared without flattening, got %v", drifts)
+	}
+}
+
+func TestWriterSchemaDriftPolicy(t *testing.T) {
+	rejecting, ignoring := &recordingWriter{}, &recordingWriter{}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{rejecting, ignoring}, WithDispatchInterval(time.Hour),
+		WithWriterSchemaDriftPolicy(rejecting, SchemaDriftReject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("request").SetProperty("status", 200).Info("ok"))
+	ld.log(1, NewLogMsg("request").SetProperty("status", "failed").Info("failed"))
+	ld.close()
+	if len(rejecting.logMessages) != 1 {
+		t.Errorf("expected drifted message to be rejected, got %s", rejecting.logMessages)
+	}
+	if len(ignoring.logMessages) != 2 || !strings.Contains(string(ignoring.logMessages[1]), `"status":"failed"`) {
+		t.Errorf("expected drifted message to be written as it is, got %s", ignoring.logMessages)
+	}
+	var driftErr *SchemaDriftError
+	for err := range ld.errorCh {
+		if errors.As(err, &driftErr) && err.Phase == PhaseSchema && err.Writer == writerName(rejecting) {
+			return
+		}
+	}
+	t.Error("expected schema drift error of the rejecting writer")
+}
@@ -0,0 +1,86 @@
+package logthing
+
+import "sync"
+
+// PropertySchemaVersion contains the schema version of the message's type (see SetTypeSchemaVersion)
+const PropertySchemaVersion = "schemaVersion"
+
+// schemaVersions contains the schema versions and migrations by log message type
+var schemaVersions = struct {
+	sync.RWMutex
+	versions   map[string]int
+	migrations map[string]map[int]func(properties map[string]interface{})
+}{
+	versions:   map[string]int{},
+	migrations: map[string]map[int]func(properties map[string]interface{}){},
+}
+
+// SetTypeSchemaVersion declares the current schema version of messages with the given log message type, which is
+// stamped as "schemaVersion" property on each message of that type, so that long-lived tables and dashboards can
+// evolve with the messages (e.g. SetTypeSchemaVersion("http_access", 3)). A version <= 0 removes the declaration.
+func SetTypeSchemaVersion(logMessageType string, version int) {
+	schemaVersions.Lock()
+	defer schemaVersions.Unlock()
+	if version <= 0 {
+		delete(schemaVersions.versions, logMessageType)
+		return
+	}
+	schemaVersions.versions[logMessageType] = version
+}
+
+// TypeSchemaVersion returns the current schema version of the log message type (see SetTypeSchemaVersion)
+func TypeSchemaVersion(logMessageType string) (version int, ok bool) {
+	schemaVersions.RLock()
+	defer schemaVersions.RUnlock()
+	version, ok = schemaVersions.versions[logMessageType]
+	return
+}
+
+// RegisterSchemaMigration registers the function that transforms the properties of messages with the given log
+// message type from schema version fromVersion to fromVersion+1, e.g. to rename a property. Before messages are
+// written, messages with an older schema version (e.g. imported messages or messages of processes that haven't been
+// updated yet) are migrated step by step to the current version. Messages of versioned types without "schemaVersion"
+// property are considered version 1.
+func RegisterSchemaMigration(logMessageType string, fromVersion int, migrate func(properties map[string]interface{})) {
+	schemaVersions.Lock()
+	defer schemaVersions.Unlock()
+	if schemaVersions.migrations[logMessageType] == nil {
+		schemaVersions.migrations[logMessageType] = map[int]func(map[string]interface{}){}
+	}
+	schemaVersions.migrations[logMessageType][fromVersion] = migrate
+}
+
+// stampSchemaVersion sets the current schema version of the message's type, unless the message has a version
+func stampSchemaVersion(msg *logMsg) {
+	version, ok := TypeSchemaVersion(msg.logMessageType)
+	if !ok || msg.Property(PropertySchemaVersion) != nil {
+		return
+	}
+	msg.SetProperty(PropertySchemaVersion, version)
+}
+
+// migrateSchema migrates the properties of messages with an older schema version to the current version
+func migrateSchema(properties map[string]interface{}) {
+	msgType, _ := properties[PropertyType].(string)
+	schemaVersions.RLock()
+	defer schemaVersions.RUnlock()
+	current, ok := schemaVersions.versions[msgType]
+	if !ok {
+		return
+	}
+	version := 1
+	if v, ok := toFloat(properties[PropertySchemaVersion]); ok {
+		version = int(v)
+	}
+	if version >= current {
+		return
+	}
+	for ; version < current; version++ {
+		migrate, ok := schemaVersions.migrations[msgType][version]
+		if !ok {
+			break
+		}
+		migrate(properties)
+	}
+	properties[PropertySchemaVersion] = version
+}
@@ -0,0 +1,38 @@
+package logthing
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	SetTypeSchemaVersion("http_access", 3)
+	defer SetTypeSchemaVersion("http_access", 0)
+	RegisterSchemaMigration("http_access", 1, func(properties map[string]interface{}) {
+		properties["status"] = properties["code"]
+		delete(properties, "code")
+	})
+	RegisterSchemaMigration("http_access", 2, func(properties map[string]interface{}) {
+		properties["latency_ms"] = properties["latency"]
+		delete(properties, "latency")
+	})
+
+	msg := NewLogMsg("http_access").msgData()
+	stampSchemaVersion(msg)
+	if version := msg.Property(PropertySchemaVersion); version != 3 {
+		t.Errorf("expected schema version 3 to be stamped, got %v", version)
+	}
+
+	var properties map[string]interface{}
+	json.Unmarshal([]byte(`{"type":"http_access","code":200,"latency":12}`), &properties)
+	migrateSchema(properties)
+	if properties["status"] != float64(200) || properties["latency_ms"] != float64(12) || properties[PropertySchemaVersion] != 3 {
+		t.Errorf("expected properties to be migrated from version 1 to 3, got %v", properties)
+	}
+	properties = nil
+	json.Unmarshal([]byte(`{"type":"http_access","schemaVersion":2,"status":200,"latency":12}`), &properties)
+	migrateSchema(properties)
+	if properties["status"] != float64(200) || properties["latency_ms"] != float64(12) {
+		t.Errorf("expected properties to be migrated from version 2 to 3, got %v", properties)
+	}
+}
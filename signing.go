@@ -0,0 +1,105 @@
+package logthing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+const (
+	// PropertySignature contains the base64 encoded Ed25519 signature of the message (see WithMessageSigning)
+	PropertySignature = "signature"
+	// PropertySignatureKeyID contains the id of the key the message has been signed with (see WithMessageSigning)
+	PropertySignatureKeyID = "signatureKeyID"
+)
+
+// ErrInvalidSignature is returned by VerifySignature when the message's signature doesn't match
+var ErrInvalidSignature error = errors.New("invalid message signature")
+
+// messageSigner signs marshalled messages with an Ed25519 key
+type messageSigner struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// marshalMessage marshals the message properties with the encoder (encoding/json if nil) and signs them if a signer is
+// given. The signature is calculated over the marshalled properties (including the key id, but without the signature)
+// and added as property. Writers that rewrite messages sign them again with logwriter.Config.Resign.
+func marshalMessage(properties map[string]interface{}, encoder Encoder, signer *messageSigner) (json.RawMessage, error) {
+	if encoder == nil {
+		encoder = defaultEncoder
+	}
+	if signer == nil {
+		return encoder.Marshal(properties)
+	}
+	properties[PropertySignatureKeyID] = signer.keyID
+	delete(properties, PropertySignature)
+	payload := logwriter.GetBuffer()
+	defer logwriter.PutBuffer(payload)
+	if _, ok := encoder.(JSONEncoder); ok {
+		// encode the payload, which is only needed for signing, into the pooled buffer
+		if err := json.NewEncoder(payload).Encode(properties); err != nil {
+			return nil, err
+		}
+		payload.Truncate(payload.Len() - 1) // trailing newline
+	} else {
+		data, err := encoder.Marshal(properties)
+		if err != nil {
+			return nil, err
+		}
+		payload.Write(data)
+	}
+	properties[PropertySignature] = base64.StdEncoding.EncodeToString(ed25519.Sign(signer.key, payload.Bytes()))
+	return encoder.Marshal(properties)
+}
+
+// resign returns a function that signs rewritten messages again (see logwriter.Config.Resign)
+func resign(encoder Encoder, signer *messageSigner) func(logMessage json.RawMessage) (json.RawMessage, error) {
+	return func(logMessage json.RawMessage) (json.RawMessage, error) {
+		var properties map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(logMessage))
+		decoder.UseNumber()
+		if err := decoder.Decode(&properties); err != nil {
+			return nil, err
+		}
+		return marshalMessage(properties, encoder, signer)
+	}
+}
+
+// VerifySignature verifies the signature of the marshalled message (see WithMessageSigning) with the public key of the
+// message's key id. ErrInvalidSignature is returned if the signature doesn't match.
+func VerifySignature(rawMessage []byte, publicKeys map[string]ed25519.PublicKey) error {
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(rawMessage, &properties); err != nil {
+		return err
+	}
+	var signature, keyID string
+	if err := json.Unmarshal(properties[PropertySignature], &signature); err != nil {
+		return fmt.Errorf("message isn't signed: %w", err)
+	}
+	if err := json.Unmarshal(properties[PropertySignatureKeyID], &keyID); err != nil {
+		return fmt.Errorf("message has no signature key id: %w", err)
+	}
+	publicKey, ok := publicKeys[keyID]
+	if !ok {
+		return fmt.Errorf("unknown signature key id %q", keyID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	delete(properties, PropertySignature)
+	payload, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package logthing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+func TestMessageSigning(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &messageSigner{keyID: "audit-1", key: privateKey}
+	raw, err := marshalMessage(map[string]interface{}{"output": []string{"<user> logged in"}, "severity": 6}, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]ed25519.PublicKey{"audit-1": publicKey}
+	if err := VerifySignature(raw, keys); err != nil {
+		t.Fatalf("expected valid signature, got %v (%s)", err, raw)
+	}
+	tampered := bytes.Replace(raw, []byte(`"severity":6`), []byte(`"severity":7`), 1)
+	if err := VerifySignature(tampered, keys); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected invalid signature of tampered message, got %v", err)
+	}
+	if err := VerifySignature(raw, map[string]ed25519.PublicKey{}); err == nil {
+		t.Error("expected error for unknown key id")
+	}
+}
+
+// recordingWriter records the written messages
+type recordingWriter struct {
+	soakWriter
+	logMessages []json.RawMessage
+}
+
+func (w *recordingWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	w.logMessages = append(w.logMessages, logMessages...)
+	return nil
+}
+
+func TestMessageSigningStringified(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &recordingWriter{}
+	stringified := logwriter.WithStringifyPolicy(writer, logwriter.StringifyPolicy{Properties: []string{"status"}})
+	ld, err := newLogDispatcher([]logwriter.LogWriter{stringified}, WithDispatchInterval(time.Hour),
+		WithMessageSigning("audit-1", privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("audit", WithWhitelistFlag()).SetProperty("status", 403).Info("access denied"))
+	ld.close()
+	if len(writer.logMessages) != 1 || !bytes.Contains(writer.logMessages[0], []byte(`"status":"403"`)) {
+		t.Fatalf("expected stringified message, got %s", writer.logMessages)
+	}
+	if err := VerifySignature(writer.logMessages[0], map[string]ed25519.PublicKey{"audit-1": publicKey}); err != nil {
+		t.Errorf("expected valid signature of stringified message, got %v (%s)", err, writer.logMessages[0])
+	}
+}
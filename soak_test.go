@@ -0,0 +1,91 @@
+package logthing
+
+import (
+	"encoding/json"
+	"flag"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+var soakDuration = flag.Duration("soak", 0, "duration of the soak test (e.g. -soak 10m)")
+
+// soakWriter simulates a backed up writer that takes delay per batch
+type soakWriter struct {
+	delay   time.Duration
+	written uint64
+}
+
+func (w *soakWriter) Init(config logwriter.Config) error { return nil }
+func (w *soakWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	time.Sleep(w.delay)
+	atomic.AddUint64(&w.written, uint64(len(logMessages)))
+	return nil
+}
+func (w *soakWriter) PropertiesSchemaChanged(schema map[string]logwriter.Kind) error { return nil }
+func (w *soakWriter) Close()                                                         {}
+
+// TestSoak logs with many goroutines into a slow writer and checks that the memory limit bounds the retained messages.
+// It only runs with the -soak flag: go test -run TestSoak -soak 10m
+func TestSoak(t *testing.T) {
+	if *soakDuration <= 0 {
+		t.Skip("soak test only runs with -soak duration")
+	}
+	const memoryLimit = 16 << 20
+	writer := &soakWriter{delay: 200 * time.Millisecond}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(100*time.Millisecond), WithQueueSize(1<<20),
+		WithMemoryLimit(memoryLimit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := time.Now().Add(*soakDuration)
+	var logged, errorMessages uint64
+	var wg sync.WaitGroup
+	for g := 0; g < 4*runtime.GOMAXPROCS(0); g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; time.Now().Before(stop); i++ {
+				severity := SeverityWarning + Severity(i%4)
+				if i%100 == 0 {
+					severity = SeverityError
+					atomic.AddUint64(&errorMessages, 1)
+				}
+				msg := NewLogMsg("soak").SetProperty("goroutine", g).SetProperty("i", i).
+					AppendOutputKV(severity, "soak message", FString("payload", "0123456789abcdef0123456789abcdef"))
+				if ld.log(1, msg) == nil {
+					atomic.AddUint64(&logged, 1)
+				}
+			}
+		}(g)
+	}
+	var maxRetained int64
+	var maxHeap uint64
+	for time.Now().Before(stop) {
+		time.Sleep(time.Second)
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		if mem.HeapInuse > maxHeap {
+			maxHeap = mem.HeapInuse
+		}
+		if retained := ld.stats().RetainedBytes; retained > maxRetained {
+			maxRetained = retained
+		}
+	}
+	wg.Wait()
+	stats := ld.stats()
+	ld.close()
+	t.Logf("logged %v, written %v, shed %v, overflows %v, max retained %v bytes, max heap %v bytes", logged,
+		atomic.LoadUint64(&writer.written), stats.Shed, stats.Overflows, maxRetained, maxHeap)
+	if stats.Shed == 0 {
+		t.Errorf("expected messages to be shed")
+	}
+	// error messages are never shed
+	if maxRetained > 2*memoryLimit+int64(errorMessages)*1024 {
+		t.Errorf("retained bytes %v exceeded the memory limit %v", maxRetained, memoryLimit)
+	}
+}
@@ -0,0 +1,103 @@
+package logthing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionBufferSize is the number of messages a subscription buffers, further messages are dropped
+const subscriptionBufferSize = 256
+
+// subscriber receives the matching messages of a subscription
+type subscriber struct {
+	matcher messageMatcher
+	ch      chan LogMsg
+}
+
+// subscriptions contains the subscribers registered with Subscribe
+var subscriptions = struct {
+	sync.RWMutex
+	count       int32
+	subscribers map[*subscriber]struct{}
+}{
+	subscribers: map[*subscriber]struct{}{},
+}
+
+// Subscribe returns a channel that receives the logged messages matching the filter as they are dispatched (e.g. to
+// live-tail the logs of a service in an admin UI without the ingestion delay of the log backend). The received messages
+// are copies that must not be modified. Messages are dropped if the subscriber doesn't keep up. The channel is closed
+// when the context is done.
+func Subscribe(ctx context.Context, filter MessageFilter) <-chan LogMsg {
+	sub := &subscriber{matcher: newMessageMatcher(filter), ch: make(chan LogMsg, subscriptionBufferSize)}
+	subscriptions.Lock()
+	subscriptions.subscribers[sub] = struct{}{}
+	atomic.AddInt32(&subscriptions.count, 1)
+	subscriptions.Unlock()
+	go func() {
+		<-ctx.Done()
+		subscriptions.Lock()
+		delete(subscriptions.subscribers, sub)
+		atomic.AddInt32(&subscriptions.count, -1)
+		close(sub.ch)
+		subscriptions.Unlock()
+	}()
+	return sub.ch
+}
+
+// publish sends a copy of the message to all matching subscribers
+func publish(msg *logMsg) {
+	if atomic.LoadInt32(&subscriptions.count) == 0 {
+		return
+	}
+	var published LogMsg
+	subscriptions.RLock()
+	defer subscriptions.RUnlock()
+	for sub := range subscriptions.subscribers {
+		if !sub.matcher.matches(msg) {
+			continue
+		}
+		if published == nil {
+			published = copyMsg(msg)
+		}
+		select {
+		case sub.ch <- published:
+		default:
+		}
+	}
+}
+
+// SubscriptionHandler returns a handler that streams the logged messages as server-sent events (one JSON object with the
+// message properties per event). Messages can be filtered with the query parameters "type" (comma separated, glob
+// patterns supported), "severity" (max severity), "trackingID" and "label" (comma separated), e.g. /logs/tail?type=http_*&severity=warning
+func SubscriptionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := messageFilterFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		for msg := range Subscribe(r.Context(), filter) {
+			data, err := json.Marshal(renderProperties(msg.Properties()))
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package logthing
+
+import (
+	"bufio"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	ld, _ := newLogDispatcher(nil, WithDispatchInterval(time.Hour))
+	defer ld.close()
+	options := ld.currentOptions()
+	send := func(msgType string, severity Severity, trackingID string) {
+		msg := NewLogMsg(msgType).AppendOutput(severity, "message").SetTrackingID(trackingID).msgData()
+		ld.prepare(msg)
+		ld.enqueue(msg, options)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := Subscribe(ctx, MessageFilter{Types: []string{"http_*"}, MaxSeverity: SeverityWarning, TrackingID: "t1"})
+	send("http_access", SeverityInfo, "t1")
+	send("db_query", SeverityError, "t1")
+	send("http_access", SeverityError, "t2")
+	send("http_access", SeverityError, "t1")
+	select {
+	case msg := <-ch:
+		if msg.Type() != "http_access" || msg.Severity() != SeverityError || msg.TrackingID() != "t1" {
+			t.Errorf("unexpected message: %v", msg.Properties())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected message")
+	}
+	cancel()
+	for range ch {
+		t.Error("expected no further messages")
+	}
+}
+
+func TestSubscriptionHandler(t *testing.T) {
+	server := httptest.NewServer(SubscriptionHandler())
+	defer server.Close()
+	if resp, err := server.Client().Get(server.URL + "?severity=unknown"); err != nil || resp.StatusCode != 400 {
+		t.Fatalf("expected bad request for invalid severity, got %v", err)
+	}
+	resp, err := server.Client().Get(server.URL + "?type=tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	msg := NewLogMsg("tail").Info("hello").msgData()
+	msg.SetProperty(PropertyType, "tail")
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		subscriptions.RLock()
+		n := len(subscriptions.subscribers)
+		subscriptions.RUnlock()
+		if n > 0 {
+			break
+		}
+	}
+	publish(msg)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: {") || !strings.Contains(line, `"type":"tail"`) {
+		t.Errorf("unexpected event %q: %v", line, err)
+	}
+}
@@ -0,0 +1,100 @@
+package logthing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HeaderTraceparent is the W3C Trace Context header carrying trace-id and parent-id
+	HeaderTraceparent = "traceparent"
+	// HeaderTracestate is the W3C Trace Context header carrying vendor specific trace information
+	HeaderTracestate = "tracestate"
+)
+
+type trackingIDContextKey struct{}
+type tracestateContextKey struct{}
+
+// isLowerHex returns true if s only consists of lower case hex digits and isn't all zeros
+func isLowerHex(s string) bool {
+	nonZero := false
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+		nonZero = nonZero || c != '0'
+	}
+	return nonZero
+}
+
+// TrackingIDFromTraceparent returns the trace-id of the W3C traceparent header (e.g. "00-<trace-id>-<parent-id>-<flags>"),
+// so that it can be used as tracking ID. An empty string is returned if the header is invalid.
+// see also: https://www.w3.org/TR/trace-context/#traceparent-header
+func TrackingIDFromTraceparent(header string) string {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ""
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return ""
+	}
+	if !isLowerHex(parts[1]) || !isLowerHex(parts[2]) {
+		return ""
+	}
+	return parts[1]
+}
+
+// ContextWithTrackingID returns context that carries the tracking ID
+func ContextWithTrackingID(ctx context.Context, trackingID string) context.Context {
+	return context.WithValue(ctx, trackingIDContextKey{}, trackingID)
+}
+
+// TrackingIDFromContext returns the tracking ID of the context (see ContextWithTrackingID and TraceContextMiddleware)
+func TrackingIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	trackingID, _ := ctx.Value(trackingIDContextKey{}).(string)
+	return trackingID
+}
+
+// newTraceID returns new trace id (UUIDv7 without dashes, so that it sorts by time)
+func newTraceID() string {
+	return strings.ReplaceAll(NewUUIDv7(), "-", "")
+}
+
+// TraceContextMiddleware extracts the trace-id of incoming traceparent headers and sets it as tracking ID of the request's
+// context (see TrackingIDFromContext). If the request doesn't carry a valid traceparent, a new trace-id is created.
+// The tracestate header is kept in the context as well to be injected into outgoing requests (see InjectTraceContext).
+func TraceContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trackingID := TrackingIDFromTraceparent(r.Header.Get(HeaderTraceparent))
+		if trackingID == "" {
+			trackingID = newTraceID()
+		}
+		ctx := ContextWithTrackingID(r.Context(), trackingID)
+		if tracestate := r.Header.Get(HeaderTracestate); tracestate != "" {
+			ctx = context.WithValue(ctx, tracestateContextKey{}, tracestate)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// InjectTraceContext sets the traceparent (and tracestate) header of the outgoing request from the tracking ID of the
+// context, so that the correlation continues in the called service. Tracking IDs that aren't valid W3C trace-ids (32 lower
+// case hex digits, dashes are removed) are not injected.
+func InjectTraceContext(ctx context.Context, req *http.Request) {
+	traceID := strings.ReplaceAll(strings.ToLower(TrackingIDFromContext(ctx)), "-", "")
+	if len(traceID) != 32 || !isLowerHex(traceID) {
+		return
+	}
+	parentID := make([]byte, 8)
+	rand.Read(parentID)
+	req.Header.Set(HeaderTraceparent, "00-"+traceID+"-"+hex.EncodeToString(parentID)+"-01")
+	if tracestate, ok := ctx.Value(tracestateContextKey{}).(string); ok {
+		req.Header.Set(HeaderTracestate, tracestate)
+	}
+}
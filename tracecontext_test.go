@@ -0,0 +1,26 @@
+package logthing_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mfmayer/logthing"
+)
+
+func TestTraceContext(t *testing.T) {
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	if id := logthing.TrackingIDFromTraceparent("00-" + traceID + "-00f067aa0ba902b7-01"); id != traceID {
+		t.Errorf("unexpected tracking id %q", id)
+	}
+	for _, invalid := range []string{"", "00-" + traceID + "-00f067aa0ba902b7", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "ff-" + traceID + "-00f067aa0ba902b7-01"} {
+		if id := logthing.TrackingIDFromTraceparent(invalid); id != "" {
+			t.Errorf("expected %q to be invalid, got %q", invalid, id)
+		}
+	}
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	logthing.InjectTraceContext(logthing.ContextWithTrackingID(context.Background(), traceID), req)
+	if id := logthing.TrackingIDFromTraceparent(req.Header.Get(logthing.HeaderTraceparent)); id != traceID {
+		t.Errorf("expected injected trace id, got %q", req.Header.Get(logthing.HeaderTraceparent))
+	}
+}
@@ -0,0 +1,49 @@
+package logthing
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// blockingValidator blocks the validation until the context is done
+type blockingValidator struct {
+	soakWriter
+	validating chan struct{}
+}
+
+func (w *blockingValidator) Validate(ctx context.Context) error {
+	close(w.validating)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestValidateDoesNotBlockDispatching(t *testing.T) {
+	writer := &blockingValidator{validating: make(chan struct{})}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	ctx, cancel := context.WithCancel(context.Background())
+	validated := make(chan []WriterValidation)
+	go func() {
+		results, _ := ld.validate(ctx)
+		validated <- results
+	}()
+	<-writer.validating
+	ld.log(1, NewLogMsg("test").Info("written while validating"))
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadUint64(&writer.written) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected message to be written while the validation is pending")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if results := <-validated; len(results) != 1 || results[0].Err != context.Canceled {
+		t.Errorf("expected cancelled validation, got %v", results)
+	}
+}
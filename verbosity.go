@@ -0,0 +1,46 @@
+package logthing
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	verboseTrackingIDsMutex sync.RWMutex
+	verboseTrackingIDs      = map[string]time.Time{}
+)
+
+// MarkTrackingIDVerbose marks the tracking ID as verbose for the given ttl: Messages with this tracking ID bypass the severity
+// filtering and are fully whitelisted (like WithWhitelistFlag), e.g. to debug the requests of a single customer in production
+// without raising the global verbosity. A ttl <= 0 removes the mark.
+func MarkTrackingIDVerbose(trackingID string, ttl time.Duration) {
+	verboseTrackingIDsMutex.Lock()
+	defer verboseTrackingIDsMutex.Unlock()
+	if ttl <= 0 {
+		delete(verboseTrackingIDs, trackingID)
+		return
+	}
+	verboseTrackingIDs[trackingID] = time.Now().Add(ttl)
+}
+
+// isVerboseTrackingID returns true if the tracking ID has been marked verbose and its ttl hasn't expired yet
+func isVerboseTrackingID(trackingID string) bool {
+	if trackingID == "" {
+		return false
+	}
+	verboseTrackingIDsMutex.RLock()
+	expiry, ok := verboseTrackingIDs[trackingID]
+	verboseTrackingIDsMutex.RUnlock()
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) {
+		verboseTrackingIDsMutex.Lock()
+		if expiry, ok := verboseTrackingIDs[trackingID]; ok && time.Now().After(expiry) {
+			delete(verboseTrackingIDs, trackingID)
+		}
+		verboseTrackingIDsMutex.Unlock()
+		return false
+	}
+	return true
+}
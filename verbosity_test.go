@@ -0,0 +1,42 @@
+package logthing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerboseTrackingID(t *testing.T) {
+	t.Cleanup(ReloadConfig)
+	t.Setenv("LOGTHING_LOG_MAX_SEVERITY", "error")
+	ReloadConfig()
+	ld, err := newLogDispatcher(nil, WithDispatchInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ld.close()
+	MarkTrackingIDVerbose("customer", time.Hour)
+	MarkTrackingIDVerbose("expired", time.Millisecond)
+	defer MarkTrackingIDVerbose("customer", 0)
+	time.Sleep(5 * time.Millisecond)
+
+	verbose := NewLogMsg("request").SetTrackingID("customer").SetProperty("path", "/orders").Trace("request")
+	if err := ld.log(1, verbose); err != nil {
+		t.Fatalf("expected message of verbose tracking ID to bypass the severity filter, got %v", err)
+	}
+	if verbose.Property("path") == nil || verbose.Property(PropertyWhitelist) != true {
+		t.Errorf("expected message of verbose tracking ID to be whitelisted, got %v", verbose.Properties())
+	}
+	for _, trackingID := range []string{"expired", "other", ""} {
+		if err := ld.log(1, NewLogMsg("request").SetTrackingID(trackingID).Trace("request")); !errors.Is(err, ErrSeverityAboveMax) {
+			t.Errorf("expected message of tracking ID %q to be filtered, got %v", trackingID, err)
+		}
+	}
+	if isVerboseTrackingID("expired") {
+		t.Errorf("expected expired tracking ID to be unmarked")
+	}
+	MarkTrackingIDVerbose("customer", 0)
+	if err := ld.log(1, NewLogMsg("request").SetTrackingID("customer").Trace("request")); !errors.Is(err, ErrSeverityAboveMax) {
+		t.Errorf("expected unmarked tracking ID to be filtered, got %v", err)
+	}
+}
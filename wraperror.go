@@ -0,0 +1,101 @@
+package logthing
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MsgTypeError is the message type of messages created by NewErrorMsg for errors without a wrapped message type
+const MsgTypeError = "error"
+
+// wrappedError carries the message type and properties of a wrap layer (see WrapError)
+type wrappedError struct {
+	err        error
+	msgType    string
+	properties map[string]interface{}
+}
+
+func (e *wrappedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.err
+}
+
+// WrapError wraps the error with a message type and properties, which are given as alternating key value pairs. Instead of
+// logging the error at every layer, each layer can add its context by wrapping the error and the top-level handler logs
+// it once with LogError, which carries all accumulated properties into one structured message. The error message
+// isn't changed. Returns nil if err is nil.
+//
+//	return logthing.WrapError(err, "charge_card", "customerID", customerID, "amount", amount)
+func WrapError(err error, msgType string, props ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	wrapped := &wrappedError{
+		err:        err,
+		msgType:    msgType,
+		properties: map[string]interface{}{},
+	}
+	for i := 0; i < len(props); i += 2 {
+		key := fmt.Sprint(props[i])
+		var value interface{}
+		if i+1 < len(props) {
+			value = props[i+1]
+		}
+		wrapped.properties[key] = value
+	}
+	return wrapped
+}
+
+// errorLayers returns the wrap layers of the error from the outermost to the innermost
+func errorLayers(err error) (layers []*wrappedError) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if wrapped, ok := err.(*wrappedError); ok {
+			layers = append(layers, wrapped)
+		}
+	}
+	return
+}
+
+// applyErrorProperties sets the properties of all wrap layers of the error. Properties of inner layers (closer to the
+// error's origin) take precedence.
+func applyErrorProperties(msg LogMsg, err error) {
+	layers := errorLayers(err)
+	for i := len(layers) - 1; i >= 0; i-- {
+		for key, value := range layers[i].properties {
+			if msg.Property(key) == nil {
+				msg.SetProperty(key, value)
+			}
+		}
+	}
+}
+
+// NewErrorMsg returns new message with error severity, the error as output and the accumulated properties of all wrap
+// layers (see WrapError). The message type is the innermost non-empty wrapped message type or "error".
+func NewErrorMsg(err error) LogMsg {
+	return newErrorMsg(3, err)
+}
+
+func newErrorMsg(calldepth int, err error) LogMsg {
+	msgType := MsgTypeError
+	for _, layer := range errorLayers(err) {
+		if layer.msgType != "" {
+			msgType = layer.msgType
+		}
+	}
+	msg := NewLogMsg(msgType)
+	applyErrorProperties(msg, err)
+	msg.SetProperty(PropertyError, fmt.Sprint(err))
+	msg.msgData().appendOutput(calldepth, SeverityError, err)
+	return msg
+}
+
+// LogError logs the error as single structured message (see NewErrorMsg and WrapError)
+func LogError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return LogMsgWithCalldepth(2, newErrorMsg(3, err))
+}
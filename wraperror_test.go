@@ -0,0 +1,32 @@
+package logthing_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mfmayer/logthing"
+)
+
+func TestWrapError(t *testing.T) {
+	errBase := errors.New("connection refused")
+	err := logthing.WrapError(errBase, "db_query", "table", "orders", "retries", 3)
+	err = fmt.Errorf("loading orders: %w", err)
+	err = logthing.WrapError(err, "handle_request", "table", "ignored", "path", "/orders")
+	if !errors.Is(err, errBase) {
+		t.Errorf("expected wrapped error to match base error")
+	}
+	msg := logthing.NewErrorMsg(err)
+	if msg.Type() != "db_query" {
+		t.Errorf("expected innermost message type, got %q", msg.Type())
+	}
+	if msg.Property("table") != "orders" || msg.Property("retries") != 3 || msg.Property("path") != "/orders" {
+		t.Errorf("unexpected properties: %v", msg.Properties())
+	}
+	if msg.Severity() != logthing.SeverityError {
+		t.Errorf("expected error severity, got %v", msg.Severity())
+	}
+	if logthing.WrapError(nil, "noop") != nil {
+		t.Errorf("expected nil for nil error")
+	}
+}
@@ -0,0 +1,21 @@
+package logthing
+
+import "github.com/mfmayer/logthing/logwriter"
+
+// MsgTypeWriterDisabled is the message type of the alert message that is logged when a writer has been disabled
+const MsgTypeWriterDisabled = "logthing_writer_disabled"
+
+// writerDisabledMsg returns the alert message about the disabled writer with the reason and whether re-initializing the
+// writer might help (see logwriter.DisableError)
+func writerDisabledMsg(writer string, err error) LogMsg {
+	reinitMayHelp := logwriter.ReinitMayHelp(err)
+	hint := "check the writer's configuration"
+	if reinitMayHelp {
+		hint = "re-initializing the writer might help"
+	}
+	return NewLogMsg(MsgTypeWriterDisabled, WithWhitelistFlag()).
+		SetProperty("writer", writer).
+		SetProperty("reason", err.Error()).
+		SetProperty("reinit_may_help", reinitMayHelp).
+		Alertf("writer %v has been disabled: %v (%v)", writer, err, hint)
+}
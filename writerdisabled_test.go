@@ -0,0 +1,43 @@
+package logthing
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// disablingWriter disables itself on the first write
+type disablingWriter struct {
+	soakWriter
+	closed bool
+}
+
+func (w *disablingWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	return logwriter.DisableError(errors.New("token expired"), true)
+}
+func (w *disablingWriter) Close() { w.closed = true }
+
+func TestWriterDisabledCallback(t *testing.T) {
+	writer := &disablingWriter{}
+	var disabledName string
+	var disabledErr error
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithMaxBatchSize(1),
+		WithWriterDisabledCallback(func(name string, err error) {
+			disabledName, disabledErr = name, err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Info("message"))
+	ld.close()
+	if !writer.closed || disabledName != writerName(writer) || !errors.Is(disabledErr, logwriter.ErrWriterDisable) || !logwriter.ReinitMayHelp(disabledErr) {
+		t.Errorf("expected disabled callback for closed writer, got %q: %v", disabledName, disabledErr)
+	}
+	msg := writerDisabledMsg("writer", disabledErr).msgData()
+	if msg.Severity() != SeverityAlert || msg.Property("reason") != "token expired" || msg.Property("reinit_may_help") != true {
+		t.Errorf("unexpected writer disabled message: %v", msg.Properties())
+	}
+}
@@ -0,0 +1,120 @@
+package logthing
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// WithWriterConcurrency lets a pool of n workers write batches to the writer in parallel (e.g. Azure Monitor accepts
+// concurrent posts), instead of the dispatcher writing one batch after the other. Batches are written asynchronously, so
+// that multiple batches are in flight at the same time. The chunks of a batch that exceeds the writer's max batch bytes
+// are written one after the other by the same worker, so that they keep their order; the remaining chunks are skipped
+// when a chunk fails. Submitting blocks while all workers are busy and n further batches are pending. The writer must be
+// safe for concurrent use. Checkpointed writers (see logwriter.CheckpointedWriter) are always written sequentially,
+// since their checkpoints must be persisted in order. The concurrency can only be set when the dispatcher is initialized
+// (see SetOptions).
+func WithWriterConcurrency(lw logwriter.LogWriter, n int) func(*dispatcherOptions) {
+	return func(opt *dispatcherOptions) {
+		writerConcurrency := map[logwriter.LogWriter]int{}
+		for w, c := range opt.writerConcurrency {
+			writerConcurrency[w] = c
+		}
+		writerConcurrency[lw] = n
+		opt.writerConcurrency = writerConcurrency
+	}
+}
+
+// writeJob contains the chunks of a batch that are written by a worker of a writer pool
+type writeJob struct {
+	chunks   []logwriter.Batch
+	done     func(chunk logwriter.Batch, duration time.Duration, err error)
+	finished func(err error)
+}
+
+// writerPool contains the workers that write batches to a writer in parallel
+type writerPool struct {
+	jobs     chan writeJob
+	wg       sync.WaitGroup
+	disabled atomic.Value // disabledError that is set by workers when the writer returned logwriter.ErrWriterDisable
+}
+
+// disabledError wraps the error that disabled the writer of a pool, so that errors of different types can be stored in
+// the atomic.Value
+type disabledError struct {
+	err error
+}
+
+// disable records the error that disabled the pool's writer
+func (p *writerPool) disable(err error) {
+	p.disabled.Store(disabledError{err: err})
+}
+
+// disabledErr returns the error that disabled the pool's writer or nil
+func (p *writerPool) disabledErr() error {
+	if disabled, ok := p.disabled.Load().(disabledError); ok {
+		return disabled.err
+	}
+	return nil
+}
+
+func newWriterPool(lw logwriter.LogWriter, n int, checkpoints *checkpointStore) *writerPool {
+	pool := &writerPool{jobs: make(chan writeJob, n)}
+	pool.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.jobs {
+				var err error
+				for _, chunk := range job.chunks {
+					start := time.Now()
+					err = writeBatch(lw, chunk, checkpoints)
+					job.done(chunk, time.Since(start), err)
+					if err != nil {
+						break // like writeBatch, the remaining chunks aren't written
+					}
+				}
+				if job.finished != nil {
+					job.finished(err)
+				}
+			}
+		}()
+	}
+	return pool
+}
+
+// submit splits the batch into chunks according to the writer's max batch bytes (see logwriter.Capabilities) and queues
+// them to be written in order by one of the workers. done is called by the worker for every written chunk and finished
+// once with the first error (nil if all chunks have been written).
+func (p *writerPool) submit(lw logwriter.LogWriter, batch logwriter.Batch, done func(chunk logwriter.Batch, duration time.Duration, err error), finished func(err error)) {
+	p.jobs <- writeJob{chunks: chunkBatch(batch, logwriter.CapabilitiesOf(lw).MaxBatchBytes), done: done, finished: finished}
+}
+
+// close waits until all queued batches have been written and stops the workers
+func (p *writerPool) close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// writerPool returns the worker pool of the writer or nil if the writer has no concurrency. Must only be called by the
+// dispatcher goroutine.
+func (ld *logDispatcher) writerPool(lw logwriter.LogWriter, options dispatcherOptions) *writerPool {
+	n := options.writerConcurrency[lw]
+	if n <= 1 {
+		return nil
+	}
+	if _, checkpointed := lw.(logwriter.CheckpointedWriter); checkpointed {
+		return nil
+	}
+	if ld.writerPools == nil {
+		ld.writerPools = map[logwriter.LogWriter]*writerPool{}
+	}
+	pool, ok := ld.writerPools[lw]
+	if !ok {
+		pool = newWriterPool(lw, n, ld.checkpoints)
+		ld.writerPools[lw] = pool
+	}
+	return pool
+}
@@ -0,0 +1,143 @@
+package logthing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfmayer/logthing/logwriter"
+)
+
+// concurrentWriter records the max number of concurrent writes
+type concurrentWriter struct {
+	soakWriter
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (w *concurrentWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	inFlight := atomic.AddInt32(&w.inFlight, 1)
+	defer atomic.AddInt32(&w.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&w.maxInFlight)
+		if inFlight <= max || atomic.CompareAndSwapInt32(&w.maxInFlight, max, inFlight) {
+			break
+		}
+	}
+	return w.soakWriter.WriteLogMessages(logMessages, timestamps)
+}
+
+func TestWriterConcurrency(t *testing.T) {
+	writer := &concurrentWriter{soakWriter: soakWriter{delay: 50 * time.Millisecond}}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithMaxBatchSize(1),
+		WithWriterConcurrency(writer, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := ld.log(1, NewLogMsg("test").Info(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ld.close()
+	if written := atomic.LoadUint64(&writer.written); written != 8 {
+		t.Errorf("expected all messages to be written on close, got %v", written)
+	}
+	if max := atomic.LoadInt32(&writer.maxInFlight); max < 2 || max > 4 {
+		t.Errorf("expected 2-4 concurrent writes, got %v", max)
+	}
+}
+
+// chunkedWriter records the written chunks and fails with differently typed ErrWriterDisable errors
+type chunkedWriter struct {
+	soakWriter
+	mutex  sync.Mutex
+	chunks [][]json.RawMessage
+	fail   bool
+}
+
+func (w *chunkedWriter) Capabilities() logwriter.Capabilities {
+	return logwriter.Capabilities{MaxBatchBytes: 16}
+}
+
+func (w *chunkedWriter) WriteLogMessages(logMessages []json.RawMessage, timestamps []time.Time) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.chunks = append(w.chunks, logMessages)
+	if w.fail {
+		if len(w.chunks)%2 == 0 {
+			return logwriter.ErrWriterDisable
+		}
+		return fmt.Errorf("chunk %v: %w", len(w.chunks), logwriter.ErrWriterDisable)
+	}
+	return nil
+}
+
+func TestWriterPoolChunks(t *testing.T) {
+	writer := &chunkedWriter{}
+	pool := newWriterPool(writer, 4, nil)
+	batch := logwriter.Batch{Ordered: true}
+	for i := 0; i < 8; i++ {
+		batch.LogMessages = append(batch.LogMessages, json.RawMessage(fmt.Sprintf(`{"i":%v}`, i)))
+		batch.Timestamps = append(batch.Timestamps, time.Now())
+	}
+	pool.submit(writer, batch, func(chunk logwriter.Batch, duration time.Duration, err error) {}, nil)
+	pool.close()
+	var order []string
+	for _, chunk := range writer.chunks {
+		for _, msg := range chunk {
+			order = append(order, string(msg))
+		}
+	}
+	if len(writer.chunks) < 2 || len(order) != 8 || order[0] != `{"i":0}` || order[7] != `{"i":7}` {
+		t.Errorf("expected ordered chunks, got %v", order)
+	}
+
+	writer = &chunkedWriter{fail: true}
+	pool = newWriterPool(writer, 4, nil)
+	done := func(chunk logwriter.Batch, duration time.Duration, err error) {
+		pool.disable(err) // must not panic with differently typed errors
+	}
+	var finished []error
+	var finishedMutex sync.Mutex
+	finish := func(err error) {
+		finishedMutex.Lock()
+		finished = append(finished, err)
+		finishedMutex.Unlock()
+	}
+	pool.submit(writer, batch, done, finish)
+	pool.submit(writer, batch, done, finish)
+	pool.close()
+	if err := pool.disabledErr(); !errors.Is(err, logwriter.ErrWriterDisable) {
+		t.Errorf("expected disabled pool, got %v", err)
+	}
+	if len(writer.chunks) != 2 {
+		t.Errorf("expected the remaining chunks to be skipped after the first failed chunk, got %v chunks", len(writer.chunks))
+	}
+	if len(finished) != 2 || !errors.Is(finished[0], logwriter.ErrWriterDisable) || !errors.Is(finished[1], logwriter.ErrWriterDisable) {
+		t.Errorf("expected jobs to finish with the chunk errors, got %v", finished)
+	}
+}
+
+func TestWriterPoolFailedWrites(t *testing.T) {
+	writer := &failingWriter{err: errors.New("backend unavailable")}
+	ld, err := newLogDispatcher([]logwriter.LogWriter{writer}, WithDispatchInterval(time.Hour), WithWriterConcurrency(writer, 2),
+		WithDailyIngestionBudget(1<<20, BudgetPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ld.log(1, NewLogMsg("test").Info("message"))
+	ld.close()
+	if stats := ld.stats(); stats.MessagesWritten != 0 || !stats.LastWrite.IsZero() || stats.WriteErrors != 1 {
+		t.Errorf("expected failed asynchronous write not to count as written, got %+v", stats)
+	}
+	for _, budget := range ld.budgets {
+		if budget.bytes != 0 {
+			t.Errorf("expected failed asynchronous write not to consume the budget, got %v bytes", budget.bytes)
+		}
+	}
+}